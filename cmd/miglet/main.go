@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/monkci/miglet/pkg/buildinfo"
 	"github.com/monkci/miglet/pkg/config"
 	"github.com/monkci/miglet/pkg/controller"
 	"github.com/monkci/miglet/pkg/events"
@@ -14,6 +20,7 @@ import (
 	"github.com/monkci/miglet/pkg/state"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.buildTime=..."
 var (
 	version   = "dev"
 	buildTime = "unknown"
@@ -28,6 +35,10 @@ func main() {
 	)
 	flag.Parse()
 
+	// Everything that reports the version (connect request, events) reads buildinfo
+	buildinfo.Version = version
+	buildinfo.BuildTime = buildTime
+
 	if *showVersion {
 		fmt.Printf("MIGlet version %s (built %s)\n", version, buildTime)
 		os.Exit(0)
@@ -46,7 +57,8 @@ func main() {
 	}
 
 	// Initialize logger with determined values
-	logger.Init(finalLogLevel, finalLogFormat)
+	// Output goes to stdout until the config names a log file
+	logger.Init(finalLogLevel, finalLogFormat, logger.Output{})
 	log := logger.Get()
 
 	log.WithFields(map[string]interface{}{
@@ -60,10 +72,22 @@ func main() {
 		log.WithError(err).Fatal("Failed to load configuration")
 	}
 
+	if cfg.Logging.OutputPath != "" && cfg.Logging.OutputPath != "stdout" {
+		err := logger.SetOutput(logger.Output{
+			Path:       cfg.Logging.OutputPath,
+			MaxSizeMB:  cfg.Logging.MaxSizeMB,
+			MaxBackups: cfg.Logging.MaxBackups,
+		})
+		if err != nil {
+			log.WithError(err).Warn("Failed to open log output, logging to stdout")
+		}
+	}
+
 	log.WithFields(map[string]interface{}{
 		"pool_id":    cfg.PoolID,
 		"vm_id":      cfg.VMID,
 		"org_id":     cfg.OrgID,
+		"zone":       cfg.Zone,
 		"controller": cfg.Controller.Endpoint,
 	}).Info("Configuration loaded successfully")
 
@@ -83,6 +107,26 @@ func main() {
 	// Create state machine
 	stateMachine := state.NewStateMachine(cfg, ctrlClient, eventEmitter)
 
+	// Start local status endpoint
+	var statusServer *http.Server
+	if cfg.StatusServer.Enabled {
+		mux := http.NewServeMux()
+		mux.Handle("/livez", stateMachine.StatusHandler())
+
+		statusServer = &http.Server{
+			Addr:              net.JoinHostPort(cfg.StatusServer.BindAddress, strconv.Itoa(cfg.StatusServer.Port)),
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			ctxLog.WithField("addr", statusServer.Addr).Info("Status server starting")
+			if err := statusServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				ctxLog.WithError(err).Error("Status server failed")
+			}
+		}()
+	}
+	defer stopStatusServer(statusServer)
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -100,6 +144,7 @@ func main() {
 	case err := <-stateMachineDone:
 		if err != nil {
 			ctxLog.WithError(err).Error("State machine exited with error")
+			stopStatusServer(statusServer)
 			os.Exit(1)
 		}
 		ctxLog.Info("State machine completed")
@@ -110,3 +155,13 @@ func main() {
 		ctxLog.Info("MIGlet shutdown complete")
 	}
 }
+
+// stopStatusServer shuts down the status endpoint, if it was started
+func stopStatusServer(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -228,7 +229,7 @@ func (s *GRPCServer) sendRegisterRunnerCommand(vmID, poolID, orgID string) {
 
 	// Create register_runner command
 	cmd := &commands.Command{
-		Id:   fmt.Sprintf("register-%s-%d", vmID, time.Now().Unix()),
+		Id:   uuid.New().String(),
 		Type: "register_runner",
 		StringParams: map[string]string{
 			"registration_token": registrationToken,
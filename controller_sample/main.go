@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 const (
@@ -184,7 +186,8 @@ func handleEvents(w http.ResponseWriter, r *http.Request, vmID string) {
 	storeData(vmID, fmt.Sprintf("event-%s", eventType), body)
 
 	// Send acknowledgment
-	// For vm_started events, explicitly acknowledge (this is what MIGlet waits for)
+	// For vm_started events, explicitly acknowledge
+	// Current MIGlets send vm_started over gRPC; this path serves HTTP-only agents
 	if eventType == "vm_started" {
 		poolID, _ := event["pool_id"].(string)
 		orgID, _ := event["org_id"].(string)
@@ -195,7 +198,6 @@ func handleEvents(w http.ResponseWriter, r *http.Request, vmID string) {
 		vmRegistrationSent[vmID] = false // Reset flag for this VM
 
 		// Send explicit acknowledgment for VM started events
-		// MIGlet will transition to "ready" state and poll for registration config
 		response := map[string]interface{}{
 			"status":       "acknowledged", // MIGlet checks for "acknowledged" or "received"
 			"acknowledged": true,           // Explicit flag
@@ -277,7 +279,7 @@ func handleCommands(w http.ResponseWriter, r *http.Request, vmID string) {
 		// Send register_runner command with registration token and config
 		log.Printf("Sending register_runner command to VM %s", vmID)
 		commands = append(commands, map[string]interface{}{
-			"id":   fmt.Sprintf("register-%s-%d", vmID, time.Now().Unix()),
+			"id":   uuid.New().String(),
 			"type": "register_runner",
 			"parameters": map[string]interface{}{
 				"registration_token": registrationToken,
@@ -8,14 +8,22 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/monkci/mig-controller/internal/admin"
 	"github.com/monkci/mig-controller/internal/config"
 	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/health"
+	"github.com/monkci/mig-controller/internal/httpapi"
+	"github.com/monkci/mig-controller/internal/metrics"
 	"github.com/monkci/mig-controller/internal/pubsub"
 	"github.com/monkci/mig-controller/internal/redis"
 	"github.com/monkci/mig-controller/internal/scheduler"
 	"github.com/monkci/mig-controller/internal/token"
 	"github.com/monkci/mig-controller/internal/vm"
+	"github.com/monkci/mig-controller/internal/webhook"
 	"github.com/monkci/mig-controller/pkg/logger"
 	"github.com/monkci/mig-controller/proto/commands"
 )
@@ -37,8 +45,15 @@ func main() {
 	}
 
 	// Initialize logger
-	logger.Init(cfg.Logging.Level, cfg.Logging.Format)
+	err = logger.Init(cfg.Logging.Level, cfg.Logging.Format, logger.Output{
+		Path:       cfg.Logging.OutputPath,
+		MaxSizeMB:  cfg.Logging.MaxSizeMB,
+		MaxBackups: cfg.Logging.MaxBackups,
+	})
 	log := logger.WithComponent("main")
+	if err != nil {
+		log.WithError(err).Warn("Failed to open log output, logging to stdout")
+	}
 
 	log.WithFields(map[string]interface{}{
 		"version":    version,
@@ -53,12 +68,15 @@ func main() {
 		log.WithError(err).Fatal("Failed to initialize job store")
 	}
 	defer jobStore.Close()
+	jobStore.SetRetryBackoff(cfg.Scheduler.RetryInterval, cfg.Scheduler.MaxRetryInterval)
+	jobStore.SetMaxRetries(cfg.Scheduler.MaxRetries)
 
 	vmStore, err := redis.NewVMStatusStore(&cfg.Redis.VMStatus, cfg.Pool.ID)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to initialize VM status store")
 	}
 	defer vmStore.Close()
+	vmStore.SetHealthThresholds(cfg.VMManager.MaxDiskUsage, cfg.VMManager.MaxMemoryUsage)
 
 	// Initialize token service
 	tokenService, err := token.NewService(&cfg.GitHubApp)
@@ -66,23 +84,61 @@ func main() {
 		log.WithError(err).Fatal("Failed to initialize token service")
 	}
 
-	// Initialize VM manager
-	vmManager, err := vm.NewManager(cfg, vmStore)
+	auditStore, err := redis.NewAuditStore(&cfg.Redis.VMStatus, cfg.Pool.ID)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to initialize VM manager")
+		log.WithError(err).Fatal("Failed to initialize audit store")
 	}
-	defer vmManager.Close()
+	defer auditStore.Close()
+
+	pendingStore, err := redis.NewPendingCommandStore(&cfg.Redis.VMStatus, cfg.Pool.ID)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize pending command store")
+	}
+	defer pendingStore.Close()
+
+	eventDedupStore, err := redis.NewEventDedupStore(&cfg.Redis.VMStatus, cfg.Pool.ID)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize event dedup store")
+	}
+	defer eventDedupStore.Close()
 
 	// Initialize gRPC server
 	grpcServer := grpcserver.NewServer(cfg, vmStore)
+	grpcServer.SetAuditStore(auditStore)
+	grpcServer.SetPendingCommandStore(pendingStore)
+	grpcServer.SetEventIDStore(eventDedupStore)
+
+	// Initialize VM manager
+	vmManager, err := vm.NewManager(cfg, vmStore, grpcServer, nil, nil)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize VM manager")
+	}
+	defer vmManager.Close()
 
 	// Initialize scheduler
 	sched := scheduler.NewScheduler(cfg, jobStore, vmStore, vmManager, grpcServer, tokenService)
 
+	// Leader election, so only one replica schedules when running several
+	if cfg.Scheduler.LeaderElection {
+		hostname, _ := os.Hostname()
+		replicaID := fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+		leaderLock, err := redis.NewLeaderLock(&cfg.Redis.Jobs, cfg.Pool.ID, replicaID, cfg.Scheduler.LeaderLeaseTTL)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to initialize leader election")
+		}
+		defer leaderLock.Close()
+		sched.SetLeaderLock(leaderLock)
+		grpcServer.SetStreamGate(sched.IsLeader)
+	}
+
 	// Set up event handlers
 	grpcServer.SetEventCallback(func(vmID string, event *commands.EventNotification) {
 		sched.HandleJobEvent(vmID, event)
 	})
+	grpcServer.SetHeartbeatCallback(func(vmID string, heartbeat *commands.Heartbeat) {
+		sched.HandleHeartbeat(vmID, heartbeat)
+	})
+	grpcServer.SetPendingCommandCheck(sched.CheckPendingCommand)
 
 	// Initialize Pub/Sub subscriber
 	subscriber, err := pubsub.NewSubscriber(cfg, jobStore)
@@ -107,8 +163,21 @@ func main() {
 	// Start scheduler
 	sched.Start()
 
+	// Push metrics to a push gateway, for deployments Prometheus can't scrape
+	var pusher *metrics.Pusher
+	if cfg.Metrics.PushGateway != "" {
+		registry := metrics.NewRegistry()
+		registry.Register("scheduler", sched.GetStats)
+		registry.Register("pubsub", subscriber.GetStats)
+		registry.Register("vm_manager", vmManager.GetStats)
+		registry.Register("grpc", grpcServer.GetStats)
+
+		pusher = metrics.NewPusher(cfg, registry)
+		pusher.Start()
+	}
+
 	// Start HTTP server for health checks and metrics
-	go startHTTPServer(cfg, sched, subscriber)
+	go startHTTPServer(cfg, sched, subscriber, jobStore, vmStore, vmManager, grpcServer, auditStore)
 
 	// Initial VM list refresh
 	if err := vmManager.RefreshVMList(ctx); err != nil {
@@ -131,13 +200,21 @@ func main() {
 	// Graceful shutdown
 	sched.Stop()
 	subscriber.Stop()
+	if pusher != nil {
+		pusher.Stop()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	grpcServer.Stop(shutdownCtx)
+	shutdownCancel()
+
 	vmManager.Close()
 
 	log.Info("MIG Controller shutdown complete")
 }
 
 // startHTTPServer starts the HTTP server for health checks and metrics
-func startHTTPServer(cfg *config.Config, sched *scheduler.Scheduler, subscriber *pubsub.Subscriber) {
+func startHTTPServer(cfg *config.Config, sched *scheduler.Scheduler, subscriber *pubsub.Subscriber, jobStore *redis.JobStore, vmStore *redis.VMStatusStore, vmManager *vm.Manager, grpcServer *grpcserver.Server, auditStore *redis.AuditStore) {
 	log := logger.WithComponent("http_server")
 
 	mux := http.NewServeMux()
@@ -148,17 +225,29 @@ func startHTTPServer(cfg *config.Config, sched *scheduler.Scheduler, subscriber
 		w.Write([]byte("OK"))
 	})
 
-	// Readiness check
-	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Ready"))
+	// Readiness check (dependency health, cached briefly)
+	readiness := health.NewReadiness(5 * time.Second)
+	readiness.Add("redis_jobs", jobStore.Ping)
+	readiness.Add("redis_vm_status", vmStore.Ping)
+	readiness.Add("vm_status_writes", grpcServer.CheckVMStore)
+	readiness.Add("pubsub_subscription", subscriber.CheckSubscription)
+	readiness.Add("gcp_compute", func(ctx context.Context) error {
+		return vmManager.CheckClients()
 	})
+	mux.Handle("/ready", readiness)
 
 	// Metrics/stats endpoint
 	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 		stats := map[string]interface{}{
-			"scheduler": sched.GetStats(),
-			"pubsub":    subscriber.GetStats(),
+			"scheduler":  sched.GetStats(),
+			"pubsub":     subscriber.GetStats(),
+			"vm_manager": vmManager.GetStats(),
+			"leader":     sched.LeaderStatus(),
+			"miglets": map[string]interface{}{
+				"connected": grpcServer.GetConnectionCount(),
+				"versions":  grpcServer.GetVersions(),
+			},
+			"grpc": grpcServer.GetStats(),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -166,6 +255,26 @@ func startHTTPServer(cfg *config.Config, sched *scheduler.Scheduler, subscriber
 		fmt.Fprintf(w, "%+v", stats)
 	})
 
+	// GitHub workflow_job webhooks (alternative to Pub/Sub ingestion)
+	if cfg.GitHubApp.WebhookSecret != "" {
+		mux.Handle("/webhooks/github", webhook.NewGitHubHandler(cfg, jobStore))
+		log.Info("GitHub webhook endpoint enabled at /webhooks/github")
+	}
+
+	// Operator actions (drain-and-recycle, ...)
+	if cfg.Server.AdminToken != "" {
+		adminHandler := admin.NewHandler(cfg, vmManager, sched, grpcServer, jobStore, auditStore)
+		mux.Handle("/admin/", adminHandler)
+		mux.Handle("/api/v1/pools/", adminHandler)
+		log.Info("Admin API enabled at /admin/ and /api/v1/pools/")
+	}
+
+	// MIGlet events and heartbeats over HTTP, for agents whose gRPC stream is down
+	if cfg.Server.HTTPFallback.Enabled {
+		mux.Handle("/api/v1/vms/", httpapi.NewHandler(cfg, grpcServer, jobStore))
+		log.Info("MIGlet HTTP API enabled at /api/v1/vms/")
+	}
+
 	addr := fmt.Sprintf(":%d", cfg.Server.HTTPPort)
 	log.WithField("addr", addr).Info("HTTP server starting")
 
@@ -173,4 +282,3 @@ func startHTTPServer(cfg *config.Config, sched *scheduler.Scheduler, subscriber
 		log.WithError(err).Error("HTTP server failed")
 	}
 }
-
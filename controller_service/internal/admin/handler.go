@@ -0,0 +1,288 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/monkci/mig-controller/internal/config"
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/pubsub"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/internal/scheduler"
+	"github.com/monkci/mig-controller/internal/vm"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// Handler serves operator actions under /admin, plus manual job injection under /api/v1/pools
+// Every request must carry "Authorization: Bearer <server.admin_token>"
+type Handler struct {
+	cfg        *config.Config
+	token      []byte
+	vmManager  *vm.Manager
+	scheduler  *scheduler.Scheduler
+	grpcServer *grpcserver.Server
+	jobStore   *redis.JobStore
+	auditStore *redis.AuditStore
+	mux        *http.ServeMux
+}
+
+// NewHandler creates a new admin handler
+// auditStore may be nil, in which case the audit query endpoint is not registered
+func NewHandler(cfg *config.Config, vmManager *vm.Manager, sched *scheduler.Scheduler, grpcServer *grpcserver.Server, jobStore *redis.JobStore, auditStore *redis.AuditStore) *Handler {
+	h := &Handler{
+		cfg:        cfg,
+		token:      []byte(cfg.Server.AdminToken),
+		vmManager:  vmManager,
+		scheduler:  sched,
+		grpcServer: grpcServer,
+		jobStore:   jobStore,
+		auditStore: auditStore,
+		mux:        http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("POST /admin/vms/{vm_id}/recycle", h.handleRecycle)
+	h.mux.HandleFunc("GET /admin/vms/{vm_id}/logs", h.handleVMLogs)
+	h.mux.HandleFunc("POST /admin/jobs/{job_id}/cancel", h.handleCancelJob)
+	h.mux.HandleFunc("POST /api/v1/pools/{pool_id}/jobs", h.handleEnqueueJob)
+	if auditStore != nil {
+		h.mux.HandleFunc("GET /admin/audit/commands", h.handleAuditQuery)
+	}
+
+	return h
+}
+
+// ServeHTTP authenticates the request and dispatches it to the matching action
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// handleRecycle drains a VM and replaces it once its current job finishes
+// The recycle runs in the background since draining can take up to DrainTimeout
+func (h *Handler) handleRecycle(w http.ResponseWriter, r *http.Request) {
+	vmID := r.PathValue("vm_id")
+	log := logger.WithVM(vmID, h.cfg.Pool.ID).WithField("component", "admin")
+
+	if !h.grpcServer.IsConnected(vmID) {
+		http.Error(w, "VM is not connected", http.StatusConflict)
+		return
+	}
+
+	log.Info("Drain-and-recycle requested")
+
+	go func() {
+		if err := h.vmManager.DrainAndRecycle(context.Background(), vmID, "admin"); err != nil {
+			log.WithError(err).Error("Drain-and-recycle failed")
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `{"result":"recycling","vm_id":%q}`, vmID)
+}
+
+// handleVMLogs returns the newest runner log lines the VM's MIGlet has buffered
+// Query params: tail (lines to return; the MIGlet's default when unset)
+func (h *Handler) handleVMLogs(w http.ResponseWriter, r *http.Request) {
+	vmID := r.PathValue("vm_id")
+	log := logger.WithVM(vmID, h.cfg.Pool.ID).WithField("component", "admin")
+
+	cmd := &commands.Command{
+		Id:        uuid.New().String(),
+		Type:      "get_logs",
+		CreatedAt: time.Now().Unix(),
+		IntParams: map[string]int64{},
+	}
+	if v := r.URL.Query().Get("tail"); v != "" {
+		tail, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || tail < 1 {
+			http.Error(w, "invalid tail", http.StatusBadRequest)
+			return
+		}
+		cmd.IntParams["tail"] = tail
+	}
+
+	// A command for a disconnected VM would only be queued, and logs are only useful now
+	if !h.grpcServer.IsConnected(vmID) {
+		http.Error(w, "VM is not connected", http.StatusConflict)
+		return
+	}
+
+	ack, err := h.grpcServer.SendCommandAs("admin", vmID, cmd, h.cfg.MIGlet.CommandTimeout)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch runner logs")
+		status := http.StatusBadGateway
+		if errors.Is(err, grpcserver.ErrCommandTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if !ack.Success {
+		http.Error(w, ack.Message, http.StatusConflict)
+		return
+	}
+
+	lines := []string{}
+	if ack.Result["lines"] != "" {
+		lines = strings.Split(ack.Result["lines"], "\n")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"vm_id":     vmID,
+		"job_id":    ack.Result["job_id"],
+		"lines":     lines,
+		"truncated": ack.Result["truncated"] == "true",
+	})
+}
+
+// handleCancelJob cancels a running job on its VM
+func (h *Handler) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID := r.PathValue("job_id")
+	log := logger.WithComponent("admin").WithField("job_id", jobID)
+
+	log.Info("Job cancellation requested")
+
+	if err := h.scheduler.CancelJob(r.Context(), jobID); err != nil {
+		log.WithError(err).Warn("Job cancellation failed")
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"result":"cancelled","job_id":%q}`, jobID)
+}
+
+// handleEnqueueJob queues a job as if it had arrived over Pub/Sub, for testing and manual reruns
+// The body is a pubsub.JobMessage; labels default to the pool's. Validation and duplicate
+// detection are the subscriber's, so a job already seen is reported rather than queued twice
+// With ?force=true a job already seen is queued again, unless it is still active
+func (h *Handler) handleEnqueueJob(w http.ResponseWriter, r *http.Request) {
+	log := logger.WithComponent("admin")
+
+	if poolID := r.PathValue("pool_id"); poolID != h.cfg.Pool.ID {
+		http.Error(w, fmt.Sprintf("this controller manages pool %q, not %q", h.cfg.Pool.ID, poolID), http.StatusNotFound)
+		return
+	}
+
+	var jobMsg pubsub.JobMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&jobMsg); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := pubsub.ValidateJobMessage(&jobMsg); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(jobMsg.Labels) == 0 {
+		jobMsg.Labels = h.cfg.GetPoolLabels()
+	}
+	if missing := h.cfg.MissingPoolLabels(jobMsg.Labels); len(missing) > 0 {
+		http.Error(w, fmt.Sprintf("labels not offered by this pool: %s", strings.Join(missing, ", ")), http.StatusUnprocessableEntity)
+		return
+	}
+	jobMsg.PoolID = h.cfg.Pool.ID
+	jobMsg.ReceivedAt = time.Now().Unix()
+
+	jobID := jobMsg.QueueID()
+	log = log.WithFields(map[string]interface{}{
+		"job_id": jobID,
+		"repo":   jobMsg.RepoFullName,
+	})
+
+	force := r.URL.Query().Get("force") == "true"
+	log = log.WithField("force", force)
+
+	enqueue := pubsub.EnqueueJobMessage
+	if force {
+		enqueue = pubsub.RerunJobMessage
+	}
+	enqueued, err := enqueue(r.Context(), h.jobStore, h.cfg.Pool.ID, &jobMsg)
+	if err != nil {
+		log.WithError(err).Error("Manual job enqueue failed")
+		http.Error(w, "failed to enqueue job", http.StatusInternalServerError)
+		return
+	}
+
+	result, status := "enqueued", http.StatusCreated
+	if !enqueued {
+		result, status = "duplicate", http.StatusOK
+	}
+	log.WithField("result", result).Info("Manual job enqueue requested")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"result":%q,"job_id":%q}`, result, jobID)
+}
+
+// handleAuditQuery returns command audit entries
+// Query params: vm_id, since and until (RFC3339), limit (default 100, max 1000)
+func (h *Handler) handleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	query := redis.CommandAuditQuery{
+		VMID: r.URL.Query().Get("vm_id"),
+	}
+
+	var err error
+	if v := r.URL.Query().Get("since"); v != "" {
+		if query.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid since: must be RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("until"); v != "" {
+		if query.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid until: must be RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Limit > 1000 {
+		query.Limit = 1000
+	}
+
+	entries, err := h.auditStore.Query(r.Context(), query)
+	if err != nil {
+		logger.WithComponent("admin").WithError(err).Error("Failed to query command audit log")
+		http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+	})
+}
+
+// authorized checks the bearer token in constant time
+func (h *Handler) authorized(r *http.Request) bool {
+	if len(h.token) == 0 {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), h.token) == 1
+}
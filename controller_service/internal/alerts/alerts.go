@@ -0,0 +1,254 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// alertSendTimeout bounds delivering one alert to every configured channel
+const alertSendTimeout = 15 * time.Second
+
+// Severity levels, matching PagerDuty's
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert keys; cooldown is tracked per key
+const (
+	AlertErrorVMs    = "error_vms"
+	AlertQueueLength = "queue_length"
+	AlertAllVMsBusy  = "all_vms_busy"
+	AlertQuota       = "quota_exhausted"
+	AlertGCPBreaker  = "gcp_breaker_open"
+	AlertJobRetries  = "job_retries_exhausted"
+)
+
+// Alert is a single notification
+type Alert struct {
+	Key      string // Identifies the condition; repeats within the cooldown are suppressed
+	Severity string
+	Summary  string
+	Details  map[string]interface{}
+}
+
+// Snapshot is the pool state alert conditions are evaluated against
+type Snapshot struct {
+	ErrorVMs    int64
+	QueueLength int64
+	ReadyVMs    int64
+	BusyVMs     int64
+	StartingVMs int64
+
+	GCPBreakerOpen bool // VM manager is short-circuiting GCP calls
+}
+
+// Manager evaluates alert conditions and notifies Slack and PagerDuty
+type Manager struct {
+	cfg        *config.Config
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	lastFired map[string]time.Time // Alert key -> when it was last delivered
+	sending   map[string]bool      // Alert keys being delivered
+	busySince time.Time            // When every VM became busy (zero if some are free)
+
+	wg sync.WaitGroup // Alerts being delivered
+}
+
+// NewManager creates an alert manager
+func NewManager(cfg *config.Config) *Manager {
+	return &Manager{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		lastFired:  make(map[string]time.Time),
+		sending:    make(map[string]bool),
+	}
+}
+
+// Evaluate checks the alert conditions against the pool state and fires any that hold
+func (m *Manager) Evaluate(ctx context.Context, snap Snapshot) {
+	alerts := &m.cfg.Alerts
+
+	if alerts.ErrorVMThreshold > 0 && snap.ErrorVMs >= int64(alerts.ErrorVMThreshold) {
+		m.Fire(ctx, Alert{
+			Key:      AlertErrorVMs,
+			Severity: SeverityCritical,
+			Summary:  fmt.Sprintf("Pool %s has %d VMs in error state", m.cfg.Pool.ID, snap.ErrorVMs),
+			Details:  map[string]interface{}{"error_vms": snap.ErrorVMs, "threshold": alerts.ErrorVMThreshold},
+		})
+	}
+
+	if alerts.QueueLengthThreshold > 0 && snap.QueueLength >= int64(alerts.QueueLengthThreshold) {
+		m.Fire(ctx, Alert{
+			Key:      AlertQueueLength,
+			Severity: SeverityWarning,
+			Summary:  fmt.Sprintf("Pool %s has %d queued jobs", m.cfg.Pool.ID, snap.QueueLength),
+			Details:  map[string]interface{}{"queue_length": snap.QueueLength, "threshold": alerts.QueueLengthThreshold},
+		})
+	}
+
+	allBusy := snap.BusyVMs > 0 && snap.ReadyVMs == 0 && snap.StartingVMs == 0
+	m.mu.Lock()
+	if !allBusy {
+		m.busySince = time.Time{}
+	} else if m.busySince.IsZero() {
+		m.busySince = time.Now()
+	}
+	busyFor := time.Duration(0)
+	if !m.busySince.IsZero() {
+		busyFor = time.Since(m.busySince)
+	}
+	m.mu.Unlock()
+
+	if alerts.AllBusyDuration > 0 && busyFor >= alerts.AllBusyDuration {
+		m.Fire(ctx, Alert{
+			Key:      AlertAllVMsBusy,
+			Severity: SeverityWarning,
+			Summary:  fmt.Sprintf("All %d VMs in pool %s have been busy for %s", snap.BusyVMs, m.cfg.Pool.ID, busyFor.Round(time.Second)),
+			Details:  map[string]interface{}{"busy_vms": snap.BusyVMs, "queue_length": snap.QueueLength},
+		})
+	}
+
+	if snap.GCPBreakerOpen {
+		m.Fire(ctx, Alert{
+			Key:      AlertGCPBreaker,
+			Severity: SeverityCritical,
+			Summary:  fmt.Sprintf("Pool %s stopped calling the GCP API after repeated failures", m.cfg.Pool.ID),
+			Details:  map[string]interface{}{"queue_length": snap.QueueLength},
+		})
+	}
+}
+
+// Fire sends an alert in the background unless the same key was delivered within
+// AlertCooldown or is being delivered now, so a slow webhook can't hold up the caller
+// Returns false if the alert was suppressed
+func (m *Manager) Fire(ctx context.Context, alert Alert) bool {
+	if !m.claim(alert.Key, time.Now()) {
+		return false
+	}
+
+	log := logger.WithComponent("alerts").WithFields(map[string]interface{}{
+		"alert":    alert.Key,
+		"severity": alert.Severity,
+	})
+	log.Warn(alert.Summary)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), alertSendTimeout)
+		defer cancel()
+		m.release(alert.Key, m.send(sendCtx, alert, log), time.Now())
+	}()
+	return true
+}
+
+// Wait blocks until alerts being delivered are done
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// send delivers the alert to every configured channel and reports whether all succeeded
+func (m *Manager) send(ctx context.Context, alert Alert, log *logrus.Entry) bool {
+	delivered := true
+	if m.cfg.Alerts.SlackWebhook != "" {
+		if err := m.notifySlack(ctx, alert); err != nil {
+			log.WithError(err).Warn("Failed to send Slack alert")
+			delivered = false
+		}
+	}
+	if m.cfg.Alerts.PagerDutyKey != "" {
+		if err := m.notifyPagerDuty(ctx, alert); err != nil {
+			log.WithError(err).Warn("Failed to send PagerDuty alert")
+			delivered = false
+		}
+	}
+	return delivered
+}
+
+// claim marks key as being delivered, unless it is already or was delivered within the cooldown
+func (m *Manager) claim(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sending[key] {
+		return false
+	}
+	if last, ok := m.lastFired[key]; ok && now.Sub(last) < m.cfg.Alerts.AlertCooldown {
+		return false
+	}
+	m.sending[key] = true
+	return true
+}
+
+// release ends delivery of key and starts its cooldown if it was delivered, so an alert
+// that failed to send is retried the next time its condition is evaluated
+func (m *Manager) release(key string, delivered bool, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sending, key)
+	if delivered {
+		m.lastFired[key] = now
+	}
+}
+
+// notifySlack posts the alert to the Slack incoming webhook
+func (m *Manager) notifySlack(ctx context.Context, alert Alert) error {
+	text := fmt.Sprintf("[%s] %s", alert.Severity, alert.Summary)
+	return m.post(ctx, m.cfg.Alerts.SlackWebhook, map[string]interface{}{"text": text})
+}
+
+// notifyPagerDuty triggers a PagerDuty incident, deduplicated per pool and alert key
+func (m *Manager) notifyPagerDuty(ctx context.Context, alert Alert) error {
+	return m.post(ctx, pagerDutyEventsURL, map[string]interface{}{
+		"routing_key":  m.cfg.Alerts.PagerDutyKey,
+		"event_action": "trigger",
+		"dedup_key":    fmt.Sprintf("mig-controller/%s/%s", m.cfg.Pool.ID, alert.Key),
+		"payload": map[string]interface{}{
+			"summary":        alert.Summary,
+			"source":         "mig-controller/" + m.cfg.Pool.ID,
+			"severity":       alert.Severity,
+			"custom_details": alert.Details,
+		},
+	})
+}
+
+// post sends body as JSON and treats any non-2xx response as an error
+func (m *Manager) post(ctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package alerts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// newTestManager returns a manager posting Slack alerts to a test server that answers
+// with the status status points to, and the number of requests it has received
+func newTestManager(t *testing.T, status *atomic.Int32) (*Manager, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{}
+	cfg.Pool.ID = "pool-test"
+	cfg.Alerts.SlackWebhook = srv.URL
+	cfg.Alerts.AlertCooldown = time.Hour
+	return NewManager(cfg), &requests
+}
+
+func queueAlert() Alert {
+	return Alert{Key: AlertQueueLength, Severity: SeverityWarning, Summary: "queue is long"}
+}
+
+func TestFireSuppressesRepeatsWithinCooldown(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	m, requests := newTestManager(t, &status)
+
+	if !m.Fire(context.Background(), queueAlert()) {
+		t.Fatal("first alert suppressed")
+	}
+	m.Wait()
+	if m.Fire(context.Background(), queueAlert()) {
+		t.Fatal("repeat within the cooldown not suppressed")
+	}
+	if !m.Fire(context.Background(), Alert{Key: AlertErrorVMs, Severity: SeverityCritical}) {
+		t.Fatal("alert with a different key suppressed")
+	}
+	m.Wait()
+
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Slack requests = %d, want 2", got)
+	}
+}
+
+func TestFireRetriesFailedDelivery(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	m, requests := newTestManager(t, &status)
+
+	m.Fire(context.Background(), queueAlert())
+	m.Wait()
+
+	status.Store(http.StatusOK)
+	if !m.Fire(context.Background(), queueAlert()) {
+		t.Fatal("alert suppressed after a failed delivery")
+	}
+	m.Wait()
+	if m.Fire(context.Background(), queueAlert()) {
+		t.Fatal("repeat after a successful delivery not suppressed")
+	}
+
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Slack requests = %d, want 2", got)
+	}
+}
+
+func TestFireAllowsRepeatAfterCooldown(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	m, _ := newTestManager(t, &status)
+
+	m.Fire(context.Background(), queueAlert())
+	m.Wait()
+
+	m.mu.Lock()
+	m.lastFired[AlertQueueLength] = time.Now().Add(-2 * time.Hour)
+	m.mu.Unlock()
+	if !m.Fire(context.Background(), queueAlert()) {
+		t.Fatal("alert suppressed after the cooldown")
+	}
+	m.Wait()
+}
+
+func TestFireDoesNotBlockOnSlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{}
+	cfg.Alerts.SlackWebhook = srv.URL
+	cfg.Alerts.AlertCooldown = time.Hour
+	m := NewManager(cfg)
+	defer m.Wait()
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		m.Fire(context.Background(), queueAlert())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Fire blocked on the webhook")
+	}
+
+	if m.Fire(context.Background(), queueAlert()) {
+		t.Error("alert being delivered not suppressed")
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -51,13 +52,24 @@ type Config struct {
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	GRPCPort          int           `mapstructure:"grpc_port"`
-	HTTPPort          int           `mapstructure:"http_port"`
-	ShutdownTimeout   time.Duration `mapstructure:"shutdown_timeout"`
-	MaxConnectionAge  time.Duration `mapstructure:"max_connection_age"`
-	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"`
-	KeepaliveTimeout  time.Duration `mapstructure:"keepalive_timeout"`
-	TLS               TLSConfig     `mapstructure:"tls"`
+	GRPCPort          int                `mapstructure:"grpc_port"`
+	HTTPPort          int                `mapstructure:"http_port"`
+	ShutdownTimeout   time.Duration      `mapstructure:"shutdown_timeout"`
+	MaxConnectionAge  time.Duration      `mapstructure:"max_connection_age"`
+	KeepaliveInterval time.Duration      `mapstructure:"keepalive_interval"`
+	KeepaliveTimeout  time.Duration      `mapstructure:"keepalive_timeout"`
+	MaxMessageSize    int                `mapstructure:"max_message_size"`   // Max gRPC message size in bytes, both directions
+	EventDedupWindow  time.Duration      `mapstructure:"event_dedup_window"` // How long event IDs are remembered to drop retried events; 0 disables
+	TLS               TLSConfig          `mapstructure:"tls"`
+	AdminToken        string             `mapstructure:"admin_token"` // Bearer token for /admin endpoints (disabled if empty)
+	HTTPFallback      HTTPFallbackConfig `mapstructure:"http_fallback"`
+}
+
+// HTTPFallbackConfig controls the MIGlet HTTP API under /api/v1/vms/, which accepts the
+// events and heartbeats a MIGlet sends over HTTP when its gRPC stream is down
+type HTTPFallbackConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Token   string `mapstructure:"token"` // Bearer token MIGlets must send; required when enabled
 }
 
 // TLSConfig holds TLS configuration
@@ -72,23 +84,71 @@ type TLSConfig struct {
 type PoolConfig struct {
 	ID          string   `mapstructure:"id"`           // Unique identifier (e.g., "pool-2vcpu-linux-us-central1")
 	Name        string   `mapstructure:"name"`         // Human-readable name
-	Type        string   `mapstructure:"type"`         // Machine type (e.g., "2vcpu", "4vcpu", "8vcpu")
+	Type        string   `mapstructure:"type"`         // Machine type (e.g., "2vcpu", "4vcpu", "highmem")
 	OS          string   `mapstructure:"os"`           // Operating system (linux, windows)
 	Arch        string   `mapstructure:"arch"`         // Architecture (x64, arm64)
 	Region      string   `mapstructure:"region"`       // GCP region
 	Labels      []string `mapstructure:"labels"`       // Default labels for runners
 	RunnerGroup string   `mapstructure:"runner_group"` // GitHub runner group
+
+	// OrgIsolation tags each VM with the org of its first job (or the org its MIGlet
+	// reports on connect) and only assigns it jobs from that org
+	OrgIsolation bool `mapstructure:"org_isolation"`
+
+	// RunnerMode is RunnerModeEphemeral (a fresh runner registration per job),
+	// RunnerModePersistent (runners stay registered, and idle ones are handed jobs directly) or
+	// RunnerModeMediated (persistent runners that only listen to GitHub for jobs the controller grants)
+	RunnerMode string `mapstructure:"runner_mode"`
+
+	// Limits on granting jobs to mediated runners; ignored in other runner modes
+	Dispatch DispatchConfig `mapstructure:"dispatch"`
+
+	// Accepted values for Type: any of AllowedTypes, or a match of TypePattern
+	// Extend these for a new machine family instead of falling back to "custom"
+	AllowedTypes []string `mapstructure:"allowed_types"`
+	TypePattern  string   `mapstructure:"type_pattern"` // Anchored regexp; empty disables
+}
+
+// Runner modes for PoolConfig.RunnerMode
+const (
+	RunnerModeEphemeral  = "ephemeral"
+	RunnerModePersistent = "persistent"
+	RunnerModeMediated   = "mediated"
+)
+
+// PersistentRunners reports whether the pool keeps runners registered between jobs
+func (p *PoolConfig) PersistentRunners() bool {
+	return p.RunnerMode == RunnerModePersistent || p.RunnerMode == RunnerModeMediated
+}
+
+// MediatedDispatch reports whether runners only take jobs the controller grants them
+func (p *PoolConfig) MediatedDispatch() bool {
+	return p.RunnerMode == RunnerModeMediated
+}
+
+// DispatchConfig limits how the controller grants jobs to mediated runners
+type DispatchConfig struct {
+	MaxConcurrentJobs int     `mapstructure:"max_concurrent_jobs"` // Assigned plus running jobs allowed at once (0 = unlimited)
+	GrantRate         float64 `mapstructure:"grant_rate"`          // Grants per second refilling the token bucket (0 = unlimited)
+	GrantBurst        int     `mapstructure:"grant_burst"`         // Token bucket size: grants allowed back to back
 }
 
 // GCPConfig holds GCP-specific configuration
 type GCPConfig struct {
-	ProjectID          string `mapstructure:"project_id"`
-	Zone               string `mapstructure:"zone"`
-	MIGName            string `mapstructure:"mig_name"`
-	NetworkProject     string `mapstructure:"network_project"`      // For shared VPC
-	Network            string `mapstructure:"network"`              // VPC network name
-	Subnetwork         string `mapstructure:"subnetwork"`           // Subnetwork name
-	ServiceAccountPath string `mapstructure:"service_account_path"` // Path to SA key (if not using default)
+	ProjectID          string      `mapstructure:"project_id"`
+	Zone               string      `mapstructure:"zone"`                 // Single-zone shorthand for migs
+	MIGName            string      `mapstructure:"mig_name"`             // Single-zone shorthand for migs
+	MIGs               []MIGTarget `mapstructure:"migs"`                 // Zonal MIGs backing the pool
+	NetworkProject     string      `mapstructure:"network_project"`      // For shared VPC
+	Network            string      `mapstructure:"network"`              // VPC network name
+	Subnetwork         string      `mapstructure:"subnetwork"`           // Subnetwork name
+	ServiceAccountPath string      `mapstructure:"service_account_path"` // Path to SA key (if not using default)
+}
+
+// MIGTarget identifies a zonal MIG that provides VMs for the pool
+type MIGTarget struct {
+	Zone    string `mapstructure:"zone"`
+	MIGName string `mapstructure:"mig_name"`
 }
 
 // GitHubAppConfig holds GitHub App configuration
@@ -98,6 +158,10 @@ type GitHubAppConfig struct {
 	PrivateKey     string `mapstructure:"private_key"` // Direct key value (for K8s secrets)
 	WebhookSecret  string `mapstructure:"webhook_secret"`
 	BaseURL        string `mapstructure:"base_url"` // For GitHub Enterprise
+
+	// Rate limit handling
+	MaxRetries   int           `mapstructure:"max_retries"`    // Retries after a rate-limited response
+	MaxRetryWait time.Duration `mapstructure:"max_retry_wait"` // Longest single wait for a rate limit reset
 }
 
 // RedisConfig holds Redis configuration
@@ -130,6 +194,7 @@ type PubSubConfig struct {
 	MaxOutstandingBytes    int           `mapstructure:"max_outstanding_bytes"`
 	NumGoroutines          int           `mapstructure:"num_goroutines"`
 	AckDeadline            time.Duration `mapstructure:"ack_deadline"`
+	DrainTimeout           time.Duration `mapstructure:"drain_timeout"` // How long Stop waits for in-flight messages
 }
 
 // SchedulerConfig holds scheduler configuration
@@ -137,9 +202,23 @@ type SchedulerConfig struct {
 	PollInterval             time.Duration `mapstructure:"poll_interval"`
 	AssignmentTimeout        time.Duration `mapstructure:"assignment_timeout"`
 	MaxConcurrentAssignments int           `mapstructure:"max_concurrent_assignments"`
-	RetryInterval            time.Duration `mapstructure:"retry_interval"`
+	RetryInterval            time.Duration `mapstructure:"retry_interval"`     // Backoff before a failed job is requeued, doubling per retry (0 requeues immediately)
+	MaxRetryInterval         time.Duration `mapstructure:"max_retry_interval"` // Cap on the requeue backoff
 	MaxRetries               int           `mapstructure:"max_retries"`
-	JobTimeout               time.Duration `mapstructure:"job_timeout"` // Max job duration
+	JobTimeout               time.Duration `mapstructure:"job_timeout"`              // Max job duration
+	FairShare                bool          `mapstructure:"fair_share"`               // Round-robin across orgs with queued jobs
+	ScanDepth                int           `mapstructure:"scan_depth"`               // Queue head entries considered when selecting the next job
+	UnschedulableTimeout     time.Duration `mapstructure:"unschedulable_timeout"`    // Fail jobs whose labels the pool can't satisfy after this long
+	VerifyRunner             bool          `mapstructure:"verify_runner"`            // Confirm registered runners appear on GitHub
+	RunnerVerifyTimeout      time.Duration `mapstructure:"runner_verify_timeout"`    // How long a runner has to appear before its job is requeued
+	StuckJobTimeout          time.Duration `mapstructure:"stuck_job_timeout"`        // Flag running jobs with no runner output for this long (0 disables)
+	PriorityAgingThreshold   time.Duration `mapstructure:"priority_aging_threshold"` // Boost jobs queued longer than this (0 disables aging)
+	PriorityAgingInterval    time.Duration `mapstructure:"priority_aging_interval"`  // Queue time per priority level of boost
+	LeaderElection           bool          `mapstructure:"leader_election"`          // Only the replica holding the Redis lease schedules and maintains VMs
+	LeaderLeaseTTL           time.Duration `mapstructure:"leader_lease_ttl"`         // How long a lease lasts without renewal
+	LeaderRenewInterval      time.Duration `mapstructure:"leader_renew_interval"`    // How often the leader renews (and followers try to take) the lease
+	ClaimLeaseTTL            time.Duration `mapstructure:"claim_lease_ttl"`          // Claimed jobs not assigned or requeued within this are returned to the queue
+	PollJitter               float64       `mapstructure:"poll_jitter"`              // Randomize each scheduling and maintenance interval by up to this percent (0 disables)
 }
 
 // VMManagerConfig holds VM manager configuration
@@ -149,11 +228,22 @@ type VMManagerConfig struct {
 	MaxScaleUpPerMinute int           `mapstructure:"max_scale_up_per_minute"`
 	MinReadyVMs         int           `mapstructure:"min_ready_vms"`
 	MaxVMs              int           `mapstructure:"max_vms"`
-	IdleTimeout         time.Duration `mapstructure:"idle_timeout"`
+	IdleTimeout         time.Duration `mapstructure:"idle_timeout"`  // Stop VMs that have had no job for this long (see VMStatus.IdleSince)
 	BootTimeout         time.Duration `mapstructure:"boot_timeout"`  // Max time for VM to boot
 	DrainTimeout        time.Duration `mapstructure:"drain_timeout"` // Max time to wait for job completion on drain
 	DeleteDelay         time.Duration `mapstructure:"delete_delay"`  // Delay before deleting stopped VMs
 	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	ScaleUpWait         bool          `mapstructure:"scale_up_wait"`         // Wait for resizes to create instances (or fail) before returning
+	ScaleUpWaitTimeout  time.Duration `mapstructure:"scale_up_wait_timeout"` // Max time ScaleUp waits per MIG
+	QuotaBackoff        time.Duration `mapstructure:"quota_backoff"`         // Pause scale-ups this long after GCP reports quota exhausted
+	BreakerThreshold    int           `mapstructure:"breaker_threshold"`     // Consecutive GCP API failures that open the circuit breaker (0 disables)
+	BreakerCooldown     time.Duration `mapstructure:"breaker_cooldown"`      // How long the breaker stays open before probing GCP again
+	MIGSizeCacheTTL     time.Duration `mapstructure:"mig_size_cache_ttl"`    // How long ScaleUp trusts a cached MIG target size (0 disables)
+	DryRun              bool          `mapstructure:"dry_run"`               // Log VM actions and update Redis without calling the GCP API
+	MaxDiskUsage        float64       `mapstructure:"max_disk_usage"`        // Exclude ready/idle VMs reporting disk usage above this percent (0 disables)
+	MaxMemoryUsage      float64       `mapstructure:"max_memory_usage"`      // Exclude ready/idle VMs reporting memory usage above this percent (0 disables)
+	RecycleDegraded     bool          `mapstructure:"recycle_degraded"`      // Delete VMs excluded for resource usage so the MIG replaces them
+	ReconcileInterval   time.Duration `mapstructure:"reconcile_interval"`    // How often the VM state index sets are rebuilt from the VM statuses (0 disables)
 }
 
 // MIGletConfig holds configuration for MIGlet communication
@@ -164,14 +254,18 @@ type MIGletConfig struct {
 	MaxReconnectDelay time.Duration `mapstructure:"max_reconnect_delay"`
 	RunnerInstallPath string        `mapstructure:"runner_install_path"`
 	RunnerVersion     string        `mapstructure:"runner_version"`
+
+	MaxPendingCommands int `mapstructure:"max_pending_commands"` // Commands queued per disconnected VM before new ones are rejected
 }
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
 	Level         string `mapstructure:"level"`
 	Format        string `mapstructure:"format"`
-	OutputPath    string `mapstructure:"output_path"` // File path or "stdout"
+	OutputPath    string `mapstructure:"output_path"` // File path, "stdout" or "stderr"
 	RedactSecrets bool   `mapstructure:"redact_secrets"`
+	MaxSizeMB     int    `mapstructure:"max_size_mb"` // Rotate the log file at this size; 0 disables rotation
+	MaxBackups    int    `mapstructure:"max_backups"` // Rotated log files to keep
 }
 
 // MetricsConfig holds metrics/observability configuration
@@ -179,17 +273,21 @@ type MetricsConfig struct {
 	Enabled      bool          `mapstructure:"enabled"`
 	Port         int           `mapstructure:"port"`
 	Path         string        `mapstructure:"path"`
-	PushGateway  string        `mapstructure:"push_gateway"` // Prometheus push gateway URL
-	PushInterval time.Duration `mapstructure:"push_interval"`
+	PushGateway  string        `mapstructure:"push_gateway"`  // Prometheus push gateway URL; empty disables pushing
+	PushInterval time.Duration `mapstructure:"push_interval"` // How often to push to the gateway
 }
 
 // AlertsConfig holds alerting configuration
 type AlertsConfig struct {
-	Enabled        bool          `mapstructure:"enabled"`
-	SlackWebhook   string        `mapstructure:"slack_webhook"`
-	PagerDutyKey   string        `mapstructure:"pagerduty_key"`
-	EmailRecipient string        `mapstructure:"email_recipient"`
-	AlertCooldown  time.Duration `mapstructure:"alert_cooldown"`
+	Enabled       bool          `mapstructure:"enabled"`
+	SlackWebhook  string        `mapstructure:"slack_webhook"`
+	PagerDutyKey  string        `mapstructure:"pagerduty_key"`
+	AlertCooldown time.Duration `mapstructure:"alert_cooldown"` // Minimum time between repeats of the same alert
+
+	// Thresholds; 0 disables the condition
+	ErrorVMThreshold     int           `mapstructure:"error_vm_threshold"`     // Alert when this many VMs are in error state
+	QueueLengthThreshold int           `mapstructure:"queue_length_threshold"` // Alert when this many jobs are queued
+	AllBusyDuration      time.Duration `mapstructure:"all_busy_duration"`      // Alert when every VM has been busy this long
 }
 
 // Load loads configuration from file and environment variables
@@ -212,6 +310,7 @@ func Load(configPath string) (*Config, error) {
 		if err := v.ReadInConfig(); err != nil {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
+		applyRenamedKeys(v)
 	}
 
 	var cfg Config
@@ -219,6 +318,8 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	normalizeMIGs(&cfg.GCP)
+
 	// Validate required fields
 	if err := validate(&cfg); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
@@ -227,6 +328,21 @@ func Load(configPath string) (*Config, error) {
 	return &cfg, nil
 }
 
+// renamedKeys maps config keys that were renamed to their current names
+var renamedKeys = map[string]string{
+	"scheduler.fair_share_scan_depth": "scheduler.scan_depth",
+}
+
+// applyRenamedKeys lets config files keep using renamed keys
+// The old value only replaces the default, so the new key or its env var still wins
+func applyRenamedKeys(v *viper.Viper) {
+	for oldKey, newKey := range renamedKeys {
+		if v.InConfig(oldKey) && !v.InConfig(newKey) {
+			v.SetDefault(newKey, v.Get(oldKey))
+		}
+	}
+}
+
 func setDefaults(v *viper.Viper) {
 	// Server defaults
 	v.SetDefault("server.grpc_port", 50051)
@@ -235,19 +351,31 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.max_connection_age", "30m")
 	v.SetDefault("server.keepalive_interval", "10s")
 	v.SetDefault("server.keepalive_timeout", "3s")
+	v.SetDefault("server.max_message_size", 4*1024*1024)
+	v.SetDefault("server.event_dedup_window", "10m")
+	v.SetDefault("server.http_fallback.enabled", false)
 	v.SetDefault("server.tls.enabled", false)
 
 	// Pool defaults
 	v.SetDefault("pool.os", "linux")
 	v.SetDefault("pool.arch", "x64")
 	v.SetDefault("pool.runner_group", "default")
+	v.SetDefault("pool.org_isolation", false)
+	v.SetDefault("pool.runner_mode", RunnerModeEphemeral)
+	v.SetDefault("pool.dispatch.max_concurrent_jobs", 0)
+	v.SetDefault("pool.dispatch.grant_rate", 0)
+	v.SetDefault("pool.dispatch.grant_burst", 5)
 	v.SetDefault("pool.labels", []string{"self-hosted"})
+	v.SetDefault("pool.allowed_types", []string{"2vcpu", "4vcpu", "8vcpu", "16vcpu", "custom"})
+	v.SetDefault("pool.type_pattern", `[0-9]+vcpu`)
 
 	// GCP defaults
 	v.SetDefault("gcp.network", "default")
 
 	// GitHub App defaults
 	v.SetDefault("github_app.base_url", "https://api.github.com")
+	v.SetDefault("github_app.max_retries", 3)
+	v.SetDefault("github_app.max_retry_wait", "60s")
 
 	// Redis defaults
 	v.SetDefault("redis.jobs.port", 6379)
@@ -273,14 +401,29 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("pubsub.max_outstanding_bytes", 10485760) // 10MB
 	v.SetDefault("pubsub.num_goroutines", 10)
 	v.SetDefault("pubsub.ack_deadline", "60s")
+	v.SetDefault("pubsub.drain_timeout", "10s")
 
 	// Scheduler defaults
 	v.SetDefault("scheduler.poll_interval", "1s")
 	v.SetDefault("scheduler.assignment_timeout", "5m")
 	v.SetDefault("scheduler.max_concurrent_assignments", 10)
 	v.SetDefault("scheduler.retry_interval", "30s")
+	v.SetDefault("scheduler.max_retry_interval", "10m")
 	v.SetDefault("scheduler.max_retries", 3)
 	v.SetDefault("scheduler.job_timeout", "6h")
+	v.SetDefault("scheduler.fair_share", false)
+	v.SetDefault("scheduler.scan_depth", 100)
+	v.SetDefault("scheduler.unschedulable_timeout", "10m")
+	v.SetDefault("scheduler.verify_runner", false)
+	v.SetDefault("scheduler.runner_verify_timeout", "2m")
+	v.SetDefault("scheduler.stuck_job_timeout", "30m")
+	v.SetDefault("scheduler.priority_aging_threshold", "0s")
+	v.SetDefault("scheduler.priority_aging_interval", "5m")
+	v.SetDefault("scheduler.leader_election", false)
+	v.SetDefault("scheduler.leader_lease_ttl", "15s")
+	v.SetDefault("scheduler.leader_renew_interval", "5s")
+	v.SetDefault("scheduler.claim_lease_ttl", "5m")
+	v.SetDefault("scheduler.poll_jitter", 10)
 
 	// VM Manager defaults
 	v.SetDefault("vm_manager.poll_interval", "30s")
@@ -293,6 +436,17 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("vm_manager.drain_timeout", "30m")
 	v.SetDefault("vm_manager.delete_delay", "1h")
 	v.SetDefault("vm_manager.health_check_interval", "1m")
+	v.SetDefault("vm_manager.scale_up_wait", true)
+	v.SetDefault("vm_manager.scale_up_wait_timeout", "30s")
+	v.SetDefault("vm_manager.quota_backoff", "5m")
+	v.SetDefault("vm_manager.breaker_threshold", 5)
+	v.SetDefault("vm_manager.breaker_cooldown", "1m")
+	v.SetDefault("vm_manager.mig_size_cache_ttl", "30s")
+	v.SetDefault("vm_manager.dry_run", false)
+	v.SetDefault("vm_manager.max_disk_usage", 0)
+	v.SetDefault("vm_manager.max_memory_usage", 0)
+	v.SetDefault("vm_manager.recycle_degraded", false)
+	v.SetDefault("vm_manager.reconcile_interval", "5m")
 
 	// MIGlet defaults
 	v.SetDefault("miglet.command_timeout", "30s")
@@ -301,12 +455,15 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("miglet.max_reconnect_delay", "5m")
 	v.SetDefault("miglet.runner_install_path", "/tmp/miglet-runner")
 	v.SetDefault("miglet.runner_version", "2.329.0")
+	v.SetDefault("miglet.max_pending_commands", 50)
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
 	v.SetDefault("logging.output_path", "stdout")
 	v.SetDefault("logging.redact_secrets", true)
+	v.SetDefault("logging.max_size_mb", 100)
+	v.SetDefault("logging.max_backups", 5)
 
 	// Metrics defaults
 	v.SetDefault("metrics.enabled", true)
@@ -317,16 +474,24 @@ func setDefaults(v *viper.Viper) {
 	// Alerts defaults
 	v.SetDefault("alerts.enabled", false)
 	v.SetDefault("alerts.alert_cooldown", "5m")
+	v.SetDefault("alerts.error_vm_threshold", 3)
+	v.SetDefault("alerts.queue_length_threshold", 50)
+	v.SetDefault("alerts.all_busy_duration", "15m")
 }
 
 func bindEnvVars(v *viper.Viper) {
 	// Server config
 	bindEnv(v, "server.grpc_port", "GRPC_PORT")
 	bindEnv(v, "server.http_port", "HTTP_PORT")
+	bindEnvInt(v, "server.max_message_size", "GRPC_MAX_MESSAGE_SIZE")
+	bindEnv(v, "server.event_dedup_window", "EVENT_DEDUP_WINDOW")
 	bindEnv(v, "server.tls.enabled", "TLS_ENABLED")
 	bindEnv(v, "server.tls.cert_path", "TLS_CERT_PATH")
 	bindEnv(v, "server.tls.key_path", "TLS_KEY_PATH")
 	bindEnv(v, "server.tls.ca_path", "TLS_CA_PATH")
+	bindEnv(v, "server.admin_token", "ADMIN_TOKEN")
+	bindEnvBool(v, "server.http_fallback.enabled", "HTTP_FALLBACK_ENABLED")
+	bindEnv(v, "server.http_fallback.token", "HTTP_FALLBACK_TOKEN")
 
 	// Pool config
 	bindEnv(v, "pool.id", "POOL_ID")
@@ -336,12 +501,20 @@ func bindEnvVars(v *viper.Viper) {
 	bindEnv(v, "pool.arch", "POOL_ARCH")
 	bindEnv(v, "pool.region", "POOL_REGION")
 	bindEnv(v, "pool.runner_group", "POOL_RUNNER_GROUP")
+	bindEnvBool(v, "pool.org_isolation", "POOL_ORG_ISOLATION")
+	bindEnv(v, "pool.runner_mode", "POOL_RUNNER_MODE")
+	bindEnvInt(v, "pool.dispatch.max_concurrent_jobs", "POOL_DISPATCH_MAX_CONCURRENT_JOBS")
+	bindEnv(v, "pool.dispatch.grant_rate", "POOL_DISPATCH_GRANT_RATE")
+	bindEnvInt(v, "pool.dispatch.grant_burst", "POOL_DISPATCH_GRANT_BURST")
 	bindEnvStringSlice(v, "pool.labels", "POOL_LABELS")
+	bindEnvStringSlice(v, "pool.allowed_types", "POOL_ALLOWED_TYPES")
+	bindEnv(v, "pool.type_pattern", "POOL_TYPE_PATTERN")
 
 	// GCP config
 	bindEnv(v, "gcp.project_id", "GCP_PROJECT_ID")
 	bindEnv(v, "gcp.zone", "GCP_ZONE")
 	bindEnv(v, "gcp.mig_name", "GCP_MIG_NAME")
+	bindEnvMIGs(v, "gcp.migs", "GCP_MIGS")
 	bindEnv(v, "gcp.network_project", "GCP_NETWORK_PROJECT")
 	bindEnv(v, "gcp.network", "GCP_NETWORK")
 	bindEnv(v, "gcp.subnetwork", "GCP_SUBNETWORK")
@@ -353,6 +526,8 @@ func bindEnvVars(v *viper.Viper) {
 	bindEnv(v, "github_app.private_key", "GITHUB_APP_PRIVATE_KEY")
 	bindEnv(v, "github_app.webhook_secret", "GITHUB_WEBHOOK_SECRET")
 	bindEnv(v, "github_app.base_url", "GITHUB_BASE_URL")
+	bindEnvInt(v, "github_app.max_retries", "GITHUB_MAX_RETRIES")
+	bindEnv(v, "github_app.max_retry_wait", "GITHUB_MAX_RETRY_WAIT")
 
 	// Redis - Jobs
 	bindEnv(v, "redis.jobs.host", "REDIS_JOBS_HOST")
@@ -372,12 +547,29 @@ func bindEnvVars(v *viper.Viper) {
 	bindEnv(v, "pubsub.project_id", "PUBSUB_PROJECT_ID")
 	bindEnv(v, "pubsub.subscription", "PUBSUB_SUBSCRIPTION")
 	bindEnv(v, "pubsub.topic_id", "PUBSUB_TOPIC_ID")
+	bindEnv(v, "pubsub.drain_timeout", "PUBSUB_DRAIN_TIMEOUT")
 
 	// Scheduler config
 	bindEnv(v, "scheduler.poll_interval", "SCHEDULER_POLL_INTERVAL")
 	bindEnv(v, "scheduler.assignment_timeout", "SCHEDULER_ASSIGNMENT_TIMEOUT")
 	bindEnvInt(v, "scheduler.max_concurrent_assignments", "SCHEDULER_MAX_CONCURRENT")
 	bindEnvInt(v, "scheduler.max_retries", "SCHEDULER_MAX_RETRIES")
+	bindEnv(v, "scheduler.retry_interval", "SCHEDULER_RETRY_INTERVAL")
+	bindEnv(v, "scheduler.max_retry_interval", "SCHEDULER_MAX_RETRY_INTERVAL")
+	bindEnvBool(v, "scheduler.fair_share", "SCHEDULER_FAIR_SHARE")
+	bindEnvInt(v, "scheduler.scan_depth", "SCHEDULER_FAIR_SHARE_SCAN_DEPTH") // Old name, overridden by the new one
+	bindEnvInt(v, "scheduler.scan_depth", "SCHEDULER_SCAN_DEPTH")
+	bindEnv(v, "scheduler.unschedulable_timeout", "SCHEDULER_UNSCHEDULABLE_TIMEOUT")
+	bindEnvBool(v, "scheduler.verify_runner", "SCHEDULER_VERIFY_RUNNER")
+	bindEnv(v, "scheduler.runner_verify_timeout", "SCHEDULER_RUNNER_VERIFY_TIMEOUT")
+	bindEnv(v, "scheduler.stuck_job_timeout", "SCHEDULER_STUCK_JOB_TIMEOUT")
+	bindEnv(v, "scheduler.priority_aging_threshold", "SCHEDULER_PRIORITY_AGING_THRESHOLD")
+	bindEnv(v, "scheduler.priority_aging_interval", "SCHEDULER_PRIORITY_AGING_INTERVAL")
+	bindEnvBool(v, "scheduler.leader_election", "SCHEDULER_LEADER_ELECTION")
+	bindEnv(v, "scheduler.leader_lease_ttl", "SCHEDULER_LEADER_LEASE_TTL")
+	bindEnv(v, "scheduler.leader_renew_interval", "SCHEDULER_LEADER_RENEW_INTERVAL")
+	bindEnv(v, "scheduler.claim_lease_ttl", "SCHEDULER_CLAIM_LEASE_TTL")
+	bindEnv(v, "scheduler.poll_jitter", "SCHEDULER_POLL_JITTER")
 
 	// VM Manager config
 	bindEnv(v, "vm_manager.poll_interval", "VM_POLL_INTERVAL")
@@ -387,27 +579,46 @@ func bindEnvVars(v *viper.Viper) {
 	bindEnvInt(v, "vm_manager.max_vms", "VM_MAX_VMS")
 	bindEnv(v, "vm_manager.idle_timeout", "VM_IDLE_TIMEOUT")
 	bindEnv(v, "vm_manager.boot_timeout", "VM_BOOT_TIMEOUT")
+	bindEnvBool(v, "vm_manager.scale_up_wait", "VM_SCALE_UP_WAIT")
+	bindEnv(v, "vm_manager.scale_up_wait_timeout", "VM_SCALE_UP_WAIT_TIMEOUT")
+	bindEnv(v, "vm_manager.quota_backoff", "VM_QUOTA_BACKOFF")
+	bindEnvInt(v, "vm_manager.breaker_threshold", "VM_BREAKER_THRESHOLD")
+	bindEnv(v, "vm_manager.breaker_cooldown", "VM_BREAKER_COOLDOWN")
+	bindEnv(v, "vm_manager.mig_size_cache_ttl", "VM_MIG_SIZE_CACHE_TTL")
+	bindEnvBool(v, "vm_manager.dry_run", "VM_DRY_RUN")
+	bindEnv(v, "vm_manager.max_disk_usage", "VM_MAX_DISK_USAGE")
+	bindEnv(v, "vm_manager.max_memory_usage", "VM_MAX_MEMORY_USAGE")
+	bindEnvBool(v, "vm_manager.recycle_degraded", "VM_RECYCLE_DEGRADED")
+	bindEnv(v, "vm_manager.reconcile_interval", "VM_RECONCILE_INTERVAL")
 
 	// MIGlet config
 	bindEnv(v, "miglet.command_timeout", "MIGLET_COMMAND_TIMEOUT")
 	bindEnv(v, "miglet.heartbeat_interval", "MIGLET_HEARTBEAT_INTERVAL")
 	bindEnv(v, "miglet.runner_version", "MIGLET_RUNNER_VERSION")
+	bindEnvInt(v, "miglet.max_pending_commands", "MIGLET_MAX_PENDING_COMMANDS")
 
 	// Logging
 	bindEnv(v, "logging.level", "LOG_LEVEL")
 	bindEnv(v, "logging.format", "LOG_FORMAT")
 	bindEnv(v, "logging.output_path", "LOG_OUTPUT")
 	bindEnvBool(v, "logging.redact_secrets", "LOG_REDACT_SECRETS")
+	bindEnvInt(v, "logging.max_size_mb", "LOG_MAX_SIZE_MB")
+	bindEnvInt(v, "logging.max_backups", "LOG_MAX_BACKUPS")
 
 	// Metrics
 	bindEnvBool(v, "metrics.enabled", "METRICS_ENABLED")
 	bindEnvInt(v, "metrics.port", "METRICS_PORT")
 	bindEnv(v, "metrics.push_gateway", "METRICS_PUSH_GATEWAY")
+	bindEnv(v, "metrics.push_interval", "METRICS_PUSH_INTERVAL")
 
 	// Alerts
 	bindEnvBool(v, "alerts.enabled", "ALERTS_ENABLED")
 	bindEnv(v, "alerts.slack_webhook", "ALERTS_SLACK_WEBHOOK")
 	bindEnv(v, "alerts.pagerduty_key", "ALERTS_PAGERDUTY_KEY")
+	bindEnv(v, "alerts.alert_cooldown", "ALERTS_COOLDOWN")
+	bindEnvInt(v, "alerts.error_vm_threshold", "ALERTS_ERROR_VM_THRESHOLD")
+	bindEnvInt(v, "alerts.queue_length_threshold", "ALERTS_QUEUE_LENGTH_THRESHOLD")
+	bindEnv(v, "alerts.all_busy_duration", "ALERTS_ALL_BUSY_DURATION")
 }
 
 // Helper functions for environment variable binding
@@ -445,6 +656,35 @@ func bindEnvStringSlice(v *viper.Viper, key, envKey string) {
 	}
 }
 
+// bindEnvMIGs parses a comma-separated list of zone/mig_name pairs
+func bindEnvMIGs(v *viper.Viper, key, envKey string) {
+	val := os.Getenv("CONTROLLER_" + envKey)
+	if val == "" {
+		return
+	}
+	var migs []map[string]interface{}
+	for _, entry := range strings.Split(val, ",") {
+		zone, name, ok := strings.Cut(strings.TrimSpace(entry), "/")
+		if !ok {
+			continue
+		}
+		migs = append(migs, map[string]interface{}{"zone": zone, "mig_name": name})
+	}
+	v.Set(key, migs)
+}
+
+// normalizeMIGs turns the single zone/mig_name shorthand into a one-element MIG list
+// and keeps Zone/MIGName pointing at the first MIG for code that logs them
+func normalizeMIGs(gcp *GCPConfig) {
+	if len(gcp.MIGs) == 0 && gcp.Zone != "" && gcp.MIGName != "" {
+		gcp.MIGs = []MIGTarget{{Zone: gcp.Zone, MIGName: gcp.MIGName}}
+	}
+	if len(gcp.MIGs) > 0 && gcp.Zone == "" && gcp.MIGName == "" {
+		gcp.Zone = gcp.MIGs[0].Zone
+		gcp.MIGName = gcp.MIGs[0].MIGName
+	}
+}
+
 func validate(cfg *Config) error {
 	// Required fields
 	if cfg.Pool.ID == "" {
@@ -453,11 +693,13 @@ func validate(cfg *Config) error {
 	if cfg.GCP.ProjectID == "" {
 		return fmt.Errorf("gcp.project_id is required (CONTROLLER_GCP_PROJECT_ID)")
 	}
-	if cfg.GCP.Zone == "" {
-		return fmt.Errorf("gcp.zone is required (CONTROLLER_GCP_ZONE)")
+	if len(cfg.GCP.MIGs) == 0 {
+		return fmt.Errorf("gcp.zone and gcp.mig_name (or gcp.migs) are required (CONTROLLER_GCP_ZONE, CONTROLLER_GCP_MIG_NAME)")
 	}
-	if cfg.GCP.MIGName == "" {
-		return fmt.Errorf("gcp.mig_name is required (CONTROLLER_GCP_MIG_NAME)")
+	for i, mig := range cfg.GCP.MIGs {
+		if mig.Zone == "" || mig.MIGName == "" {
+			return fmt.Errorf("gcp.migs[%d] needs both zone and mig_name", i)
+		}
 	}
 	if cfg.GitHubApp.AppID == 0 {
 		return fmt.Errorf("github_app.app_id is required (CONTROLLER_GITHUB_APP_ID)")
@@ -479,9 +721,66 @@ func validate(cfg *Config) error {
 	}
 
 	// Validate pool type
-	validTypes := map[string]bool{"2vcpu": true, "4vcpu": true, "8vcpu": true, "16vcpu": true, "custom": true}
-	if cfg.Pool.Type != "" && !validTypes[cfg.Pool.Type] {
-		return fmt.Errorf("invalid pool.type: %s (valid: 2vcpu, 4vcpu, 8vcpu, 16vcpu, custom)", cfg.Pool.Type)
+	if err := cfg.Pool.validateType(); err != nil {
+		return err
+	}
+
+	// Validate runner group
+	if err := ValidateRunnerGroup(cfg.Pool.RunnerGroup); err != nil {
+		return fmt.Errorf("invalid pool.runner_group: %w", err)
+	}
+
+	switch cfg.Pool.RunnerMode {
+	case RunnerModeEphemeral, RunnerModePersistent, RunnerModeMediated:
+	default:
+		return fmt.Errorf("invalid pool.runner_mode: %s (valid: %s, %s, %s)", cfg.Pool.RunnerMode, RunnerModeEphemeral, RunnerModePersistent, RunnerModeMediated)
+	}
+	if cfg.Pool.Dispatch.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("pool.dispatch.max_concurrent_jobs must be >= 0")
+	}
+	if cfg.Pool.Dispatch.GrantRate < 0 {
+		return fmt.Errorf("pool.dispatch.grant_rate must be >= 0")
+	}
+	if cfg.Pool.Dispatch.GrantRate > 0 && cfg.Pool.Dispatch.GrantBurst < 1 {
+		return fmt.Errorf("pool.dispatch.grant_burst must be >= 1 when grant_rate is set")
+	}
+
+	if cfg.Server.MaxMessageSize <= 0 {
+		return fmt.Errorf("server.max_message_size must be > 0")
+	}
+
+	if cfg.Server.EventDedupWindow < 0 {
+		return fmt.Errorf("server.event_dedup_window must be >= 0")
+	}
+
+	if cfg.Scheduler.RetryInterval < 0 || cfg.Scheduler.MaxRetryInterval < 0 {
+		return fmt.Errorf("scheduler.retry_interval and scheduler.max_retry_interval must be >= 0")
+	}
+
+	if cfg.Scheduler.MaxRetries < 0 {
+		return fmt.Errorf("scheduler.max_retries must be >= 0")
+	}
+
+	if cfg.Scheduler.ScanDepth < 1 {
+		return fmt.Errorf("scheduler.scan_depth must be >= 1")
+	}
+
+	if cfg.Scheduler.PriorityAgingThreshold > 0 && cfg.Scheduler.PriorityAgingInterval <= 0 {
+		return fmt.Errorf("scheduler.priority_aging_interval must be > 0 when priority aging is enabled")
+	}
+
+	if cfg.Scheduler.ClaimLeaseTTL <= 0 {
+		return fmt.Errorf("scheduler.claim_lease_ttl must be > 0")
+	}
+
+	if cfg.Scheduler.PollJitter < 0 || cfg.Scheduler.PollJitter >= 100 {
+		return fmt.Errorf("scheduler.poll_jitter must be >= 0 and < 100")
+	}
+
+	if cfg.Scheduler.LeaderElection {
+		if cfg.Scheduler.LeaderRenewInterval <= 0 || cfg.Scheduler.LeaderRenewInterval >= cfg.Scheduler.LeaderLeaseTTL {
+			return fmt.Errorf("scheduler.leader_renew_interval must be > 0 and shorter than scheduler.leader_lease_ttl")
+		}
 	}
 
 	// Validate VM limits
@@ -491,7 +790,75 @@ func validate(cfg *Config) error {
 	if cfg.VMManager.MaxVMs < cfg.VMManager.MinReadyVMs {
 		return fmt.Errorf("vm_manager.max_vms must be >= min_ready_vms")
 	}
+	if cfg.VMManager.MaxDiskUsage < 0 || cfg.VMManager.MaxDiskUsage > 100 {
+		return fmt.Errorf("vm_manager.max_disk_usage must be between 0 and 100")
+	}
+	if cfg.VMManager.MaxMemoryUsage < 0 || cfg.VMManager.MaxMemoryUsage > 100 {
+		return fmt.Errorf("vm_manager.max_memory_usage must be between 0 and 100")
+	}
+
+	if cfg.VMManager.ReconcileInterval < 0 {
+		return fmt.Errorf("vm_manager.reconcile_interval must be >= 0")
+	}
+
+	if cfg.Server.HTTPFallback.Enabled && cfg.Server.HTTPFallback.Token == "" {
+		return fmt.Errorf("server.http_fallback.token is required when server.http_fallback.enabled is set (CONTROLLER_HTTP_FALLBACK_TOKEN)")
+	}
+
+	if cfg.MIGlet.MaxPendingCommands < 1 {
+		return fmt.Errorf("miglet.max_pending_commands must be >= 1")
+	}
+
+	return nil
+}
+
+// validateType checks Type against AllowedTypes and TypePattern
+func (p *PoolConfig) validateType() error {
+	var pattern *regexp.Regexp
+	if p.TypePattern != "" {
+		var err error
+		pattern, err = regexp.Compile("^(?:" + p.TypePattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pool.type_pattern: %w", err)
+		}
+	}
+
+	if p.Type == "" {
+		return nil
+	}
+	for _, allowed := range p.AllowedTypes {
+		if p.Type == allowed {
+			return nil
+		}
+	}
+	if pattern != nil && pattern.MatchString(p.Type) {
+		return nil
+	}
+	valid := strings.Join(p.AllowedTypes, ", ")
+	if p.TypePattern != "" {
+		valid += fmt.Sprintf(", or matching %q", p.TypePattern)
+	}
+	return fmt.Errorf("invalid pool.type: %s (valid: %s)", p.Type, valid)
+}
 
+// ValidateRunnerGroup checks that a GitHub runner group name is usable with config.sh
+// Names must be non-empty, at most 64 characters, and contain only letters, digits,
+// spaces, '-', '_' and '.'
+func ValidateRunnerGroup(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("runner group name is empty")
+	}
+	if len(name) > 64 {
+		return fmt.Errorf("runner group name too long: %d > 64 characters", len(name))
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == ' ', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("runner group name %q contains invalid character %q", name, r)
+		}
+	}
 	return nil
 }
 
@@ -507,7 +874,7 @@ func (c *Config) GetRedisVMStatusAddr() string {
 
 // GetPoolLabels returns the pool labels with defaults
 func (c *Config) GetPoolLabels() []string {
-	labels := c.Pool.Labels
+	labels := append([]string(nil), c.Pool.Labels...)
 	if len(labels) == 0 {
 		labels = []string{"self-hosted"}
 	}
@@ -524,6 +891,55 @@ func (c *Config) GetPoolLabels() []string {
 	return labels
 }
 
+// RunnerLabels returns the labels to register a runner with: the pool's labels followed
+// by the job's, deduplicated case-insensitively (first spelling wins), so every runner
+// can also serve other jobs routed to the pool
+func (c *Config) RunnerLabels(jobLabels []string) []string {
+	seen := make(map[string]bool)
+	var labels []string
+	for _, label := range append(c.GetPoolLabels(), jobLabels...) {
+		key := strings.ToLower(label)
+		if label == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// MatchesPoolLabels reports whether every label a job requires is offered by this pool
+// Labels are compared case-insensitively, as GitHub does
+func (c *Config) MatchesPoolLabels(jobLabels []string) bool {
+	return len(c.MissingPoolLabels(jobLabels)) == 0
+}
+
+// MissingPoolLabels returns the job labels this pool doesn't offer
+func (c *Config) MissingPoolLabels(jobLabels []string) []string {
+	poolLabels := make(map[string]bool)
+	for _, label := range c.GetPoolLabels() {
+		poolLabels[strings.ToLower(label)] = true
+	}
+
+	var missing []string
+	for _, label := range jobLabels {
+		if !poolLabels[strings.ToLower(label)] {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
+
+// MIGForZone returns the MIG configured in zone
+func (c *Config) MIGForZone(zone string) (MIGTarget, bool) {
+	for _, mig := range c.GCP.MIGs {
+		if mig.Zone == zone {
+			return mig, true
+		}
+	}
+	return MIGTarget{}, false
+}
+
 // IsProduction returns true if running in production mode
 func (c *Config) IsProduction() bool {
 	return c.Logging.Level != "debug" && c.Logging.Format == "json"
@@ -531,6 +947,10 @@ func (c *Config) IsProduction() bool {
 
 // String returns a sanitized string representation of the config
 func (c *Config) String() string {
-	return fmt.Sprintf("Config{Pool: %s, Type: %s, GCP: %s/%s/%s, GitHub App: %d}",
-		c.Pool.ID, c.Pool.Type, c.GCP.ProjectID, c.GCP.Zone, c.GCP.MIGName, c.GitHubApp.AppID)
+	migs := make([]string, len(c.GCP.MIGs))
+	for i, mig := range c.GCP.MIGs {
+		migs[i] = mig.Zone + "/" + mig.MIGName
+	}
+	return fmt.Sprintf("Config{Pool: %s, Type: %s, GCP: %s/[%s], GitHub App: %d}",
+		c.Pool.ID, c.Pool.Type, c.GCP.ProjectID, strings.Join(migs, ","), c.GitHubApp.AppID)
 }
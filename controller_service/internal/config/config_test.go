@@ -0,0 +1,105 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// loadYAML returns a viper with the defaults and env bindings Load uses, reading the given YAML
+func loadYAML(t *testing.T, yaml string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	setDefaults(v)
+	bindEnvVars(v)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	applyRenamedKeys(v)
+	return v
+}
+
+func TestRenamedScanDepthKeyStillApplies(t *testing.T) {
+	v := loadYAML(t, "scheduler:\n  fair_share_scan_depth: 25\n")
+	if got := v.GetInt("scheduler.scan_depth"); got != 25 {
+		t.Fatalf("scan_depth = %d, want 25 from fair_share_scan_depth", got)
+	}
+}
+
+func TestNewScanDepthKeyWinsOverRenamed(t *testing.T) {
+	v := loadYAML(t, "scheduler:\n  fair_share_scan_depth: 25\n  scan_depth: 40\n")
+	if got := v.GetInt("scheduler.scan_depth"); got != 40 {
+		t.Fatalf("scan_depth = %d, want 40", got)
+	}
+}
+
+func TestScanDepthEnvWinsOverRenamedKey(t *testing.T) {
+	t.Setenv("CONTROLLER_SCHEDULER_SCAN_DEPTH", "60")
+	v := loadYAML(t, "scheduler:\n  fair_share_scan_depth: 25\n")
+	if got := v.GetInt("scheduler.scan_depth"); got != 60 {
+		t.Fatalf("scan_depth = %d, want 60 from the env var", got)
+	}
+}
+
+func TestRenamedScanDepthEnvStillApplies(t *testing.T) {
+	t.Setenv("CONTROLLER_SCHEDULER_FAIR_SHARE_SCAN_DEPTH", "30")
+	v := loadYAML(t, "")
+	if got := v.GetInt("scheduler.scan_depth"); got != 30 {
+		t.Fatalf("scan_depth = %d, want 30 from the old env var", got)
+	}
+}
+
+func TestRunnerLabelsPutsPoolLabelsFirst(t *testing.T) {
+	cfg := &Config{}
+	cfg.Pool.Labels = []string{"self-hosted", "gpu"}
+	cfg.Pool.OS = "linux"
+
+	got := cfg.RunnerLabels([]string{"Self-Hosted", "LINUX", "cuda-12", "", "cuda-12"})
+	want := []string{"self-hosted", "gpu", "linux", "cuda-12"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RunnerLabels = %v, want %v", got, want)
+	}
+}
+
+func TestRunnerLabelsDefaultsToSelfHosted(t *testing.T) {
+	cfg := &Config{}
+	if got, want := cfg.RunnerLabels(nil), []string{"self-hosted"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("RunnerLabels(nil) = %v, want %v", got, want)
+	}
+	// The pool's labels aren't modified by merging
+	cfg.Pool.Labels = []string{"gpu"}
+	cfg.RunnerLabels([]string{"cuda-12"})
+	if !reflect.DeepEqual(cfg.Pool.Labels, []string{"gpu"}) {
+		t.Errorf("pool labels = %v after merge, want [gpu]", cfg.Pool.Labels)
+	}
+}
+
+func TestValidateRunnerGroup(t *testing.T) {
+	cases := []struct {
+		name    string
+		group   string
+		wantErr bool
+	}{
+		{name: "default", group: "Default"},
+		{name: "allowed punctuation", group: "gpu runners_v2.prod-east"},
+		{name: "64 characters", group: strings.Repeat("a", 64)},
+		{name: "empty", group: "", wantErr: true},
+		{name: "whitespace only", group: "   ", wantErr: true},
+		{name: "65 characters", group: strings.Repeat("a", 65), wantErr: true},
+		{name: "slash", group: "team/gpu", wantErr: true},
+		{name: "newline", group: "gpu\nrunners", wantErr: true},
+		{name: "non-ASCII letter", group: "grüppe", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRunnerGroup(tc.group)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ValidateRunnerGroup(%q) = %v, want error %v", tc.group, err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// EventIDStore records event IDs shared by all controller replicas
+// Satisfied by *redis.EventDedupStore
+type EventIDStore interface {
+	MarkSeen(ctx context.Context, eventID string, window time.Duration) (bool, error)
+}
+
+// eventIDStoreTimeout bounds each event ID store call, so a slow Redis can't hold up events
+const eventIDStoreTimeout = 2 * time.Second
+
+// eventDedup remembers recently seen event IDs so an event delivered twice (a gRPC send
+// that reached us before the MIGlet fell back to HTTP, or a replay after reconnect)
+// only has its side effects applied once
+// With a store, IDs are shared by all replicas; IDs are kept in memory without one, or
+// while the store fails
+type eventDedup struct {
+	window time.Duration // 0 disables deduplication
+	store  EventIDStore  // Optional
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // Event ID -> when first seen
+	lastSweep time.Time
+}
+
+func newEventDedup(window time.Duration) *eventDedup {
+	return &eventDedup{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// duplicate records eventID and reports whether it was already seen within the window
+// Events without an ID (agents predating event IDs) are never treated as duplicates
+func (d *eventDedup) duplicate(eventID string) bool {
+	if d.window <= 0 || eventID == "" {
+		return false
+	}
+
+	if d.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), eventIDStoreTimeout)
+		first, err := d.store.MarkSeen(ctx, eventID, d.window)
+		cancel()
+		if err == nil {
+			return !first
+		}
+		logger.WithComponent("grpc_server").WithError(err).WithField("event_id", eventID).
+			Warn("Failed to record event ID, deduplicating in memory")
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= d.window {
+		for id, at := range d.seen {
+			if now.Sub(at) >= d.window {
+				delete(d.seen, id)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if at, ok := d.seen[eventID]; ok && now.Sub(at) < d.window {
+		return true
+	}
+	d.seen[eventID] = now
+	return false
+}
@@ -0,0 +1,90 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sharedIDStore is an EventIDStore shared by several dedups, like replicas sharing Redis
+type sharedIDStore struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	err  error
+}
+
+func (s *sharedIDStore) MarkSeen(ctx context.Context, eventID string, window time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.seen[eventID] {
+		return false, nil
+	}
+	s.seen[eventID] = true
+	return true, nil
+}
+
+func TestEventDedupDropsRepeatedID(t *testing.T) {
+	d := newEventDedup(time.Minute)
+	if d.duplicate("event-1") {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.duplicate("event-1") {
+		t.Fatal("second sighting not reported as duplicate")
+	}
+	if d.duplicate("event-2") {
+		t.Fatal("different event reported as duplicate")
+	}
+}
+
+func TestEventDedupForgetsAfterWindow(t *testing.T) {
+	d := newEventDedup(time.Minute)
+	d.duplicate("event-1")
+	d.seen["event-1"] = time.Now().Add(-time.Minute)
+	if d.duplicate("event-1") {
+		t.Fatal("event seen before the window reported as duplicate")
+	}
+}
+
+func TestEventDedupIgnoresMissingIDAndDisabledWindow(t *testing.T) {
+	d := newEventDedup(time.Minute)
+	if d.duplicate("") || d.duplicate("") {
+		t.Fatal("event without an ID reported as duplicate")
+	}
+
+	off := newEventDedup(0)
+	if off.duplicate("event-1") || off.duplicate("event-1") {
+		t.Fatal("duplicate reported with deduplication disabled")
+	}
+}
+
+func TestEventDedupSharesIDsAcrossReplicas(t *testing.T) {
+	store := &sharedIDStore{seen: make(map[string]bool)}
+	grpcReplica := newEventDedup(time.Minute)
+	grpcReplica.store = store
+	httpReplica := newEventDedup(time.Minute)
+	httpReplica.store = store
+
+	if grpcReplica.duplicate("event-1") {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !httpReplica.duplicate("event-1") {
+		t.Fatal("retry on another replica not reported as duplicate")
+	}
+}
+
+func TestEventDedupFallsBackToMemory(t *testing.T) {
+	d := newEventDedup(time.Minute)
+	d.store = &sharedIDStore{err: errors.New("connection refused")}
+
+	if d.duplicate("event-1") {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.duplicate("event-1") {
+		t.Fatal("second sighting not reported as duplicate while the store fails")
+	}
+}
@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// receivingService hands every message received on a stream to received, and the
+// error that ended the stream to done
+type receivingService struct {
+	commands.UnimplementedCommandServiceServer
+	received chan *commands.MIGletMessage
+	done     chan error
+}
+
+func (r *receivingService) StreamCommands(stream commands.CommandService_StreamCommandsServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			r.done <- err
+			return err
+		}
+		r.received <- msg
+	}
+}
+
+// heartbeatOfSize returns a heartbeat message whose encoded size is exactly size bytes
+func heartbeatOfSize(t *testing.T, size int) *commands.MIGletMessage {
+	t.Helper()
+	hb := &commands.Heartbeat{VmId: "vm-1", PoolId: "pool-test"}
+	msg := &commands.MIGletMessage{Message: &commands.MIGletMessage_Heartbeat{Heartbeat: hb}}
+	// Grow the state until the length prefixes settle at the requested size
+	for pad := size - proto.Size(msg); pad != 0; pad = size - proto.Size(msg) {
+		if len(hb.MigletState)+pad < 0 {
+			t.Fatalf("cannot build a %d byte heartbeat", size)
+		}
+		hb.MigletState = strings.Repeat("x", len(hb.MigletState)+pad)
+	}
+	return msg
+}
+
+func TestServerMessageSizeLimit(t *testing.T) {
+	const limit = 64 * 1024
+	cfg := &config.Config{}
+	cfg.Server.MaxMessageSize = limit
+	s := NewServer(cfg, nil)
+
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer(s.serverOptions()...)
+	svc := &receivingService{received: make(chan *commands.MIGletMessage, 1), done: make(chan error, 1)}
+	commands.RegisterCommandServiceServer(grpcServer, svc)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Let oversized messages through so the server's limit is what gets tested
+		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(4*limit)),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := commands.NewCommandServiceClient(conn).StreamCommands(ctx)
+	if err != nil {
+		t.Fatalf("StreamCommands: %v", err)
+	}
+
+	atLimit := heartbeatOfSize(t, limit)
+	if err := stream.Send(atLimit); err != nil {
+		t.Fatalf("Send at the limit: %v", err)
+	}
+	select {
+	case msg := <-svc.received:
+		if got := proto.Size(msg); got != limit {
+			t.Errorf("received a %d byte heartbeat, want %d", got, limit)
+		}
+	case err := <-svc.done:
+		t.Fatalf("heartbeat at the limit ended the stream: %v", err)
+	case <-ctx.Done():
+		t.Fatal("heartbeat at the limit was not received")
+	}
+
+	// Send may report success before the server rejects the message
+	_ = stream.Send(heartbeatOfSize(t, limit+1))
+	select {
+	case msg := <-svc.received:
+		t.Fatalf("received a %d byte heartbeat over the %d byte limit", proto.Size(msg), limit)
+	case err := <-svc.done:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("oversized heartbeat ended the stream with %v, want ResourceExhausted", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("oversized heartbeat was neither received nor rejected")
+	}
+}
@@ -0,0 +1,278 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// pendingStoreTimeout bounds each pending store call, so a slow Redis can't hold up a connect
+const pendingStoreTimeout = 2 * time.Second
+
+// storedQueue is what this replica knows of a VM's queue in the pending store
+type storedQueue struct {
+	depth     int
+	expiresAt time.Time // When the newest entry, and so the whole queue, expires
+}
+
+// PendingCommand represents a command waiting to be sent to a MIGlet
+type PendingCommand struct {
+	Command   *commands.Command
+	CreatedAt time.Time
+	ExpiresAt time.Time // Dropped instead of sent once this has passed
+}
+
+// queueCommand queues a command for delivery when the MIGlet connects
+// The command expires after its timeout (miglet.command_timeout if unset). It is kept in
+// the pending store when one is set, and in memory otherwise or if the store fails.
+// A VM's queue holds at most miglet.max_pending_commands; beyond that commands are
+// rejected with an error wrapping redis.ErrPendingQueueFull. The command was not queued,
+// so callers should treat the VM as unable to take work rather than wait for it
+func (s *Server) queueCommand(vmID string, cmd *commands.Command, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = s.cfg.MIGlet.CommandTimeout
+	}
+	now := time.Now()
+	pending := &PendingCommand{
+		Command:   cmd,
+		CreatedAt: now,
+		ExpiresAt: now.Add(timeout),
+	}
+	log := logger.WithVM(vmID, s.cfg.Pool.ID).WithFields(map[string]interface{}{
+		"command_id":   cmd.Id,
+		"command_type": cmd.Type,
+	})
+
+	limit := s.cfg.MIGlet.MaxPendingCommands
+
+	if s.pendingStore != nil {
+		err := s.storePendingCommand(vmID, pending)
+		if err == nil {
+			return fmt.Errorf("command queued - VM not connected")
+		}
+		if errors.Is(err, redis.ErrPendingQueueFull) {
+			return s.rejectPending(vmID, limit, log)
+		}
+		log.WithError(err).Warn("Failed to persist pending command, queueing in memory")
+	}
+
+	s.pendingCommandsLock.Lock()
+	// Expired commands would only be dropped on reconnect, so they don't hold places
+	queue := s.pendingCommands[vmID][:0]
+	for _, p := range s.pendingCommands[vmID] {
+		if now.Before(p.ExpiresAt) {
+			queue = append(queue, p)
+		}
+	}
+	full := len(queue) >= limit
+	if !full {
+		queue = append(queue, pending)
+	}
+	if len(queue) > 0 {
+		s.pendingCommands[vmID] = queue
+	} else {
+		delete(s.pendingCommands, vmID)
+	}
+	s.pendingCommandsLock.Unlock()
+
+	if full {
+		return s.rejectPending(vmID, limit, log)
+	}
+	return fmt.Errorf("command queued - VM not connected")
+}
+
+// rejectPending counts and logs a command turned away from a full pending queue
+func (s *Server) rejectPending(vmID string, limit int, log *logrus.Entry) error {
+	s.stats.pendingRejected.Add(1)
+	log.WithField("max_pending_commands", limit).Warn("Pending command queue full, rejecting command")
+	return fmt.Errorf("%w: VM %s has %d commands waiting for its MIGlet", redis.ErrPendingQueueFull, vmID, limit)
+}
+
+// storePendingCommand writes a pending command to the pending store and records the
+// VM's queue depth for GetStats
+func (s *Server) storePendingCommand(vmID string, pending *PendingCommand) error {
+	data, err := proto.Marshal(pending.Command)
+	if err != nil {
+		return fmt.Errorf("failed to marshal command: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pendingStoreTimeout)
+	defer cancel()
+
+	depth, err := s.pendingStore.Push(ctx, vmID, &redis.PendingCommandEntry{
+		CommandID:   pending.Command.Id,
+		CommandType: pending.Command.Type,
+		Command:     data,
+		QueuedAt:    pending.CreatedAt,
+		ExpiresAt:   pending.ExpiresAt,
+	}, s.cfg.MIGlet.MaxPendingCommands)
+	if err != nil && !errors.Is(err, redis.ErrPendingQueueFull) {
+		return err
+	}
+
+	s.pendingCommandsLock.Lock()
+	queue := s.storedPending[vmID]
+	queue.depth = depth
+	if err == nil && pending.ExpiresAt.After(queue.expiresAt) {
+		queue.expiresAt = pending.ExpiresAt
+	}
+	s.storedPending[vmID] = queue
+	s.pendingCommandsLock.Unlock()
+	return err
+}
+
+// takePendingCommands removes and returns the VM's pending commands, oldest first
+// Both the pending store and the in-memory queue are drained, since commands fall back
+// to memory when the store is unavailable
+func (s *Server) takePendingCommands(vmID string) []*PendingCommand {
+	s.pendingCommandsLock.Lock()
+	pending := s.pendingCommands[vmID]
+	delete(s.pendingCommands, vmID)
+	delete(s.storedPending, vmID)
+	s.pendingCommandsLock.Unlock()
+
+	if s.pendingStore == nil {
+		return pending
+	}
+
+	log := logger.WithVM(vmID, s.cfg.Pool.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pendingStoreTimeout)
+	defer cancel()
+
+	entries, err := s.pendingStore.Take(ctx, vmID)
+	if err != nil {
+		log.WithError(err).Warn("Failed to load pending commands from store")
+		return pending
+	}
+
+	for _, entry := range entries {
+		cmd := &commands.Command{}
+		if err := proto.Unmarshal(entry.Command, cmd); err != nil {
+			log.WithError(err).WithField("command_id", entry.CommandID).Warn("Skipping undecodable pending command")
+			continue
+		}
+		pending = append(pending, &PendingCommand{
+			Command:   cmd,
+			CreatedAt: entry.QueuedAt,
+			ExpiresAt: entry.ExpiresAt,
+		})
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	return pending
+}
+
+// sendPendingCommands sends any pending commands to a newly connected MIGlet
+// The queue has already been taken from the store, so if a send fails the stream is
+// broken; that command and the rest are queued again for the MIGlet's next connect
+func (s *Server) sendPendingCommands(conn *MIGletConnection) {
+	log := conn.log
+
+	now := time.Now()
+	pending := s.takePendingCommands(conn.VMID)
+	for i, p := range pending {
+		cmdLog := log.WithFields(map[string]interface{}{
+			"command_id":   p.Command.Id,
+			"command_type": p.Command.Type,
+		})
+
+		if now.After(p.ExpiresAt) {
+			cmdLog.WithField("queued_for", now.Sub(p.CreatedAt).String()).Info("Dropping expired pending command")
+			continue
+		}
+
+		if s.checkPending != nil {
+			if err := s.checkPending(conn.VMID, p.Command); err != nil {
+				cmdLog.WithError(err).Info("Dropping stale pending command")
+				continue
+			}
+		}
+
+		s.connectionsLock.RLock()
+		supported, version := conn.Supports(p.Command.Type), conn.Version
+		s.connectionsLock.RUnlock()
+		if !supported {
+			cmdLog.WithField("miglet_version", version).Warn("Dropping pending command the MIGlet does not support")
+			continue
+		}
+
+		msg := &commands.ControllerMessage{
+			Message: &commands.ControllerMessage_Command{
+				Command: p.Command,
+			},
+		}
+
+		if err := conn.Send(msg); err != nil {
+			cmdLog.WithError(err).Warn("Failed to send pending command, queueing it again")
+			s.requeuePendingCommands(conn.VMID, pending[i:], now)
+			return
+		}
+
+		s.stats.commandsSent.Add(1)
+		cmdLog.Info("Sent pending command")
+	}
+}
+
+// requeuePendingCommands puts commands that could not be sent back in the VM's queue,
+// keeping their original expiry; expired ones are dropped. They were already accepted
+// once, so they go to memory rather than being lost when the store is full or unavailable
+func (s *Server) requeuePendingCommands(vmID string, pending []*PendingCommand, now time.Time) {
+	log := logger.WithVM(vmID, s.cfg.Pool.ID)
+
+	var unstored []*PendingCommand
+	for _, p := range pending {
+		if now.After(p.ExpiresAt) {
+			continue
+		}
+		if s.pendingStore != nil {
+			err := s.storePendingCommand(vmID, p)
+			if err == nil {
+				continue
+			}
+			log.WithError(err).WithField("command_id", p.Command.Id).Warn("Failed to persist requeued command, queueing in memory")
+		}
+		unstored = append(unstored, p)
+	}
+	if len(unstored) == 0 {
+		return
+	}
+
+	s.pendingCommandsLock.Lock()
+	defer s.pendingCommandsLock.Unlock()
+	// Ahead of anything queued since, so the MIGlet still gets commands in order
+	s.pendingCommands[vmID] = append(unstored, s.pendingCommands[vmID]...)
+}
+
+// pendingDepths returns the number of commands queued for each VM, for GetStats
+// Queues in the pending store are counted as of this replica's last push, until the
+// queue has expired, so a scrape doesn't read Redis
+func (s *Server) pendingDepths() map[string]int64 {
+	now := time.Now()
+	depths := make(map[string]int64)
+
+	s.pendingCommandsLock.Lock()
+	defer s.pendingCommandsLock.Unlock()
+	for vmID, queue := range s.pendingCommands {
+		depths[vmID] += int64(len(queue))
+	}
+	for vmID, queue := range s.storedPending {
+		if !now.Before(queue.expiresAt) {
+			delete(s.storedPending, vmID)
+			continue
+		}
+		depths[vmID] += int64(queue.depth)
+	}
+	return depths
+}
@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// brokenStream is a MIGlet stream whose sends fail after the first ok ones
+type brokenStream struct {
+	commands.CommandService_StreamCommandsServer // Only Send is used
+	ok                                           int
+	attempts                                     int
+	sent                                         []string // Command IDs sent successfully
+}
+
+func (b *brokenStream) Send(msg *commands.ControllerMessage) error {
+	b.attempts++
+	if b.attempts > b.ok {
+		return errors.New("stream closed")
+	}
+	b.sent = append(b.sent, msg.GetCommand().GetId())
+	return nil
+}
+
+func TestSendPendingCommandsRequeuesAfterSendFailure(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	cfg := &config.Config{}
+	cfg.MIGlet.MaxPendingCommands = 10
+	s := NewServer(cfg, nil)
+
+	expiresAt := make(map[string]time.Time)
+	for i := 1; i <= 3; i++ {
+		cmd := &commands.Command{Id: fmt.Sprintf("cmd-%d", i), Type: "register_runner"}
+		_ = s.queueCommand("vm-1", cmd, time.Duration(i)*time.Minute)
+		expiresAt[cmd.Id] = s.pendingCommands["vm-1"][i-1].ExpiresAt
+	}
+
+	stream := &brokenStream{ok: 1}
+	addTestConnection(s, "vm-1", stream)
+	s.sendPendingCommands(s.connections["vm-1"])
+
+	if stream.attempts != 2 {
+		t.Errorf("Send called %d times, want 2 (stop after the first failure)", stream.attempts)
+	}
+	if len(stream.sent) != 1 || stream.sent[0] != "cmd-1" {
+		t.Errorf("sent %v, want [cmd-1]", stream.sent)
+	}
+
+	queue := s.pendingCommands["vm-1"]
+	if len(queue) != 2 {
+		t.Fatalf("requeued %d commands, want 2", len(queue))
+	}
+	for i, want := range []string{"cmd-2", "cmd-3"} {
+		p := queue[i]
+		if p.Command.Id != want {
+			t.Errorf("queue[%d] = %s, want %s", i, p.Command.Id, want)
+		}
+		if !p.ExpiresAt.Equal(expiresAt[want]) {
+			t.Errorf("%s expires at %v, want the original %v", want, p.ExpiresAt, expiresAt[want])
+		}
+	}
+
+	// The next connect delivers the requeued commands in order
+	next := &brokenStream{ok: 10}
+	addTestConnection(s, "vm-1", next)
+	s.sendPendingCommands(s.connections["vm-1"])
+	if len(next.sent) != 2 || next.sent[0] != "cmd-2" || next.sent[1] != "cmd-3" {
+		t.Errorf("sent on reconnect %v, want [cmd-2 cmd-3]", next.sent)
+	}
+}
+
+func TestRequeuePendingCommandsKeepsThemAheadOfNewOnes(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	cfg := &config.Config{}
+	cfg.MIGlet.MaxPendingCommands = 10
+	s := NewServer(cfg, nil)
+
+	_ = s.queueCommand("vm-1", &commands.Command{Id: "cmd-new", Type: "register_runner"}, time.Minute)
+
+	now := time.Now()
+	s.requeuePendingCommands("vm-1", []*PendingCommand{
+		{Command: &commands.Command{Id: "cmd-expired"}, CreatedAt: now.Add(-2 * time.Minute), ExpiresAt: now.Add(-time.Minute)},
+		{Command: &commands.Command{Id: "cmd-old"}, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Minute)},
+	}, now)
+
+	queue := s.pendingCommands["vm-1"]
+	if len(queue) != 2 || queue[0].Command.Id != "cmd-old" || queue[1].Command.Id != "cmd-new" {
+		var ids []string
+		for _, p := range queue {
+			ids = append(ids, p.Command.Id)
+		}
+		t.Errorf("queue = %v, want [cmd-old cmd-new]", ids)
+	}
+}
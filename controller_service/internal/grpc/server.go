@@ -2,14 +2,19 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	"github.com/monkci/mig-controller/internal/config"
 	"github.com/monkci/mig-controller/internal/redis"
@@ -19,6 +24,7 @@ import (
 
 // MIGletConnection represents an active connection from a MIGlet
 type MIGletConnection struct {
+	ID          string // Correlation ID of the stream, logged as connection_id
 	VMID        string
 	PoolID      string
 	OrgID       string
@@ -27,13 +33,79 @@ type MIGletConnection struct {
 	RunnerState string
 	ConnectedAt time.Time
 	LastSeen    time.Time
+
+	Version      string   // MIGlet build version reported on connect
+	Capabilities []string // Command types the MIGlet handles; empty for legacy agents
+
+	log          *logrus.Entry // Tagged with connection_id, vm_id and pool_id
+	replaced     chan struct{} // Closed when a newer stream for the same VM takes over
+	evicted      chan struct{} // Closed by CloseStreams when this replica stops serving MIGlets
+	evictOnce    sync.Once
+	stateChanged chan struct{} // Closed and replaced whenever MigletState changes or the stream goes away
+
+	sendMu sync.Mutex // Serializes Stream.Send, which gRPC doesn't allow concurrently
+}
+
+// Send sends msg on the connection's stream
+// Safe for concurrent use: commands from different callers go out one at a time,
+// in the order they take the lock
+func (c *MIGletConnection) Send(msg *commands.ControllerMessage) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.Stream.Send(msg)
+}
+
+// waitForStatePollInterval is how often WaitForState checks Redis for a VM with no stream
+const waitForStatePollInterval = 5 * time.Second
+
+// ErrCommandTimeout is returned when a sent command isn't acked in time
+// The MIGlet may still be processing it, so callers shouldn't assume it failed
+var ErrCommandTimeout = errors.New("command timeout")
+
+// ErrCommandUnsupported is returned (wrapped) when the connected MIGlet doesn't handle
+// the command type; the command was not sent
+var ErrCommandUnsupported = errors.New("command not supported by MIGlet")
+
+// legacyCapabilities are the commands every MIGlet handles, assumed for agents
+// that connect without reporting capabilities
+var legacyCapabilities = []string{"register_runner"}
+
+// notifyStateChange wakes everyone waiting on this connection's state
+// Caller must hold connectionsLock for writing
+func (c *MIGletConnection) notifyStateChange() {
+	close(c.stateChanged)
+	c.stateChanged = make(chan struct{})
+}
+
+// Supports reports whether the MIGlet on this connection handles cmdType
+// Caller must hold connectionsLock, since a repeated Connect updates the capabilities
+func (c *MIGletConnection) Supports(cmdType string) bool {
+	capabilities := c.Capabilities
+	if len(capabilities) == 0 {
+		capabilities = legacyCapabilities
+	}
+	for _, capability := range capabilities {
+		if capability == cmdType {
+			return true
+		}
+	}
+	return false
+}
+
+// VMStateStore is the VM status persistence the server uses
+// *redis.VMStatusStore implements it against Redis, and *redis.MemoryVMStatusStore in memory for tests
+type VMStateStore interface {
+	Get(ctx context.Context, vmID string) (*redis.VMStatus, error)
+	SetConnected(ctx context.Context, vmID string, connected bool) error
+	SetAgentInfo(ctx context.Context, vmID, version string, capabilities []string, orgID string) error
+	UpdateFromHeartbeat(ctx context.Context, vmID string, migletState redis.MigletState, runnerState redis.RunnerState, cpuUsage, memoryUsage, diskUsage float64, currentJobID string) error
+	Ping(ctx context.Context) error
 }
 
-// PendingCommand represents a command waiting to be sent to a MIGlet
-type PendingCommand struct {
-	Command   *commands.Command
-	ResultCh  chan *commands.CommandAck
-	CreatedAt time.Time
+// CommandAuditor records command lifecycle events
+// *redis.AuditStore implements it with a Redis stream
+type CommandAuditor interface {
+	Record(ctx context.Context, entry *redis.CommandAuditEntry) error
 }
 
 // Server implements the gRPC CommandService
@@ -44,31 +116,99 @@ type Server struct {
 	// Active connections
 	connections     map[string]*MIGletConnection // vmID -> connection
 	connectionsLock sync.RWMutex
+	connected       chan struct{} // Closed and replaced whenever a new connection registers
 
-	// Pending commands (waiting for MIGlet to connect)
+	// Pending commands (waiting for MIGlet to connect), used when no pending store is set
 	pendingCommands     map[string][]*PendingCommand // vmID -> commands
+	storedPending       map[string]storedQueue       // vmID -> its queue in the pending store, as of this replica's last push
 	pendingCommandsLock sync.Mutex
 
 	// Command acknowledgments
 	commandAcks     map[string]chan *commands.CommandAck // commandID -> ack channel
 	commandAcksLock sync.Mutex
 
-	// VM status store
-	vmStore *redis.VMStatusStore
+	// VM status store, and whether it is taking writes
+	vmStore       VMStateStore
+	vmStoreHealth *storeHealth
+
+	// Command audit log (optional)
+	auditStore CommandAuditor
+
+	// Persistent pending command queue (optional)
+	pendingStore *redis.PendingCommandStore
+
+	// Connection lifecycle counters, served by GetStats
+	stats *serverStats
+
+	// Recently seen event IDs, so retried events are handled once
+	eventDedup *eventDedup
+
+	// Reports whether this replica may serve MIGlet streams; nil serves all
+	acceptStreams func() bool
 
 	// Callbacks
 	onHeartbeat func(vmID string, heartbeat *commands.Heartbeat)
 	onEvent     func(vmID string, event *commands.EventNotification)
+
+	// Reports why a pending command should no longer be sent; nil sends all
+	checkPending func(vmID string, cmd *commands.Command) error
+
+	// Underlying gRPC server, set by Start
+	grpcServer     *grpc.Server
+	grpcServerLock sync.Mutex
+	shutdown       chan struct{} // Closed by Stop to end all open streams
+	shutdownOnce   sync.Once
 }
 
 // NewServer creates a new gRPC server
-func NewServer(cfg *config.Config, vmStore *redis.VMStatusStore) *Server {
+func NewServer(cfg *config.Config, vmStore VMStateStore) *Server {
 	return &Server{
 		cfg:             cfg,
 		connections:     make(map[string]*MIGletConnection),
+		connected:       make(chan struct{}),
 		pendingCommands: make(map[string][]*PendingCommand),
+		storedPending:   make(map[string]storedQueue),
 		commandAcks:     make(map[string]chan *commands.CommandAck),
 		vmStore:         vmStore,
+		vmStoreHealth:   &storeHealth{},
+		stats:           newServerStats(),
+		eventDedup:      newEventDedup(cfg.Server.EventDedupWindow),
+		shutdown:        make(chan struct{}),
+	}
+}
+
+// SetAuditStore enables recording every sent command and its outcome in the audit log
+func (s *Server) SetAuditStore(store CommandAuditor) {
+	s.auditStore = store
+}
+
+// SetPendingCommandStore keeps commands for disconnected VMs in Redis instead of memory,
+// so they are still delivered after a controller restart
+func (s *Server) SetPendingCommandStore(store *redis.PendingCommandStore) {
+	s.pendingStore = store
+}
+
+// SetEventIDStore shares seen event IDs with the other replicas, so an event retried
+// over HTTP to a different replica is still dropped
+func (s *Server) SetEventIDStore(store EventIDStore) {
+	s.eventDedup.store = store
+}
+
+// SetStreamGate makes the server refuse MIGlet streams while accept returns false
+// Commands only reach MIGlets streaming to the replica that sends them, so with leader
+// election every MIGlet must stream to the leader; refused MIGlets retry with backoff
+// until they reach it
+func (s *Server) SetStreamGate(accept func() bool) {
+	s.acceptStreams = accept
+}
+
+// CloseStreams ends every open MIGlet stream, e.g. when this replica loses leadership,
+// so the MIGlets reconnect to the replica that now sends their commands
+func (s *Server) CloseStreams() {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+	for _, conn := range s.connections {
+		conn.evictOnce.Do(func() { close(conn.evicted) })
 	}
 }
 
@@ -82,6 +222,13 @@ func (s *Server) SetEventCallback(cb func(vmID string, event *commands.EventNoti
 	s.onEvent = cb
 }
 
+// SetPendingCommandCheck sets a check run on each pending command before it is sent to a
+// reconnected MIGlet; commands it returns an error for are dropped, e.g. a register_runner
+// whose job has since been requeued
+func (s *Server) SetPendingCommandCheck(check func(vmID string, cmd *commands.Command) error) {
+	s.checkPending = check
+}
+
 // Start starts the gRPC server
 func (s *Server) Start(port int) error {
 	log := logger.WithComponent("grpc_server")
@@ -91,7 +238,22 @@ func (s *Server) Start(port int) error {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	grpcServer := grpc.NewServer(
+	grpcServer := grpc.NewServer(s.serverOptions()...)
+
+	commands.RegisterCommandServiceServer(grpcServer, s)
+
+	s.grpcServerLock.Lock()
+	s.grpcServer = grpcServer
+	s.grpcServerLock.Unlock()
+
+	log.WithField("port", port).Info("gRPC server starting")
+	return grpcServer.Serve(lis)
+}
+
+// serverOptions returns the gRPC server options: credentials, keepalive and the
+// message size limit shared with MIGlets
+func (s *Server) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
 		grpc.Creds(insecure.NewCredentials()), // TODO: Add TLS
 		grpc.KeepaliveParams(keepalive.ServerParameters{
 			MaxConnectionIdle:     15 * time.Minute,
@@ -104,53 +266,146 @@ func (s *Server) Start(port int) error {
 			MinTime:             5 * time.Second,
 			PermitWithoutStream: true,
 		}),
-	)
+		// MIGlets truncate log tails and event data to fit the same limit
+		grpc.MaxRecvMsgSize(s.cfg.Server.MaxMessageSize),
+		grpc.MaxSendMsgSize(s.cfg.Server.MaxMessageSize),
+	}
+}
 
-	commands.RegisterCommandServiceServer(grpcServer, s)
+// Stop ends all MIGlet streams and gracefully stops the gRPC server
+// If in-flight RPCs don't finish before ctx is done, the server is stopped forcibly
+func (s *Server) Stop(ctx context.Context) {
+	log := logger.WithComponent("grpc_server")
 
-	log.WithField("port", port).Info("gRPC server starting")
-	return grpcServer.Serve(lis)
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+
+	s.grpcServerLock.Lock()
+	grpcServer := s.grpcServer
+	s.grpcServerLock.Unlock()
+	if grpcServer == nil {
+		return
+	}
+
+	log.WithField("connections", s.GetConnectionCount()).Info("gRPC server stopping")
+
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("gRPC server stopped")
+	case <-ctx.Done():
+		log.Warn("gRPC graceful stop timed out, forcing stop")
+		grpcServer.Stop()
+	}
 }
 
 // StreamCommands handles bidirectional streaming with MIGlets
 func (s *Server) StreamCommands(stream commands.CommandService_StreamCommandsServer) error {
-	log := logger.WithComponent("grpc_server")
+	// Every line logged for this stream and the commands sent on it carries connID,
+	// so one connection can be followed through the controller's logs
+	connID := uuid.NewString()
+	log := logger.WithComponent("grpc_server").WithField("connection_id", connID)
 
-	var vmID, poolID, orgID string
-	var connected bool
+	var vmID, poolID string
+	var conn *MIGletConnection
 
 	defer func() {
-		if connected {
-			s.handleDisconnect(vmID)
+		if conn != nil {
+			s.handleDisconnect(vmID, conn)
+		}
+	}()
+
+	// Receive in a separate goroutine so a replaced stream can be closed while Recv blocks
+	type received struct {
+		msg *commands.MIGletMessage
+		err error
+	}
+	recvCh := make(chan received)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			select {
+			case recvCh <- received{msg, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
 		}
 	}()
 
 	for {
-		msg, err := stream.Recv()
-		if err != nil {
-			if connected {
-				log.WithError(err).WithField("vm_id", vmID).Warn("Stream error")
+		var replaced, evicted <-chan struct{}
+		if conn != nil {
+			replaced = conn.replaced
+			evicted = conn.evicted
+		}
+
+		var msg *commands.MIGletMessage
+		select {
+		case <-s.shutdown:
+			// MIGlets reconnect (to this or another replica) with backoff
+			return nil
+		case <-replaced:
+			// Returning ends this stream; the newer one owns the connection entry
+			log.Info("Closing stream superseded by a newer connection")
+			return nil
+		case <-evicted:
+			log.Info("Closing stream, this replica no longer serves MIGlets")
+			return status.Error(codes.Unavailable, "replica is not the scheduling leader")
+		case r := <-recvCh:
+			if r.err != nil {
+				if conn != nil {
+					log.WithError(r.err).Warn("Stream error")
+				}
+				return r.err
 			}
-			return err
+			msg = r.msg
 		}
+		connected := conn != nil
 
 		switch m := msg.Message.(type) {
 		case *commands.MIGletMessage_Connect:
+			if m.Connect.VmId == "" {
+				s.stats.rejectedConnects.Add(1)
+				log.Warn("Rejecting MIGlet connect without a VM ID")
+				stream.Send(&commands.ControllerMessage{
+					Message: &commands.ControllerMessage_ConnectAck{
+						ConnectAck: &commands.ConnectAck{
+							Accepted: false,
+							Message:  "vm_id is required",
+						},
+					},
+				})
+				return fmt.Errorf("connect rejected: vm_id is required")
+			}
+			if s.acceptStreams != nil && !s.acceptStreams() {
+				// Unavailable rather than a rejected ack, so the MIGlet retries with backoff
+				s.stats.followerRefusals.Add(1)
+				log.WithField("vm_id", m.Connect.VmId).Debug("Refusing MIGlet stream, this replica is not the leader")
+				return status.Error(codes.Unavailable, "replica is not the scheduling leader")
+			}
 			vmID = m.Connect.VmId
 			poolID = m.Connect.PoolId
-			orgID = m.Connect.OrgId
+			log = logger.WithVM(vmID, poolID).WithFields(map[string]interface{}{
+				"component":     "grpc_server",
+				"connection_id": connID,
+			})
 
 			log.WithFields(map[string]interface{}{
-				"vm_id":   vmID,
-				"pool_id": poolID,
-				"version": m.Connect.Version,
+				"version":      m.Connect.Version,
+				"capabilities": m.Connect.Capabilities,
 			}).Info("MIGlet connected")
 
-			// Register connection
-			s.handleConnect(vmID, poolID, orgID, stream)
-			connected = true
-
-			// Send connect acknowledgment
+			// Send connect acknowledgment before registering the connection, so no command
+			// can reach the MIGlet ahead of it. Once registered, sends go through conn.Send
 			ack := &commands.ControllerMessage{
 				Message: &commands.ControllerMessage_ConnectAck{
 					ConnectAck: &commands.ConnectAck{
@@ -160,85 +415,145 @@ func (s *Server) StreamCommands(stream commands.CommandService_StreamCommandsSer
 					},
 				},
 			}
-			if err := stream.Send(ack); err != nil {
+			var err error
+			if connected {
+				err = conn.Send(ack) // Repeated connect on a registered stream
+			} else {
+				err = stream.Send(ack)
+			}
+			if err != nil {
 				log.WithError(err).Warn("Failed to send connect ack")
 				return err
 			}
 
+			// Register connection
+			conn = s.handleConnect(m.Connect, stream, connID, log)
+
 			// Send any pending commands
-			s.sendPendingCommands(vmID, stream)
+			s.sendPendingCommands(conn)
 
 		case *commands.MIGletMessage_Heartbeat:
 			if !connected {
 				continue
 			}
-			s.handleHeartbeat(vmID, m.Heartbeat)
+			s.handleHeartbeat(vmID, m.Heartbeat, log)
 
 		case *commands.MIGletMessage_CommandAck:
 			if !connected {
 				continue
 			}
-			s.handleCommandAck(m.CommandAck)
+			s.handleCommandAck(m.CommandAck, log)
 
 		case *commands.MIGletMessage_Event:
 			if !connected {
 				continue
 			}
-			s.handleEvent(vmID, m.Event)
+			s.handleEvent(vmID, m.Event, log)
 
 		case *commands.MIGletMessage_Error:
 			if !connected {
 				continue
 			}
 			log.WithFields(map[string]interface{}{
-				"vm_id": vmID,
-				"code":  m.Error.Code,
-				"msg":   m.Error.Message,
+				"code": m.Error.Code,
+				"msg":  m.Error.Message,
 			}).Warn("Received error from MIGlet")
 		}
 	}
 }
 
-// handleConnect registers a new connection
-func (s *Server) handleConnect(vmID, poolID, orgID string, stream commands.CommandService_StreamCommandsServer) {
+// handleConnect registers a connection and returns it
+// A Connect for a VM that is already connected on another stream replaces that stream;
+// a repeated Connect on the same stream keeps the existing entry
+// log is the stream's logger, kept on the connection for everything logged about it
+func (s *Server) handleConnect(req *commands.ConnectRequest, stream commands.CommandService_StreamCommandsServer, connID string, log *logrus.Entry) *MIGletConnection {
+	vmID := req.VmId
+
 	s.connectionsLock.Lock()
 	defer s.connectionsLock.Unlock()
 
-	s.connections[vmID] = &MIGletConnection{
-		VMID:        vmID,
-		PoolID:      poolID,
-		OrgID:       orgID,
-		Stream:      stream,
-		ConnectedAt: time.Now(),
-		LastSeen:    time.Now(),
+	if existing, ok := s.connections[vmID]; ok {
+		if existing.Stream == stream {
+			existing.OrgID = req.OrgId
+			existing.Version = req.Version
+			existing.Capabilities = req.Capabilities
+			existing.LastSeen = time.Now()
+			return existing // Same stream, so existing.log already carries connID
+		}
+		log.WithField("replaced_connection_id", existing.ID).Info("MIGlet reconnected while an older stream was still open, replacing it")
+		close(existing.replaced)
+		existing.notifyStateChange()
+		s.stats.replaced.Add(1)
+		s.stats.observeConnection(time.Since(existing.ConnectedAt))
 	}
 
+	conn := &MIGletConnection{
+		ID:           connID,
+		VMID:         vmID,
+		PoolID:       req.PoolId,
+		OrgID:        req.OrgId,
+		Stream:       stream,
+		ConnectedAt:  time.Now(),
+		LastSeen:     time.Now(),
+		Version:      req.Version,
+		Capabilities: req.Capabilities,
+		log:          log,
+		replaced:     make(chan struct{}),
+		evicted:      make(chan struct{}),
+		stateChanged: make(chan struct{}),
+	}
+	s.connections[vmID] = conn
+	s.stats.connects.Add(1)
+	close(s.connected)
+	s.connected = make(chan struct{})
+
 	// Update VM status
 	ctx := context.Background()
-	s.vmStore.SetConnected(ctx, vmID, true)
+	s.observeVMStore(log, "set_connected", s.vmStore.SetConnected(ctx, vmID, true))
+	s.observeVMStore(log, "set_agent_info", s.vmStore.SetAgentInfo(ctx, vmID, req.Version, req.Capabilities, req.OrgId))
+
+	return conn
 }
 
 // handleDisconnect handles a disconnection
-func (s *Server) handleDisconnect(vmID string) {
-	log := logger.WithVM(vmID, s.cfg.Pool.ID)
-	log.Info("MIGlet disconnected")
+// The entry is only removed if it still belongs to conn, so a stale stream
+// closing late can't mark a reconnected VM as disconnected
+func (s *Server) handleDisconnect(vmID string, conn *MIGletConnection) {
+	log := conn.log
 
 	s.connectionsLock.Lock()
-	delete(s.connections, vmID)
+	current, ok := s.connections[vmID]
+	owned := ok && current == conn
+	if owned {
+		delete(s.connections, vmID)
+		conn.notifyStateChange()
+	}
 	s.connectionsLock.Unlock()
 
+	if !owned {
+		log.Debug("Stale stream closed, VM is connected on a newer stream")
+		return
+	}
+
+	s.stats.disconnects.Add(1)
+	s.stats.observeConnection(time.Since(conn.ConnectedAt))
+	log.Info("MIGlet disconnected")
+
 	// Update VM status
 	ctx := context.Background()
-	s.vmStore.SetConnected(ctx, vmID, false)
+	s.observeVMStore(log, "set_connected", s.vmStore.SetConnected(ctx, vmID, false))
 }
 
 // handleHeartbeat processes a heartbeat message
-func (s *Server) handleHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
+func (s *Server) handleHeartbeat(vmID string, heartbeat *commands.Heartbeat, log *logrus.Entry) {
 	// Update last seen
 	s.connectionsLock.Lock()
 	if conn, ok := s.connections[vmID]; ok {
 		conn.LastSeen = time.Now()
-		conn.MigletState = heartbeat.MigletState
+		if conn.MigletState != heartbeat.MigletState {
+			conn.MigletState = heartbeat.MigletState
+			conn.notifyStateChange()
+		}
 		if heartbeat.RunnerState != nil {
 			conn.RunnerState = heartbeat.RunnerState.State
 		}
@@ -247,10 +562,11 @@ func (s *Server) handleHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
 
 	// Update VM status in Redis
 	ctx := context.Background()
-	var cpuUsage, memUsage float64
+	var cpuUsage, memUsage, diskUsage float64
 	if heartbeat.Health != nil {
 		cpuUsage = heartbeat.Health.CpuUsagePercent
 		memUsage = heartbeat.Health.MemoryUsagePercent
+		diskUsage = heartbeat.Health.DiskUsagePercent
 	}
 
 	var runnerState redis.RunnerState = redis.RunnerStateOffline
@@ -263,15 +579,17 @@ func (s *Server) handleHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
 		currentJobID = heartbeat.CurrentJob.JobId
 	}
 
-	s.vmStore.UpdateFromHeartbeat(
+	err := s.vmStore.UpdateFromHeartbeat(
 		ctx,
 		vmID,
 		redis.MigletState(heartbeat.MigletState),
 		runnerState,
 		cpuUsage,
 		memUsage,
+		diskUsage,
 		currentJobID,
 	)
+	s.observeVMStore(log, "update_from_heartbeat", err)
 
 	// Call callback if set
 	if s.onHeartbeat != nil {
@@ -280,53 +598,128 @@ func (s *Server) handleHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
 }
 
 // handleCommandAck processes a command acknowledgment
-func (s *Server) handleCommandAck(ack *commands.CommandAck) {
-	log := logger.WithComponent("grpc_server")
+// The waiter's channel is claimed under the lock, so an ack either reaches a live waiter
+// or is dropped as late; it never races the waiter's timeout
+func (s *Server) handleCommandAck(ack *commands.CommandAck, log *logrus.Entry) {
+	s.stats.acksReceived.Add(1)
+	ch, ok := s.takeAckChannel(ack.CommandId)
+	if !ok {
+		log.WithField("command_id", ack.CommandId).Debug("Ack for unknown or timed-out command")
+		return
+	}
+
+	// Buffered with size 1 and claimed exactly once, so this never blocks
+	ch <- ack
+}
 
+// registerAckChannel creates the channel a command's ack is delivered on
+// Fails if another in-flight command already uses the same ID
+func (s *Server) registerAckChannel(commandID string) (chan *commands.CommandAck, error) {
 	s.commandAcksLock.Lock()
-	ch, ok := s.commandAcks[ack.CommandId]
-	if ok {
-		delete(s.commandAcks, ack.CommandId)
+	defer s.commandAcksLock.Unlock()
+
+	if _, exists := s.commandAcks[commandID]; exists {
+		return nil, fmt.Errorf("command %s is already in flight", commandID)
 	}
-	s.commandAcksLock.Unlock()
+	ch := make(chan *commands.CommandAck, 1)
+	s.commandAcks[commandID] = ch
+	return ch, nil
+}
 
-	if ok && ch != nil {
-		select {
-		case ch <- ack:
-		default:
-			log.WithField("command_id", ack.CommandId).Warn("Ack channel full or closed")
-		}
+// takeAckChannel removes and returns a command's ack channel
+// Whichever side takes it (the ack handler or the timed-out waiter) owns it
+func (s *Server) takeAckChannel(commandID string) (chan *commands.CommandAck, bool) {
+	s.commandAcksLock.Lock()
+	defer s.commandAcksLock.Unlock()
+
+	ch, ok := s.commandAcks[commandID]
+	if ok {
+		delete(s.commandAcks, commandID)
 	}
+	return ch, ok
+}
+
+// HandleHTTPHeartbeat processes a heartbeat a MIGlet sent over the HTTP fallback API
+// It takes the same path as a heartbeat received on the stream
+func (s *Server) HandleHTTPHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
+	s.stats.httpHeartbeats.Add(1)
+	s.handleHeartbeat(vmID, heartbeat, logger.WithVM(vmID, s.cfg.Pool.ID).WithField("transport", "http"))
+}
+
+// HandleHTTPEvent processes an event a MIGlet sent over the HTTP fallback API
+// It takes the same path as an event received on the stream, including deduplication,
+// and returns false if the event was dropped as a duplicate
+func (s *Server) HandleHTTPEvent(vmID string, event *commands.EventNotification) bool {
+	s.stats.httpEvents.Add(1)
+	return s.handleEvent(vmID, event, logger.WithVM(vmID, s.cfg.Pool.ID).WithField("transport", "http"))
 }
 
 // handleEvent processes an event notification
-func (s *Server) handleEvent(vmID string, event *commands.EventNotification) {
-	log := logger.WithVM(vmID, s.cfg.Pool.ID)
-	log.WithField("event_type", event.Type).Info("Received event from MIGlet")
+// Returns false if the event was dropped as a duplicate
+func (s *Server) handleEvent(vmID string, event *commands.EventNotification, log *logrus.Entry) bool {
+	log = log.WithFields(map[string]interface{}{
+		"event_type": event.Type,
+		"event_id":   event.EventId,
+	})
+
+	if s.eventDedup.duplicate(event.EventId) {
+		s.stats.duplicateEvents.Add(1)
+		log.Info("Dropping duplicate event from MIGlet")
+		return false
+	}
+	log.Info("Received event from MIGlet")
 
 	if s.onEvent != nil {
 		s.onEvent(vmID, event)
 	}
+	return true
 }
 
-// SendCommand sends a command to a specific VM
+// SendCommand sends a command to a specific VM on behalf of the controller
 func (s *Server) SendCommand(vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error) {
-	log := logger.WithVM(vmID, s.cfg.Pool.ID)
+	return s.SendCommandAs("controller", vmID, cmd, timeout)
+}
 
+// SendCommandAs sends a command to a specific VM, recording issuer in the audit log
+func (s *Server) SendCommandAs(issuer, vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error) {
 	s.connectionsLock.RLock()
 	conn, connected := s.connections[vmID]
+	var supported bool
+	var version string
+	if connected {
+		// Read under the lock, since a repeated Connect updates them
+		supported, version = conn.Supports(cmd.Type), conn.Version
+	}
 	s.connectionsLock.RUnlock()
 
 	if !connected {
 		// Queue the command for when MIGlet connects
-		return nil, s.queueCommand(vmID, cmd, timeout)
+		err := s.queueCommand(vmID, cmd, timeout)
+		log := logger.WithVM(vmID, s.cfg.Pool.ID)
+		if errors.Is(err, redis.ErrPendingQueueFull) {
+			s.audit(log, issuer, vmID, cmd, redis.CommandAuditRejected, nil)
+		} else {
+			s.audit(log, issuer, vmID, cmd, redis.CommandAuditQueued, nil)
+		}
+		return nil, err
+	}
+
+	log := conn.log.WithFields(map[string]interface{}{
+		"command_id":   cmd.Id,
+		"command_type": cmd.Type,
+		"issuer":       issuer,
+	})
+
+	if !supported {
+		s.audit(log, issuer, vmID, cmd, redis.CommandAuditRejected, nil)
+		return nil, fmt.Errorf("%w: MIGlet %s does not handle %s", ErrCommandUnsupported, version, cmd.Type)
 	}
 
 	// Create ack channel
-	ackCh := make(chan *commands.CommandAck, 1)
-	s.commandAcksLock.Lock()
-	s.commandAcks[cmd.Id] = ackCh
-	s.commandAcksLock.Unlock()
+	ackCh, err := s.registerAckChannel(cmd.Id)
+	if err != nil {
+		return nil, err
+	}
 
 	// Send command
 	msg := &commands.ControllerMessage{
@@ -335,69 +728,62 @@ func (s *Server) SendCommand(vmID string, cmd *commands.Command, timeout time.Du
 		},
 	}
 
-	if err := conn.Stream.Send(msg); err != nil {
-		s.commandAcksLock.Lock()
-		delete(s.commandAcks, cmd.Id)
-		s.commandAcksLock.Unlock()
+	if err := conn.Send(msg); err != nil {
+		s.takeAckChannel(cmd.Id)
+		s.audit(log, issuer, vmID, cmd, redis.CommandAuditSendFailed, nil)
 		return nil, fmt.Errorf("failed to send command: %w", err)
 	}
 
-	log.WithField("command_id", cmd.Id).Info("Command sent")
+	s.stats.commandsSent.Add(1)
+	log.Info("Command sent")
+	s.audit(log, issuer, vmID, cmd, redis.CommandAuditSent, nil)
 
 	// Wait for acknowledgment
 	select {
 	case ack := <-ackCh:
+		log.WithField("success", ack.Success).Debug("Command acked")
+		s.audit(log, issuer, vmID, cmd, redis.CommandAuditAcked, ack)
 		return ack, nil
 	case <-time.After(timeout):
-		s.commandAcksLock.Lock()
-		delete(s.commandAcks, cmd.Id)
-		s.commandAcksLock.Unlock()
-		return nil, fmt.Errorf("command timeout")
+		if _, ok := s.takeAckChannel(cmd.Id); !ok {
+			// The ack arrived as the timer fired and is already buffered
+			ack := <-ackCh
+			log.WithField("success", ack.Success).Debug("Command acked")
+			s.audit(log, issuer, vmID, cmd, redis.CommandAuditAcked, ack)
+			return ack, nil
+		}
+		s.stats.commandTimeouts.Add(1)
+		log.WithField("timeout", timeout.String()).Warn("Command not acked in time")
+		s.audit(log, issuer, vmID, cmd, redis.CommandAuditTimeout, nil)
+		return nil, ErrCommandTimeout
 	}
 }
 
-// queueCommand queues a command for later delivery
-func (s *Server) queueCommand(vmID string, cmd *commands.Command, timeout time.Duration) error {
-	pending := &PendingCommand{
-		Command:   cmd,
-		ResultCh:  make(chan *commands.CommandAck, 1),
-		CreatedAt: time.Now(),
+// audit records a command lifecycle event; failures are logged to log and never block the command
+func (s *Server) audit(log *logrus.Entry, issuer, vmID string, cmd *commands.Command, status redis.CommandAuditStatus, ack *commands.CommandAck) {
+	if s.auditStore == nil {
+		return
 	}
 
-	s.pendingCommandsLock.Lock()
-	s.pendingCommands[vmID] = append(s.pendingCommands[vmID], pending)
-	s.pendingCommandsLock.Unlock()
-
-	return fmt.Errorf("command queued - VM not connected")
-}
-
-// sendPendingCommands sends any pending commands to a newly connected MIGlet
-func (s *Server) sendPendingCommands(vmID string, stream commands.CommandService_StreamCommandsServer) {
-	log := logger.WithVM(vmID, s.cfg.Pool.ID)
-
-	s.pendingCommandsLock.Lock()
-	pending := s.pendingCommands[vmID]
-	delete(s.pendingCommands, vmID)
-	s.pendingCommandsLock.Unlock()
-
-	for _, p := range pending {
-		// Skip expired commands
-		if time.Since(p.CreatedAt) > 5*time.Minute {
-			continue
-		}
-
-		msg := &commands.ControllerMessage{
-			Message: &commands.ControllerMessage_Command{
-				Command: p.Command,
-			},
-		}
+	entry := &redis.CommandAuditEntry{
+		CommandID:   cmd.Id,
+		CommandType: cmd.Type,
+		VMID:        vmID,
+		Issuer:      issuer,
+		Status:      status,
+		Params:      redis.RedactParams(cmd.StringParams), // Never hand a credential to the auditor
+	}
+	if ack != nil {
+		success := ack.Success
+		entry.AckSuccess = &success
+		entry.AckMessage = ack.Message
+	}
 
-		if err := stream.Send(msg); err != nil {
-			log.WithError(err).WithField("command_id", p.Command.Id).Warn("Failed to send pending command")
-			continue
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
 
-		log.WithField("command_id", p.Command.Id).Info("Sent pending command")
+	if err := s.auditStore.Record(ctx, entry); err != nil {
+		log.WithError(err).WithField("command_id", cmd.Id).Warn("Failed to record command audit entry")
 	}
 }
 
@@ -409,6 +795,28 @@ func (s *Server) IsConnected(vmID string) bool {
 	return ok
 }
 
+// GetMigletState returns the MIGlet state last reported on the VM's stream
+// False when the VM is not connected
+func (s *Server) GetMigletState(vmID string) (redis.MigletState, bool) {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+	conn, ok := s.connections[vmID]
+	if !ok {
+		return "", false
+	}
+	return redis.MigletState(conn.MigletState), true
+}
+
+// ConnectionID returns the connection_id of the VM's current stream, or "" if it isn't connected
+func (s *Server) ConnectionID(vmID string) string {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+	if conn, ok := s.connections[vmID]; ok {
+		return conn.ID
+	}
+	return ""
+}
+
 // GetConnectedVMs returns list of connected VM IDs
 func (s *Server) GetConnectedVMs() []string {
 	s.connectionsLock.RLock()
@@ -421,6 +829,31 @@ func (s *Server) GetConnectedVMs() []string {
 	return vmIDs
 }
 
+// Supports reports whether the connected MIGlet on vmID handles cmdType
+// False when the VM is not connected, since its capabilities are unknown
+func (s *Server) Supports(vmID, cmdType string) bool {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+	conn, ok := s.connections[vmID]
+	return ok && conn.Supports(cmdType)
+}
+
+// GetVersions returns the number of connected MIGlets per reported version
+func (s *Server) GetVersions() map[string]int {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+
+	versions := make(map[string]int)
+	for _, conn := range s.connections {
+		version := conn.Version
+		if version == "" {
+			version = "unknown"
+		}
+		versions[version]++
+	}
+	return versions
+}
+
 // GetConnectionCount returns the number of active connections
 func (s *Server) GetConnectionCount() int {
 	s.connectionsLock.RLock()
@@ -429,30 +862,56 @@ func (s *Server) GetConnectionCount() int {
 }
 
 // WaitForState waits for a VM to reach a specific state
+// While the VM is connected it wakes on heartbeat state changes; without a stream it
+// polls Redis every waitForStatePollInterval until the VM connects or the timeout passes
 func (s *Server) WaitForState(ctx context.Context, vmID string, targetState redis.MigletState, timeout time.Duration) error {
 	log := logger.WithVM(vmID, s.cfg.Pool.ID)
 
-	deadline := time.Now().Add(timeout)
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-			if time.Now().After(deadline) {
-				return fmt.Errorf("timeout waiting for state %s", targetState)
-			}
+		s.connectionsLock.RLock()
+		conn, ok := s.connections[vmID]
+		var state string
+		var changed <-chan struct{}
+		if ok {
+			state = conn.MigletState
+			changed = conn.stateChanged
+		}
+		connected := s.connected
+		s.connectionsLock.RUnlock()
 
-			status, err := s.vmStore.Get(ctx, vmID)
-			if err != nil {
-				continue
-			}
-			if status != nil && status.MigletState == targetState {
-				log.WithField("state", targetState).Info("VM reached target state")
+		if ok {
+			if redis.MigletState(state) == targetState {
+				conn.log.WithField("state", targetState).Info("VM reached target state")
 				return nil
 			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return fmt.Errorf("timeout waiting for state %s", targetState)
+			case <-changed:
+			}
+			continue
+		}
+
+		// No stream: the last state written to Redis is the best we have
+		status, err := s.vmStore.Get(ctx, vmID)
+		if err == nil && status != nil && status.MigletState == targetState {
+			log.WithField("state", targetState).Info("VM reached target state")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("timeout waiting for state %s", targetState)
+		case <-connected:
+		case <-time.After(waitForStatePollInterval):
 		}
 	}
 }
@@ -0,0 +1,330 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// ackingStream is a MIGlet stream that acks every command it is sent, and records
+// whether Send was ever entered by two callers at once
+type ackingStream struct {
+	commands.CommandService_StreamCommandsServer // Only Send is used
+	server                                       *Server
+
+	inFlight   atomic.Int32
+	concurrent atomic.Bool
+	mu         sync.Mutex
+	sent       []string // Command IDs, in send order
+}
+
+func (a *ackingStream) Send(msg *commands.ControllerMessage) error {
+	if a.inFlight.Add(1) > 1 {
+		a.concurrent.Store(true)
+	}
+	defer a.inFlight.Add(-1)
+	time.Sleep(100 * time.Microsecond) // Widen the window for an overlapping Send
+
+	cmd := msg.GetCommand()
+	if cmd == nil {
+		return nil
+	}
+	a.mu.Lock()
+	a.sent = append(a.sent, cmd.Id)
+	a.mu.Unlock()
+	go a.server.handleCommandAck(&commands.CommandAck{CommandId: cmd.Id, Success: true}, logger.WithComponent("test"))
+	return nil
+}
+
+// addTestConnection registers a connection for vmID on stream without touching the VM store
+func addTestConnection(s *Server, vmID string, stream commands.CommandService_StreamCommandsServer) {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+	s.connections[vmID] = &MIGletConnection{
+		ID:           "conn-" + vmID,
+		VMID:         vmID,
+		Stream:       stream,
+		ConnectedAt:  time.Now(),
+		LastSeen:     time.Now(),
+		log:          logger.WithVM(vmID, s.cfg.Pool.ID),
+		replaced:     make(chan struct{}),
+		stateChanged: make(chan struct{}),
+	}
+}
+
+func TestSendCommandSerializesConcurrentSendsToOneVM(t *testing.T) {
+	logger.Init("error", "text", logger.Output{}) // Before the senders race to initialize it
+	s := NewServer(&config.Config{}, nil)
+	stream := &ackingStream{server: s}
+	addTestConnection(s, "vm-1", stream)
+
+	const senders = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmd := &commands.Command{Id: fmt.Sprintf("cmd-%d", i), Type: "register_runner"}
+			ack, err := s.SendCommand("vm-1", cmd, 5*time.Second)
+			if err != nil {
+				errs <- fmt.Errorf("%s: %w", cmd.Id, err)
+				return
+			}
+			if ack.CommandId != cmd.Id || !ack.Success {
+				errs <- fmt.Errorf("%s: got ack %+v", cmd.Id, ack)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if stream.concurrent.Load() {
+		t.Error("Send called concurrently on one stream")
+	}
+	if got := len(stream.sent); got != senders {
+		t.Errorf("sent %d commands, want %d", got, senders)
+	}
+}
+
+func TestSendCommandRejectsCommandsLegacyMIGletsLack(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, nil)
+	stream := &ackingStream{server: s}
+	addTestConnection(s, "vm-1", stream) // Reported no capabilities
+
+	_, err := s.SendCommand("vm-1", &commands.Command{Id: "cmd-drain", Type: "drain"}, time.Second)
+	if !errors.Is(err, ErrCommandUnsupported) {
+		t.Fatalf("SendCommand(drain) error = %v, want ErrCommandUnsupported", err)
+	}
+	if len(stream.sent) != 0 {
+		t.Errorf("sent %v to a MIGlet without drain support", stream.sent)
+	}
+
+	if _, err := s.SendCommand("vm-1", &commands.Command{Id: "cmd-register", Type: "register_runner"}, time.Second); err != nil {
+		t.Errorf("SendCommand(register_runner): %v", err)
+	}
+}
+
+func TestSendCommandWhileMIGletRepeatsConnect(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, redis.NewMemoryVMStatusStore("pool-test"))
+	stream := &ackingStream{server: s}
+	req := &commands.ConnectRequest{VmId: "vm-1", Version: "v2", Capabilities: []string{"register_runner", "drain"}}
+	conn := s.handleConnect(req, stream, "conn-1", logger.WithComponent("test"))
+
+	// A repeated Connect on the same stream updates the capabilities while commands are checked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if got := s.handleConnect(req, stream, "conn-1", logger.WithComponent("test")); got != conn {
+				t.Error("repeated Connect on the same stream replaced the connection")
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		cmd := &commands.Command{Id: fmt.Sprintf("cmd-%d", i), Type: "drain"}
+		if _, err := s.SendCommand("vm-1", cmd, time.Second); err != nil {
+			t.Fatalf("SendCommand(%s): %v", cmd.Id, err)
+		}
+	}
+	<-done
+}
+
+// delayedAckStream is a MIGlet stream that acks each command acks times, after delay
+type delayedAckStream struct {
+	commands.CommandService_StreamCommandsServer // Only Send is used
+	server                                       *Server
+	acks                                         int
+	delay                                        time.Duration
+}
+
+func (d *delayedAckStream) Send(msg *commands.ControllerMessage) error {
+	cmd := msg.GetCommand()
+	if cmd == nil {
+		return nil
+	}
+	for i := 0; i < d.acks; i++ {
+		go func() {
+			time.Sleep(d.delay)
+			d.server.handleCommandAck(&commands.CommandAck{CommandId: cmd.Id, Success: true}, logger.WithComponent("test"))
+		}()
+	}
+	return nil
+}
+
+// pendingAcks returns how many commands are still waiting for an ack
+func pendingAcks(s *Server) int {
+	s.commandAcksLock.Lock()
+	defer s.commandAcksLock.Unlock()
+	return len(s.commandAcks)
+}
+
+func TestAckAfterTimeoutIsDropped(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, nil)
+	addTestConnection(s, "vm-1", &delayedAckStream{server: s})
+
+	cmd := &commands.Command{Id: "cmd-1", Type: "register_runner"}
+	if _, err := s.SendCommand("vm-1", cmd, 20*time.Millisecond); !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("SendCommand error = %v, want ErrCommandTimeout", err)
+	}
+
+	// The late ack finds no waiter and must not block
+	done := make(chan struct{})
+	go func() {
+		s.handleCommandAck(&commands.CommandAck{CommandId: "cmd-1", Success: true}, logger.WithComponent("test"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("late ack blocked")
+	}
+
+	if n := pendingAcks(s); n != 0 {
+		t.Errorf("%d ack channels left registered, want 0", n)
+	}
+	if got := s.stats.commandTimeouts.Load(); got != 1 {
+		t.Errorf("command timeouts = %d, want 1", got)
+	}
+	// The ID is free again, so a retried command isn't refused as in flight
+	if _, err := s.registerAckChannel("cmd-1"); err != nil {
+		t.Errorf("registerAckChannel after timeout: %v", err)
+	}
+}
+
+func TestAckRacingTimeoutIsDeliveredOrTimesOut(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, nil)
+	const timeout = 5 * time.Millisecond
+	addTestConnection(s, "vm-1", &delayedAckStream{server: s, acks: 1, delay: timeout})
+
+	const commandsSent = 200
+	var acked, timedOut int
+	for i := 0; i < commandsSent; i++ {
+		cmd := &commands.Command{Id: fmt.Sprintf("cmd-%d", i), Type: "register_runner"}
+		ack, err := s.SendCommand("vm-1", cmd, timeout)
+		switch {
+		case errors.Is(err, ErrCommandTimeout):
+			timedOut++
+		case err != nil:
+			t.Fatalf("SendCommand(%s): %v", cmd.Id, err)
+		case ack.CommandId != cmd.Id:
+			t.Fatalf("SendCommand(%s) got the ack for %s", cmd.Id, ack.CommandId)
+		default:
+			acked++
+		}
+	}
+
+	if acked+timedOut != commandsSent {
+		t.Errorf("acked %d + timed out %d, want %d", acked, timedOut, commandsSent)
+	}
+	if got := s.stats.commandTimeouts.Load(); got != int64(timedOut) {
+		t.Errorf("command timeouts = %d, want %d", got, timedOut)
+	}
+	// Let the acks still in flight land
+	time.Sleep(2 * timeout)
+	if n := pendingAcks(s); n != 0 {
+		t.Errorf("%d ack channels left registered, want 0", n)
+	}
+}
+
+func TestDuplicateAckIsIgnored(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, nil)
+	addTestConnection(s, "vm-1", &delayedAckStream{server: s, acks: 2})
+
+	cmd := &commands.Command{Id: "cmd-1", Type: "register_runner"}
+	ack, err := s.SendCommand("vm-1", cmd, time.Second)
+	if err != nil {
+		t.Fatalf("SendCommand: %v", err)
+	}
+	if ack.CommandId != "cmd-1" || !ack.Success {
+		t.Errorf("ack = %+v, want a successful ack for cmd-1", ack)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.stats.acksReceived.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := s.stats.acksReceived.Load(); got != 2 {
+		t.Fatalf("acks received = %d, want 2", got)
+	}
+	if n := pendingAcks(s); n != 0 {
+		t.Errorf("%d ack channels left registered, want 0", n)
+	}
+}
+
+// recordingAuditor keeps the audit entries it is given
+type recordingAuditor struct {
+	mu      sync.Mutex
+	entries []*redis.CommandAuditEntry
+}
+
+func (r *recordingAuditor) Record(ctx context.Context, entry *redis.CommandAuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func TestAuditRecordsSentCommandAndAckWithTokenMasked(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := NewServer(&config.Config{}, nil)
+	auditor := &recordingAuditor{}
+	s.SetAuditStore(auditor)
+	addTestConnection(s, "vm-1", &ackingStream{server: s})
+
+	cmd := &commands.Command{
+		Id:   "cmd-1",
+		Type: "register_runner",
+		StringParams: map[string]string{
+			"registration_token": "AABBCCDD",
+			"runner_url":         "https://github.com/acme/app",
+		},
+	}
+	if _, err := s.SendCommandAs("scheduler", "vm-1", cmd, time.Second); err != nil {
+		t.Fatalf("SendCommandAs: %v", err)
+	}
+
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	if len(auditor.entries) != 2 {
+		t.Fatalf("recorded %d audit entries, want 2", len(auditor.entries))
+	}
+	for i, want := range []redis.CommandAuditStatus{redis.CommandAuditSent, redis.CommandAuditAcked} {
+		entry := auditor.entries[i]
+		if entry.Status != want || entry.CommandID != "cmd-1" || entry.VMID != "vm-1" || entry.Issuer != "scheduler" {
+			t.Errorf("entry %d = %+v, want %s for cmd-1 on vm-1 by scheduler", i, entry, want)
+		}
+		if got := entry.Params["registration_token"]; got != "[REDACTED]" {
+			t.Errorf("entry %d registration_token = %q, want it masked", i, got)
+		}
+		if got := entry.Params["runner_url"]; got != "https://github.com/acme/app" {
+			t.Errorf("entry %d runner_url = %q, want it kept", i, got)
+		}
+	}
+	if ack := auditor.entries[1]; ack.AckSuccess == nil || !*ack.AckSuccess {
+		t.Errorf("ack entry success = %v, want true", ack.AckSuccess)
+	}
+	// The command itself still carries the token to the MIGlet
+	if cmd.StringParams["registration_token"] != "AABBCCDD" {
+		t.Error("auditing modified the command's parameters")
+	}
+}
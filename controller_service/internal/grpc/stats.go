@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connectionDurationBuckets are the upper bounds, in seconds, of the connected-duration histogram
+// Short-lived connections land in the low buckets, so flapping agents stand out
+var connectionDurationBuckets = []float64{10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// serverStats counts connection lifecycle and command events
+type serverStats struct {
+	connects         atomic.Int64
+	disconnects      atomic.Int64
+	replaced         atomic.Int64 // Streams superseded by a newer connection from the same VM
+	rejectedConnects atomic.Int64
+	followerRefusals atomic.Int64 // Streams refused because this replica is not the leader
+	commandsSent     atomic.Int64
+	commandTimeouts  atomic.Int64
+	acksReceived     atomic.Int64
+	duplicateEvents  atomic.Int64 // Events dropped because their ID was already handled
+	httpEvents       atomic.Int64 // Events received over the HTTP fallback API
+	httpHeartbeats   atomic.Int64 // Heartbeats received over the HTTP fallback API
+	vmStoreErrors    atomic.Int64 // Failed VM status writes for connects, disconnects and heartbeats
+	pendingRejected  atomic.Int64 // Commands turned away because the VM's pending queue was full
+
+	// Histogram of how long finished connections lasted
+	durationsLock  sync.Mutex
+	durationCounts []int64 // Per bucket, not cumulative; the last entry is +Inf
+	durationSum    float64
+	durationCount  int64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{
+		durationCounts: make([]int64, len(connectionDurationBuckets)+1),
+	}
+}
+
+// observeConnection records the lifetime of a connection that just ended
+func (st *serverStats) observeConnection(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(connectionDurationBuckets, seconds)
+
+	st.durationsLock.Lock()
+	defer st.durationsLock.Unlock()
+	st.durationCounts[i]++
+	st.durationSum += seconds
+	st.durationCount++
+}
+
+// durationHistogram returns cumulative bucket counts keyed le_<seconds>, plus sum and count
+func (st *serverStats) durationHistogram() map[string]interface{} {
+	st.durationsLock.Lock()
+	defer st.durationsLock.Unlock()
+
+	buckets := make(map[string]interface{}, len(connectionDurationBuckets)+1)
+	var cumulative int64
+	for i, bound := range connectionDurationBuckets {
+		cumulative += st.durationCounts[i]
+		buckets[fmt.Sprintf("le_%g", bound)] = cumulative
+	}
+	buckets["le_inf"] = cumulative + st.durationCounts[len(connectionDurationBuckets)]
+
+	return map[string]interface{}{
+		"buckets": buckets,
+		"sum":     st.durationSum,
+		"count":   st.durationCount,
+	}
+}
+
+// ConnectionInfo describes a live MIGlet connection
+type ConnectionInfo struct {
+	VMID             string  `json:"vm_id"`
+	Version          string  `json:"version,omitempty"`
+	ConnectedSeconds float64 `json:"connected_seconds"`
+}
+
+// GetStats returns connection lifecycle counters, the connected-duration histogram,
+// pending command queue depths and how long each live connection has been up
+func (s *Server) GetStats() map[string]interface{} {
+	s.connectionsLock.RLock()
+	connections := make([]ConnectionInfo, 0, len(s.connections))
+	for vmID, conn := range s.connections {
+		connections = append(connections, ConnectionInfo{
+			VMID:             vmID,
+			Version:          conn.Version,
+			ConnectedSeconds: time.Since(conn.ConnectedAt).Seconds(),
+		})
+	}
+	s.connectionsLock.RUnlock()
+	sort.Slice(connections, func(i, j int) bool { return connections[i].VMID < connections[j].VMID })
+
+	var pending, deepest int64
+	for _, depth := range s.pendingDepths() {
+		pending += depth
+		if depth > deepest {
+			deepest = depth
+		}
+	}
+
+	return map[string]interface{}{
+		"connected":                   len(connections),
+		"connects_total":              s.stats.connects.Load(),
+		"disconnects_total":           s.stats.disconnects.Load(),
+		"replaced_total":              s.stats.replaced.Load(),
+		"rejected_connects_total":     s.stats.rejectedConnects.Load(),
+		"follower_refusals_total":     s.stats.followerRefusals.Load(),
+		"commands_sent_total":         s.stats.commandsSent.Load(),
+		"command_timeouts_total":      s.stats.commandTimeouts.Load(),
+		"acks_received_total":         s.stats.acksReceived.Load(),
+		"duplicate_events_total":      s.stats.duplicateEvents.Load(),
+		"http_events_total":           s.stats.httpEvents.Load(),
+		"http_heartbeats_total":       s.stats.httpHeartbeats.Load(),
+		"vm_store_errors_total":       s.stats.vmStoreErrors.Load(),
+		"pending_commands":            pending,
+		"pending_queue_depth_max":     deepest, // Deepest single VM queue
+		"pending_rejected_total":      s.stats.pendingRejected.Load(),
+		"connection_duration_seconds": s.stats.durationHistogram(),
+		"connections":                 connections, // Lists are skipped by the metrics registry
+	}
+}
@@ -0,0 +1,88 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// vmStoreUnhealthyAfter is how long VM status writes must keep failing before readiness fails
+const vmStoreUnhealthyAfter = 30 * time.Second
+
+// vmStoreWarnInterval limits how often failed VM status writes are logged
+const vmStoreWarnInterval = 10 * time.Second
+
+// storeHealth tracks whether the VM status store is taking the server's writes
+// Without it a Redis outage goes unnoticed: MIGlets stay connected while their state is lost
+type storeHealth struct {
+	mu           sync.Mutex
+	failingSince time.Time // First failure since the last success; zero while healthy
+	lastErr      error
+	lastWarn     time.Time
+	suppressed   int // Failures not logged since lastWarn
+}
+
+// observeVMStore records the outcome of a VM status store call, logging to the logger of the
+// MIGlet it was made for. Failures are counted and logged at most every vmStoreWarnInterval,
+// with the number suppressed
+func (s *Server) observeVMStore(log *logrus.Entry, op string, err error) {
+	h := s.vmStoreHealth
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		if !h.failingSince.IsZero() {
+			log.WithField("failing_for", time.Since(h.failingSince).Round(time.Second).String()).
+				Info("VM status store recovered")
+		}
+		h.failingSince, h.lastErr, h.suppressed = time.Time{}, nil, 0
+		return
+	}
+
+	s.stats.vmStoreErrors.Add(1)
+	now := time.Now()
+	if h.failingSince.IsZero() {
+		h.failingSince = now
+	}
+	h.lastErr = err
+
+	if now.Sub(h.lastWarn) < vmStoreWarnInterval {
+		h.suppressed++
+		return
+	}
+	log.WithError(err).WithFields(map[string]interface{}{
+		"operation":  op,
+		"suppressed": h.suppressed,
+	}).Warn("Failed to write VM status")
+	h.lastWarn, h.suppressed = now, 0
+}
+
+// CheckVMStore is a readiness check that fails once VM status writes have failed for
+// vmStoreUnhealthyAfter without a success, so orchestration can replace the controller
+// Writes only happen while MIGlets are connected, which an unready controller may have none
+// of, so the store is pinged first: a successful ping clears the failure
+func (s *Server) CheckVMStore(ctx context.Context) error {
+	h := s.vmStoreHealth
+	h.mu.Lock()
+	failingSince, lastErr := h.failingSince, h.lastErr
+	h.mu.Unlock()
+
+	if failingSince.IsZero() {
+		return nil
+	}
+	failing := time.Since(failingSince)
+	if failing < vmStoreUnhealthyAfter {
+		return nil
+	}
+	if err := s.vmStore.Ping(ctx); err == nil {
+		s.observeVMStore(logger.WithComponent("grpc_server"), "ping", nil)
+		return nil
+	}
+	return fmt.Errorf("VM status writes failing for %s: %v", failing.Round(time.Second), lastErr)
+}
@@ -0,0 +1,60 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// pingStore is an in-memory VM status store whose Ping returns pingErr
+type pingStore struct {
+	*redis.MemoryVMStatusStore
+	pingErr error
+}
+
+func (p *pingStore) Ping(ctx context.Context) error { return p.pingErr }
+
+// newStoreHealthServer returns a server whose VM status writes have been failing for failingFor
+func newStoreHealthServer(failingFor time.Duration, pingErr error) *Server {
+	s := NewServer(&config.Config{}, &pingStore{redis.NewMemoryVMStatusStore("pool-test"), pingErr})
+	s.observeVMStore(logger.WithComponent("test"), "update_from_heartbeat", errors.New("connection refused"))
+	s.vmStoreHealth.failingSince = time.Now().Add(-failingFor)
+	return s
+}
+
+func TestCheckVMStoreToleratesRecentFailures(t *testing.T) {
+	s := newStoreHealthServer(vmStoreUnhealthyAfter/2, errors.New("connection refused"))
+	if err := s.CheckVMStore(context.Background()); err != nil {
+		t.Fatalf("CheckVMStore = %v, want nil before vmStoreUnhealthyAfter", err)
+	}
+}
+
+func TestCheckVMStoreFailsWhileStoreIsDown(t *testing.T) {
+	s := newStoreHealthServer(vmStoreUnhealthyAfter, errors.New("connection refused"))
+	if err := s.CheckVMStore(context.Background()); err == nil {
+		t.Fatal("CheckVMStore = nil, want an error once writes failed for vmStoreUnhealthyAfter")
+	}
+}
+
+func TestCheckVMStoreRecoversOnPing(t *testing.T) {
+	s := newStoreHealthServer(vmStoreUnhealthyAfter, nil)
+	if err := s.CheckVMStore(context.Background()); err != nil {
+		t.Fatalf("CheckVMStore = %v, want nil once the store answers pings", err)
+	}
+	if !s.vmStoreHealth.failingSince.IsZero() {
+		t.Error("failure not cleared after a successful ping")
+	}
+}
+
+func TestObserveVMStoreClearsOnSuccess(t *testing.T) {
+	s := newStoreHealthServer(vmStoreUnhealthyAfter, errors.New("connection refused"))
+	s.observeVMStore(logger.WithComponent("test"), "update_from_heartbeat", nil)
+	if err := s.CheckVMStore(context.Background()); err != nil {
+		t.Fatalf("CheckVMStore = %v, want nil after a successful write", err)
+	}
+}
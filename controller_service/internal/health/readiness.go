@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds each dependency check
+const checkTimeout = 3 * time.Second
+
+// Check probes a single dependency; a nil error means healthy
+type Check func(ctx context.Context) error
+
+// Readiness reports whether the controller's dependencies are usable
+// Results are cached for a short TTL so frequent probes don't hammer dependencies
+type Readiness struct {
+	ttl    time.Duration
+	names  []string
+	checks map[string]Check
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	result    map[string]string // Dependency name -> "ok" or the error message
+	ready     bool
+}
+
+// NewReadiness creates a readiness checker that caches results for ttl
+func NewReadiness(ttl time.Duration) *Readiness {
+	return &Readiness{
+		ttl:    ttl,
+		checks: make(map[string]Check),
+	}
+}
+
+// Add registers a dependency check under name
+func (r *Readiness) Add(name string, check Check) {
+	r.names = append(r.names, name)
+	r.checks[name] = check
+}
+
+// Status runs the checks (or returns the cached result) and reports per-dependency status
+func (r *Readiness) Status(ctx context.Context) (bool, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.result != nil && time.Since(r.checkedAt) < r.ttl {
+		return r.ready, r.result
+	}
+
+	type outcome struct {
+		name string
+		err  error
+	}
+	outcomes := make(chan outcome, len(r.names))
+	for _, name := range r.names {
+		go func(name string, check Check) {
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			outcomes <- outcome{name, check(checkCtx)}
+		}(name, r.checks[name])
+	}
+
+	result := make(map[string]string, len(r.names))
+	ready := true
+	for range r.names {
+		o := <-outcomes
+		if o.err != nil {
+			result[o.name] = o.err.Error()
+			ready = false
+		} else {
+			result[o.name] = "ok"
+		}
+	}
+
+	r.ready, r.result, r.checkedAt = ready, result, time.Now()
+	return ready, result
+}
+
+// ServeHTTP responds 200 when every dependency is healthy and 503 otherwise
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ready, checks := r.Status(req.Context())
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
@@ -0,0 +1,319 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// Handler serves the MIGlet HTTP API under /api/v1/vms/
+// MIGlets use it for events and heartbeats while their gRPC stream is down; requests are
+// converted to the gRPC messages and handled by the gRPC server, so both paths behave the same.
+// Commands and registration tokens are only delivered over gRPC
+type Handler struct {
+	cfg        *config.Config
+	token      []byte
+	grpcServer *grpcserver.Server
+	jobs       JobLookup
+	mux        *http.ServeMux
+}
+
+// JobLookup finds the job an event refers to, to check it belongs to the reporting VM
+type JobLookup interface {
+	Get(ctx context.Context, jobID string) (*redis.Job, error)
+}
+
+// NewHandler creates a new MIGlet HTTP API handler
+func NewHandler(cfg *config.Config, grpcServer *grpcserver.Server, jobs JobLookup) *Handler {
+	h := &Handler{
+		cfg:        cfg,
+		token:      []byte(cfg.Server.HTTPFallback.Token),
+		grpcServer: grpcServer,
+		jobs:       jobs,
+		mux:        http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("POST /api/v1/vms/{vm_id}/events", h.handleEvent)
+	h.mux.HandleFunc("POST /api/v1/vms/{vm_id}/heartbeat", h.handleHeartbeat)
+
+	return h
+}
+
+// ServeHTTP authenticates the request and dispatches it to the matching endpoint
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Same cap as a gRPC message, so a payload that fits one transport fits the other
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.cfg.Server.MaxMessageSize))
+	h.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token in constant time
+// Config validation requires a token, so an empty one only refuses everything
+func (h *Handler) authorized(r *http.Request) bool {
+	if len(h.token) == 0 {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), h.token) == 1
+}
+
+// eventBaseFields are the event fields carried outside EventNotification.Data
+var eventBaseFields = map[string]bool{
+	"event_id":  true,
+	"type":      true,
+	"timestamp": true,
+	"vm_id":     true,
+	"pool_id":   true,
+	"org_id":    true,
+	"metadata":  true,
+}
+
+// handleEvent accepts a MIGlet event (events.Event plus its type-specific fields)
+// Responds 200 with {"status":"received"|"duplicate","event_id":...}
+func (h *Handler) handleEvent(w http.ResponseWriter, r *http.Request) {
+	vmID := r.PathValue("vm_id")
+	log := logger.WithVM(vmID, h.cfg.Pool.ID).WithField("component", "http_api")
+
+	var body map[string]interface{}
+	if err := decodeJSON(r, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := toEventNotification(vmID, body)
+	if err != nil {
+		log.WithError(err).Warn("Rejected MIGlet event")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A VM may only report on its own jobs
+	if jobID := event.Data["job_id"]; jobID != "" {
+		job, err := h.jobs.Get(r.Context(), jobID)
+		if err != nil {
+			log.WithError(err).Warn("Failed to look up event job")
+			http.Error(w, "failed to look up job", http.StatusServiceUnavailable)
+			return
+		}
+		if job != nil && job.AssignedVMID != vmID {
+			log.WithFields(map[string]interface{}{
+				"job_id":      jobID,
+				"assigned_vm": job.AssignedVMID,
+				"event_type":  event.Type,
+			}).Warn("Rejected MIGlet event for a job not assigned to the VM")
+			http.Error(w, fmt.Sprintf("job %s is not assigned to VM %s", jobID, vmID), http.StatusForbidden)
+			return
+		}
+	}
+
+	status := "received"
+	if !h.grpcServer.HandleHTTPEvent(vmID, event) {
+		status = "duplicate"
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"status":   status,
+		"vm_id":    vmID,
+		"event_id": event.EventId,
+	})
+}
+
+// heartbeatRequest is the JSON heartbeat a MIGlet sends (events.HeartbeatEvent)
+type heartbeatRequest struct {
+	VMID        string    `json:"vm_id"`
+	PoolID      string    `json:"pool_id"`
+	OrgID       string    `json:"org_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	MigletState string    `json:"miglet_state"`
+	VMHealth    struct {
+		CPULoad     float64 `json:"cpu_load"`
+		MemoryUsed  int64   `json:"memory_used"`  // MB
+		MemoryTotal int64   `json:"memory_total"` // MB
+		DiskUsed    int64   `json:"disk_used"`    // GB
+		DiskTotal   int64   `json:"disk_total"`   // GB
+	} `json:"vm_health"`
+	RunnerState string `json:"runner_state"`
+	CurrentJob  *struct {
+		JobID          string    `json:"job_id"`
+		RunID          string    `json:"run_id"`
+		Repository     string    `json:"repository"`
+		StartedAt      time.Time `json:"started_at"`
+		LogIdleSeconds int64     `json:"log_idle_seconds"`
+	} `json:"current_job"`
+}
+
+// handleHeartbeat accepts a MIGlet heartbeat
+// Responds 200 with {"status":"received"}
+func (h *Handler) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	vmID := r.PathValue("vm_id")
+
+	var req heartbeatRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.VMID != "" && req.VMID != vmID {
+		http.Error(w, fmt.Sprintf("vm_id %q does not match path", req.VMID), http.StatusBadRequest)
+		return
+	}
+
+	h.grpcServer.HandleHTTPHeartbeat(vmID, req.toProto(vmID))
+
+	writeJSON(w, map[string]interface{}{
+		"status": "received",
+		"vm_id":  vmID,
+	})
+}
+
+// toProto converts the heartbeat the same way MIGlet does before sending it over gRPC
+func (req *heartbeatRequest) toProto(vmID string) *commands.Heartbeat {
+	health := &commands.VMHealth{
+		CpuUsagePercent:  req.VMHealth.CPULoad,
+		MemoryTotalBytes: req.VMHealth.MemoryTotal * 1024 * 1024,
+		MemoryUsedBytes:  req.VMHealth.MemoryUsed * 1024 * 1024,
+		DiskTotalBytes:   req.VMHealth.DiskTotal * 1024 * 1024 * 1024,
+		DiskUsedBytes:    req.VMHealth.DiskUsed * 1024 * 1024 * 1024,
+	}
+	if req.VMHealth.MemoryTotal > 0 {
+		health.MemoryUsagePercent = float64(req.VMHealth.MemoryUsed) / float64(req.VMHealth.MemoryTotal) * 100
+	}
+	if req.VMHealth.DiskTotal > 0 {
+		health.DiskUsagePercent = float64(req.VMHealth.DiskUsed) / float64(req.VMHealth.DiskTotal) * 100
+	}
+
+	heartbeat := &commands.Heartbeat{
+		VmId:        vmID,
+		PoolId:      req.PoolID,
+		OrgId:       req.OrgID,
+		Health:      health,
+		Timestamp:   req.Timestamp.Unix(),
+		MigletState: req.MigletState,
+		RunnerState: &commands.RunnerState{
+			State:      req.RunnerState,
+			RunnerName: vmID,
+		},
+	}
+	if job := req.CurrentJob; job != nil {
+		heartbeat.CurrentJob = &commands.JobInfo{
+			JobId:          job.JobID,
+			RunId:          job.RunID,
+			Repository:     job.Repository,
+			Status:         "running",
+			StartedAt:      job.StartedAt.Unix(),
+			LogIdleSeconds: job.LogIdleSeconds,
+		}
+	}
+	return heartbeat
+}
+
+// toEventNotification converts a JSON event into the message a MIGlet sends over gRPC
+// Type-specific fields become Data entries named after their JSON keys; metadata entries
+// are added without overriding them
+func toEventNotification(vmID string, body map[string]interface{}) (*commands.EventNotification, error) {
+	eventType, _ := body["type"].(string)
+	if eventType == "" {
+		return nil, errors.New("type is required")
+	}
+	if bodyVMID, _ := body["vm_id"].(string); bodyVMID != "" && bodyVMID != vmID {
+		return nil, fmt.Errorf("vm_id %q does not match path", bodyVMID)
+	}
+
+	event := &commands.EventNotification{
+		Type:      eventType,
+		VmId:      vmID,
+		Data:      make(map[string]string),
+		Timestamp: time.Now().Unix(),
+	}
+	event.EventId, _ = body["event_id"].(string)
+	event.PoolId, _ = body["pool_id"].(string)
+	event.OrgId, _ = body["org_id"].(string)
+	if ts, ok := body["timestamp"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			event.Timestamp = t.Unix()
+		}
+	}
+
+	for key, value := range body {
+		if eventBaseFields[key] {
+			continue
+		}
+		if s, ok := flatten(value); ok {
+			event.Data[key] = s
+		}
+	}
+	if metadata, ok := body["metadata"].(map[string]interface{}); ok {
+		for key, value := range metadata {
+			if _, exists := event.Data[key]; exists {
+				continue
+			}
+			if s, ok := flatten(value); ok {
+				event.Data[key] = s
+			}
+		}
+	}
+	return event, nil
+}
+
+// flatten renders a JSON value as a Data string, the way MIGlet formats it for gRPC
+// String lists (e.g. log_tail) are joined with newlines; nulls are skipped
+func flatten(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := flatten(item); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, "\n"), true
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
+
+// decodeJSON reads the request body into v, keeping numbers as json.Number
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes a 200 response with a JSON body
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// Pusher periodically pushes the registry to a Prometheus push gateway
+// Used where Prometheus can't scrape the controller directly
+type Pusher struct {
+	cfg        *config.Config
+	registry   *Registry
+	httpClient *http.Client
+	url        string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPusher creates a pusher for cfg.Metrics.PushGateway
+// Metrics are grouped under job "mig-controller-<pool_id>"
+func NewPusher(cfg *config.Config, registry *Registry) *Pusher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := "mig-controller-" + cfg.Pool.ID
+	return &Pusher{
+		cfg:        cfg,
+		registry:   registry,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		url:        strings.TrimSuffix(cfg.Metrics.PushGateway, "/") + "/metrics/job/" + url.PathEscape(job),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start begins pushing every PushInterval
+func (p *Pusher) Start() {
+	log := logger.WithComponent("metrics")
+	log.WithFields(map[string]interface{}{
+		"gateway":  p.cfg.Metrics.PushGateway,
+		"interval": p.cfg.Metrics.PushInterval,
+	}).Info("Metrics pusher starting")
+
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop pushes one final time and stops the pusher
+func (p *Pusher) Stop() {
+	p.cancel()
+	p.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Push(ctx); err != nil {
+		logger.WithComponent("metrics").WithError(err).Warn("Failed to push final metrics")
+	}
+}
+
+// run pushes on every tick until stopped
+func (p *Pusher) run() {
+	defer p.wg.Done()
+
+	log := logger.WithComponent("metrics")
+
+	interval := p.cfg.Metrics.PushInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Push(p.ctx); err != nil {
+				log.WithError(err).Warn("Failed to push metrics")
+			}
+		}
+	}
+}
+
+// Push sends the current metrics, replacing the job's previous push
+func (p *Pusher) Push(ctx context.Context) error {
+	var buf bytes.Buffer
+	if err := WriteText(&buf, p.registry.Gather()); err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.url, &buf)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push gateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
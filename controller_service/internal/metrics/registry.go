@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// namespace prefixes every exported metric name
+const namespace = "mig_controller"
+
+// StatsFunc returns a component's stats, as served by its GetStats method
+type StatsFunc func() map[string]interface{}
+
+// Sample is a single gauge value
+type Sample struct {
+	Name  string
+	Value float64
+}
+
+// Registry collects stats from the controller's components
+type Registry struct {
+	mu      sync.Mutex
+	sources map[string]StatsFunc
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		sources: make(map[string]StatsFunc),
+	}
+}
+
+// Register adds a stats source; its numeric values are exported as
+// mig_controller_<name>_<key>, with nested maps and structs flattened
+func (r *Registry) Register(name string, fn StatsFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[name] = fn
+}
+
+// Gather collects every registered source, sorted by metric name
+func (r *Registry) Gather() []Sample {
+	r.mu.Lock()
+	sources := make(map[string]StatsFunc, len(r.sources))
+	for name, fn := range r.sources {
+		sources[name] = fn
+	}
+	r.mu.Unlock()
+
+	var samples []Sample
+	for name, fn := range sources {
+		// Round-trip through JSON so structs (e.g. PoolStats) flatten like maps
+		data, err := json.Marshal(fn())
+		if err != nil {
+			continue
+		}
+		var stats map[string]interface{}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			continue
+		}
+		samples = flatten(samples, namespace+"_"+sanitize(name), stats)
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+	return samples
+}
+
+// WriteText writes samples in the Prometheus text exposition format
+func WriteText(w io.Writer, samples []Sample) error {
+	for _, s := range samples {
+		if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", s.Name, s.Name, s.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatten appends a sample for every numeric or boolean value under prefix
+func flatten(samples []Sample, prefix string, stats map[string]interface{}) []Sample {
+	for key, value := range stats {
+		name := prefix + "_" + sanitize(key)
+		switch v := value.(type) {
+		case float64:
+			samples = append(samples, Sample{Name: name, Value: v})
+		case bool:
+			if v {
+				samples = append(samples, Sample{Name: name, Value: 1})
+			} else {
+				samples = append(samples, Sample{Name: name, Value: 0})
+			}
+		case map[string]interface{}:
+			samples = flatten(samples, name, v)
+		}
+	}
+	return samples
+}
+
+// sanitize maps a stats key to a valid Prometheus metric name component
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
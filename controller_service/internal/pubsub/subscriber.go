@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -24,22 +25,44 @@ type JobMessage struct {
 	RunID          int64    `json:"run_id"`
 	JobID          int64    `json:"job_id"`
 	Labels         []string `json:"labels"`
+	RunnerGroup    string   `json:"runner_group,omitempty"`
 	PoolID         string   `json:"pool_id"`
 	Priority       int      `json:"priority"`
+	MaxRetries     *int     `json:"max_retries,omitempty"` // Overrides scheduler.max_retries for this job
 	ReceivedAt     int64    `json:"received_at"`
 }
 
+// JobEnqueuer is the job persistence that queueing a job message uses
+// *redis.JobStore implements it against Redis, and *redis.MemoryJobStore in memory for tests
+type JobEnqueuer interface {
+	Get(ctx context.Context, jobID string) (*redis.Job, error)
+	MarkSeen(ctx context.Context, jobID string) (bool, error)
+	ForgetSeen(ctx context.Context, jobID string) error
+	Enqueue(ctx context.Context, job *redis.Job) error
+	MaxRetries() int
+}
+
+var (
+	_ JobEnqueuer = (*redis.JobStore)(nil)
+	_ JobEnqueuer = (*redis.MemoryJobStore)(nil)
+)
+
 // Subscriber handles Pub/Sub message consumption
 type Subscriber struct {
 	cfg      *config.Config
 	client   *pubsub.Client
 	sub      *pubsub.Subscription
-	jobStore *redis.JobStore
+	jobStore JobEnqueuer
 
-	ctx    context.Context
+	ctx    context.Context // Cancelled by Stop to stop pulling new messages
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
+	// processCtx outlives ctx so in-flight messages can finish during Stop
+	processCtx    context.Context
+	processCancel context.CancelFunc
+	inFlight      atomic.Int64
+
 	// Metrics
 	receivedMessages int64
 	processedJobs    int64
@@ -47,7 +70,7 @@ type Subscriber struct {
 }
 
 // NewSubscriber creates a new Pub/Sub subscriber
-func NewSubscriber(cfg *config.Config, jobStore *redis.JobStore) (*Subscriber, error) {
+func NewSubscriber(cfg *config.Config, jobStore JobEnqueuer) (*Subscriber, error) {
 	ctx := context.Background()
 
 	client, err := pubsub.NewClient(ctx, cfg.PubSub.ProjectID)
@@ -69,14 +92,17 @@ func NewSubscriber(cfg *config.Config, jobStore *redis.JobStore) (*Subscriber, e
 	}).Info("Pub/Sub subscriber initialized")
 
 	subscriberCtx, cancel := context.WithCancel(context.Background())
+	processCtx, processCancel := context.WithCancel(context.Background())
 
 	return &Subscriber{
-		cfg:      cfg,
-		client:   client,
-		sub:      sub,
-		jobStore: jobStore,
-		ctx:      subscriberCtx,
-		cancel:   cancel,
+		cfg:           cfg,
+		client:        client,
+		sub:           sub,
+		jobStore:      jobStore,
+		ctx:           subscriberCtx,
+		cancel:        cancel,
+		processCtx:    processCtx,
+		processCancel: processCancel,
 	}, nil
 }
 
@@ -92,13 +118,30 @@ func (s *Subscriber) Start() {
 	}()
 }
 
-// Stop stops the subscriber
+// Stop stops pulling new messages, waits up to DrainTimeout for in-flight
+// messages to finish, then closes the client
+// Messages still running after the timeout are cancelled and will be redelivered
 func (s *Subscriber) Stop() error {
 	log := logger.WithComponent("pubsub_subscriber")
-	log.Info("Stopping Pub/Sub subscriber")
+	log.WithField("in_flight", s.inFlight.Load()).Info("Stopping Pub/Sub subscriber")
 
+	// Receive returns once every callback has returned
 	s.cancel()
-	s.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.cfg.PubSub.DrainTimeout):
+		log.WithField("in_flight", s.inFlight.Load()).Warn("Timed out draining in-flight messages, cancelling them")
+		s.processCancel()
+		<-done
+	}
+	s.processCancel()
 
 	if err := s.client.Close(); err != nil {
 		return fmt.Errorf("failed to close pubsub client: %w", err)
@@ -108,14 +151,30 @@ func (s *Subscriber) Stop() error {
 	return nil
 }
 
+// CheckSubscription verifies the configured subscription exists and is reachable
+func (s *Subscriber) CheckSubscription(ctx context.Context) error {
+	exists, err := s.sub.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check subscription: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("subscription %s does not exist", s.cfg.PubSub.Subscription)
+	}
+	return nil
+}
+
 // receiveMessages continuously receives messages from Pub/Sub
 func (s *Subscriber) receiveMessages() {
 	log := logger.WithComponent("pubsub_subscriber")
 
-	err := s.sub.Receive(s.ctx, func(ctx context.Context, msg *pubsub.Message) {
+	err := s.sub.Receive(s.ctx, func(_ context.Context, msg *pubsub.Message) {
 		s.receivedMessages++
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
 
-		if err := s.processMessage(ctx, msg); err != nil {
+		// Not Receive's context: that is cancelled as soon as Stop begins,
+		// which would abort the enqueue half-way
+		if err := s.processMessage(s.processCtx, msg); err != nil {
 			log.WithError(err).Warn("Failed to process message")
 			s.failedMessages++
 			// Nack to retry later
@@ -155,17 +214,75 @@ func (s *Subscriber) processMessage(ctx context.Context, msg *pubsub.Message) er
 		"installation_id": jobMsg.InstallationID,
 	}).Info("Received job message")
 
+	_, err := EnqueueJobMessage(ctx, s.jobStore, s.cfg.Pool.ID, &jobMsg)
+	return err
+}
+
+// EnqueueJobMessage turns a validated job message into a queued job
+// Duplicate deliveries (same installation + GitHub job ID) are skipped
+// Returns false without error if the job was a duplicate
+// Shared by the Pub/Sub subscriber and the GitHub webhook endpoint
+func EnqueueJobMessage(ctx context.Context, jobStore JobEnqueuer, poolID string, jobMsg *JobMessage) (bool, error) {
+	log := logger.WithComponent("pubsub_subscriber")
+
 	// Check for duplicate (idempotency)
-	existingJobID := fmt.Sprintf("%d-%d", jobMsg.InstallationID, jobMsg.JobID)
-	existingJob, err := s.jobStore.Get(ctx, existingJobID)
+	existingJobID := jobMsg.QueueID()
+	existingJob, err := jobStore.Get(ctx, existingJobID)
 	if err == nil && existingJob != nil {
 		log.WithField("job_id", existingJobID).Info("Duplicate job, skipping")
-		return nil
+		return false, nil
+	}
+
+	// The dedup set outlives job details, so a redelivery after a finished job's
+	// details have expired is still caught
+	firstSeen, err := jobStore.MarkSeen(ctx, existingJobID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for duplicate job: %w", err)
+	}
+	if !firstSeen {
+		log.WithField("job_id", existingJobID).Info("Duplicate job (already seen), skipping")
+		return false, nil
 	}
 
+	return enqueueJob(ctx, jobStore, poolID, jobMsg)
+}
+
+// RerunJobMessage queues a job message even if the job was seen before, for manual reruns
+// A job that is still queued, assigned or running is not queued twice; returns false for it
+func RerunJobMessage(ctx context.Context, jobStore JobEnqueuer, poolID string, jobMsg *JobMessage) (bool, error) {
+	log := logger.WithComponent("pubsub_subscriber")
+
+	jobID := jobMsg.QueueID()
+	existingJob, err := jobStore.Get(ctx, jobID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load job: %w", err)
+	}
+	if existingJob != nil {
+		switch existingJob.Status {
+		case redis.JobStatusQueued, redis.JobStatusAssigned, redis.JobStatusRunning:
+			log.WithFields(map[string]interface{}{
+				"job_id": jobID,
+				"status": existingJob.Status,
+			}).Info("Job still active, not rerunning")
+			return false, nil
+		}
+	}
+
+	// Keep the job in the dedup set, so a later redelivery isn't queued a third time
+	if _, err := jobStore.MarkSeen(ctx, jobID); err != nil {
+		return false, fmt.Errorf("failed to record rerun job: %w", err)
+	}
+	return enqueueJob(ctx, jobStore, poolID, jobMsg)
+}
+
+// enqueueJob creates and queues the job for a job message that passed duplicate checks
+// The dedup entry is cleared again if queueing fails
+func enqueueJob(ctx context.Context, jobStore JobEnqueuer, poolID string, jobMsg *JobMessage) (bool, error) {
+	log := logger.WithComponent("pubsub_subscriber")
+
 	// Create job record
 	job := &redis.Job{
-		ID:             existingJobID,
+		ID:             jobMsg.QueueID(),
 		OrgID:          jobMsg.OrgID,
 		OrgName:        jobMsg.OrgName,
 		InstallationID: jobMsg.InstallationID,
@@ -173,21 +290,39 @@ func (s *Subscriber) processMessage(ctx context.Context, msg *pubsub.Message) er
 		RunID:          jobMsg.RunID,
 		JobID:          jobMsg.JobID,
 		Labels:         jobMsg.Labels,
-		PoolID:         s.cfg.Pool.ID,
+		RunnerGroup:    jobMsg.RunnerGroup,
+		PoolID:         poolID,
 		Priority:       jobMsg.Priority,
+		MaxRetries:     jobStore.MaxRetries(),
+	}
+	if jobMsg.MaxRetries != nil {
+		job.MaxRetries = *jobMsg.MaxRetries
 	}
 
 	// Enqueue job
-	if err := s.jobStore.Enqueue(ctx, job); err != nil {
-		return fmt.Errorf("failed to enqueue job: %w", err)
+	if err := jobStore.Enqueue(ctx, job); err != nil {
+		if forgetErr := jobStore.ForgetSeen(ctx, job.ID); forgetErr != nil {
+			log.WithError(forgetErr).WithField("job_id", job.ID).Warn("Failed to clear dedup entry for unqueued job")
+		}
+		return false, fmt.Errorf("failed to enqueue job: %w", err)
 	}
 
 	log.WithField("job_id", job.ID).Info("Job enqueued")
-	return nil
+	return true, nil
+}
+
+// QueueID returns the ID the job is queued under: installation ID and GitHub job ID
+func (m *JobMessage) QueueID() string {
+	return fmt.Sprintf("%d-%d", m.InstallationID, m.JobID)
 }
 
 // validateMessage validates a job message
 func (s *Subscriber) validateMessage(msg *JobMessage) error {
+	return ValidateJobMessage(msg)
+}
+
+// ValidateJobMessage validates the required fields of a job message
+func ValidateJobMessage(msg *JobMessage) error {
 	if msg.InstallationID == 0 {
 		return fmt.Errorf("installation_id is required")
 	}
@@ -197,6 +332,14 @@ func (s *Subscriber) validateMessage(msg *JobMessage) error {
 	if msg.RepoFullName == "" {
 		return fmt.Errorf("repo_full_name is required")
 	}
+	if msg.RunnerGroup != "" {
+		if err := config.ValidateRunnerGroup(msg.RunnerGroup); err != nil {
+			return err
+		}
+	}
+	if msg.MaxRetries != nil && *msg.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must be >= 0")
+	}
 	return nil
 }
 
@@ -206,6 +349,7 @@ func (s *Subscriber) GetStats() map[string]interface{} {
 		"received_messages": s.receivedMessages,
 		"processed_jobs":    s.processedJobs,
 		"failed_messages":   s.failedMessages,
+		"in_flight":         s.inFlight.Load(),
 	}
 }
 
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+func testJobMessage() *JobMessage {
+	return &JobMessage{
+		OrgID:          "org-1",
+		InstallationID: 42,
+		RepoFullName:   "acme/app",
+		RunID:          7,
+		JobID:          1001,
+		Labels:         []string{"self-hosted"},
+		PoolID:         "pool-test",
+	}
+}
+
+// completeJob runs a queued job through to COMPLETED on vm-1
+func completeJob(t *testing.T, s *redis.MemoryJobStore, jobID string) {
+	t.Helper()
+	ctx := context.Background()
+	if job, err := s.ClaimJob(ctx, jobID); err != nil || job == nil {
+		t.Fatalf("ClaimJob = %v, %v", job, err)
+	}
+	if err := s.AssignToVM(ctx, jobID, "vm-1"); err != nil {
+		t.Fatalf("AssignToVM: %v", err)
+	}
+	if err := s.MarkRunning(ctx, jobID); err != nil {
+		t.Fatalf("MarkRunning: %v", err)
+	}
+	if err := s.MarkCompleted(ctx, jobID); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+}
+
+func TestEnqueueJobMessageIgnoresRedeliveryAfterCompletion(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := redis.NewMemoryJobStore("pool-test")
+	ctx := context.Background()
+	msg := testJobMessage()
+
+	enqueued, err := EnqueueJobMessage(ctx, s, "pool-test", msg)
+	if err != nil || !enqueued {
+		t.Fatalf("EnqueueJobMessage = %v, %v, want enqueued", enqueued, err)
+	}
+	completeJob(t, s, msg.QueueID())
+
+	// Pub/Sub redelivers the message after the job already ran
+	enqueued, err = EnqueueJobMessage(ctx, s, "pool-test", msg)
+	if err != nil || enqueued {
+		t.Fatalf("EnqueueJobMessage on redelivery = %v, %v, want skipped", enqueued, err)
+	}
+	if n, _ := s.QueueLength(ctx); n != 0 {
+		t.Errorf("queue length = %d after redelivery, want 0", n)
+	}
+	job, err := s.Get(ctx, msg.QueueID())
+	if err != nil || job == nil || job.Status != redis.JobStatusCompleted {
+		t.Errorf("job after redelivery = %+v, %v, want it still COMPLETED", job, err)
+	}
+}
+
+func TestEnqueueJobMessageIgnoresRedeliveryWhileQueued(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := redis.NewMemoryJobStore("pool-test")
+	ctx := context.Background()
+	msg := testJobMessage()
+
+	for i, want := range []bool{true, false} {
+		enqueued, err := EnqueueJobMessage(ctx, s, "pool-test", msg)
+		if err != nil || enqueued != want {
+			t.Fatalf("delivery %d: EnqueueJobMessage = %v, %v, want %v", i+1, enqueued, err, want)
+		}
+	}
+	if n, _ := s.QueueLength(ctx); n != 1 {
+		t.Errorf("queue length = %d, want 1", n)
+	}
+}
+
+func TestRerunJobMessageQueuesCompletedJobAgain(t *testing.T) {
+	logger.Init("error", "text", logger.Output{})
+	s := redis.NewMemoryJobStore("pool-test")
+	ctx := context.Background()
+	msg := testJobMessage()
+
+	if _, err := EnqueueJobMessage(ctx, s, "pool-test", msg); err != nil {
+		t.Fatalf("EnqueueJobMessage: %v", err)
+	}
+	// A rerun of a job that is still queued is refused
+	if rerun, err := RerunJobMessage(ctx, s, "pool-test", msg); err != nil || rerun {
+		t.Fatalf("RerunJobMessage while queued = %v, %v, want refused", rerun, err)
+	}
+
+	completeJob(t, s, msg.QueueID())
+	if rerun, err := RerunJobMessage(ctx, s, "pool-test", msg); err != nil || !rerun {
+		t.Fatalf("RerunJobMessage after completion = %v, %v, want queued", rerun, err)
+	}
+	if n, _ := s.QueueLength(ctx); n != 1 {
+		t.Errorf("queue length = %d after rerun, want 1", n)
+	}
+}
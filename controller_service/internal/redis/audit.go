@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// auditStreamKey is the fleet-wide command audit stream shared by all pools
+const auditStreamKey = "audit:commands"
+
+// auditStreamMaxLen caps the stream length (approximate trimming)
+const auditStreamMaxLen = 1_000_000
+
+// redactedValue replaces sensitive command parameters in the audit log
+const redactedValue = "[REDACTED]"
+
+// CommandAuditStatus is the lifecycle point an audit entry records
+type CommandAuditStatus string
+
+const (
+	CommandAuditSent       CommandAuditStatus = "SENT"
+	CommandAuditQueued     CommandAuditStatus = "QUEUED"
+	CommandAuditSendFailed CommandAuditStatus = "SEND_FAILED"
+	CommandAuditAcked      CommandAuditStatus = "ACKED"
+	CommandAuditTimeout    CommandAuditStatus = "TIMEOUT"
+	CommandAuditRejected   CommandAuditStatus = "REJECTED" // Not sent: the MIGlet doesn't support the command type
+)
+
+// CommandAuditEntry is a single record in the command audit log
+type CommandAuditEntry struct {
+	ID          string             `json:"id,omitempty"` // Stream entry ID (set on read)
+	CommandID   string             `json:"command_id"`
+	CommandType string             `json:"command_type"`
+	VMID        string             `json:"vm_id"`
+	PoolID      string             `json:"pool_id"`
+	Issuer      string             `json:"issuer"`
+	Status      CommandAuditStatus `json:"status"`
+	Params      map[string]string  `json:"params,omitempty"` // Sensitive values redacted
+	AckSuccess  *bool              `json:"ack_success,omitempty"`
+	AckMessage  string             `json:"ack_message,omitempty"`
+	Timestamp   time.Time          `json:"timestamp"`
+}
+
+// CommandAuditQuery filters audit log reads
+type CommandAuditQuery struct {
+	VMID  string    // Empty matches all VMs
+	Since time.Time // Zero means from the beginning
+	Until time.Time // Zero means up to now
+	Limit int       // Max entries returned (0 uses a default)
+}
+
+// AuditStore appends command audit entries to an append-only Redis stream
+type AuditStore struct {
+	client *redis.Client
+	poolID string
+}
+
+// NewAuditStore creates a new audit store
+func NewAuditStore(cfg *config.RedisInstanceConfig, poolID string) (*AuditStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log := logger.WithComponent("audit_store")
+	log.Info("Connected to audit Redis")
+
+	return &AuditStore{
+		client: client,
+		poolID: poolID,
+	}, nil
+}
+
+// Close closes the Redis connection
+func (s *AuditStore) Close() error {
+	return s.client.Close()
+}
+
+// Record appends an entry to the audit stream
+// Params are redacted before writing; the pool ID and timestamp are filled in if unset
+func (s *AuditStore) Record(ctx context.Context, entry *CommandAuditEntry) error {
+	if entry.PoolID == "" {
+		entry.PoolID = s.poolID
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Params = RedactParams(entry.Params)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	err = s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: auditStreamKey,
+		MaxLen: auditStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"vm_id": entry.VMID,
+			"entry": data,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// Query returns audit entries in chronological order matching the query
+func (s *AuditStore) Query(ctx context.Context, q CommandAuditQuery) ([]*CommandAuditEntry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	// Stream IDs are millisecond timestamps, so time filters map onto the ID range
+	start := "-"
+	if !q.Since.IsZero() {
+		start = strconv.FormatInt(q.Since.UnixMilli(), 10)
+	}
+	end := "+"
+	if !q.Until.IsZero() {
+		end = strconv.FormatInt(q.Until.UnixMilli(), 10)
+	}
+
+	var entries []*CommandAuditEntry
+	for len(entries) < limit {
+		// Over-fetch when filtering by VM since other VMs' entries are skipped
+		messages, err := s.client.XRangeN(ctx, auditStreamKey, start, end, int64(limit)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit stream: %w", err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, msg := range messages {
+			if q.VMID != "" && msg.Values["vm_id"] != q.VMID {
+				continue
+			}
+			raw, _ := msg.Values["entry"].(string)
+			var entry CommandAuditEntry
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				continue
+			}
+			entry.ID = msg.ID
+			entries = append(entries, &entry)
+			if len(entries) >= limit {
+				break
+			}
+		}
+
+		if len(messages) < limit {
+			break
+		}
+		// Continue after the last returned ID (exclusive range)
+		start = "(" + messages[len(messages)-1].ID
+	}
+
+	return entries, nil
+}
+
+// RedactParams returns a copy of params with sensitive values masked
+func RedactParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return params
+	}
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if isSensitiveParam(k) {
+			redacted[k] = redactedValue
+		} else {
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+// isSensitiveParam reports whether a parameter name looks like it carries a credential
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, marker := range []string{"token", "secret", "password", "key", "credential"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
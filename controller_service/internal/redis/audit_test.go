@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+func TestRedactParams(t *testing.T) {
+	params := map[string]string{
+		"registration_token": "AABBCCDD",
+		"webhook_secret":     "s3cret",
+		"db_password":        "hunter2",
+		"api_key":            "k-123",
+		"gcp_credentials":    "{}",
+		"runner_url":         "https://github.com/acme/app",
+		"job_id":             "job-1",
+	}
+
+	got := RedactParams(params)
+
+	for _, name := range []string{"registration_token", "webhook_secret", "db_password", "api_key", "gcp_credentials"} {
+		if got[name] != redactedValue {
+			t.Errorf("%s = %q, want %q", name, got[name], redactedValue)
+		}
+	}
+	for _, name := range []string{"runner_url", "job_id"} {
+		if got[name] != params[name] {
+			t.Errorf("%s = %q, want it kept as %q", name, got[name], params[name])
+		}
+	}
+	if params["registration_token"] != "AABBCCDD" {
+		t.Error("RedactParams modified its input")
+	}
+	if RedactParams(nil) != nil {
+		t.Error("RedactParams(nil) != nil")
+	}
+}
+
+// newRedisAuditStore connects to the Redis at REDIS_TEST_ADDR (host:port), skipping the
+// test when the variable is unset. The audit stream is shared, so entries the test
+// records are deleted by ID when it ends
+func newRedisAuditStore(t *testing.T) *AuditStore {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	s, err := NewAuditStore(&config.RedisInstanceConfig{Host: host, Port: port}, "pool-test")
+	if err != nil {
+		t.Fatalf("NewAuditStore: %v", err)
+	}
+	t.Cleanup(func() { s.client.Close() })
+	return s
+}
+
+func TestAuditStoreRecordsCommandWithTokenMasked(t *testing.T) {
+	s := newRedisAuditStore(t)
+	ctx := context.Background()
+	vmID := fmt.Sprintf("vm-test-%d", time.Now().UnixNano())
+
+	success := true
+	for _, entry := range []*CommandAuditEntry{
+		{CommandID: "cmd-1", CommandType: "register_runner", VMID: vmID, Issuer: "scheduler", Status: CommandAuditSent,
+			Params: map[string]string{"registration_token": "AABBCCDD", "job_id": "job-1"}},
+		{CommandID: "cmd-1", CommandType: "register_runner", VMID: vmID, Issuer: "scheduler", Status: CommandAuditAcked,
+			Params: map[string]string{"registration_token": "AABBCCDD", "job_id": "job-1"}, AckSuccess: &success, AckMessage: "Runner registered"},
+	} {
+		if err := s.Record(ctx, entry); err != nil {
+			t.Fatalf("Record(%s): %v", entry.Status, err)
+		}
+	}
+
+	entries, err := s.Query(ctx, CommandAuditQuery{VMID: vmID})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, entry := range entries {
+			s.client.XDel(context.Background(), auditStreamKey, entry.ID)
+		}
+	})
+
+	if len(entries) != 2 {
+		t.Fatalf("Query returned %d entries, want 2", len(entries))
+	}
+	for i, want := range []CommandAuditStatus{CommandAuditSent, CommandAuditAcked} {
+		entry := entries[i]
+		if entry.Status != want || entry.CommandID != "cmd-1" || entry.PoolID != "pool-test" {
+			t.Errorf("entry %d = %+v, want %s for cmd-1 in pool-test", i, entry, want)
+		}
+		if got := entry.Params["registration_token"]; got != redactedValue {
+			t.Errorf("entry %d registration_token = %q, want it masked", i, got)
+		}
+		if got := entry.Params["job_id"]; got != "job-1" {
+			t.Errorf("entry %d job_id = %q, want job-1", i, got)
+		}
+	}
+	if ack := entries[1]; ack.AckSuccess == nil || !*ack.AckSuccess || ack.AckMessage != "Runner registered" {
+		t.Errorf("ack entry = %+v, want a successful ack", ack)
+	}
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// EventDedupStore remembers MIGlet event IDs in Redis, so an event retried over HTTP is
+// dropped even when the retry reaches a different replica than the original send
+type EventDedupStore struct {
+	client *redis.Client
+	poolID string
+}
+
+// NewEventDedupStore creates a new event dedup store
+func NewEventDedupStore(cfg *config.RedisInstanceConfig, poolID string) (*EventDedupStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log := logger.WithComponent("event_dedup_store")
+	log.Info("Connected to event dedup Redis")
+
+	return &EventDedupStore{
+		client: client,
+		poolID: poolID,
+	}, nil
+}
+
+// Close closes the Redis connection
+func (s *EventDedupStore) Close() error {
+	return s.client.Close()
+}
+
+// MarkSeen records eventID for window and reports whether this is its first sighting
+// Uses SET NX, so of several replicas handling the same event only one sees true
+func (s *EventDedupStore) MarkSeen(ctx context.Context, eventID string, window time.Duration) (bool, error) {
+	key := fmt.Sprintf("events:seen:%s:%s", s.poolID, eventID)
+	first, err := s.client.SetNX(ctx, key, 1, window).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record event ID: %w", err)
+	}
+	return first, nil
+}
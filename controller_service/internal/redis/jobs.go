@@ -24,6 +24,66 @@ const (
 	JobStatusCancelled JobStatus = "CANCELLED"
 )
 
+// allJobStatuses lists every status that has an index set
+var allJobStatuses = []JobStatus{
+	JobStatusQueued,
+	JobStatusAssigned,
+	JobStatusRunning,
+	JobStatusCompleted,
+	JobStatusFailed,
+	JobStatusCancelled,
+}
+
+// jobRetention matches how long job details are kept
+const jobRetention = 7 * 24 * time.Hour
+
+// jobDedupRetention is how long a job ID is remembered for dedup
+// Longer than jobRetention so a late redelivery can't re-run a job whose details have expired
+const jobDedupRetention = 30 * 24 * time.Hour
+
+// claimJobScript moves one job from the queue to the processing set
+// Returns 1 if the job was queued and is now claimed by the caller
+var claimJobScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// promoteDelayedScript moves delayed jobs due by ARGV[1] to the queue, scored by priority and
+// due time like queueScore; ARGV[2] is the job details key prefix. Jobs that expired or left
+// QUEUED (ARGV[3]) while waiting are dropped. Atomic, so concurrent callers can't both queue one
+// Returns the number of jobs queued
+var promoteDelayedScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES')
+local promoted = 0
+for i = 1, #due, 2 do
+	local jobID = due[i]
+	redis.call('ZREM', KEYS[1], jobID)
+	local data = redis.call('GET', ARGV[2] .. jobID)
+	if data then
+		local job = cjson.decode(data)
+		if job.status == ARGV[3] then
+			local score = (tonumber(job.priority) or 0) * 1e12 + tonumber(due[i + 1])
+			redis.call('ZADD', KEYS[2], score, jobID)
+			promoted = promoted + 1
+		end
+	end
+end
+return promoted
+`)
+
+// reclaimScript moves a job whose claim expired from the processing set back to the queue
+// Returns 1 if the caller removed the claim, so concurrent reapers can't both requeue it
+var reclaimScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], 'NX', ARGV[2], ARGV[1])
+return 1
+`)
+
 // Job represents a job in the queue
 type Job struct {
 	ID             string    `json:"id"`
@@ -34,14 +94,19 @@ type Job struct {
 	RunID          int64     `json:"run_id"`
 	JobID          int64     `json:"job_id"`
 	Labels         []string  `json:"labels"`
+	RunnerGroup    string    `json:"runner_group,omitempty"` // Empty means use the pool's runner group
 	PoolID         string    `json:"pool_id"`
 	Priority       int       `json:"priority"`
 	Status         JobStatus `json:"status"`
 	AssignedVMID   string    `json:"assigned_vm_id,omitempty"`
 	AssignedAt     time.Time `json:"assigned_at,omitempty"`
+	RunnerName     string    `json:"runner_name,omitempty"` // Runner registered for the job, as reported by MIGlet
+	RunnerID       int64     `json:"runner_id,omitempty"`   // GitHub runner ID, once the runner is known
 	StartedAt      time.Time `json:"started_at,omitempty"`
 	CompletedAt    time.Time `json:"completed_at,omitempty"`
 	ErrorMessage   string    `json:"error_message,omitempty"`
+	CrashLog       string    `json:"crash_log,omitempty"` // Runner output tail from the last crash while running this job
+	Stuck          bool      `json:"stuck,omitempty"`     // Running but the runner has printed nothing for StuckJobTimeout
 	RetryCount     int       `json:"retry_count"`
 	MaxRetries     int       `json:"max_retries"`
 	CreatedAt      time.Time `json:"created_at"`
@@ -52,6 +117,16 @@ type Job struct {
 type JobStore struct {
 	client *redis.Client
 	poolID string
+	retryPolicy
+}
+
+// retryPolicy holds a job store's retry budget and requeue backoff
+type retryPolicy struct {
+	// Backoff for requeues that count as a retry, see SetRetryBackoff
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+
+	maxRetries int // Retry budget for jobs without their own, see SetMaxRetries
 }
 
 // NewJobStore creates a new job store
@@ -74,22 +149,64 @@ func NewJobStore(cfg *config.RedisInstanceConfig, poolID string) (*JobStore, err
 	log.Info("Connected to Jobs Redis")
 
 	return &JobStore{
-		client: client,
-		poolID: poolID,
+		client:      client,
+		poolID:      poolID,
+		retryPolicy: retryPolicy{maxRetries: 3},
 	}, nil
 }
 
+// SetRetryBackoff delays requeues that count as a retry by interval * 2^(retries-1), capped at max
+// Delayed jobs wait in a separate set until PromoteDelayedJobs moves them back to the queue,
+// so a job that keeps failing doesn't spin through the queue. A zero interval requeues immediately
+func (p *retryPolicy) SetRetryBackoff(interval, max time.Duration) {
+	p.retryInterval = interval
+	p.maxRetryInterval = max
+}
+
+// SetMaxRetries sets the retry budget (scheduler.max_retries) for jobs that don't carry their own
+func (p *retryPolicy) SetMaxRetries(maxRetries int) {
+	p.maxRetries = maxRetries
+}
+
+// MaxRetries returns the retry budget for jobs that don't carry their own
+func (p *retryPolicy) MaxRetries() int {
+	return p.maxRetries
+}
+
+// retryDelay returns how long a job that has been retried retryCount times waits before requeueing
+func (p *retryPolicy) retryDelay(retryCount int) time.Duration {
+	if p.retryInterval <= 0 || retryCount <= 0 {
+		return 0
+	}
+	delay := p.retryInterval
+	for i := 1; i < retryCount && (p.maxRetryInterval <= 0 || delay < p.maxRetryInterval); i++ {
+		delay *= 2
+	}
+	if p.maxRetryInterval > 0 && delay > p.maxRetryInterval {
+		delay = p.maxRetryInterval
+	}
+	return delay
+}
+
 // Close closes the Redis connection
 func (s *JobStore) Close() error {
 	return s.client.Close()
 }
 
+// Ping checks the Redis connection
+func (s *JobStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
 // Enqueue adds a job to the queue
+// The job keeps the MaxRetries its caller set, which must be >= 0
 func (s *JobStore) Enqueue(ctx context.Context, job *Job) error {
+	if job.MaxRetries < 0 {
+		return fmt.Errorf("invalid max_retries %d: must be >= 0", job.MaxRetries)
+	}
 	job.Status = JobStatusQueued
 	job.CreatedAt = time.Now()
 	job.UpdatedAt = time.Now()
-	job.MaxRetries = 3
 
 	// Store job details
 	if err := s.saveJob(ctx, job); err != nil {
@@ -97,11 +214,10 @@ func (s *JobStore) Enqueue(ctx context.Context, job *Job) error {
 	}
 
 	// Add to queue (sorted set with priority + timestamp score)
-	score := float64(job.Priority)*1e12 + float64(job.CreatedAt.UnixNano())
 	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
 
 	if err := s.client.ZAdd(ctx, queueKey, redis.Z{
-		Score:  score,
+		Score:  queueScore(job.Priority, job.CreatedAt),
 		Member: job.ID,
 	}).Err(); err != nil {
 		return fmt.Errorf("failed to add job to queue: %w", err)
@@ -113,38 +229,131 @@ func (s *JobStore) Enqueue(ctx context.Context, job *Job) error {
 	return nil
 }
 
-// Dequeue removes and returns the highest priority job from the queue
-func (s *JobStore) Dequeue(ctx context.Context) (*Job, error) {
+// Peek returns the next job without removing it
+func (s *JobStore) Peek(ctx context.Context) (*Job, error) {
 	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
 
-	// Pop the job with lowest score (highest priority + oldest)
-	result, err := s.client.ZPopMin(ctx, queueKey, 1).Result()
+	result, err := s.client.ZRange(ctx, queueKey, 0, 0).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to pop job from queue: %w", err)
+		return nil, fmt.Errorf("failed to peek job: %w", err)
 	}
 
 	if len(result) == 0 {
-		return nil, nil // No jobs available
+		return nil, nil
 	}
 
-	jobID := result[0].Member.(string)
-	return s.Get(ctx, jobID)
+	return s.Get(ctx, result[0])
 }
 
-// Peek returns the next job without removing it
-func (s *JobStore) Peek(ctx context.Context) (*Job, error) {
+// PeekN returns up to n jobs from the head of the queue without removing them
+func (s *JobStore) PeekN(ctx context.Context, n int) ([]*Job, error) {
 	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
 
-	result, err := s.client.ZRange(ctx, queueKey, 0, 0).Result()
+	jobIDs, err := s.client.ZRange(ctx, queueKey, 0, int64(n-1)).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to peek job: %w", err)
+		return nil, fmt.Errorf("failed to peek jobs: %w", err)
 	}
 
-	if len(result) == 0 {
+	if len(jobIDs) == 0 {
 		return nil, nil
 	}
 
-	return s.Get(ctx, result[0])
+	// One MGET for every job's details, since this runs on each scheduler poll
+	return s.getMany(ctx, jobIDs)
+}
+
+// getMany reads the details of several jobs with one MGET, in the given order
+// Jobs whose details have expired are left out
+func (s *JobStore) getMany(ctx context.Context, jobIDs []string) ([]*Job, error) {
+	keys := make([]string, len(jobIDs))
+	for i, jobID := range jobIDs {
+		keys[i] = fmt.Sprintf("jobs:details:%s", jobID)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %w", err)
+	}
+
+	jobs := make([]*Job, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue // Details expired while the job was queued
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// ClaimJob moves a specific job from the queue to the processing set and returns it
+// The claim lasts until the job leaves QUEUED (assigned, failed, cancelled) or is requeued;
+// if the claimer crashes first, ReapExpiredClaims returns the job to the queue.
+// Returns nil if the job is no longer queued (e.g. another caller took it)
+func (s *JobStore) ClaimJob(ctx context.Context, jobID string) (*Job, error) {
+	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
+
+	claimed, err := claimJobScript.Run(ctx, s.client, []string{queueKey, s.processingKey()}, jobID, time.Now().UnixNano()).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	if claimed == 0 {
+		return nil, nil
+	}
+
+	return s.Get(ctx, jobID)
+}
+
+// ReapExpiredClaims returns jobs claimed longer than leaseTTL ago to the queue
+// A claim that old means its scheduler crashed (or lost Redis) between claiming and
+// assigning the job. Reclaimed jobs keep their original queue position, and don't count
+// as a retry. Returns the number of jobs requeued
+func (s *JobStore) ReapExpiredClaims(ctx context.Context, leaseTTL time.Duration) (int, error) {
+	expired, err := s.client.ZRangeByScore(ctx, s.processingKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", time.Now().Add(-leaseTTL).UnixNano()),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired claims: %w", err)
+	}
+
+	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
+	reaped := 0
+	for _, jobID := range expired {
+		job, err := s.Get(ctx, jobID)
+		if err != nil {
+			return reaped, err
+		}
+		if job == nil || job.Status != JobStatusQueued {
+			// Expired, or moved on without its claim being released
+			s.client.ZRem(ctx, s.processingKey(), jobID)
+			continue
+		}
+
+		requeued, err := reclaimScript.Run(ctx, s.client, []string{s.processingKey(), queueKey},
+			jobID, queueScore(job.Priority, job.CreatedAt)).Int()
+		if err != nil {
+			return reaped, fmt.Errorf("failed to requeue expired claim: %w", err)
+		}
+		if requeued == 1 {
+			logger.WithJob(jobID, s.poolID).Warn("Job claim expired, returned to the queue")
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+// ProcessingLength returns the number of claimed jobs not yet assigned, failed or requeued
+func (s *JobStore) ProcessingLength(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, s.processingKey()).Result()
+}
+
+// processingKey returns the sorted set of claimed job IDs (score = when claimed)
+func (s *JobStore) processingKey() string {
+	return fmt.Sprintf("jobs:processing:%s", s.poolID)
 }
 
 // Get retrieves a job by ID
@@ -199,6 +408,22 @@ func (s *JobStore) AssignToVM(ctx context.Context, jobID, vmID string) error {
 	return nil
 }
 
+// SetRunner records the runner registered for a job
+func (s *JobStore) SetRunner(ctx context.Context, jobID, runnerName string, runnerID int64) error {
+	job, err := s.Get(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	job.RunnerName = runnerName
+	job.RunnerID = runnerID
+
+	return s.Update(ctx, job)
+}
+
 // MarkRunning marks a job as running
 func (s *JobStore) MarkRunning(ctx context.Context, jobID string) error {
 	job, err := s.Get(ctx, jobID)
@@ -267,8 +492,53 @@ func (s *JobStore) MarkFailed(ctx context.Context, jobID, errorMsg string) error
 	return nil
 }
 
-// Requeue puts a job back in the queue for retry
+// MarkCancelled marks a job as cancelled
+func (s *JobStore) MarkCancelled(ctx context.Context, jobID, reason string) error {
+	job, err := s.Get(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	job.Status = JobStatusCancelled
+	job.CompletedAt = time.Now()
+	job.ErrorMessage = reason
+
+	if err := s.Update(ctx, job); err != nil {
+		return err
+	}
+
+	// Clear job from VM tracking
+	if job.AssignedVMID != "" {
+		vmJobKey := fmt.Sprintf("jobs:by_vm:%s", job.AssignedVMID)
+		s.client.Del(ctx, vmJobKey)
+	}
+
+	return nil
+}
+
+// Requeue puts a job back in the queue for retry, once its retry backoff has passed
 func (s *JobStore) Requeue(ctx context.Context, jobID string) error {
+	return s.requeue(ctx, jobID, true)
+}
+
+// RequeuePreempted puts a job interrupted by VM preemption back in the queue
+// Preemption isn't the job's fault, so it doesn't count against MaxRetries
+func (s *JobStore) RequeuePreempted(ctx context.Context, jobID string) error {
+	return s.requeue(ctx, jobID, false)
+}
+
+// RequeueUnsent puts back a job whose assignment was never sent to a VM, e.g. because
+// the VM's pending command queue was full. No attempt was made, so it doesn't count
+// against MaxRetries
+func (s *JobStore) RequeueUnsent(ctx context.Context, jobID string) error {
+	return s.requeue(ctx, jobID, false)
+}
+
+// requeue resets a job to QUEUED and adds it back to the queue
+func (s *JobStore) requeue(ctx context.Context, jobID string, countRetry bool) error {
 	job, err := s.Get(ctx, jobID)
 	if err != nil {
 		return err
@@ -277,7 +547,9 @@ func (s *JobStore) Requeue(ctx context.Context, jobID string) error {
 		return fmt.Errorf("job not found: %s", jobID)
 	}
 
-	job.RetryCount++
+	if countRetry {
+		job.RetryCount++
+	}
 	job.Status = JobStatusQueued
 	job.AssignedVMID = ""
 	job.AssignedAt = time.Time{}
@@ -287,16 +559,55 @@ func (s *JobStore) Requeue(ctx context.Context, jobID string) error {
 		return err
 	}
 
+	// The job is back under the queue's control, so whoever claimed it is done with it
+	if err := s.client.ZRem(ctx, s.processingKey(), job.ID).Err(); err != nil {
+		return fmt.Errorf("failed to release job claim: %w", err)
+	}
+
+	if countRetry {
+		if delay := s.retryDelay(job.RetryCount); delay > 0 {
+			logger.WithJob(job.ID, s.poolID).WithFields(map[string]interface{}{
+				"retry_count": job.RetryCount,
+				"delay":       delay.String(),
+			}).Info("Job requeue delayed")
+			return s.client.ZAdd(ctx, s.delayedKey(), redis.Z{
+				Score:  float64(time.Now().Add(delay).UnixNano()),
+				Member: job.ID,
+			}).Err()
+		}
+	}
+
 	// Add back to queue
-	score := float64(job.Priority)*1e12 + float64(time.Now().UnixNano())
 	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
 
 	return s.client.ZAdd(ctx, queueKey, redis.Z{
-		Score:  score,
+		Score:  queueScore(job.Priority, time.Now()),
 		Member: job.ID,
 	}).Err()
 }
 
+// PromoteDelayedJobs moves delayed requeues whose backoff has passed back to the queue
+// They are queued as of when they became eligible. Returns the number of jobs moved
+func (s *JobStore) PromoteDelayedJobs(ctx context.Context) (int, error) {
+	promoted, err := promoteDelayedScript.Run(ctx, s.client,
+		[]string{s.delayedKey(), fmt.Sprintf("jobs:queue:%s", s.poolID)},
+		time.Now().UnixNano(), "jobs:details:", string(JobStatusQueued),
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to promote delayed jobs: %w", err)
+	}
+	return promoted, nil
+}
+
+// DelayedLength returns the number of requeued jobs waiting out their retry backoff
+func (s *JobStore) DelayedLength(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, s.delayedKey()).Result()
+}
+
+func (s *JobStore) delayedKey() string {
+	return fmt.Sprintf("jobs:delayed:%s", s.poolID)
+}
+
 // GetByVM returns the current job for a VM
 func (s *JobStore) GetByVM(ctx context.Context, vmID string) (*Job, error) {
 	vmJobKey := fmt.Sprintf("jobs:by_vm:%s", vmID)
@@ -310,13 +621,171 @@ func (s *JobStore) GetByVM(ctx context.Context, vmID string) (*Job, error) {
 	return s.Get(ctx, jobID)
 }
 
+// MarkSeen records that a job ID has been accepted for enqueueing
+// Returns false if it was already seen within jobDedupRetention; the check and
+// the insert are a single ZADD NX, so concurrent deliveries can't both win
+func (s *JobStore) MarkSeen(ctx context.Context, jobID string) (bool, error) {
+	seenKey := s.seenKey()
+	now := time.Now()
+
+	var added *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Drop entries older than the dedup window first, so an expired ID counts as new
+		cutoff := now.Add(-jobDedupRetention).UnixNano()
+		pipe.ZRemRangeByScore(ctx, seenKey, "-inf", fmt.Sprintf("(%d", cutoff))
+		added = pipe.ZAddNX(ctx, seenKey, redis.Z{
+			Score:  float64(now.UnixNano()),
+			Member: jobID,
+		})
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record seen job: %w", err)
+	}
+	return added.Val() == 1, nil
+}
+
+// ForgetSeen removes a job ID from the dedup set, e.g. when enqueueing it failed
+// so a redelivery of the same message can try again
+func (s *JobStore) ForgetSeen(ctx context.Context, jobID string) error {
+	return s.client.ZRem(ctx, s.seenKey(), jobID).Err()
+}
+
+// seenKey returns the sorted set of job IDs accepted for this pool (score = when first seen)
+func (s *JobStore) seenKey() string {
+	return fmt.Sprintf("jobs:seen:%s", s.poolID)
+}
+
+// AgeQueuedJobs boosts jobs that have been queued longer than threshold by one
+// priority level per interval beyond it, so low-priority work can't starve
+// The boost is recomputed from the queue time each pass, not accumulated
+// Returns the number of jobs rescored
+func (s *JobStore) AgeQueuedJobs(ctx context.Context, threshold, interval time.Duration) (int, error) {
+	if threshold <= 0 || interval <= 0 {
+		return 0, nil
+	}
+
+	// The QUEUED index is scored by when each job (re)entered the queue, oldest first
+	now := time.Now()
+	cutoff := now.Add(-threshold).UnixNano()
+	stale, err := s.client.ZRangeByScoreWithScores(ctx, s.statusIndexKey(JobStatusQueued), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", cutoff),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list queued jobs: %w", err)
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	queuedAt := make(map[string]time.Time, len(stale))
+	jobIDs := make([]string, 0, len(stale))
+	for _, z := range stale {
+		if jobID, ok := z.Member.(string); ok {
+			queuedAt[jobID] = time.Unix(0, int64(z.Score))
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+
+	// Read the jobs before the pipeline; commands queued on it don't run until it executes
+	jobs, err := s.getMany(ctx, jobIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
+	aged := 0
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, job := range jobs {
+			if job.Status != JobStatusQueued {
+				continue
+			}
+
+			since := queuedAt[job.ID]
+			boost := int((now.Sub(since)-threshold)/interval) + 1
+
+			// XX: only rescore jobs still in the queue, never re-add a dequeued one
+			pipe.ZAddXX(ctx, queueKey, redis.Z{
+				Score:  queueScore(job.Priority-boost, since),
+				Member: job.ID,
+			})
+			aged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to rescore queued jobs: %w", err)
+	}
+	return aged, nil
+}
+
+// queueScore orders the queue by priority (lower first), then by time
+// One priority level is worth 1e12ns (~17 minutes) of queue time
+func queueScore(priority int, at time.Time) float64 {
+	return float64(priority)*1e12 + float64(at.UnixNano())
+}
+
 // QueueLength returns the number of jobs in the queue
 func (s *JobStore) QueueLength(ctx context.Context) (int64, error) {
 	queueKey := fmt.Sprintf("jobs:queue:%s", s.poolID)
 	return s.client.ZCard(ctx, queueKey).Result()
 }
 
-// saveJob saves job details to Redis
+// GetByStatus returns jobs currently in status, oldest transition first
+func (s *JobStore) GetByStatus(ctx context.Context, status JobStatus) ([]*Job, error) {
+	indexKey := s.statusIndexKey(status)
+	jobIDs, err := s.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job status index: %w", err)
+	}
+
+	jobs := make([]*Job, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		job, err := s.Get(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			// Details expired; drop the dangling index entry
+			s.client.ZRem(ctx, indexKey, jobID)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// GetRunning returns jobs currently running, longest-running first
+func (s *JobStore) GetRunning(ctx context.Context) ([]*Job, error) {
+	return s.GetByStatus(ctx, JobStatusRunning)
+}
+
+// GetAssigned returns jobs assigned to a VM that haven't started yet, oldest first
+func (s *JobStore) GetAssigned(ctx context.Context) ([]*Job, error) {
+	return s.GetByStatus(ctx, JobStatusAssigned)
+}
+
+// CountByStatus returns the number of jobs in each status
+func (s *JobStore) CountByStatus(ctx context.Context) (map[JobStatus]int64, error) {
+	counts := make(map[JobStatus]int64, len(allJobStatuses))
+	for _, status := range allJobStatuses {
+		count, err := s.client.ZCard(ctx, s.statusIndexKey(status)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count jobs by status: %w", err)
+		}
+		counts[status] = count
+	}
+	return counts, nil
+}
+
+// statusIndexKey returns the sorted set indexing jobs in status (score = when they entered it)
+func (s *JobStore) statusIndexKey(status JobStatus) string {
+	return fmt.Sprintf("jobs:by_status:%s:%s", s.poolID, status)
+}
+
+// saveJob saves job details to Redis and moves the job to its status index
+// Details and indexes are written in one transaction so they can't diverge
 func (s *JobStore) saveJob(ctx context.Context, job *Job) error {
 	key := fmt.Sprintf("jobs:details:%s", job.ID)
 	data, err := json.Marshal(job)
@@ -324,7 +793,30 @@ func (s *JobStore) saveJob(ctx context.Context, job *Job) error {
 		return fmt.Errorf("failed to marshal job: %w", err)
 	}
 
-	// Store with 7-day expiry
-	return s.client.Set(ctx, key, data, 7*24*time.Hour).Err()
-}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Store with 7-day expiry
+		pipe.Set(ctx, key, data, jobRetention)
 
+		for _, status := range allJobStatuses {
+			if status != job.Status {
+				pipe.ZRem(ctx, s.statusIndexKey(status), job.ID)
+			}
+		}
+		// A claimed job that leaves QUEUED has been dealt with; release the claim
+		if job.Status != JobStatusQueued {
+			pipe.ZRem(ctx, s.processingKey(), job.ID)
+		}
+
+		// NX keeps the original entry time when a job is saved without changing status
+		indexKey := s.statusIndexKey(job.Status)
+		pipe.ZAddNX(ctx, indexKey, redis.Z{
+			Score:  float64(job.UpdatedAt.UnixNano()),
+			Member: job.ID,
+		})
+		// Expire index entries along with the details they point to
+		cutoff := time.Now().Add(-jobRetention).UnixNano()
+		pipe.ZRemRangeByScore(ctx, indexKey, "-inf", fmt.Sprintf("(%d", cutoff))
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,258 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// newRedisJobStore connects to the Redis at REDIS_TEST_ADDR (host:port) with a pool ID
+// unique to the test, skipping the test when the variable is unset
+// Jobs must have IDs containing the pool ID so their keys are deleted when the test ends
+func newRedisJobStore(t *testing.T) *JobStore {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	poolID := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	s, err := NewJobStore(&config.RedisInstanceConfig{Host: host, Port: port}, poolID)
+	if err != nil {
+		t.Fatalf("NewJobStore: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, _ := s.client.Keys(ctx, "jobs*"+poolID+"*").Result()
+		if len(keys) > 0 {
+			s.client.Del(ctx, keys...)
+		}
+		s.client.Close()
+	})
+	return s
+}
+
+// enqueuer is satisfied by both JobStore and MemoryJobStore
+type enqueuer interface {
+	Enqueue(ctx context.Context, job *Job) error
+}
+
+// enqueueTestJob queues a job with a retry budget of 3
+func enqueueTestJob(t *testing.T, s enqueuer, jobID string) {
+	t.Helper()
+	if err := s.Enqueue(context.Background(), &Job{ID: jobID, PoolID: "pool-test", MaxRetries: 3}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func TestReapExpiredClaimsRequeuesAbandonedClaim(t *testing.T) {
+	s := newRedisJobStore(t)
+	ctx := context.Background()
+	jobID := s.poolID + "-job-1"
+	enqueueTestJob(t, s, jobID)
+
+	// The claiming scheduler crashes before assigning the job
+	if job, err := s.ClaimJob(ctx, jobID); err != nil || job == nil {
+		t.Fatalf("ClaimJob = %v, %v", job, err)
+	}
+	if reaped, err := s.ReapExpiredClaims(ctx, time.Minute); err != nil || reaped != 0 {
+		t.Fatalf("ReapExpiredClaims within the lease = %d, %v, want 0", reaped, err)
+	}
+
+	s.client.ZAdd(ctx, s.processingKey(), redis.Z{Score: float64(time.Now().Add(-2 * time.Minute).UnixNano()), Member: jobID})
+	if reaped, err := s.ReapExpiredClaims(ctx, time.Minute); err != nil || reaped != 1 {
+		t.Fatalf("ReapExpiredClaims after the lease = %d, %v, want 1", reaped, err)
+	}
+	if n, _ := s.ProcessingLength(ctx); n != 0 {
+		t.Errorf("processing = %d after reaping, want 0", n)
+	}
+	job, err := s.ClaimJob(ctx, jobID)
+	if err != nil || job == nil || job.ID != jobID {
+		t.Fatalf("ClaimJob after reaping = %v, %v, want %s", job, err, jobID)
+	}
+}
+
+func TestClaimJobOnlyOneClaimerWins(t *testing.T) {
+	s := newRedisJobStore(t)
+	jobID := s.poolID + "-job-1"
+	enqueueTestJob(t, s, jobID)
+
+	var wg sync.WaitGroup
+	var claimed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if job, err := s.ClaimJob(context.Background(), jobID); err == nil && job != nil {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := claimed.Load(); got != 1 {
+		t.Fatalf("job claimed %d times, want once", got)
+	}
+}
+
+// jobLifecycle is the part of JobStore and MemoryJobStore that moves a job between statuses
+type jobLifecycle interface {
+	enqueuer
+	ClaimJob(ctx context.Context, jobID string) (*Job, error)
+	AssignToVM(ctx context.Context, jobID, vmID string) error
+	MarkRunning(ctx context.Context, jobID string) error
+	MarkCompleted(ctx context.Context, jobID string) error
+	Requeue(ctx context.Context, jobID string) error
+	GetByStatus(ctx context.Context, status JobStatus) ([]*Job, error)
+	CountByStatus(ctx context.Context) (map[JobStatus]int64, error)
+}
+
+// assertOnlyInStatus checks the status index lists jobID under want and no other status
+func assertOnlyInStatus(t *testing.T, s jobLifecycle, jobID string, want JobStatus) {
+	t.Helper()
+	ctx := context.Background()
+
+	counts, err := s.CountByStatus(ctx)
+	if err != nil {
+		t.Fatalf("CountByStatus: %v", err)
+	}
+	for _, status := range allJobStatuses {
+		jobs, err := s.GetByStatus(ctx, status)
+		if err != nil {
+			t.Fatalf("GetByStatus(%s): %v", status, err)
+		}
+		listed := false
+		for _, job := range jobs {
+			if job.ID == jobID {
+				listed = true
+				if job.Status != status {
+					t.Errorf("job listed under %s has status %s", status, job.Status)
+				}
+			}
+		}
+		if listed != (status == want) {
+			t.Errorf("job listed under %s = %v, want it only under %s", status, listed, want)
+		}
+		if counts[status] != int64(len(jobs)) {
+			t.Errorf("CountByStatus(%s) = %d, GetByStatus returned %d", status, counts[status], len(jobs))
+		}
+	}
+}
+
+// checkStatusIndexLifecycle walks a job through a failed attempt, a requeue and a successful
+// one, checking the status index after every transition
+func checkStatusIndexLifecycle(t *testing.T, s jobLifecycle, jobID, vmID string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enqueueTestJob(t, s, jobID)
+	assertOnlyInStatus(t, s, jobID, JobStatusQueued)
+
+	// A claim leaves the job QUEUED until it is assigned
+	if job, err := s.ClaimJob(ctx, jobID); err != nil || job == nil {
+		t.Fatalf("ClaimJob = %v, %v", job, err)
+	}
+	assertOnlyInStatus(t, s, jobID, JobStatusQueued)
+
+	// The requeued job waits out its retry backoff, so the second attempt is assigned unclaimed
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := s.AssignToVM(ctx, jobID, vmID); err != nil {
+			t.Fatalf("AssignToVM: %v", err)
+		}
+		assertOnlyInStatus(t, s, jobID, JobStatusAssigned)
+
+		if err := s.MarkRunning(ctx, jobID); err != nil {
+			t.Fatalf("MarkRunning: %v", err)
+		}
+		assertOnlyInStatus(t, s, jobID, JobStatusRunning)
+
+		if attempt == 1 {
+			if err := s.Requeue(ctx, jobID); err != nil {
+				t.Fatalf("Requeue: %v", err)
+			}
+			assertOnlyInStatus(t, s, jobID, JobStatusQueued)
+		}
+	}
+
+	if err := s.MarkCompleted(ctx, jobID); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	assertOnlyInStatus(t, s, jobID, JobStatusCompleted)
+}
+
+func TestStatusIndexFollowsJobLifecycle(t *testing.T) {
+	s := newRedisJobStore(t)
+	checkStatusIndexLifecycle(t, s, s.poolID+"-job-1", s.poolID+"-vm-1")
+}
+
+// queueAger is the part of JobStore and MemoryJobStore that ages queued jobs
+type queueAger interface {
+	enqueuer
+	PeekN(ctx context.Context, n int) ([]*Job, error)
+	AgeQueuedJobs(ctx context.Context, threshold, interval time.Duration) (int, error)
+}
+
+// checkAgedJobOvertakes queues a low-priority job, backdates it by two hours, then queues
+// fresh high-priority jobs, and checks that aging moves the old job to the head of the queue
+func checkAgedJobOvertakes(t *testing.T, s queueAger, jobPrefix string, backdate func(jobID string, queuedAt time.Time)) {
+	t.Helper()
+	ctx := context.Background()
+
+	oldID := jobPrefix + "-old"
+	if err := s.Enqueue(ctx, &Job{ID: oldID, PoolID: "pool-test", Priority: 10}); err != nil {
+		t.Fatalf("Enqueue(%s): %v", oldID, err)
+	}
+	backdate(oldID, time.Now().Add(-2*time.Hour))
+	for i := 1; i <= 3; i++ {
+		jobID := fmt.Sprintf("%s-fresh-%d", jobPrefix, i)
+		if err := s.Enqueue(ctx, &Job{ID: jobID, PoolID: "pool-test", Priority: 0}); err != nil {
+			t.Fatalf("Enqueue(%s): %v", jobID, err)
+		}
+	}
+
+	head := func() string {
+		t.Helper()
+		jobs, err := s.PeekN(ctx, 4)
+		if err != nil || len(jobs) != 4 {
+			t.Fatalf("PeekN = %d jobs, %v, want 4", len(jobs), err)
+		}
+		return jobs[0].ID
+	}
+	if got := head(); got == oldID {
+		t.Fatalf("old low-priority job at the head before aging")
+	}
+
+	// 2h queued, 10m threshold and 10m interval: boosted by 12 levels, past the 10 it trails by
+	aged, err := s.AgeQueuedJobs(ctx, 10*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("AgeQueuedJobs: %v", err)
+	}
+	if aged != 1 {
+		t.Errorf("AgeQueuedJobs aged %d jobs, want only the old one", aged)
+	}
+	if got := head(); got != oldID {
+		t.Errorf("head after aging = %s, want %s", got, oldID)
+	}
+}
+
+func TestAgeQueuedJobsOldJobOvertakesFreshHighPriority(t *testing.T) {
+	s := newRedisJobStore(t)
+	checkAgedJobOvertakes(t, s, s.poolID, func(jobID string, queuedAt time.Time) {
+		s.client.ZAdd(context.Background(), s.statusIndexKey(JobStatusQueued), redis.Z{Score: float64(queuedAt.UnixNano()), Member: jobID})
+	})
+}
@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// acquireLeaderScript takes the lock if it is free or extends it if we already hold it
+// Returns 1 when the caller holds the lock afterwards
+var acquireLeaderScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if not holder then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseLeaderScript deletes the lock only if we still hold it
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// LeaderLock is a Redis lease that elects one controller replica per pool to run the scheduler
+type LeaderLock struct {
+	client *redis.Client
+	poolID string
+	id     string        // This replica's identity, stored as the lock value
+	ttl    time.Duration // Lease length; the holder must renew before it expires
+}
+
+// NewLeaderLock creates a leader lock for the pool, identified as id
+func NewLeaderLock(cfg *config.RedisInstanceConfig, poolID, id string, ttl time.Duration) (*LeaderLock, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log := logger.WithComponent("leader_lock")
+	log.WithField("id", id).Info("Connected to leader election Redis")
+
+	return &LeaderLock{
+		client: client,
+		poolID: poolID,
+		id:     id,
+		ttl:    ttl,
+	}, nil
+}
+
+// Close closes the Redis connection
+func (l *LeaderLock) Close() error {
+	return l.client.Close()
+}
+
+// ID returns this replica's identity
+func (l *LeaderLock) ID() string {
+	return l.id
+}
+
+// TTL returns the lease length
+func (l *LeaderLock) TTL() time.Duration {
+	return l.ttl
+}
+
+// Acquire takes or renews the lease and reports whether this replica is the leader
+func (l *LeaderLock) Acquire(ctx context.Context) (bool, error) {
+	held, err := acquireLeaderScript.Run(ctx, l.client, []string{l.key()}, l.id, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire leader lock: %w", err)
+	}
+	return held == 1, nil
+}
+
+// Release gives up the lease if this replica holds it, so a follower can take over immediately
+func (l *LeaderLock) Release(ctx context.Context) error {
+	if err := releaseLeaderScript.Run(ctx, l.client, []string{l.key()}, l.id).Err(); err != nil {
+		return fmt.Errorf("failed to release leader lock: %w", err)
+	}
+	return nil
+}
+
+// Holder returns the identity of the current leader, or "" if there is none
+func (l *LeaderLock) Holder(ctx context.Context) (string, error) {
+	holder, err := l.client.Get(ctx, l.key()).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get leader: %w", err)
+	}
+	return holder, nil
+}
+
+func (l *LeaderLock) key() string {
+	return fmt.Sprintf("leader:%s", l.poolID)
+}
@@ -0,0 +1,443 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryJobStore is an in-memory JobStore for tests
+// It follows JobStore's queue, claim, retry and status semantics without a Redis server.
+// Jobs are stored encoded, so callers can't modify them without Update, as with Redis;
+// retention and expiry are not modelled
+type MemoryJobStore struct {
+	poolID string
+	retryPolicy
+
+	mu          sync.Mutex
+	jobs        map[string][]byte    // Job ID → encoded Job
+	statusSince map[string]time.Time // Job ID → when it entered its current status
+	queue       map[string]float64   // Queued job ID → queue score
+	processing  map[string]time.Time // Claimed job ID → when claimed
+	delayed     map[string]time.Time // Delayed job ID → when its backoff ends
+	byVM        map[string]string    // VM ID → its current job ID
+	seen        map[string]time.Time // Job ID → when first seen
+}
+
+// NewMemoryJobStore creates an empty in-memory job store
+func NewMemoryJobStore(poolID string) *MemoryJobStore {
+	return &MemoryJobStore{
+		poolID:      poolID,
+		retryPolicy: retryPolicy{maxRetries: 3},
+		jobs:        make(map[string][]byte),
+		statusSince: make(map[string]time.Time),
+		queue:       make(map[string]float64),
+		processing:  make(map[string]time.Time),
+		delayed:     make(map[string]time.Time),
+		byVM:        make(map[string]string),
+		seen:        make(map[string]time.Time),
+	}
+}
+
+// Enqueue adds a job to the queue, see JobStore.Enqueue
+func (s *MemoryJobStore) Enqueue(ctx context.Context, job *Job) error {
+	if job.MaxRetries < 0 {
+		return fmt.Errorf("invalid max_retries %d: must be >= 0", job.MaxRetries)
+	}
+	job.Status = JobStatusQueued
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(job); err != nil {
+		return err
+	}
+	s.queue[job.ID] = queueScore(job.Priority, job.CreatedAt)
+	return nil
+}
+
+// PeekN returns up to n jobs from the head of the queue without removing them
+func (s *MemoryJobStore) PeekN(ctx context.Context, n int) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.queuedIDs()
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	jobs := make([]*Job, 0, len(ids))
+	for _, jobID := range ids {
+		job, err := s.get(jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// ClaimJob moves a specific job from the queue to the processing set and returns it
+// Returns nil if the job is no longer queued
+func (s *MemoryJobStore) ClaimJob(ctx context.Context, jobID string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queue[jobID]; !ok {
+		return nil, nil
+	}
+	delete(s.queue, jobID)
+	s.processing[jobID] = time.Now()
+	return s.get(jobID)
+}
+
+// ReapExpiredClaims returns jobs claimed longer than leaseTTL ago to the queue
+func (s *MemoryJobStore) ReapExpiredClaims(ctx context.Context, leaseTTL time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-leaseTTL)
+	reaped := 0
+	for jobID, claimedAt := range s.processing {
+		if claimedAt.After(cutoff) {
+			continue
+		}
+		delete(s.processing, jobID)
+		job, err := s.get(jobID)
+		if err != nil {
+			return reaped, err
+		}
+		if job == nil || job.Status != JobStatusQueued {
+			continue
+		}
+		if _, ok := s.queue[jobID]; !ok {
+			s.queue[jobID] = queueScore(job.Priority, job.CreatedAt)
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// ProcessingLength returns the number of claimed jobs not yet assigned, failed or requeued
+func (s *MemoryJobStore) ProcessingLength(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.processing)), nil
+}
+
+// Get retrieves a job by ID
+func (s *MemoryJobStore) Get(ctx context.Context, jobID string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(jobID)
+}
+
+// Update updates a job
+func (s *MemoryJobStore) Update(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.UpdatedAt = time.Now()
+	return s.save(job)
+}
+
+// AssignToVM assigns a job to a VM
+func (s *MemoryJobStore) AssignToVM(ctx context.Context, jobID, vmID string) error {
+	return s.modify(jobID, func(job *Job) {
+		job.Status = JobStatusAssigned
+		job.AssignedVMID = vmID
+		job.AssignedAt = time.Now()
+		s.byVM[vmID] = jobID
+	})
+}
+
+// SetRunner records the runner registered for a job
+func (s *MemoryJobStore) SetRunner(ctx context.Context, jobID, runnerName string, runnerID int64) error {
+	return s.modify(jobID, func(job *Job) {
+		job.RunnerName = runnerName
+		job.RunnerID = runnerID
+	})
+}
+
+// MarkRunning marks a job as running
+func (s *MemoryJobStore) MarkRunning(ctx context.Context, jobID string) error {
+	return s.modify(jobID, func(job *Job) {
+		job.Status = JobStatusRunning
+		job.StartedAt = time.Now()
+	})
+}
+
+// MarkCompleted marks a job as completed
+func (s *MemoryJobStore) MarkCompleted(ctx context.Context, jobID string) error {
+	return s.finish(jobID, JobStatusCompleted, "")
+}
+
+// MarkFailed marks a job as failed
+func (s *MemoryJobStore) MarkFailed(ctx context.Context, jobID, errorMsg string) error {
+	return s.finish(jobID, JobStatusFailed, errorMsg)
+}
+
+// MarkCancelled marks a job as cancelled
+func (s *MemoryJobStore) MarkCancelled(ctx context.Context, jobID, reason string) error {
+	return s.finish(jobID, JobStatusCancelled, reason)
+}
+
+// finish moves a job to a final status and clears it from VM tracking
+// Completed jobs keep their error message, as with JobStore.MarkCompleted
+func (s *MemoryJobStore) finish(jobID string, status JobStatus, message string) error {
+	return s.modify(jobID, func(job *Job) {
+		job.Status = status
+		job.CompletedAt = time.Now()
+		if status != JobStatusCompleted {
+			job.ErrorMessage = message
+		}
+		if job.AssignedVMID != "" {
+			delete(s.byVM, job.AssignedVMID)
+		}
+	})
+}
+
+// Requeue puts a job back in the queue for retry, once its retry backoff has passed
+func (s *MemoryJobStore) Requeue(ctx context.Context, jobID string) error {
+	return s.requeue(jobID, true)
+}
+
+// RequeuePreempted puts a job interrupted by VM preemption back in the queue
+func (s *MemoryJobStore) RequeuePreempted(ctx context.Context, jobID string) error {
+	return s.requeue(jobID, false)
+}
+
+// RequeueUnsent puts back a job whose assignment was never sent to a VM, see JobStore.RequeueUnsent
+func (s *MemoryJobStore) RequeueUnsent(ctx context.Context, jobID string) error {
+	return s.requeue(jobID, false)
+}
+
+// requeue resets a job to QUEUED and adds it back to the queue, see JobStore.requeue
+func (s *MemoryJobStore) requeue(jobID string, countRetry bool) error {
+	return s.modify(jobID, func(job *Job) {
+		if countRetry {
+			job.RetryCount++
+		}
+		job.Status = JobStatusQueued
+		job.AssignedVMID = ""
+		job.AssignedAt = time.Time{}
+		job.ErrorMessage = ""
+		delete(s.processing, jobID)
+
+		if countRetry {
+			if delay := s.retryDelay(job.RetryCount); delay > 0 {
+				s.delayed[jobID] = time.Now().Add(delay)
+				return
+			}
+		}
+		s.queue[jobID] = queueScore(job.Priority, time.Now())
+	})
+}
+
+// PromoteDelayedJobs moves delayed requeues whose backoff has passed back to the queue
+func (s *MemoryJobStore) PromoteDelayedJobs(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	promoted := 0
+	for jobID, dueAt := range s.delayed {
+		if dueAt.After(now) {
+			continue
+		}
+		delete(s.delayed, jobID)
+		job, err := s.get(jobID)
+		if err != nil {
+			return promoted, err
+		}
+		if job == nil || job.Status != JobStatusQueued {
+			continue
+		}
+		s.queue[jobID] = queueScore(job.Priority, dueAt)
+		promoted++
+	}
+	return promoted, nil
+}
+
+// DelayedLength returns the number of requeued jobs waiting out their retry backoff
+func (s *MemoryJobStore) DelayedLength(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.delayed)), nil
+}
+
+// GetByVM returns the current job for a VM
+func (s *MemoryJobStore) GetByVM(ctx context.Context, vmID string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobID, ok := s.byVM[vmID]
+	if !ok {
+		return nil, nil
+	}
+	return s.get(jobID)
+}
+
+// MarkSeen records that a job ID has been accepted for enqueueing
+// Returns false if it was already seen within jobDedupRetention
+func (s *MemoryJobStore) MarkSeen(ctx context.Context, jobID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seenAt, ok := s.seen[jobID]; ok && time.Since(seenAt) <= jobDedupRetention {
+		return false, nil
+	}
+	s.seen[jobID] = time.Now()
+	return true, nil
+}
+
+// ForgetSeen removes a job ID from the dedup set
+func (s *MemoryJobStore) ForgetSeen(ctx context.Context, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, jobID)
+	return nil
+}
+
+// AgeQueuedJobs boosts jobs that have been queued longer than threshold, see JobStore.AgeQueuedJobs
+func (s *MemoryJobStore) AgeQueuedJobs(ctx context.Context, threshold, interval time.Duration) (int, error) {
+	if threshold <= 0 || interval <= 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	aged := 0
+	for jobID := range s.queue {
+		job, err := s.get(jobID)
+		if err != nil || job == nil || job.Status != JobStatusQueued {
+			continue
+		}
+		queuedAt := s.statusSince[jobID]
+		if now.Sub(queuedAt) < threshold {
+			continue
+		}
+		boost := int((now.Sub(queuedAt)-threshold)/interval) + 1
+		s.queue[jobID] = queueScore(job.Priority-boost, queuedAt)
+		aged++
+	}
+	return aged, nil
+}
+
+// QueueLength returns the number of jobs in the queue
+func (s *MemoryJobStore) QueueLength(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.queue)), nil
+}
+
+// GetByStatus returns jobs currently in status, oldest transition first
+func (s *MemoryJobStore) GetByStatus(ctx context.Context, status JobStatus) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []*Job
+	for jobID := range s.jobs {
+		job, err := s.get(jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return s.statusSince[jobs[i].ID].Before(s.statusSince[jobs[j].ID])
+	})
+	return jobs, nil
+}
+
+// CountByStatus returns the number of jobs in each status
+func (s *MemoryJobStore) CountByStatus(ctx context.Context) (map[JobStatus]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[JobStatus]int64, len(allJobStatuses))
+	for _, status := range allJobStatuses {
+		counts[status] = 0
+	}
+	for jobID := range s.jobs {
+		job, err := s.get(jobID)
+		if err != nil {
+			return nil, err
+		}
+		counts[job.Status]++
+	}
+	return counts, nil
+}
+
+// modify applies fn to a stored job and saves it; fn runs with mu held
+func (s *MemoryJobStore) modify(jobID string, fn func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.get(jobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	fn(job)
+	job.UpdatedAt = time.Now()
+	return s.save(job)
+}
+
+// get decodes a stored job; callers hold mu
+func (s *MemoryJobStore) get(jobID string) (*Job, error) {
+	data, ok := s.jobs[jobID]
+	if !ok {
+		return nil, nil
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	return &job, nil
+}
+
+// save stores a job and tracks when it entered its status, see JobStore.saveJob; callers hold mu
+func (s *MemoryJobStore) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	previous, _ := s.get(job.ID)
+	if previous == nil || previous.Status != job.Status {
+		s.statusSince[job.ID] = job.UpdatedAt
+	}
+	// A claimed job that leaves QUEUED has been dealt with; release the claim
+	if job.Status != JobStatusQueued {
+		delete(s.processing, job.ID)
+	}
+	s.jobs[job.ID] = data
+	return nil
+}
+
+// queuedIDs returns the queued job IDs in dequeue order; callers hold mu
+func (s *MemoryJobStore) queuedIDs() []string {
+	ids := make([]string, 0, len(s.queue))
+	for jobID := range s.queue {
+		ids = append(ids, jobID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if s.queue[ids[i]] != s.queue[ids[j]] {
+			return s.queue[ids[i]] < s.queue[ids[j]]
+		}
+		return ids[i] < ids[j] // Redis orders equal scores by member
+	})
+	return ids
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryReapExpiredClaimsRequeuesAbandonedClaim(t *testing.T) {
+	s := NewMemoryJobStore("pool-test")
+	ctx := context.Background()
+	enqueueTestJob(t, s, "job-1")
+
+	// The claiming scheduler crashes before assigning the job
+	if job, err := s.ClaimJob(ctx, "job-1"); err != nil || job == nil {
+		t.Fatalf("ClaimJob = %v, %v", job, err)
+	}
+	if job, _ := s.ClaimJob(ctx, "job-1"); job != nil {
+		t.Fatalf("claimed job %s handed out twice", job.ID)
+	}
+
+	if reaped, err := s.ReapExpiredClaims(ctx, time.Minute); err != nil || reaped != 0 {
+		t.Fatalf("ReapExpiredClaims within the lease = %d, %v, want 0", reaped, err)
+	}
+
+	s.mu.Lock()
+	s.processing["job-1"] = time.Now().Add(-2 * time.Minute)
+	s.mu.Unlock()
+	if reaped, err := s.ReapExpiredClaims(ctx, time.Minute); err != nil || reaped != 1 {
+		t.Fatalf("ReapExpiredClaims after the lease = %d, %v, want 1", reaped, err)
+	}
+	if n, _ := s.ProcessingLength(ctx); n != 0 {
+		t.Errorf("processing = %d after reaping, want 0", n)
+	}
+
+	job, err := s.ClaimJob(ctx, "job-1")
+	if err != nil || job == nil || job.ID != "job-1" {
+		t.Fatalf("ClaimJob after reaping = %v, %v, want job-1", job, err)
+	}
+	if job.RetryCount != 0 {
+		t.Errorf("retry count = %d, want a reclaim not to count as a retry", job.RetryCount)
+	}
+}
+
+func TestMemoryReapExpiredClaimsDropsJobsThatMovedOn(t *testing.T) {
+	s := NewMemoryJobStore("pool-test")
+	ctx := context.Background()
+	enqueueTestJob(t, s, "job-1")
+
+	job, _ := s.ClaimJob(ctx, "job-1")
+	job.Status = JobStatusAssigned
+	if err := s.Update(ctx, job); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	s.mu.Lock()
+	s.processing["job-1"] = time.Now().Add(-2 * time.Minute)
+	s.mu.Unlock()
+
+	if reaped, err := s.ReapExpiredClaims(ctx, time.Minute); err != nil || reaped != 0 {
+		t.Fatalf("ReapExpiredClaims = %d, %v, want 0", reaped, err)
+	}
+	if n, _ := s.ProcessingLength(ctx); n != 0 {
+		t.Errorf("processing = %d, want the stale claim released", n)
+	}
+	if peeked, _ := s.PeekN(ctx, 10); len(peeked) != 0 {
+		t.Errorf("assigned job returned to the queue")
+	}
+}
+
+func TestMemoryClaimJobOnlyOneClaimerWins(t *testing.T) {
+	s := NewMemoryJobStore("pool-test")
+	enqueueTestJob(t, s, "job-1")
+
+	var wg sync.WaitGroup
+	var claimed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if job, err := s.ClaimJob(context.Background(), "job-1"); err == nil && job != nil {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := claimed.Load(); got != 1 {
+		t.Fatalf("job claimed %d times, want once", got)
+	}
+}
+
+func TestMemoryStatusIndexFollowsJobLifecycle(t *testing.T) {
+	checkStatusIndexLifecycle(t, NewMemoryJobStore("pool-test"), "job-1", "vm-1")
+}
+
+func TestMemoryAgeQueuedJobsOldJobOvertakesFreshHighPriority(t *testing.T) {
+	s := NewMemoryJobStore("pool-test")
+	checkAgedJobOvertakes(t, s, "job", func(jobID string, queuedAt time.Time) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.statusSince[jobID] = queuedAt
+	})
+}
@@ -0,0 +1,322 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryVMStatusStore is an in-memory VMStatusStore for tests
+// Effective states are derived exactly as VMStatusStore derives them, including the health
+// thresholds. Statuses are stored encoded, so callers can't modify them without Update, as
+// with Redis; there are no index sets to drift, and state changes are not published
+type MemoryVMStatusStore struct {
+	poolID string
+	healthThresholds
+
+	mu  sync.Mutex
+	vms map[string][]byte // VM ID → encoded VMStatus
+}
+
+// NewMemoryVMStatusStore creates an empty in-memory VM status store
+func NewMemoryVMStatusStore(poolID string) *MemoryVMStatusStore {
+	return &MemoryVMStatusStore{
+		poolID: poolID,
+		vms:    make(map[string][]byte),
+	}
+}
+
+// Get retrieves VM status by ID
+func (s *MemoryVMStatusStore) Get(ctx context.Context, vmID string) (*VMStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(vmID)
+}
+
+// Update updates VM status
+func (s *MemoryVMStatusStore) Update(ctx context.Context, status *VMStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(status)
+}
+
+// UpdateFromInfra updates VM status from GCloud infrastructure data
+func (s *MemoryVMStatusStore) UpdateFromInfra(ctx context.Context, vmID, zone string, infraState VMInfraState) error {
+	return s.modify(ctx, vmID, func() *VMStatus {
+		return &VMStatus{
+			VMID:        vmID,
+			PoolID:      s.poolID,
+			MigletState: MigletStateUnknown,
+			RunnerState: RunnerStateOffline,
+			CreatedAt:   time.Now(),
+		}
+	}, func(status *VMStatus) {
+		status.InfraState = infraState
+		status.Zone = zone
+	})
+}
+
+// UpdateFromHeartbeat updates VM status from MIGlet heartbeat
+func (s *MemoryVMStatusStore) UpdateFromHeartbeat(ctx context.Context, vmID string, migletState MigletState, runnerState RunnerState, cpuUsage, memoryUsage, diskUsage float64, currentJobID string) error {
+	return s.modify(ctx, vmID, s.newRunningVM(vmID), func(status *VMStatus) {
+		status.MigletState = migletState
+		status.RunnerState = runnerState
+		status.CPUUsage = cpuUsage
+		status.MemoryUsage = memoryUsage
+		status.DiskUsage = diskUsage
+		status.CurrentJobID = currentJobID
+		status.LastHeartbeat = time.Now()
+		status.IsConnected = true
+
+		if runnerState == RunnerStateRunning || currentJobID != "" {
+			status.IdleSince = time.Time{}
+		} else if status.IdleSince.IsZero() {
+			status.IdleSince = status.LastHeartbeat
+		}
+	})
+}
+
+// SetSpec records the machine specs reported by MIGlet
+func (s *MemoryVMStatusStore) SetSpec(ctx context.Context, vmID, zone string, spec *VMSpec) error {
+	return s.modify(ctx, vmID, s.newRunningVM(vmID), func(status *VMStatus) {
+		status.Spec = spec
+		if zone != "" {
+			status.Zone = zone
+		}
+	})
+}
+
+// SetLastError records the latest failure reported for a VM
+func (s *MemoryVMStatusStore) SetLastError(ctx context.Context, vmID string, vmErr *VMError) error {
+	return s.modify(ctx, vmID, nil, func(status *VMStatus) {
+		status.LastError = vmErr
+	})
+}
+
+// SetConnected sets the gRPC connection status
+func (s *MemoryVMStatusStore) SetConnected(ctx context.Context, vmID string, connected bool) error {
+	return s.modify(ctx, vmID, nil, func(status *VMStatus) {
+		status.IsConnected = connected
+		if !connected && status.MigletState != MigletStateShuttingDown {
+			status.MigletState = MigletStateUnknown
+		}
+	})
+}
+
+// SetMigletState records a MIGlet state reported outside a heartbeat
+func (s *MemoryVMStatusStore) SetMigletState(ctx context.Context, vmID string, state MigletState) error {
+	return s.modify(ctx, vmID, nil, func(status *VMStatus) {
+		status.MigletState = state
+	})
+}
+
+// SetOrg tags a VM with the org it serves
+func (s *MemoryVMStatusStore) SetOrg(ctx context.Context, vmID, orgID string) error {
+	return s.modify(ctx, vmID, nil, func(status *VMStatus) {
+		status.OrgID = orgID
+	})
+}
+
+// SetRunner records the runner the MIGlet registered
+func (s *MemoryVMStatusStore) SetRunner(ctx context.Context, vmID, runnerName string, runnerID int64) error {
+	return s.modify(ctx, vmID, nil, func(status *VMStatus) {
+		status.RunnerName = runnerName
+		status.RunnerID = runnerID
+	})
+}
+
+// SetAgentInfo records the MIGlet version, capabilities and org reported on connect
+func (s *MemoryVMStatusStore) SetAgentInfo(ctx context.Context, vmID, version string, capabilities []string, orgID string) error {
+	return s.modify(ctx, vmID, s.newRunningVM(vmID), func(status *VMStatus) {
+		status.MigletVersion = version
+		status.Capabilities = capabilities
+		if status.OrgID == "" {
+			status.OrgID = orgID
+		}
+	})
+}
+
+// Ping always succeeds: there is no connection to check
+func (s *MemoryVMStatusStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Delete removes VM status
+func (s *MemoryVMStatusStore) Delete(ctx context.Context, vmID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.vms, vmID)
+	return nil
+}
+
+// GetAll returns all VM statuses for the pool
+func (s *MemoryVMStatusStore) GetAll(ctx context.Context) ([]*VMStatus, error) {
+	s.mu.Lock()
+	vmIDs := make([]string, 0, len(s.vms))
+	for vmID := range s.vms {
+		vmIDs = append(vmIDs, vmID)
+	}
+	s.mu.Unlock()
+
+	var statuses []*VMStatus
+	for _, vmID := range vmIDs {
+		status, err := s.Get(ctx, vmID)
+		if err != nil || status == nil {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// GetByEffectiveState returns VMs with a specific effective state, in no particular order
+func (s *MemoryVMStatusStore) GetByEffectiveState(ctx context.Context, state EffectiveState) ([]*VMStatus, error) {
+	return s.GetByEffectiveStateOrdered(ctx, state, VMOrderNone)
+}
+
+// GetByEffectiveStateOrdered returns VMs with a specific effective state, sorted by order
+func (s *MemoryVMStatusStore) GetByEffectiveStateOrdered(ctx context.Context, state EffectiveState, order VMOrder) ([]*VMStatus, error) {
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*VMStatus
+	for _, status := range all {
+		if status.EffectiveState == state {
+			statuses = append(statuses, status)
+		}
+	}
+	sortStatuses(statuses, order)
+	return statuses, nil
+}
+
+// GetFirstReady returns the first ready VM (for job assignment), see VMStatusStore.GetFirstReady
+func (s *MemoryVMStatusStore) GetFirstReady(ctx context.Context, orgID string) (*VMStatus, error) {
+	statuses, err := s.GetByEffectiveStateOrdered(ctx, EffectiveStateReady, VMOrderLongestIdleFirst)
+	if err != nil {
+		return nil, err
+	}
+	if status := firstForOrg(canRegisterRunner(statuses), orgID); status != nil {
+		return status, nil
+	}
+
+	statuses, err = s.GetByEffectiveStateOrdered(ctx, EffectiveStateIdle, VMOrderLongestIdleFirst)
+	if err != nil {
+		return nil, err
+	}
+	return firstForOrg(canRegisterRunner(statuses), orgID), nil
+}
+
+// GetFirstIdleRunner returns the VM idle longest whose registered runner can be handed a job
+func (s *MemoryVMStatusStore) GetFirstIdleRunner(ctx context.Context, orgID string) (*VMStatus, error) {
+	statuses, err := s.GetByEffectiveStateOrdered(ctx, EffectiveStateIdle, VMOrderLongestIdleFirst)
+	if err != nil {
+		return nil, err
+	}
+
+	var idle []*VMStatus
+	for _, status := range statuses {
+		if status.HasIdleRunner() {
+			idle = append(idle, status)
+		}
+	}
+	return firstForOrg(idle, orgID), nil
+}
+
+// GetFirstStopped returns the first stopped VM (for starting)
+func (s *MemoryVMStatusStore) GetFirstStopped(ctx context.Context, orgID string) (*VMStatus, error) {
+	statuses, err := s.GetByEffectiveState(ctx, EffectiveStateStopped)
+	if err != nil {
+		return nil, err
+	}
+	return firstForOrg(statuses, orgID), nil
+}
+
+// CountByState returns count of VMs in each state
+func (s *MemoryVMStatusStore) CountByState(ctx context.Context) (map[EffectiveState]int64, error) {
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[EffectiveState]int64)
+	for _, status := range all {
+		counts[status.EffectiveState]++
+	}
+	return counts, nil
+}
+
+// GetStats returns pool statistics
+func (s *MemoryVMStatusStore) GetStats(ctx context.Context) (*PoolStats, error) {
+	counts, err := s.CountByState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newPoolStats(s.poolID, counts), nil
+}
+
+// ReconcileStateIndex is a no-op: states are read from the statuses themselves
+func (s *MemoryVMStatusStore) ReconcileStateIndex(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// modify applies fn to a VM's status and saves it
+// newStatus creates the status of an untracked VM; if nil, untracked VMs are skipped
+// The lock is held throughout, so concurrent modifications don't lose each other's changes
+func (s *MemoryVMStatusStore) modify(ctx context.Context, vmID string, newStatus func() *VMStatus, fn func(status *VMStatus)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, err := s.get(vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		if newStatus == nil {
+			return nil // VM not tracked yet
+		}
+		status = newStatus()
+	}
+
+	fn(status)
+	return s.save(status)
+}
+
+// get decodes a VM's status; the caller must hold s.mu
+func (s *MemoryVMStatusStore) get(vmID string) (*VMStatus, error) {
+	data, ok := s.vms[vmID]
+	if !ok {
+		return nil, nil
+	}
+	var status VMStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal VM status: %w", err)
+	}
+	return &status, nil
+}
+
+// save derives a VM's effective state and stores its status; the caller must hold s.mu
+func (s *MemoryVMStatusStore) save(status *VMStatus) error {
+	s.prepareUpdate(status)
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal VM status: %w", err)
+	}
+	s.vms[status.VMID] = data
+	return nil
+}
+
+// newRunningVM returns a constructor for a VM first heard of from its MIGlet, so running
+func (s *MemoryVMStatusStore) newRunningVM(vmID string) func() *VMStatus {
+	return func() *VMStatus {
+		return &VMStatus{
+			VMID:       vmID,
+			PoolID:     s.poolID,
+			InfraState: VMInfraRunning,
+			CreatedAt:  time.Now(),
+		}
+	}
+}
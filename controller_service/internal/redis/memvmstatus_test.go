@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// putVM stores a running VM in the given MIGlet state, last heard from at heartbeat
+// and idle since idleSince
+func putVM(t *testing.T, s *MemoryVMStatusStore, vmID string, state MigletState, heartbeat, idleSince time.Time) {
+	t.Helper()
+	err := s.Update(context.Background(), &VMStatus{
+		VMID:          vmID,
+		PoolID:        "pool-test",
+		InfraState:    VMInfraRunning,
+		MigletState:   state,
+		RunnerState:   RunnerStateIdle,
+		RunnerName:    "runner-" + vmID,
+		Capabilities:  []string{"register_runner", "job_available"},
+		IsConnected:   true,
+		LastHeartbeat: heartbeat,
+		IdleSince:     idleSince,
+	})
+	if err != nil {
+		t.Fatalf("Update(%s): %v", vmID, err)
+	}
+}
+
+func TestGetFirstReadyPrefersLongestIdle(t *testing.T) {
+	s := NewMemoryVMStatusStore("pool-test")
+	now := time.Now()
+	// vm-quiet was heard from least recently but only just became free
+	putVM(t, s, "vm-quiet", MigletStateReady, now.Add(-20*time.Second), now.Add(-time.Minute))
+	putVM(t, s, "vm-waiting", MigletStateReady, now, now.Add(-time.Hour))
+
+	status, err := s.GetFirstReady(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetFirstReady: %v", err)
+	}
+	if status == nil || status.VMID != "vm-waiting" {
+		t.Fatalf("GetFirstReady = %v, want vm-waiting", status)
+	}
+}
+
+func TestGetFirstIdleRunnerPrefersLongestIdle(t *testing.T) {
+	s := NewMemoryVMStatusStore("pool-test")
+	now := time.Now()
+	putVM(t, s, "vm-quiet", MigletStateIdle, now.Add(-20*time.Second), now.Add(-time.Minute))
+	putVM(t, s, "vm-waiting", MigletStateIdle, now, now.Add(-time.Hour))
+
+	status, err := s.GetFirstIdleRunner(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetFirstIdleRunner: %v", err)
+	}
+	if status == nil || status.VMID != "vm-waiting" {
+		t.Fatalf("GetFirstIdleRunner = %v, want vm-waiting", status)
+	}
+}
+
+func TestHealthThresholdsExcludeVMsOnlyAboveLimit(t *testing.T) {
+	s := NewMemoryVMStatusStore("pool-test")
+	s.SetHealthThresholds(90, 85)
+	ctx := context.Background()
+
+	update := func(vmID string, state MigletState, disk, mem float64) EffectiveState {
+		t.Helper()
+		status := &VMStatus{
+			VMID:        vmID,
+			PoolID:      "pool-test",
+			InfraState:  VMInfraRunning,
+			MigletState: state,
+			IsConnected: true,
+			DiskUsage:   disk,
+			MemoryUsage: mem,
+		}
+		if err := s.Update(ctx, status); err != nil {
+			t.Fatalf("Update(%s): %v", vmID, err)
+		}
+		return status.EffectiveState
+	}
+
+	if got := update("vm-at-limit", MigletStateReady, 90, 85); got != EffectiveStateReady {
+		t.Errorf("VM at both limits = %s, want %s", got, EffectiveStateReady)
+	}
+	if got := update("vm-full-disk", MigletStateReady, 90.5, 10); got != EffectiveStateDegraded {
+		t.Errorf("ready VM over the disk limit = %s, want %s", got, EffectiveStateDegraded)
+	}
+	if got := update("vm-idle-full-memory", MigletStateIdle, 10, 85.5); got != EffectiveStateDegraded {
+		t.Errorf("idle VM over the memory limit = %s, want %s", got, EffectiveStateDegraded)
+	}
+	// A VM running a job is left alone until it is free
+	if got := update("vm-busy", MigletStateJobRunning, 99, 99); got != EffectiveStateBusy {
+		t.Errorf("busy VM over both limits = %s, want %s", got, EffectiveStateBusy)
+	}
+
+	status, err := s.GetFirstReady(ctx, "")
+	if err != nil {
+		t.Fatalf("GetFirstReady: %v", err)
+	}
+	if status == nil || status.VMID != "vm-at-limit" {
+		t.Fatalf("GetFirstReady = %v, want vm-at-limit", status)
+	}
+
+	// Usage dropping back to the limit makes the VM schedulable again
+	if got := update("vm-full-disk", MigletStateReady, 90, 10); got != EffectiveStateReady {
+		t.Errorf("VM back at the disk limit = %s, want %s", got, EffectiveStateReady)
+	}
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// PendingCommandEntry is a command waiting for its VM's MIGlet to connect
+type PendingCommandEntry struct {
+	CommandID   string    `json:"command_id"`
+	CommandType string    `json:"command_type"`
+	Command     []byte    `json:"command"` // Proto-encoded commands.Command
+	QueuedAt    time.Time `json:"queued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ExpiresAtMs int64     `json:"expires_at_ms,omitempty"` // ExpiresAt in Unix milliseconds, set by Push for pushPendingScript
+}
+
+// ErrPendingQueueFull is returned by Push when the VM already has the maximum number
+// of pending commands; the entry is not queued
+var ErrPendingQueueFull = errors.New("pending command queue full")
+
+// pushPendingScript drops expired entries, then appends an entry unless the list is full
+// and extends the key TTL to cover the new entry. Returns the new length, or -1 if the
+// list is full. KEYS[1] = pending list, ARGV[1] = entry, ARGV[2] = max entries,
+// ARGV[3] = TTL in ms, ARGV[4] = now in Unix ms
+var pushPendingScript = redis.NewScript(`
+local now = tonumber(ARGV[4])
+for _, raw in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	local expires = cjson.decode(raw).expires_at_ms
+	if expires and tonumber(expires) <= now then
+		redis.call('LREM', KEYS[1], 1, raw)
+	end
+end
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[2]) then
+	return -1
+end
+local depth = redis.call('RPUSH', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return depth
+`)
+
+// takePendingScript reads and deletes a VM's pending list in one step, so a command
+// is delivered by only one controller replica
+// KEYS[1] = pending list
+var takePendingScript = redis.NewScript(`
+local entries = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1])
+return entries
+`)
+
+// PendingCommandStore keeps commands for disconnected VMs in Redis, so they survive
+// controller restarts and are delivered by whichever replica the MIGlet reconnects to
+type PendingCommandStore struct {
+	client *redis.Client
+	poolID string
+}
+
+// NewPendingCommandStore creates a new pending command store
+func NewPendingCommandStore(cfg *config.RedisInstanceConfig, poolID string) (*PendingCommandStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log := logger.WithComponent("pending_command_store")
+	log.Info("Connected to pending command Redis")
+
+	return &PendingCommandStore{
+		client: client,
+		poolID: poolID,
+	}, nil
+}
+
+// Close closes the Redis connection
+func (s *PendingCommandStore) Close() error {
+	return s.client.Close()
+}
+
+// pendingKey returns the Redis key for a VM's pending command list
+func (s *PendingCommandStore) pendingKey(vmID string) string {
+	return fmt.Sprintf("commands:pending:%s:%s", s.poolID, vmID)
+}
+
+// Push queues an entry for the VM and returns how many entries the VM now has queued
+// Expired entries are dropped first and don't count. Fails with ErrPendingQueueFull if
+// the VM still has maxEntries. The list expires once its newest entry has expired
+func (s *PendingCommandStore) Push(ctx context.Context, vmID string, entry *PendingCommandEntry, maxEntries int) (int, error) {
+	stored := *entry
+	stored.ExpiresAtMs = entry.ExpiresAt.UnixMilli()
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal pending command: %w", err)
+	}
+
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+
+	depth, err := pushPendingScript.Run(ctx, s.client, []string{s.pendingKey(vmID)},
+		data, maxEntries, ttl.Milliseconds(), time.Now().UnixMilli()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue pending command: %w", err)
+	}
+	if depth < 0 {
+		return maxEntries, ErrPendingQueueFull
+	}
+	return depth, nil
+}
+
+// Take removes and returns all of the VM's pending entries, oldest first
+// Entries that fail to decode are skipped; expired ones are returned for the caller to drop
+func (s *PendingCommandStore) Take(ctx context.Context, vmID string) ([]*PendingCommandEntry, error) {
+	raw, err := takePendingScript.Run(ctx, s.client, []string{s.pendingKey(vmID)}).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take pending commands: %w", err)
+	}
+
+	entries := make([]*PendingCommandEntry, 0, len(raw))
+	for _, data := range raw {
+		var entry PendingCommandEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			logger.WithVM(vmID, s.poolID).WithError(err).Warn("Skipping undecodable pending command")
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
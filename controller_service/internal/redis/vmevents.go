@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// stateChangeBufferSize is the per-subscriber buffer for state change notifications
+const stateChangeBufferSize = 64
+
+// StateChange describes a transition of a VM's effective state
+type StateChange struct {
+	VMID      string         `json:"vm_id"`
+	PoolID    string         `json:"pool_id"`
+	OldState  EffectiveState `json:"old_state"`
+	NewState  EffectiveState `json:"new_state"`
+	ChangedAt time.Time      `json:"changed_at"`
+}
+
+// stateChangeHub fans out state changes to in-process subscribers
+type stateChangeHub struct {
+	mu          sync.RWMutex
+	subscribers map[int]chan StateChange
+	nextID      int
+}
+
+func newStateChangeHub() *stateChangeHub {
+	return &stateChangeHub{
+		subscribers: make(map[int]chan StateChange),
+	}
+}
+
+// subscribe registers a new subscriber and returns its channel and an unsubscribe func
+func (h *stateChangeHub) subscribe() (<-chan StateChange, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextID
+	h.nextID++
+	ch := make(chan StateChange, stateChangeBufferSize)
+	h.subscribers[id] = ch
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, id)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// publish delivers a change to every subscriber without blocking
+// Slow subscribers miss notifications rather than stalling status updates
+func (h *stateChangeHub) publish(change StateChange) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, ch := range h.subscribers {
+		select {
+		case ch <- change:
+		default:
+			logger.WithComponent("vm_status_store").WithField("vm_id", change.VMID).
+				Warn("State change subscriber is full, dropping notification")
+		}
+	}
+}
+
+// SubscribeStateChanges returns a channel that receives every effective state change
+// in this pool, plus a function to unsubscribe (which closes the channel)
+func (s *VMStatusStore) SubscribeStateChanges() (<-chan StateChange, func()) {
+	return s.stateChanges.subscribe()
+}
+
+// StateChangeChannel returns the Redis pub/sub channel state changes are published on
+// External consumers (dashboards, other services) can SUBSCRIBE to it
+func (s *VMStatusStore) StateChangeChannel() string {
+	return fmt.Sprintf("vms:state_changes:%s", s.poolID)
+}
+
+// notifyStateChange publishes a state change in-process and to Redis pub/sub
+func (s *VMStatusStore) notifyStateChange(ctx context.Context, change StateChange) {
+	s.stateChanges.publish(change)
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return
+	}
+	if err := s.client.Publish(ctx, s.StateChangeChannel(), data).Err(); err != nil {
+		logger.WithComponent("vm_status_store").WithError(err).WithField("vm_id", change.VMID).
+			Debug("Failed to publish state change to Redis")
+	}
+}
@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateChangeHubFansOutToSubscribers(t *testing.T) {
+	h := newStateChangeHub()
+	first, unsubFirst := h.subscribe()
+	defer unsubFirst()
+	second, unsubSecond := h.subscribe()
+	defer unsubSecond()
+
+	h.publish(StateChange{VMID: "vm-1", OldState: EffectiveStateReady, NewState: EffectiveStateBusy})
+
+	for i, ch := range []<-chan StateChange{first, second} {
+		select {
+		case change := <-ch:
+			if change.VMID != "vm-1" || change.NewState != EffectiveStateBusy {
+				t.Errorf("subscriber %d got %+v", i, change)
+			}
+		default:
+			t.Errorf("subscriber %d got no change", i)
+		}
+	}
+}
+
+func TestStateChangeHubDropsForFullSubscriber(t *testing.T) {
+	h := newStateChangeHub()
+	ch, unsubscribe := h.subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < stateChangeBufferSize+10; i++ {
+			h.publish(StateChange{VMID: "vm-1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+	if got := len(ch); got != stateChangeBufferSize {
+		t.Errorf("buffered changes = %d, want %d", got, stateChangeBufferSize)
+	}
+}
+
+func TestStateChangeHubUnsubscribeClosesChannel(t *testing.T) {
+	h := newStateChangeHub()
+	ch, unsubscribe := h.subscribe()
+	unsubscribe()
+	unsubscribe() // A second call is a no-op
+
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after unsubscribe")
+	}
+	// Publishing with no subscribers left must not panic on the closed channel
+	h.publish(StateChange{VMID: "vm-1"})
+}
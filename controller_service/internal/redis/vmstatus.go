@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -61,6 +62,7 @@ const (
 	EffectiveStateIdle       EffectiveState = "IDLE"
 	EffectiveStateBusy       EffectiveState = "BUSY"
 	EffectiveStateError      EffectiveState = "ERROR"
+	EffectiveStateDegraded   EffectiveState = "DEGRADED" // Ready or idle, but over a resource usage threshold
 	EffectiveStateStopping   EffectiveState = "STOPPING"
 	EffectiveStateUnknown    EffectiveState = "UNKNOWN"
 )
@@ -69,24 +71,63 @@ const (
 type VMStatus struct {
 	VMID           string         `json:"vm_id"`
 	PoolID         string         `json:"pool_id"`
+	OrgID          string         `json:"org_id,omitempty"` // Org the VM is tagged with; empty until known
 	Zone           string         `json:"zone"`
 	InfraState     VMInfraState   `json:"infra_state"`
 	MigletState    MigletState    `json:"miglet_state"`
 	RunnerState    RunnerState    `json:"runner_state"`
 	EffectiveState EffectiveState `json:"effective_state"`
 	CurrentJobID   string         `json:"current_job_id,omitempty"`
+	RunnerName     string         `json:"runner_name,omitempty"` // Last runner registered on the VM
+	RunnerID       int64          `json:"runner_id,omitempty"`   // GitHub ID of that runner
 	CPUUsage       float64        `json:"cpu_usage"`
 	MemoryUsage    float64        `json:"memory_usage"`
+	DiskUsage      float64        `json:"disk_usage"`
 	LastHeartbeat  time.Time      `json:"last_heartbeat"`
+	IdleSince      time.Time      `json:"idle_since,omitempty"` // When the VM last became free of jobs (zero while busy)
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
-	IsConnected    bool           `json:"is_connected"` // gRPC connection status
+	StoppedAt      time.Time      `json:"stopped_at,omitempty"`     // When the VM entered STOPPED (zero otherwise)
+	IsConnected    bool           `json:"is_connected"`             // gRPC connection status
+	Spec           *VMSpec        `json:"spec,omitempty"`           // Machine specs reported by MIGlet's vm_started event
+	LastError      *VMError       `json:"last_error,omitempty"`     // Most recent runner crash reported by MIGlet
+	MigletVersion  string         `json:"miglet_version,omitempty"` // Build version reported on connect
+	Capabilities   []string       `json:"capabilities,omitempty"`   // Command types the MIGlet reported on connect
+}
+
+// VMError is a failure MIGlet reported for a VM, kept so operators can debug without SSH
+type VMError struct {
+	Reason   string    `json:"reason"` // e.g. "process_exited", "killed"
+	Message  string    `json:"message,omitempty"`
+	ExitCode int       `json:"exit_code"`
+	JobID    string    `json:"job_id,omitempty"`   // Job that was on the VM at the time
+	LogTail  string    `json:"log_tail,omitempty"` // Last runner or config.sh output lines, newline-separated
+	At       time.Time `json:"at"`
+}
+
+// VMSpec is the machine a VM actually runs on, as reported by MIGlet
+type VMSpec struct {
+	MachineType string `json:"machine_type,omitempty"`
+	Region      string `json:"region,omitempty"`
+	CPU         int    `json:"cpu,omitempty"`
+	MemoryMB    int    `json:"memory_mb,omitempty"`
+	DiskGB      int    `json:"disk_gb,omitempty"`
+	Version     string `json:"version,omitempty"` // MIGlet version
 }
 
 // VMStatusStore handles VM status persistence in Redis
 type VMStatusStore struct {
-	client *redis.Client
-	poolID string
+	client       *redis.Client
+	poolID       string
+	stateChanges *stateChangeHub
+	healthThresholds
+}
+
+// healthThresholds holds the resource usage thresholds, in percent, over which ready and
+// idle VMs are DEGRADED (0 disables a threshold), and derives effective states with them
+type healthThresholds struct {
+	maxDiskUsage   float64
+	maxMemoryUsage float64
 }
 
 // NewVMStatusStore creates a new VM status store
@@ -109,16 +150,29 @@ func NewVMStatusStore(cfg *config.RedisInstanceConfig, poolID string) (*VMStatus
 	log.Info("Connected to VM Status Redis")
 
 	return &VMStatusStore{
-		client: client,
-		poolID: poolID,
+		client:       client,
+		poolID:       poolID,
+		stateChanges: newStateChangeHub(),
 	}, nil
 }
 
+// SetHealthThresholds excludes ready and idle VMs reporting disk or memory usage above
+// these percentages from scheduling by moving them to DEGRADED (0 disables a threshold)
+func (h *healthThresholds) SetHealthThresholds(maxDiskUsage, maxMemoryUsage float64) {
+	h.maxDiskUsage = maxDiskUsage
+	h.maxMemoryUsage = maxMemoryUsage
+}
+
 // Close closes the Redis connection
 func (s *VMStatusStore) Close() error {
 	return s.client.Close()
 }
 
+// Ping checks the Redis connection
+func (s *VMStatusStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
 // Get retrieves VM status by ID
 func (s *VMStatusStore) Get(ctx context.Context, vmID string) (*VMStatus, error) {
 	key := fmt.Sprintf("vms:%s:%s", s.poolID, vmID)
@@ -139,9 +193,10 @@ func (s *VMStatusStore) Get(ctx context.Context, vmID string) (*VMStatus, error)
 }
 
 // Update updates VM status
+// Subscribers are notified when the effective state changes
 func (s *VMStatusStore) Update(ctx context.Context, status *VMStatus) error {
-	status.UpdatedAt = time.Now()
-	status.EffectiveState = s.calculateEffectiveState(status)
+	oldState := status.EffectiveState // As loaded from Redis (empty for new VMs)
+	s.prepareUpdate(status)
 
 	key := fmt.Sprintf("vms:%s:%s", s.poolID, status.VMID)
 	data, err := json.Marshal(status)
@@ -159,6 +214,16 @@ func (s *VMStatusStore) Update(ctx context.Context, status *VMStatus) error {
 		return fmt.Errorf("failed to update state index: %w", err)
 	}
 
+	if oldState != status.EffectiveState {
+		s.notifyStateChange(ctx, StateChange{
+			VMID:      status.VMID,
+			PoolID:    s.poolID,
+			OldState:  oldState,
+			NewState:  status.EffectiveState,
+			ChangedAt: status.UpdatedAt,
+		})
+	}
+
 	return nil
 }
 
@@ -187,7 +252,7 @@ func (s *VMStatusStore) UpdateFromInfra(ctx context.Context, vmID, zone string,
 }
 
 // UpdateFromHeartbeat updates VM status from MIGlet heartbeat
-func (s *VMStatusStore) UpdateFromHeartbeat(ctx context.Context, vmID string, migletState MigletState, runnerState RunnerState, cpuUsage, memoryUsage float64, currentJobID string) error {
+func (s *VMStatusStore) UpdateFromHeartbeat(ctx context.Context, vmID string, migletState MigletState, runnerState RunnerState, cpuUsage, memoryUsage, diskUsage float64, currentJobID string) error {
 	status, err := s.Get(ctx, vmID)
 	if err != nil {
 		return err
@@ -206,10 +271,57 @@ func (s *VMStatusStore) UpdateFromHeartbeat(ctx context.Context, vmID string, mi
 	status.RunnerState = runnerState
 	status.CPUUsage = cpuUsage
 	status.MemoryUsage = memoryUsage
+	status.DiskUsage = diskUsage
 	status.CurrentJobID = currentJobID
 	status.LastHeartbeat = time.Now()
 	status.IsConnected = true
 
+	// Heartbeats arrive every few seconds whatever the VM is doing, so idle time is
+	// tracked from the busy-to-idle transition rather than from heartbeat age
+	if runnerState == RunnerStateRunning || currentJobID != "" {
+		status.IdleSince = time.Time{}
+	} else if status.IdleSince.IsZero() {
+		status.IdleSince = status.LastHeartbeat
+	}
+
+	return s.Update(ctx, status)
+}
+
+// SetSpec records the machine specs reported by MIGlet
+func (s *VMStatusStore) SetSpec(ctx context.Context, vmID, zone string, spec *VMSpec) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+
+	if status == nil {
+		status = &VMStatus{
+			VMID:       vmID,
+			PoolID:     s.poolID,
+			InfraState: VMInfraRunning, // It is running if MIGlet reported in
+			CreatedAt:  time.Now(),
+		}
+	}
+
+	status.Spec = spec
+	if zone != "" {
+		status.Zone = zone
+	}
+
+	return s.Update(ctx, status)
+}
+
+// SetLastError records the latest failure reported for a VM
+func (s *VMStatusStore) SetLastError(ctx context.Context, vmID string, vmErr *VMError) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return nil // VM not tracked yet
+	}
+
+	status.LastError = vmErr
 	return s.Update(ctx, status)
 }
 
@@ -224,13 +336,86 @@ func (s *VMStatusStore) SetConnected(ctx context.Context, vmID string, connected
 	}
 
 	status.IsConnected = connected
-	if !connected {
+	// A MIGlet that announced its shutdown is expected to disconnect; keep it STOPPING
+	if !connected && status.MigletState != MigletStateShuttingDown {
 		status.MigletState = MigletStateUnknown
 	}
 
 	return s.Update(ctx, status)
 }
 
+// SetMigletState records a MIGlet state reported outside a heartbeat, e.g. by vm_shutting_down
+func (s *VMStatusStore) SetMigletState(ctx context.Context, vmID string, state MigletState) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return nil // VM not tracked yet
+	}
+
+	status.MigletState = state
+
+	return s.Update(ctx, status)
+}
+
+// SetOrg tags a VM with the org it serves
+func (s *VMStatusStore) SetOrg(ctx context.Context, vmID, orgID string) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return nil // VM not tracked yet
+	}
+
+	status.OrgID = orgID
+
+	return s.Update(ctx, status)
+}
+
+// SetRunner records the runner the MIGlet registered, as returned in the register_runner ack
+func (s *VMStatusStore) SetRunner(ctx context.Context, vmID, runnerName string, runnerID int64) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return nil // VM not tracked yet
+	}
+
+	status.RunnerName = runnerName
+	status.RunnerID = runnerID
+
+	return s.Update(ctx, status)
+}
+
+// SetAgentInfo records the MIGlet version, capabilities and org reported on connect
+// An org the VM is already tagged with is kept; orgID only fills in an untagged VM
+func (s *VMStatusStore) SetAgentInfo(ctx context.Context, vmID, version string, capabilities []string, orgID string) error {
+	status, err := s.Get(ctx, vmID)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		// Track it now: capabilities decide whether the VM may be given a job
+		status = &VMStatus{
+			VMID:       vmID,
+			PoolID:     s.poolID,
+			InfraState: VMInfraRunning, // It is running if MIGlet connected
+			CreatedAt:  time.Now(),
+		}
+	}
+
+	status.MigletVersion = version
+	status.Capabilities = capabilities
+	if status.OrgID == "" {
+		status.OrgID = orgID
+	}
+
+	return s.Update(ctx, status)
+}
+
 // Delete removes VM status
 func (s *VMStatusStore) Delete(ctx context.Context, vmID string) error {
 	key := fmt.Sprintf("vms:%s:%s", s.poolID, vmID)
@@ -242,7 +427,7 @@ func (s *VMStatusStore) Delete(ctx context.Context, vmID string) error {
 		for _, state := range []EffectiveState{
 			EffectiveStateStopped, EffectiveStateStarting, EffectiveStateBooting,
 			EffectiveStateConnecting, EffectiveStateReady, EffectiveStateIdle,
-			EffectiveStateBusy, EffectiveStateError, EffectiveStateStopping,
+			EffectiveStateBusy, EffectiveStateError, EffectiveStateDegraded, EffectiveStateStopping,
 		} {
 			indexKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, state)
 			s.client.SRem(ctx, indexKey, vmID)
@@ -277,8 +462,23 @@ func (s *VMStatusStore) GetAll(ctx context.Context) ([]*VMStatus, error) {
 	return statuses, nil
 }
 
-// GetByEffectiveState returns VMs with a specific effective state
+// VMOrder selects how GetByEffectiveStateOrdered sorts VMs
+type VMOrder int
+
+const (
+	VMOrderNone             VMOrder = iota // Set iteration order
+	VMOrderOldestFirst                     // Least recently heard from first
+	VMOrderMostRecentFirst                 // Most recently heard from first
+	VMOrderLongestIdleFirst                // Earliest IdleSince first; VMs without one last
+)
+
+// GetByEffectiveState returns VMs with a specific effective state, in no particular order
 func (s *VMStatusStore) GetByEffectiveState(ctx context.Context, state EffectiveState) ([]*VMStatus, error) {
+	return s.GetByEffectiveStateOrdered(ctx, state, VMOrderNone)
+}
+
+// GetByEffectiveStateOrdered returns VMs with a specific effective state, sorted by order
+func (s *VMStatusStore) GetByEffectiveStateOrdered(ctx context.Context, state EffectiveState, order VMOrder) ([]*VMStatus, error) {
 	indexKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, state)
 	vmIDs, err := s.client.SMembers(ctx, indexKey).Result()
 	if err != nil {
@@ -294,42 +494,129 @@ func (s *VMStatusStore) GetByEffectiveState(ctx context.Context, state Effective
 		statuses = append(statuses, status)
 	}
 
+	sortStatuses(statuses, order)
 	return statuses, nil
 }
 
+// sortStatuses orders statuses by order; VMOrderNone leaves them as is
+func sortStatuses(statuses []*VMStatus, order VMOrder) {
+	switch order {
+	case VMOrderOldestFirst:
+		sort.SliceStable(statuses, func(i, j int) bool {
+			return statuses[i].LastHeartbeat.Before(statuses[j].LastHeartbeat)
+		})
+	case VMOrderMostRecentFirst:
+		sort.SliceStable(statuses, func(i, j int) bool {
+			return statuses[i].LastHeartbeat.After(statuses[j].LastHeartbeat)
+		})
+	case VMOrderLongestIdleFirst:
+		sort.SliceStable(statuses, func(i, j int) bool {
+			a, b := statuses[i].IdleSince, statuses[j].IdleSince
+			if a.IsZero() || b.IsZero() {
+				return !a.IsZero() && b.IsZero()
+			}
+			return a.Before(b)
+		})
+	}
+}
+
 // GetFirstReady returns the first ready VM (for job assignment)
-func (s *VMStatusStore) GetFirstReady(ctx context.Context) (*VMStatus, error) {
+// A non-empty orgID skips VMs tagged with a different org, and VMs whose MIGlet can't
+// register a runner (see CanRegisterRunner) are never returned
+// Within each state the VM idle longest is preferred, so no VM sits idle indefinitely
+func (s *VMStatusStore) GetFirstReady(ctx context.Context, orgID string) (*VMStatus, error) {
 	// First try "ready" state (MIGlet is ready but runner not started)
-	statuses, err := s.GetByEffectiveState(ctx, EffectiveStateReady)
+	statuses, err := s.GetByEffectiveStateOrdered(ctx, EffectiveStateReady, VMOrderLongestIdleFirst)
 	if err != nil {
 		return nil, err
 	}
-	if len(statuses) > 0 {
-		return statuses[0], nil
+	if status := firstForOrg(canRegisterRunner(statuses), orgID); status != nil {
+		return status, nil
 	}
 
 	// Then try "idle" state (runner is idle)
-	statuses, err = s.GetByEffectiveState(ctx, EffectiveStateIdle)
+	statuses, err = s.GetByEffectiveStateOrdered(ctx, EffectiveStateIdle, VMOrderLongestIdleFirst)
 	if err != nil {
 		return nil, err
 	}
-	if len(statuses) > 0 {
-		return statuses[0], nil
+	return firstForOrg(canRegisterRunner(statuses), orgID), nil
+}
+
+// GetFirstIdleRunner returns the VM idle longest whose registered runner can be handed
+// a job directly (see HasIdleRunner), or nil if there is none
+// A non-empty orgID skips VMs tagged with a different org
+func (s *VMStatusStore) GetFirstIdleRunner(ctx context.Context, orgID string) (*VMStatus, error) {
+	statuses, err := s.GetByEffectiveStateOrdered(ctx, EffectiveStateIdle, VMOrderLongestIdleFirst)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	var idle []*VMStatus
+	for _, status := range statuses {
+		if status.HasIdleRunner() {
+			idle = append(idle, status)
+		}
+	}
+	return firstForOrg(idle, orgID), nil
+}
+
+// CanRegisterRunner reports whether the VM's MIGlet accepts register_runner
+// MIGlets only advertise it once the runner binary is verified present; agents
+// that report no capabilities at all predate negotiation and are assumed able to
+func (v *VMStatus) CanRegisterRunner() bool {
+	return len(v.Capabilities) == 0 || v.hasCapability("register_runner")
+}
+
+// HasIdleRunner reports whether the VM has a registered runner waiting for work that its
+// MIGlet can be told about with job_available, instead of registering a new runner
+func (v *VMStatus) HasIdleRunner() bool {
+	return v.EffectiveState == EffectiveStateIdle &&
+		v.RunnerState == RunnerStateIdle &&
+		v.RunnerName != "" &&
+		v.CurrentJobID == "" &&
+		v.hasCapability("job_available")
+}
+
+// hasCapability reports whether the VM's MIGlet advertised the command type on connect
+func (v *VMStatus) hasCapability(commandType string) bool {
+	for _, capability := range v.Capabilities {
+		if capability == commandType {
+			return true
+		}
+	}
+	return false
+}
+
+// canRegisterRunner filters statuses down to VMs that can register a runner
+func canRegisterRunner(statuses []*VMStatus) []*VMStatus {
+	var usable []*VMStatus
+	for _, status := range statuses {
+		if status.CanRegisterRunner() {
+			usable = append(usable, status)
+		}
+	}
+	return usable
 }
 
 // GetFirstStopped returns the first stopped VM (for starting)
-func (s *VMStatusStore) GetFirstStopped(ctx context.Context) (*VMStatus, error) {
+// A non-empty orgID skips VMs tagged with a different org
+func (s *VMStatusStore) GetFirstStopped(ctx context.Context, orgID string) (*VMStatus, error) {
 	statuses, err := s.GetByEffectiveState(ctx, EffectiveStateStopped)
 	if err != nil {
 		return nil, err
 	}
-	if len(statuses) > 0 {
-		return statuses[0], nil
+	return firstForOrg(statuses, orgID), nil
+}
+
+// firstForOrg returns the first status usable for orgID: untagged, or tagged with that org
+// An empty orgID matches every VM
+func firstForOrg(statuses []*VMStatus, orgID string) *VMStatus {
+	for _, status := range statuses {
+		if orgID == "" || status.OrgID == "" || status.OrgID == orgID {
+			return status
+		}
 	}
-	return nil, nil
+	return nil
 }
 
 // CountByState returns count of VMs in each state
@@ -339,7 +626,7 @@ func (s *VMStatusStore) CountByState(ctx context.Context) (map[EffectiveState]in
 	for _, state := range []EffectiveState{
 		EffectiveStateStopped, EffectiveStateStarting, EffectiveStateBooting,
 		EffectiveStateConnecting, EffectiveStateReady, EffectiveStateIdle,
-		EffectiveStateBusy, EffectiveStateError, EffectiveStateStopping,
+		EffectiveStateBusy, EffectiveStateError, EffectiveStateDegraded, EffectiveStateStopping,
 	} {
 		indexKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, state)
 		count, err := s.client.SCard(ctx, indexKey).Result()
@@ -359,14 +646,20 @@ func (s *VMStatusStore) GetStats(ctx context.Context) (*PoolStats, error) {
 		return nil, err
 	}
 
+	return newPoolStats(s.poolID, counts), nil
+}
+
+// newPoolStats summarizes per-state VM counts
+func newPoolStats(poolID string, counts map[EffectiveState]int64) *PoolStats {
 	stats := &PoolStats{
-		PoolID:      s.poolID,
+		PoolID:      poolID,
 		TotalVMs:    0,
 		RunningVMs:  0,
 		ReadyVMs:    counts[EffectiveStateReady] + counts[EffectiveStateIdle],
 		BusyVMs:     counts[EffectiveStateBusy],
 		StoppedVMs:  counts[EffectiveStateStopped],
 		ErrorVMs:    counts[EffectiveStateError],
+		DegradedVMs: counts[EffectiveStateDegraded],
 		StartingVMs: counts[EffectiveStateStarting] + counts[EffectiveStateBooting] + counts[EffectiveStateConnecting],
 	}
 
@@ -375,7 +668,7 @@ func (s *VMStatusStore) GetStats(ctx context.Context) (*PoolStats, error) {
 	}
 	stats.RunningVMs = stats.TotalVMs - stats.StoppedVMs
 
-	return stats, nil
+	return stats
 }
 
 // PoolStats represents pool statistics
@@ -387,11 +680,28 @@ type PoolStats struct {
 	BusyVMs     int64  `json:"busy_vms"`
 	StoppedVMs  int64  `json:"stopped_vms"`
 	ErrorVMs    int64  `json:"error_vms"`
+	DegradedVMs int64  `json:"degraded_vms"`
 	StartingVMs int64  `json:"starting_vms"`
 }
 
+// prepareUpdate stamps a status about to be saved and recalculates its effective state
+func (h *healthThresholds) prepareUpdate(status *VMStatus) {
+	status.UpdatedAt = time.Now()
+	status.EffectiveState = h.calculateEffectiveState(status)
+
+	// Track how long a VM has been stopped; restarting clears it (cancelling pending deletion)
+	if status.EffectiveState == EffectiveStateStopped {
+		if status.StoppedAt.IsZero() {
+			status.StoppedAt = status.UpdatedAt
+		}
+		status.IdleSince = time.Time{} // Idle time restarts from the first heartbeat after boot
+	} else {
+		status.StoppedAt = time.Time{}
+	}
+}
+
 // calculateEffectiveState determines the effective state based on infra and miglet states
-func (s *VMStatusStore) calculateEffectiveState(status *VMStatus) EffectiveState {
+func (h *healthThresholds) calculateEffectiveState(status *VMStatus) EffectiveState {
 	switch status.InfraState {
 	case VMInfraStopped:
 		return EffectiveStateStopped
@@ -406,11 +716,11 @@ func (s *VMStatusStore) calculateEffectiveState(status *VMStatus) EffectiveState
 		case MigletStateConnecting:
 			return EffectiveStateConnecting
 		case MigletStateReady:
-			return EffectiveStateReady
+			return h.healthGate(status, EffectiveStateReady)
 		case MigletStateRegisteringRunner:
 			return EffectiveStateConnecting
 		case MigletStateIdle:
-			return EffectiveStateIdle
+			return h.healthGate(status, EffectiveStateIdle)
 		case MigletStateJobRunning:
 			return EffectiveStateBusy
 		case MigletStateDraining:
@@ -427,13 +737,30 @@ func (s *VMStatusStore) calculateEffectiveState(status *VMStatus) EffectiveState
 	}
 }
 
+// healthGate returns DEGRADED instead of state if the VM is over a resource usage threshold
+// Busy VMs are never gated, so a running job isn't disturbed; they become DEGRADED once free
+func (h *healthThresholds) healthGate(status *VMStatus, state EffectiveState) EffectiveState {
+	if status.OverUsage(h.maxDiskUsage, h.maxMemoryUsage) {
+		return EffectiveStateDegraded
+	}
+	return state
+}
+
+// OverUsage reports whether the VM's last reported disk or memory usage exceeds the given
+// percentages; a threshold of 0 is ignored, and usage equal to a threshold is within it
+func (v *VMStatus) OverUsage(maxDiskUsage, maxMemoryUsage float64) bool {
+	return (maxDiskUsage > 0 && v.DiskUsage > maxDiskUsage) ||
+		(maxMemoryUsage > 0 && v.MemoryUsage > maxMemoryUsage)
+}
+
 // updateStateIndex updates the state index sets in Redis
 func (s *VMStatusStore) updateStateIndex(ctx context.Context, status *VMStatus) error {
 	// Remove from all state indexes first
 	for _, state := range []EffectiveState{
 		EffectiveStateStopped, EffectiveStateStarting, EffectiveStateBooting,
 		EffectiveStateConnecting, EffectiveStateReady, EffectiveStateIdle,
-		EffectiveStateBusy, EffectiveStateError, EffectiveStateStopping, EffectiveStateUnknown,
+		EffectiveStateBusy, EffectiveStateError, EffectiveStateDegraded, EffectiveStateStopping,
+		EffectiveStateUnknown,
 	} {
 		indexKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, state)
 		s.client.SRem(ctx, indexKey, status.VMID)
@@ -444,3 +771,108 @@ func (s *VMStatusStore) updateStateIndex(ctx context.Context, status *VMStatus)
 	return s.client.SAdd(ctx, indexKey, status.VMID).Err()
 }
 
+// reconcileIndexEntryScript fixes one state index entry if the VM's document still disagrees
+// with it, checking and fixing in one step so a concurrent Update can't be undone
+// KEYS[1] = VM document, KEYS[2] = state index set
+// ARGV[1] = VM ID, ARGV[2] = the index's state, ARGV[3] = "remove" or "add"
+// Returns 1 if the entry was corrected
+var reconcileIndexEntryScript = redis.NewScript(`
+local doc = redis.call('GET', KEYS[1])
+local state = false
+if doc then
+	state = cjson.decode(doc).effective_state
+end
+if ARGV[3] == 'remove' then
+	if state ~= ARGV[2] then
+		return redis.call('SREM', KEYS[2], ARGV[1])
+	end
+	return 0
+end
+if state == ARGV[2] then
+	return redis.call('SADD', KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// ReconcileStateIndex rebuilds the effective-state index sets from the VM status documents,
+// removing entries for VMs in another state (or with no document) and adding missing ones
+// Each discrepancy is re-checked against the VM's document and fixed in one script, so a
+// VM updated meanwhile is left to Update. Returns the number of index entries corrected
+func (s *VMStatusStore) ReconcileStateIndex(ctx context.Context) (int, error) {
+	log := logger.WithComponent("vm_status_store")
+
+	statuses, err := s.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	expected := make(map[EffectiveState]map[string]bool)
+	for _, status := range statuses {
+		if expected[status.EffectiveState] == nil {
+			expected[status.EffectiveState] = make(map[string]bool)
+		}
+		expected[status.EffectiveState][status.VMID] = true
+	}
+
+	// fix corrects vmID's entry in the state's index if its document still calls for it
+	fix := func(indexKey string, state EffectiveState, vmID, action string) (bool, error) {
+		docKey := fmt.Sprintf("vms:%s:%s", s.poolID, vmID)
+		n, err := reconcileIndexEntryScript.Run(ctx, s.client, []string{docKey, indexKey}, vmID, string(state), action).Int()
+		if err != nil {
+			return false, fmt.Errorf("failed to reconcile state index entry: %w", err)
+		}
+		return n > 0, nil
+	}
+
+	corrected := 0
+	for _, state := range []EffectiveState{
+		EffectiveStateStopped, EffectiveStateStarting, EffectiveStateBooting,
+		EffectiveStateConnecting, EffectiveStateReady, EffectiveStateIdle,
+		EffectiveStateBusy, EffectiveStateError, EffectiveStateDegraded, EffectiveStateStopping,
+		EffectiveStateUnknown,
+	} {
+		indexKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, state)
+		members, err := s.client.SMembers(ctx, indexKey).Result()
+		if err != nil {
+			return corrected, fmt.Errorf("failed to read state index: %w", err)
+		}
+		indexed := make(map[string]bool, len(members))
+
+		for _, vmID := range members {
+			indexed[vmID] = true
+			if expected[state][vmID] {
+				continue
+			}
+			fixed, err := fix(indexKey, state, vmID, "remove")
+			if err != nil {
+				return corrected, err
+			}
+			if fixed {
+				corrected++
+				log.WithFields(map[string]interface{}{
+					"vm_id": vmID,
+					"state": state,
+				}).Warn("Removed stale VM state index entry")
+			}
+		}
+
+		for vmID := range expected[state] {
+			if indexed[vmID] {
+				continue
+			}
+			fixed, err := fix(indexKey, state, vmID, "add")
+			if err != nil {
+				return corrected, err
+			}
+			if fixed {
+				corrected++
+				log.WithFields(map[string]interface{}{
+					"vm_id": vmID,
+					"state": state,
+				}).Warn("Added missing VM state index entry")
+			}
+		}
+	}
+
+	return corrected, nil
+}
+
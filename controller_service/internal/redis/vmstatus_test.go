@@ -0,0 +1,224 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// newRedisVMStatusStore connects to the Redis at REDIS_TEST_ADDR (host:port) with a pool
+// ID unique to the test, skipping the test when the variable is unset
+// The test's keys are deleted when it ends
+func newRedisVMStatusStore(t *testing.T) *VMStatusStore {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	poolID := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	s, err := NewVMStatusStore(&config.RedisInstanceConfig{Host: host, Port: port}, poolID)
+	if err != nil {
+		t.Fatalf("NewVMStatusStore: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, _ := s.client.Keys(ctx, "vms*"+poolID+"*").Result()
+		if len(keys) > 0 {
+			s.client.Del(ctx, keys...)
+		}
+		s.client.Close()
+	})
+	return s
+}
+
+func TestReconcileStateIndexFixesDrift(t *testing.T) {
+	s := newRedisVMStatusStore(t)
+	ctx := context.Background()
+
+	status := &VMStatus{VMID: "vm-1", PoolID: s.poolID, InfraState: VMInfraRunning, MigletState: MigletStateReady, IsConnected: true}
+	if err := s.Update(ctx, status); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	readyKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, EffectiveStateReady)
+	busyKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, EffectiveStateBusy)
+
+	// Drift: the VM is missing from its own index and listed under another state
+	s.client.SRem(ctx, readyKey, "vm-1")
+	s.client.SAdd(ctx, busyKey, "vm-1")
+	// A VM with no document at all
+	s.client.SAdd(ctx, busyKey, "vm-gone")
+
+	corrected, err := s.ReconcileStateIndex(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileStateIndex: %v", err)
+	}
+	if corrected != 3 {
+		t.Errorf("corrected = %d, want 3", corrected)
+	}
+	if ok, _ := s.client.SIsMember(ctx, readyKey, "vm-1").Result(); !ok {
+		t.Error("vm-1 not added back to the ready index")
+	}
+	if n, _ := s.client.SCard(ctx, busyKey).Result(); n != 0 {
+		t.Errorf("busy index has %d entries, want none", n)
+	}
+
+	if corrected, err := s.ReconcileStateIndex(ctx); err != nil || corrected != 0 {
+		t.Errorf("second ReconcileStateIndex = %d, %v, want nothing left to correct", corrected, err)
+	}
+}
+
+func TestReconcileIndexEntryScriptRechecksDocument(t *testing.T) {
+	s := newRedisVMStatusStore(t)
+	ctx := context.Background()
+
+	status := &VMStatus{VMID: "vm-1", PoolID: s.poolID, InfraState: VMInfraRunning, MigletState: MigletStateReady, IsConnected: true}
+	if err := s.Update(ctx, status); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	docKey := fmt.Sprintf("vms:%s:vm-1", s.poolID)
+	readyKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, EffectiveStateReady)
+
+	// A removal decided from a stale read is refused once the document says READY again
+	n, err := reconcileIndexEntryScript.Run(ctx, s.client, []string{docKey, readyKey}, "vm-1", string(EffectiveStateReady), "remove").Int()
+	if err != nil || n != 0 {
+		t.Fatalf("remove = %d, %v, want 0", n, err)
+	}
+	if ok, _ := s.client.SIsMember(ctx, readyKey, "vm-1").Result(); !ok {
+		t.Fatal("entry matching the document was removed")
+	}
+
+	// An addition for a state the document no longer has is refused
+	busyKey := fmt.Sprintf("vms:by_state:%s:%s", s.poolID, EffectiveStateBusy)
+	n, err = reconcileIndexEntryScript.Run(ctx, s.client, []string{docKey, busyKey}, "vm-1", string(EffectiveStateBusy), "add").Int()
+	if err != nil || n != 0 {
+		t.Fatalf("add = %d, %v, want 0", n, err)
+	}
+}
+
+func TestUpdatePublishesEffectiveStateChanges(t *testing.T) {
+	s := newRedisVMStatusStore(t)
+	ctx := context.Background()
+
+	pubsub := s.client.Subscribe(ctx, s.StateChangeChannel())
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	changes, unsubscribe := s.SubscribeStateChanges()
+	defer unsubscribe()
+
+	status := &VMStatus{VMID: "vm-1", PoolID: s.poolID, InfraState: VMInfraRunning, MigletState: MigletStateReady, IsConnected: true}
+	if err := s.Update(ctx, status); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	// A heartbeat that leaves the effective state alone publishes nothing
+	if err := s.Update(ctx, status); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	status.MigletState = MigletStateJobRunning
+	if err := s.Update(ctx, status); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	var got []StateChange
+	for len(got) < 2 {
+		select {
+		case change := <-changes:
+			got = append(got, change)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d in-process changes, want 2", len(got))
+		}
+	}
+	if got[0].OldState != "" || got[0].NewState != EffectiveStateReady {
+		t.Errorf("first change = %s -> %s, want '' -> %s", got[0].OldState, got[0].NewState, EffectiveStateReady)
+	}
+	if got[1].OldState != EffectiveStateReady || got[1].NewState != EffectiveStateBusy {
+		t.Errorf("second change = %s -> %s, want %s -> %s", got[1].OldState, got[1].NewState, EffectiveStateReady, EffectiveStateBusy)
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change %+v", change)
+	default:
+	}
+
+	recvCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	msg, err := pubsub.ReceiveMessage(recvCtx)
+	if err != nil {
+		t.Fatalf("no change published to Redis: %v", err)
+	}
+	var published StateChange
+	if err := json.Unmarshal([]byte(msg.Payload), &published); err != nil {
+		t.Fatalf("invalid published change %q: %v", msg.Payload, err)
+	}
+	if published.VMID != "vm-1" || published.NewState != EffectiveStateReady {
+		t.Errorf("published change = %+v, want vm-1 -> %s", published, EffectiveStateReady)
+	}
+}
+
+func TestOverUsageAtThresholdBoundaries(t *testing.T) {
+	tests := []struct {
+		name      string
+		disk, mem float64
+		maxDisk   float64
+		maxMemory float64
+		wantOver  bool
+	}{
+		{"disk at threshold", 90, 10, 90, 85, false},
+		{"disk just over threshold", 90.01, 10, 90, 85, true},
+		{"memory at threshold", 10, 85, 90, 85, false},
+		{"memory just over threshold", 10, 85.01, 90, 85, true},
+		{"both at thresholds", 90, 85, 90, 85, false},
+		{"thresholds disabled", 100, 100, 0, 0, false},
+		{"only disk threshold set", 10, 100, 90, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &VMStatus{DiskUsage: tt.disk, MemoryUsage: tt.mem}
+			if got := v.OverUsage(tt.maxDisk, tt.maxMemory); got != tt.wantOver {
+				t.Errorf("OverUsage(%v, %v) with disk %v, memory %v = %v, want %v",
+					tt.maxDisk, tt.maxMemory, tt.disk, tt.mem, got, tt.wantOver)
+			}
+		})
+	}
+}
+
+func TestCalculateEffectiveStateGatesOnlyAboveLimit(t *testing.T) {
+	s := &VMStatusStore{}
+	s.SetHealthThresholds(90, 85)
+
+	tests := []struct {
+		name      string
+		state     MigletState
+		disk, mem float64
+		want      EffectiveState
+	}{
+		{"ready at both limits", MigletStateReady, 90, 85, EffectiveStateReady},
+		{"ready over the disk limit", MigletStateReady, 90.5, 10, EffectiveStateDegraded},
+		{"idle over the memory limit", MigletStateIdle, 10, 85.5, EffectiveStateDegraded},
+		{"idle back at the disk limit", MigletStateIdle, 90, 10, EffectiveStateIdle},
+		// A VM running a job is left alone until it is free
+		{"busy over both limits", MigletStateJobRunning, 99, 99, EffectiveStateBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &VMStatus{InfraState: VMInfraRunning, MigletState: tt.state, DiskUsage: tt.disk, MemoryUsage: tt.mem}
+			if got := s.calculateEffectiveState(status); got != tt.want {
+				t.Errorf("effective state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
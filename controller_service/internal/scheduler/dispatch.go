@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/redis"
+)
+
+// grantBucket is a token bucket limiting how fast jobs are granted to mediated runners
+// Each grant takes a token; tokens refill at rate per second up to burst
+type grantBucket struct {
+	rate  float64 // 0 disables the limit
+	burst float64
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	granted  int64 // Jobs granted
+	deferred int64 // Scheduling passes that held a job back because of a dispatch limit
+}
+
+func newGrantBucket(rate float64, burst int) *grantBucket {
+	return &grantBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accrued since the last call; callers hold mu
+func (b *grantBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// ready reports whether a grant can be made now, without taking a token
+func (b *grantBucket) ready() bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens >= 1
+}
+
+// take records a grant, spending a token
+func (b *grantBucket) take() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.granted++
+	if b.rate <= 0 {
+		return
+	}
+	b.refill(time.Now())
+	b.tokens = math.Max(0, b.tokens-1)
+}
+
+// deferGrant counts a job held back by a dispatch limit
+func (b *grantBucket) deferGrant() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deferred++
+}
+
+// stats returns the bucket state for GetStats
+func (b *grantBucket) stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := map[string]interface{}{
+		"granted":  b.granted,
+		"deferred": b.deferred,
+	}
+	if b.rate > 0 {
+		b.refill(time.Now())
+		stats["tokens"] = math.Floor(b.tokens)
+	}
+	return stats
+}
+
+// grantBlocked returns why no job can be granted to a mediated runner right now, or ""
+// Concurrency counts the pool's assigned and running jobs, so it holds across restarts
+// and leader changes; the grant rate is per controller
+func (s *Scheduler) grantBlocked(ctx context.Context) string {
+	if limit := s.cfg.Pool.Dispatch.MaxConcurrentJobs; limit > 0 {
+		counts, err := s.jobStore.CountByStatus(ctx)
+		if err != nil {
+			return fmt.Sprintf("failed to count active jobs: %v", err)
+		}
+		if active := counts[redis.JobStatusAssigned] + counts[redis.JobStatusRunning]; active >= int64(limit) {
+			return fmt.Sprintf("%d of %d concurrent jobs active", active, limit)
+		}
+	}
+	if !s.grants.ready() {
+		return "grant rate limit reached"
+	}
+	return ""
+}
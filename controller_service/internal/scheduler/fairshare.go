@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/redis"
+)
+
+// fairShareForget drops orgs that haven't been served for this long
+const fairShareForget = time.Hour
+
+// fairShare rotates between orgs with queued jobs so one org can't starve the others
+// Within an org, the queue order (priority, then age) is kept
+type fairShare struct {
+	mu         sync.Mutex
+	lastServed map[string]time.Time // Org ID -> last time one of its jobs was dequeued
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{
+		lastServed: make(map[string]time.Time),
+	}
+}
+
+// pick returns the head job of the org served least recently
+// jobs must be in queue order; ties go to the org whose head job is further ahead
+func (f *fairShare) pick(jobs []*redis.Job) *redis.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var best *redis.Job
+	var bestServed time.Time
+	seen := make(map[string]bool)
+	for _, job := range jobs {
+		if seen[job.OrgID] {
+			continue
+		}
+		seen[job.OrgID] = true
+
+		served := f.lastServed[job.OrgID]
+		if best == nil || served.Before(bestServed) {
+			best = job
+			bestServed = served
+		}
+	}
+	return best
+}
+
+// served records that orgID just had a job dequeued
+func (f *fairShare) served(orgID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.lastServed[orgID] = now
+	for org, at := range f.lastServed {
+		if now.Sub(at) > fairShareForget {
+			delete(f.lastServed, org)
+		}
+	}
+}
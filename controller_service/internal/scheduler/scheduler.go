@@ -2,12 +2,19 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 
+	"github.com/monkci/mig-controller/internal/alerts"
 	"github.com/monkci/mig-controller/internal/config"
 	grpcserver "github.com/monkci/mig-controller/internal/grpc"
 	"github.com/monkci/mig-controller/internal/redis"
@@ -17,14 +24,59 @@ import (
 	"github.com/monkci/mig-controller/proto/commands"
 )
 
+// TokenProvider issues runner registration tokens and manages registered runners
+// *token.Service implements it against the GitHub API
+type TokenProvider interface {
+	GetRegistrationToken(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool) (*token.RegistrationToken, error)
+	GetRunnerURL(repoOrOrg string, isOrg bool) string
+	FindRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, name string) (*token.Runner, error)
+	DeleteRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, runnerID int64) error
+}
+
+// VMController creates, starts, stops and recycles the pool's VMs
+// *vm.Manager implements it against Compute Engine
+type VMController interface {
+	BreakerOpen() bool
+	RefreshVMList(ctx context.Context) error
+	ScaleUp(ctx context.Context, count int) error
+	StartVM(ctx context.Context, vmName string) error
+	StopVM(ctx context.Context, vmName string) error
+	EnsureMinReadyVMs(ctx context.Context) error
+	CleanupIdleVMs(ctx context.Context) error
+	DeleteStoppedVMs(ctx context.Context) error
+	RecycleDegradedVMs(ctx context.Context) error
+	DrainAndRecycle(ctx context.Context, vmID, issuer string) error
+}
+
+// MIGletGateway delivers commands to MIGlets and tracks their reported state
+// *grpc.Server implements it over the MIGlet command streams
+type MIGletGateway interface {
+	SendCommandAs(issuer, vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error)
+	WaitForState(ctx context.Context, vmID string, targetState redis.MigletState, timeout time.Duration) error
+	GetMigletState(vmID string) (redis.MigletState, bool)
+	GetConnectionCount() int
+	ConnectionID(vmID string) string
+	CloseStreams()
+}
+
+var (
+	_ VMController  = (*vm.Manager)(nil)
+	_ MIGletGateway = (*grpcserver.Server)(nil)
+)
+
 // Scheduler handles job assignment to VMs
 type Scheduler struct {
 	cfg          *config.Config
-	jobStore     *redis.JobStore
-	vmStore      *redis.VMStatusStore
-	vmManager    *vm.Manager
-	grpcServer   *grpcserver.Server
-	tokenService *token.Service
+	jobStore     JobQueue
+	vmStore      VMStateStore
+	vmManager    VMController
+	grpcServer   MIGletGateway
+	tokenService TokenProvider
+	fairShare    *fairShare        // Org rotation, used when Scheduler.FairShare is enabled
+	alerts       *alerts.Manager   // nil when alerting is disabled
+	leaderLock   *redis.LeaderLock // nil when leader election is disabled; this replica always leads
+	grants       *grantBucket      // Grant rate limit for mediated dispatch
+	isLeader     atomic.Bool
 
 	// Control
 	ctx    context.Context
@@ -32,23 +84,33 @@ type Scheduler struct {
 	wg     sync.WaitGroup
 
 	// Metrics
-	assignedJobs   int64
-	failedJobs     int64
-	startedVMs     int64
-	createdVMs     int64
+	assignedJobs  int64
+	failedJobs    int64
+	startedVMs    int64
+	createdVMs    int64
+	exhaustedJobs atomic.Int64 // Jobs failed because they ran out of retries
+	indexDrift    atomic.Int64 // VM state index entries corrected by reconciliation
+	setAsideVMs   atomic.Int64 // VMs taken out of selection because their pending command queue was full
+
+	lastIndexReconcile time.Time // Maintenance loop only
 }
 
 // NewScheduler creates a new scheduler
 func NewScheduler(
 	cfg *config.Config,
-	jobStore *redis.JobStore,
-	vmStore *redis.VMStatusStore,
-	vmManager *vm.Manager,
-	grpcServer *grpcserver.Server,
-	tokenService *token.Service,
+	jobStore JobQueue,
+	vmStore VMStateStore,
+	vmManager VMController,
+	grpcServer MIGletGateway,
+	tokenService TokenProvider,
 ) *Scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	var alertManager *alerts.Manager
+	if cfg.Alerts.Enabled {
+		alertManager = alerts.NewManager(cfg)
+	}
+
 	return &Scheduler{
 		cfg:          cfg,
 		jobStore:     jobStore,
@@ -56,21 +118,124 @@ func NewScheduler(
 		vmManager:    vmManager,
 		grpcServer:   grpcServer,
 		tokenService: tokenService,
+		fairShare:    newFairShare(),
+		alerts:       alertManager,
+		grants:       newGrantBucket(cfg.Pool.Dispatch.GrantRate, cfg.Pool.Dispatch.GrantBurst),
 		ctx:          ctx,
 		cancel:       cancel,
 	}
 }
 
+// SetLeaderLock enables leader election: only the replica holding the lock runs the
+// scheduling and VM maintenance loops. Must be called before Start
+func (s *Scheduler) SetLeaderLock(lock *redis.LeaderLock) {
+	s.leaderLock = lock
+}
+
 // Start starts the scheduler loop
 func (s *Scheduler) Start() {
 	log := logger.WithComponent("scheduler")
 	log.Info("Scheduler starting")
 
-	s.wg.Add(1)
-	go s.runSchedulerLoop()
+	if s.leaderLock == nil {
+		s.isLeader.Store(true)
+		s.startLoops(s.ctx, &s.wg)
+		return
+	}
 
 	s.wg.Add(1)
-	go s.runVMMaintenanceLoop()
+	go s.runLeaderElection()
+}
+
+// startLoops starts the scheduling and VM maintenance loops, which run until ctx is cancelled
+func (s *Scheduler) startLoops(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go s.runSchedulerLoop(ctx, wg)
+
+	wg.Add(1)
+	go s.runVMMaintenanceLoop(ctx, wg)
+}
+
+// runLeaderElection renews or contends for the leader lease, running the loops while it is held
+// Followers serve the HTTP API but refuse MIGlet streams (see grpc.Server.SetStreamGate),
+// and take over when the leader's lease lapses
+func (s *Scheduler) runLeaderElection() {
+	defer s.wg.Done()
+
+	log := logger.WithComponent("scheduler").WithField("replica_id", s.leaderLock.ID())
+	ticker := time.NewTicker(s.cfg.Scheduler.LeaderRenewInterval)
+	defer ticker.Stop()
+
+	var (
+		loops       sync.WaitGroup
+		stopLoops   context.CancelFunc
+		lastRenewed time.Time
+	)
+
+	stepDown := func() {
+		if stopLoops == nil {
+			return
+		}
+		stopLoops()
+		loops.Wait()
+		stopLoops = nil
+		s.isLeader.Store(false)
+		// Commands are sent by the leader, so its MIGlets must reconnect to it
+		s.grpcServer.CloseStreams()
+	}
+
+	for {
+		// Bound the call by what is left of our lease, so a hung Redis call can't keep
+		// this replica acting as leader after another one could have taken over
+		timeout := s.cfg.Scheduler.LeaderRenewInterval
+		if stopLoops != nil {
+			timeout = min(timeout, s.leaderLock.TTL()-time.Since(lastRenewed))
+		}
+		acquireCtx, cancelAcquire := context.WithTimeout(s.ctx, max(timeout, time.Millisecond))
+		held, err := s.leaderLock.Acquire(acquireCtx)
+		cancelAcquire()
+		switch {
+		case err != nil:
+			if s.ctx.Err() != nil {
+				break
+			}
+			log.WithError(err).Warn("Failed to renew leader lease")
+			// Keep leading until the lease we last renewed could have expired
+			if stopLoops != nil && time.Since(lastRenewed) >= s.leaderLock.TTL() {
+				log.Warn("Leader lease expired, stepping down")
+				stepDown()
+			}
+		case held:
+			lastRenewed = time.Now()
+			if stopLoops == nil {
+				log.Info("Acquired leadership, starting scheduling")
+				var leaderCtx context.Context
+				leaderCtx, stopLoops = context.WithCancel(s.ctx)
+				s.isLeader.Store(true)
+				s.startLoops(leaderCtx, &loops)
+			}
+		default:
+			if stopLoops != nil {
+				log.Warn("Lost leadership, stopping scheduling")
+				stepDown()
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			wasLeader := stopLoops != nil
+			stepDown()
+			if wasLeader {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := s.leaderLock.Release(ctx); err != nil {
+					log.WithError(err).Warn("Failed to release leader lease")
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // Stop stops the scheduler
@@ -79,23 +244,27 @@ func (s *Scheduler) Stop() {
 	log.Info("Scheduler stopping")
 	s.cancel()
 	s.wg.Wait()
+	if s.alerts != nil {
+		s.alerts.Wait() // Let alerts already fired reach Slack and PagerDuty
+	}
 	log.Info("Scheduler stopped")
 }
 
 // runSchedulerLoop is the main scheduling loop
-func (s *Scheduler) runSchedulerLoop() {
-	defer s.wg.Done()
+func (s *Scheduler) runSchedulerLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	log := logger.WithComponent("scheduler")
-	ticker := time.NewTicker(s.cfg.Scheduler.PollInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(s.pollDelay(s.cfg.Scheduler.PollInterval))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-s.ctx.Done():
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if err := s.processNextJob(); err != nil {
+		case <-timer.C:
+			timer.Reset(s.pollDelay(s.cfg.Scheduler.PollInterval))
+			if err := s.processNextJob(ctx); err != nil {
 				log.WithError(err).Debug("No jobs to process or error")
 			}
 		}
@@ -103,42 +272,153 @@ func (s *Scheduler) runSchedulerLoop() {
 }
 
 // runVMMaintenanceLoop handles VM warm pool and cleanup
-func (s *Scheduler) runVMMaintenanceLoop() {
-	defer s.wg.Done()
+func (s *Scheduler) runVMMaintenanceLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	log := logger.WithComponent("scheduler")
-	ticker := time.NewTicker(s.cfg.VMManager.PollInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(s.pollDelay(s.cfg.VMManager.PollInterval))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-s.ctx.Done():
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
+			timer.Reset(s.pollDelay(s.cfg.VMManager.PollInterval))
 			// Ensure minimum ready VMs
-			if err := s.vmManager.EnsureMinReadyVMs(s.ctx); err != nil {
-				log.WithError(err).Warn("Failed to ensure min ready VMs")
+			if err := s.vmManager.EnsureMinReadyVMs(ctx); err != nil {
+				warnMaintenance(err, "Failed to ensure min ready VMs")
 			}
 
 			// Cleanup idle VMs
-			if err := s.vmManager.CleanupIdleVMs(s.ctx); err != nil {
-				log.WithError(err).Warn("Failed to cleanup idle VMs")
+			if err := s.vmManager.CleanupIdleVMs(ctx); err != nil {
+				warnMaintenance(err, "Failed to cleanup idle VMs")
+			}
+
+			// Replace VMs excluded for high resource usage, if enabled
+			if err := s.vmManager.RecycleDegradedVMs(ctx); err != nil {
+				warnMaintenance(err, "Failed to recycle degraded VMs")
+			}
+
+			// Delete VMs stopped for longer than DeleteDelay
+			if err := s.vmManager.DeleteStoppedVMs(ctx); err != nil {
+				warnMaintenance(err, "Failed to delete stopped VMs")
 			}
 
 			// Refresh VM list from GCloud
-			if err := s.vmManager.RefreshVMList(s.ctx); err != nil {
-				log.WithError(err).Warn("Failed to refresh VM list")
+			if err := s.vmManager.RefreshVMList(ctx); err != nil {
+				warnMaintenance(err, "Failed to refresh VM list")
+			}
+
+			// Requeue jobs claimed by a scheduler that died before assigning them
+			if reaped, err := s.jobStore.ReapExpiredClaims(ctx, s.cfg.Scheduler.ClaimLeaseTTL); err != nil {
+				log.WithError(err).Warn("Failed to reap expired job claims")
+			} else if reaped > 0 {
+				log.WithField("jobs", reaped).Info("Requeued jobs with expired claims")
+			}
+
+			// Boost long-queued jobs so low priorities can't starve
+			if s.cfg.Scheduler.PriorityAgingThreshold > 0 {
+				aged, err := s.jobStore.AgeQueuedJobs(ctx, s.cfg.Scheduler.PriorityAgingThreshold, s.cfg.Scheduler.PriorityAgingInterval)
+				if err != nil {
+					log.WithError(err).Warn("Failed to age queued jobs")
+				} else if aged > 0 {
+					log.WithField("jobs", aged).Debug("Aged queued jobs")
+				}
+			}
+
+			// Correct VM state index sets that drifted from the VM statuses
+			s.reconcileStateIndex()
+
+			if s.alerts != nil {
+				s.evaluateAlerts()
 			}
 		}
 	}
 }
 
+// reconcileStateIndex rebuilds the VM state index sets every vm_manager.reconcile_interval
+func (s *Scheduler) reconcileStateIndex() {
+	interval := s.cfg.VMManager.ReconcileInterval
+	if interval <= 0 || time.Since(s.lastIndexReconcile) < interval {
+		return
+	}
+	s.lastIndexReconcile = time.Now()
+
+	log := logger.WithComponent("scheduler")
+	corrected, err := s.vmStore.ReconcileStateIndex(s.ctx)
+	if corrected > 0 {
+		s.indexDrift.Add(int64(corrected))
+		log.WithField("entries", corrected).Warn("Corrected VM state index drift")
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed to reconcile VM state index")
+	}
+}
+
+// pollDelay returns the wait before the next loop iteration: interval randomized by up to
+// scheduler.poll_jitter percent either way, so replicas and loops don't fire in lockstep
+func (s *Scheduler) pollDelay(interval time.Duration) time.Duration {
+	jitter := s.cfg.Scheduler.PollJitter / 100
+	if jitter <= 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// warnMaintenance logs a failed maintenance step
+// Failures short-circuited by the GCP circuit breaker are logged at debug, since the
+// breaker already warned when it opened and would otherwise flood the log every tick
+func warnMaintenance(err error, msg string) {
+	log := logger.WithComponent("scheduler")
+	if errors.Is(err, vm.ErrCircuitOpen) {
+		log.WithError(err).Debug(msg)
+		return
+	}
+	log.WithError(err).Warn(msg)
+}
+
+// evaluateAlerts feeds the current pool state to the alert manager
+func (s *Scheduler) evaluateAlerts() {
+	log := logger.WithComponent("scheduler")
+
+	stats, err := s.vmStore.GetStats(s.ctx)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get VM stats for alerting")
+		return
+	}
+	queueLen, err := s.jobStore.QueueLength(s.ctx)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get queue length for alerting")
+		return
+	}
+
+	s.alerts.Evaluate(s.ctx, alerts.Snapshot{
+		ErrorVMs:    stats.ErrorVMs,
+		QueueLength: queueLen,
+		ReadyVMs:    stats.ReadyVMs,
+		BusyVMs:     stats.BusyVMs,
+		StartingVMs: stats.StartingVMs,
+
+		GCPBreakerOpen: s.vmManager.BreakerOpen(),
+	})
+}
+
 // processNextJob attempts to process the next job in the queue
-func (s *Scheduler) processNextJob() error {
+// ctx is the leader's: losing leadership stops finding, provisioning and claiming. A
+// claimed job's assignment is still completed and recorded, as the MIGlet may have taken it
+func (s *Scheduler) processNextJob(ctx context.Context) error {
 	log := logger.WithComponent("scheduler")
 
+	// Return requeued jobs whose retry backoff has passed
+	if promoted, err := s.jobStore.PromoteDelayedJobs(ctx); err != nil {
+		log.WithError(err).Warn("Failed to promote delayed jobs")
+	} else if promoted > 0 {
+		log.WithField("jobs", promoted).Debug("Promoted delayed jobs to the queue")
+	}
+
 	// Peek at next job (don't dequeue yet)
-	job, err := s.jobStore.Peek(s.ctx)
+	job, err := s.nextJob(ctx)
 	if err != nil {
 		return err
 	}
@@ -146,10 +426,20 @@ func (s *Scheduler) processNextJob() error {
 		return nil // No jobs
 	}
 
+	// Mediated runners only take a job when granted one, so hold jobs back while the
+	// pool is at its concurrency or grant rate limit
+	if s.cfg.Pool.MediatedDispatch() {
+		if reason := s.grantBlocked(ctx); reason != "" {
+			s.grants.deferGrant()
+			logger.WithJob(job.ID, s.cfg.Pool.ID).WithField("reason", reason).Debug("Job grant deferred")
+			return nil
+		}
+	}
+
 	logger.WithJob(job.ID, s.cfg.Pool.ID).Info("Processing job")
 
 	// Find available VM
-	vmStatus, err := s.findAvailableVM()
+	vmStatus, err := s.findAvailableVM(ctx, job)
 	if err != nil {
 		log.WithError(err).Warn("Failed to find available VM")
 		return err
@@ -157,25 +447,49 @@ func (s *Scheduler) processNextJob() error {
 
 	if vmStatus == nil {
 		// No VMs available - need to start or create one
-		vmStatus, err = s.provisionVM()
+		vmStatus, err = s.provisionVM(ctx, job)
 		if err != nil {
 			log.WithError(err).Warn("Failed to provision VM")
 			return err
 		}
 	}
 
-	// Dequeue the job
-	job, err = s.jobStore.Dequeue(s.ctx)
+	// Claim the job; if we crash before it is assigned or requeued, the claim expires
+	// and the job goes back to the queue
+	job, err = s.jobStore.ClaimJob(ctx, job.ID)
 	if err != nil {
 		return err
 	}
+	if job == nil {
+		return nil // Taken or cancelled meanwhile
+	}
+	if s.cfg.Scheduler.FairShare {
+		s.fairShare.served(job.OrgID)
+	}
+
+	if s.cfg.Pool.MediatedDispatch() {
+		s.grants.take()
+	}
 
 	// Assign job to VM
 	if err := s.assignJobToVM(job, vmStatus); err != nil {
 		log.WithError(err).Warn("Failed to assign job to VM")
-		// Requeue the job
-		s.jobStore.Requeue(s.ctx, job.ID)
 		s.failedJobs++
+		if errors.Is(err, redis.ErrPendingQueueFull) {
+			// The command never reached the VM, so this isn't an attempt
+			s.setAsideVM(vmStatus.VMID)
+			if requeueErr := s.jobStore.RequeueUnsent(s.ctx, job.ID); requeueErr != nil {
+				log.WithError(requeueErr).WithField("job_id", job.ID).Warn("Failed to requeue job")
+			}
+			return err
+		}
+		if job.RetryCount < job.MaxRetries {
+			if requeueErr := s.jobStore.Requeue(s.ctx, job.ID); requeueErr != nil {
+				log.WithError(requeueErr).WithField("job_id", job.ID).Warn("Failed to requeue job")
+			}
+		} else {
+			s.failExhaustedJob(job, fmt.Sprintf("exceeded max assignment retries: %v", err))
+		}
 		return err
 	}
 
@@ -183,18 +497,136 @@ func (s *Scheduler) processNextJob() error {
 	return nil
 }
 
+// vmLog returns a logger for the VM that carries its stream's connection_id while it is
+// connected, so scheduler lines can be matched with the gRPC server's
+func (s *Scheduler) vmLog(vmID string) *logrus.Entry {
+	log := logger.WithVM(vmID, s.cfg.Pool.ID)
+	if connID := s.grpcServer.ConnectionID(vmID); connID != "" {
+		log = log.WithField("connection_id", connID)
+	}
+	return log
+}
+
+// setAsideVM takes a VM whose pending command queue is full out of selection, so the
+// next pass picks a different VM. Its MIGlet isn't taking commands, so its reported state
+// is reset to unknown; its next heartbeat makes it selectable again
+func (s *Scheduler) setAsideVM(vmID string) {
+	log := s.vmLog(vmID)
+	if err := s.vmStore.SetMigletState(s.ctx, vmID, redis.MigletStateUnknown); err != nil {
+		log.WithError(err).Warn("Failed to set aside VM with a full pending command queue")
+		return
+	}
+	s.setAsideVMs.Add(1)
+	log.Warn("VM is not taking commands, set aside until its next heartbeat")
+}
+
+// failExhaustedJob marks a job that has used up its retries as failed instead of requeuing it,
+// so a job that can never succeed doesn't churn the scheduler forever
+func (s *Scheduler) failExhaustedJob(job *redis.Job, reason string) {
+	log := logger.WithJob(job.ID, s.cfg.Pool.ID).WithFields(map[string]interface{}{
+		"retry_count": job.RetryCount,
+		"max_retries": job.MaxRetries,
+	})
+
+	if err := s.jobStore.MarkFailed(s.ctx, job.ID, reason); err != nil {
+		log.WithError(err).Warn("Failed to mark job as failed")
+		return
+	}
+	s.exhaustedJobs.Add(1)
+	log.WithField("reason", reason).Error("Job failed after exhausting its retries")
+
+	if s.alerts != nil {
+		s.alerts.Fire(s.ctx, alerts.Alert{
+			Key:      alerts.AlertJobRetries,
+			Severity: alerts.SeverityWarning,
+			Summary:  fmt.Sprintf("Job %s in pool %s failed after %d retries", job.ID, s.cfg.Pool.ID, job.RetryCount),
+			Details: map[string]interface{}{
+				"job_id": job.ID,
+				"repo":   job.RepoFullName,
+				"reason": reason,
+			},
+		})
+	}
+}
+
+// nextJob returns the job to schedule next without removing it from the queue
+// Jobs whose labels this pool can't satisfy are skipped. By default the first
+// remaining job is chosen; with FairShare the head job of the least recently served org
+func (s *Scheduler) nextJob(ctx context.Context) (*redis.Job, error) {
+	jobs, err := s.jobStore.PeekN(ctx, s.cfg.Scheduler.ScanDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs = s.schedulableJobs(ctx, jobs)
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	if !s.cfg.Scheduler.FairShare {
+		return jobs[0], nil
+	}
+	return s.fairShare.pick(jobs), nil
+}
+
+// schedulableJobs returns the jobs whose required labels this pool offers
+// Unmatched jobs stay queued until UnschedulableTimeout (so a pool label fix can
+// still pick them up), after which they are failed
+func (s *Scheduler) schedulableJobs(ctx context.Context, jobs []*redis.Job) []*redis.Job {
+	matched := jobs[:0]
+	for _, job := range jobs {
+		missing := s.cfg.MissingPoolLabels(job.Labels)
+		if len(missing) == 0 {
+			matched = append(matched, job)
+			continue
+		}
+
+		log := logger.WithJob(job.ID, s.cfg.Pool.ID).WithField("missing_labels", missing)
+		if time.Since(job.CreatedAt) < s.cfg.Scheduler.UnschedulableTimeout {
+			log.Debug("Job labels not offered by this pool, skipping")
+			continue
+		}
+
+		if taken, err := s.jobStore.ClaimJob(ctx, job.ID); err != nil || taken == nil {
+			continue
+		}
+		reason := fmt.Sprintf("unschedulable: pool %s does not offer labels %v", s.cfg.Pool.ID, missing)
+		if err := s.jobStore.MarkFailed(ctx, job.ID, reason); err != nil {
+			log.WithError(err).Warn("Failed to mark unschedulable job as failed")
+		}
+		log.Warn("Job unschedulable in this pool, marked failed")
+	}
+	return matched
+}
+
 // findAvailableVM finds a VM ready to accept a job
-func (s *Scheduler) findAvailableVM() (*redis.VMStatus, error) {
+// Persistent pools prefer a VM whose runner is already registered and idle, which
+// takes the job without a new registration
+func (s *Scheduler) findAvailableVM(ctx context.Context, job *redis.Job) (*redis.VMStatus, error) {
+	if s.cfg.Pool.PersistentRunners() {
+		vmStatus, err := s.vmStore.GetFirstIdleRunner(ctx, s.vmOrgFilter(job))
+		if err != nil || vmStatus != nil {
+			return vmStatus, err
+		}
+	}
+
 	// First check for ready/idle VMs
-	return s.vmStore.GetFirstReady(s.ctx)
+	return s.vmStore.GetFirstReady(ctx, s.vmOrgFilter(job))
+}
+
+// vmOrgFilter returns the org a job's VM must be tagged with, or "" for any VM
+func (s *Scheduler) vmOrgFilter(job *redis.Job) string {
+	if !s.cfg.Pool.OrgIsolation {
+		return ""
+	}
+	return job.OrgID
 }
 
 // provisionVM provisions a new VM (start stopped or create new)
-func (s *Scheduler) provisionVM() (*redis.VMStatus, error) {
+func (s *Scheduler) provisionVM(ctx context.Context, job *redis.Job) (*redis.VMStatus, error) {
 	log := logger.WithComponent("scheduler")
 
 	// First try to find a stopped VM
-	stoppedVM, err := s.vmStore.GetFirstStopped(s.ctx)
+	stoppedVM, err := s.vmStore.GetFirstStopped(ctx, s.vmOrgFilter(job))
 	if err != nil {
 		return nil, err
 	}
@@ -202,23 +634,31 @@ func (s *Scheduler) provisionVM() (*redis.VMStatus, error) {
 	if stoppedVM != nil {
 		log.WithField("vm_id", stoppedVM.VMID).Info("Starting stopped VM")
 
-		if err := s.vmManager.StartVM(s.ctx, stoppedVM.VMID); err != nil {
+		if err := s.vmManager.StartVM(ctx, stoppedVM.VMID); err != nil {
 			return nil, fmt.Errorf("failed to start VM: %w", err)
 		}
 
 		// Wait for VM to become ready
-		if err := s.grpcServer.WaitForState(s.ctx, stoppedVM.VMID, redis.MigletStateReady, s.cfg.Scheduler.AssignmentTimeout); err != nil {
+		if err := s.grpcServer.WaitForState(ctx, stoppedVM.VMID, redis.MigletStateReady, s.cfg.Scheduler.AssignmentTimeout); err != nil {
 			return nil, fmt.Errorf("VM did not become ready: %w", err)
 		}
 
 		s.startedVMs++
-		return s.vmStore.Get(s.ctx, stoppedVM.VMID)
+		return s.vmStore.Get(ctx, stoppedVM.VMID)
 	}
 
 	// No stopped VMs - need to scale up
 	log.Info("No stopped VMs available, scaling up MIG")
 
-	if err := s.vmManager.ScaleUp(s.ctx, 1); err != nil {
+	if err := s.vmManager.ScaleUp(ctx, 1); err != nil {
+		if errors.Is(err, vm.ErrQuotaExhausted) && s.alerts != nil {
+			s.alerts.Fire(ctx, alerts.Alert{
+				Key:      alerts.AlertQuota,
+				Severity: alerts.SeverityCritical,
+				Summary:  fmt.Sprintf("Pool %s cannot scale up: GCP quota exhausted", s.cfg.Pool.ID),
+				Details:  map[string]interface{}{"error": err.Error()},
+			})
+		}
 		return nil, fmt.Errorf("failed to scale up: %w", err)
 	}
 
@@ -231,9 +671,148 @@ func (s *Scheduler) provisionVM() (*redis.VMStatus, error) {
 
 // assignJobToVM assigns a job to a specific VM
 func (s *Scheduler) assignJobToVM(job *redis.Job, vmStatus *redis.VMStatus) error {
-	log := logger.WithJob(job.ID, s.cfg.Pool.ID).WithField("vm_id", vmStatus.VMID)
+	log := s.vmLog(vmStatus.VMID).WithField("job_id", job.ID)
 	log.Info("Assigning job to VM")
 
+	if s.cfg.Pool.OrgIsolation && vmStatus.OrgID != "" && vmStatus.OrgID != job.OrgID {
+		return fmt.Errorf("VM %s belongs to org %s, refusing job from org %s", vmStatus.VMID, vmStatus.OrgID, job.OrgID)
+	}
+
+	// A persistent runner that is already registered and idle only needs to be told about
+	// the job; everything else gets a freshly registered runner
+	var ack *commands.CommandAck
+	var err error
+	reuseRunner := s.cfg.Pool.PersistentRunners() && vmStatus.HasIdleRunner()
+	if reuseRunner {
+		ack, err = s.sendJobAvailable(job, vmStatus)
+	} else {
+		ack, err = s.sendRegisterRunner(job, vmStatus)
+	}
+	if err != nil {
+		if reuseRunner || !errors.Is(err, grpcserver.ErrCommandTimeout) || !s.registrationStarted(vmStatus.VMID) {
+			return err
+		}
+		// The ack is late, not missing: the MIGlet took the command. Requeueing now would
+		// hand the job to a second VM, so keep this assignment; the runner's identity is
+		// recorded from its runner_registered event instead of the ack
+		log.WithError(err).Warn("Runner registration ack timed out but the VM is registering, keeping the assignment")
+		s.wg.Add(1)
+		go s.awaitRegistration(vmStatus.VMID, job.ID)
+	}
+
+	// Update job status
+	if err := s.jobStore.AssignToVM(s.ctx, job.ID, vmStatus.VMID); err != nil {
+		return fmt.Errorf("failed to update job status: %w", err)
+	}
+
+	// Tag the VM so later jobs from other orgs are kept off it
+	if s.cfg.Pool.OrgIsolation && vmStatus.OrgID == "" && job.OrgID != "" {
+		if err := s.vmStore.SetOrg(s.ctx, vmStatus.VMID, job.OrgID); err != nil {
+			log.WithError(err).Warn("Failed to tag VM with job org")
+		}
+	}
+
+	// Keep the runner's identity for auditing and later de-registration
+	if ack != nil && ack.Result["runner_name"] != "" {
+		runnerName := ack.Result["runner_name"]
+		runnerID, _ := strconv.ParseInt(ack.Result["runner_id"], 10, 64)
+		s.recordRunner(job.ID, vmStatus.VMID, runnerName, runnerID)
+		log = log.WithFields(map[string]interface{}{"runner_name": runnerName, "runner_id": runnerID})
+	}
+
+	log.Info("Job assigned successfully")
+	return nil
+}
+
+// registrationGrace is how long past miglet.command_timeout a registration whose ack
+// timed out may take to report runner_registered before its job is requeued
+const registrationGrace = 30 * time.Second
+
+// awaitRegistration gives a registration whose ack timed out until command_timeout plus
+// registrationGrace to report its runner, so a MIGlet that took the command but hung
+// doesn't hold the job forever
+func (s *Scheduler) awaitRegistration(vmID, jobID string) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(s.cfg.MIGlet.CommandTimeout + registrationGrace)
+	defer timer.Stop()
+
+	select {
+	case <-s.ctx.Done():
+	case <-timer.C:
+		s.handleMissingRegistration(vmID, jobID)
+	}
+}
+
+// handleMissingRegistration requeues a job still assigned to the VM without a recorded
+// runner and recycles the VM, whose MIGlet evidently never finished registering
+func (s *Scheduler) handleMissingRegistration(vmID, jobID string) {
+	log := s.vmLog(vmID).WithField("job_id", jobID)
+
+	job, err := s.jobStore.Get(s.ctx, jobID)
+	if err != nil || job == nil {
+		return
+	}
+	if job.Status != redis.JobStatusAssigned || job.AssignedVMID != vmID || job.RunnerName != "" {
+		// The runner registered, or the job moved on, meanwhile
+		return
+	}
+
+	log.Error("Runner registration never completed")
+
+	if job.RetryCount < job.MaxRetries {
+		if err := s.jobStore.Requeue(s.ctx, job.ID); err != nil {
+			log.WithError(err).Warn("Failed to requeue job")
+		} else {
+			log.Info("Job requeued after runner registration timed out")
+		}
+	} else {
+		s.failExhaustedJob(job, "runner registration never completed - max retries exceeded")
+	}
+
+	if err := s.vmManager.DrainAndRecycle(s.ctx, vmID, "scheduler"); err != nil {
+		log.WithError(err).Warn("Failed to recycle VM with stuck registration")
+	}
+}
+
+// registrationStarted reports whether a VM whose register_runner ack timed out has
+// nonetheless taken the command, i.e. its MIGlet has moved past ready
+// The state comes from the live stream, which a MIGlet heartbeats on every transition,
+// falling back to the last state stored in Redis
+func (s *Scheduler) registrationStarted(vmID string) bool {
+	state, ok := s.grpcServer.GetMigletState(vmID)
+	if !ok {
+		status, err := s.vmStore.Get(s.ctx, vmID)
+		if err != nil || status == nil {
+			return false
+		}
+		state = status.MigletState
+	}
+
+	switch state {
+	case redis.MigletStateRegisteringRunner, redis.MigletStateIdle, redis.MigletStateJobRunning:
+		return true
+	}
+	return false
+}
+
+// recordRunner stores the runner registered for a job on both the job and its VM
+func (s *Scheduler) recordRunner(jobID, vmID, runnerName string, runnerID int64) {
+	log := logger.WithJob(jobID, s.cfg.Pool.ID).WithFields(map[string]interface{}{
+		"vm_id":       vmID,
+		"runner_name": runnerName,
+	})
+	if err := s.jobStore.SetRunner(s.ctx, jobID, runnerName, runnerID); err != nil {
+		log.WithError(err).Warn("Failed to record runner on job")
+	}
+	if err := s.vmStore.SetRunner(s.ctx, vmID, runnerName, runnerID); err != nil {
+		log.WithError(err).Warn("Failed to record runner on VM")
+	}
+}
+
+// sendRegisterRunner has the VM's MIGlet register a new runner for the job
+// Returns the successful ack, which carries the runner's identity
+func (s *Scheduler) sendRegisterRunner(job *redis.Job, vmStatus *redis.VMStatus) (*commands.CommandAck, error) {
 	// Generate registration token
 	regToken, err := s.tokenService.GetRegistrationToken(
 		s.ctx,
@@ -242,7 +821,13 @@ func (s *Scheduler) assignJobToVM(job *redis.Job, vmStatus *redis.VMStatus) erro
 		false, // isOrg - use repo-level token
 	)
 	if err != nil {
-		return fmt.Errorf("failed to get registration token: %w", err)
+		return nil, fmt.Errorf("failed to get registration token: %w", err)
+	}
+
+	// Resolve runner group (job-specific, falling back to the pool's group)
+	runnerGroup, err := s.resolveRunnerGroup(job)
+	if err != nil {
+		return nil, err
 	}
 
 	// Build register_runner command
@@ -251,40 +836,142 @@ func (s *Scheduler) assignJobToVM(job *redis.Job, vmStatus *redis.VMStatus) erro
 		Type:      "register_runner",
 		CreatedAt: time.Now().Unix(),
 		StringParams: map[string]string{
-			"token":        regToken.Token,
-			"url":          token.GetRunnerURL(job.RepoFullName, false),
-			"runner_group": "default",
-			"name":         vmStatus.VMID,
+			"registration_token": regToken.Token,
+			"runner_url":         s.tokenService.GetRunnerURL(job.RepoFullName, false),
+			"runner_group":       runnerGroup,
+			"name":               vmStatus.VMID,
+			"job_id":             job.ID,
 		},
-		StringArrayParams: job.Labels,
+		StringArrayParams: s.cfg.RunnerLabels(job.Labels),
+	}
+	if !regToken.ExpiresAt.IsZero() {
+		cmd.StringParams["expires_at"] = regToken.ExpiresAt.Format(time.RFC3339)
+	}
+	if s.cfg.Pool.PersistentRunners() {
+		// Keep the runner registered after the job, so later jobs can reuse it
+		cmd.StringParams["ephemeral"] = "false"
+	}
+	if s.cfg.Pool.MediatedDispatch() {
+		// The runner takes one job per grant: this one, then each later job_available
+		cmd.StringParams["dispatch"] = "mediated"
 	}
 
 	// Send command to MIGlet
-	ack, err := s.grpcServer.SendCommand(vmStatus.VMID, cmd, 30*time.Second)
+	ack, err := s.grpcServer.SendCommandAs("scheduler", vmStatus.VMID, cmd, s.cfg.MIGlet.CommandTimeout)
 	if err != nil {
-		return fmt.Errorf("failed to send register command: %w", err)
+		return nil, fmt.Errorf("failed to send register command: %w", err)
 	}
 
 	if !ack.Success {
-		return fmt.Errorf("registration failed: %s", ack.Message)
+		return nil, fmt.Errorf("registration failed: %s", ack.Message)
 	}
+	return ack, nil
+}
 
-	// Update job status
-	if err := s.jobStore.AssignToVM(s.ctx, job.ID, vmStatus.VMID); err != nil {
-		return fmt.Errorf("failed to update job status: %w", err)
+// sendJobAvailable tells the VM's idle persistent runner about the job, without a new
+// registration token or config.sh run. The runner is already listening to GitHub, so this
+// only confirms it is still idle; GitHub hands the job to it like any matching runner
+func (s *Scheduler) sendJobAvailable(job *redis.Job, vmStatus *redis.VMStatus) (*commands.CommandAck, error) {
+	logger.WithJob(job.ID, s.cfg.Pool.ID).WithFields(map[string]interface{}{
+		"vm_id":       vmStatus.VMID,
+		"runner_name": vmStatus.RunnerName,
+	}).Debug("Dispatching job to registered runner")
+
+	cmd := &commands.Command{
+		Id:        uuid.New().String(),
+		Type:      "job_available",
+		CreatedAt: time.Now().Unix(),
+		StringParams: map[string]string{
+			"job_id":     job.ID,
+			"repository": job.RepoFullName,
+			"run_id":     strconv.FormatInt(job.RunID, 10),
+		},
 	}
 
-	log.Info("Job assigned successfully")
+	ack, err := s.grpcServer.SendCommandAs("scheduler", vmStatus.VMID, cmd, s.cfg.MIGlet.CommandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send job_available command: %w", err)
+	}
+	if !ack.Success {
+		return nil, fmt.Errorf("runner not available: %s", ack.Message)
+	}
+	return ack, nil
+}
+
+// resolveRunnerGroup returns the runner group to register the job's runner in
+// Jobs that don't specify a group use the pool's configured RunnerGroup
+func (s *Scheduler) resolveRunnerGroup(job *redis.Job) (string, error) {
+	group := job.RunnerGroup
+	if group == "" {
+		group = s.cfg.Pool.RunnerGroup
+	}
+	if err := config.ValidateRunnerGroup(group); err != nil {
+		return "", fmt.Errorf("invalid runner group for job %s: %w", job.ID, err)
+	}
+	return group, nil
+}
+
+// CancelJob cancels a job running on a VM by sending it a cancel_job command
+// The job's post steps still run on the VM; the job is marked CANCELLED once the MIGlet accepts
+func (s *Scheduler) CancelJob(ctx context.Context, jobID string) error {
+	job, err := s.jobStore.Get(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+	if job.Status != redis.JobStatusAssigned && job.Status != redis.JobStatusRunning {
+		return fmt.Errorf("job %s is %s, not running", jobID, job.Status)
+	}
+
+	log := s.vmLog(job.AssignedVMID).WithField("job_id", jobID)
+
+	cmd := &commands.Command{
+		Id:        uuid.New().String(),
+		Type:      "cancel_job",
+		CreatedAt: time.Now().Unix(),
+	}
+
+	ack, err := s.grpcServer.SendCommandAs("scheduler", job.AssignedVMID, cmd, s.cfg.MIGlet.CommandTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to send cancel command: %w", err)
+	}
+	if !ack.Success {
+		return fmt.Errorf("cancel rejected: %s", ack.Message)
+	}
+
+	if err := s.jobStore.MarkCancelled(ctx, jobID, "cancelled by controller"); err != nil {
+		return fmt.Errorf("failed to mark job as cancelled: %w", err)
+	}
+
+	log.Info("Job cancelled")
 	return nil
 }
 
 // HandleJobEvent handles job events from MIGlets
 func (s *Scheduler) HandleJobEvent(vmID string, event *commands.EventNotification) {
-	log := logger.WithVM(vmID, s.cfg.Pool.ID).WithField("event_type", event.Type)
+	log := s.vmLog(vmID).WithField("event_type", event.Type)
 
 	switch event.Type {
+	case "vm_started":
+		s.handleVMStarted(vmID, event)
+
 	case "runner_registered":
 		log.Info("Runner registered on VM")
+		s.reconcileRegisteredRunner(vmID, event.Data)
+		if s.cfg.Scheduler.VerifyRunner {
+			runnerName := event.Data["runner_name"]
+			if runnerName == "" {
+				runnerName = vmID
+			}
+			s.wg.Add(1)
+			go s.verifyRunner(vmID, runnerName)
+		}
+
+	case "runner_deregistered":
+		s.wg.Add(1)
+		go s.removeDeregisteredRunner(vmID, event.Data)
 
 	case "job_started":
 		jobID := event.Data["job_id"]
@@ -298,6 +985,11 @@ func (s *Scheduler) HandleJobEvent(vmID string, event *commands.EventNotificatio
 	case "job_completed":
 		jobID := event.Data["job_id"]
 		success := event.Data["success"] == "true"
+		if job, err := s.jobStore.Get(s.ctx, jobID); err == nil && job != nil && job.Status == redis.JobStatusCancelled {
+			// Keep the cancelled status rather than reporting the interrupted run as failed
+			log.WithField("job_id", jobID).Info("Cancelled job finished")
+			return
+		}
 		if jobID != "" {
 			if success {
 				if err := s.jobStore.MarkCompleted(s.ctx, jobID); err != nil {
@@ -312,38 +1004,476 @@ func (s *Scheduler) HandleJobEvent(vmID string, event *commands.EventNotificatio
 		}
 		log.Info("Job completed")
 
+	case "vm_preempted":
+		s.handleVMPreempted(vmID, event)
+
+	case "vm_shutting_down":
+		// Mark the VM STOPPING now rather than waiting for its final heartbeat or the disconnect
+		log.WithFields(map[string]interface{}{
+			"reason":      event.Data["reason"],
+			"job_running": event.Data["job_running"],
+		}).Info("MIGlet shutting down")
+		if err := s.vmStore.SetMigletState(s.ctx, vmID, redis.MigletStateShuttingDown); err != nil {
+			log.WithError(err).Warn("Failed to mark shutting down VM as stopping")
+		}
+		if event.Data["reason"] == "max_jobs" {
+			// The VM ran its last job; stop it so it can be restarted fresh or deleted
+			s.wg.Add(1)
+			go s.stopRetiredVM(vmID)
+		}
+
 	case "runner_crashed":
-		// Handle runner crash - may need to reassign job
-		job, err := s.jobStore.GetByVM(s.ctx, vmID)
-		if err == nil && job != nil && job.Status == redis.JobStatusRunning {
-			// Attempt to requeue the job
-			if job.RetryCount < job.MaxRetries {
-				if err := s.jobStore.Requeue(s.ctx, job.ID); err != nil {
-					log.WithError(err).Warn("Failed to requeue job after crash")
-				} else {
-					log.WithField("job_id", job.ID).Info("Job requeued after runner crash")
-				}
+		s.handleRunnerCrashed(vmID, event)
+
+	case "runner_available":
+		// A mediated runner finished its granted job and is idle until the next grant
+		log.WithFields(map[string]interface{}{
+			"runner_name":    event.Data["runner_name"],
+			"jobs_completed": event.Data["jobs_completed"],
+		}).Info("Runner available for the next job")
+
+	case "error":
+		// e.g. a failed runner registration; keep config.sh's output for debugging
+		vmErr := &redis.VMError{
+			Reason:  event.Data["reason"],
+			Message: event.Data["message"],
+			LogTail: truncateCrashLog(event.Data["output"]),
+			At:      time.Now(),
+		}
+		log.WithFields(map[string]interface{}{
+			"reason": vmErr.Reason,
+			"error":  vmErr.Message,
+		}).Error("MIGlet reported an error")
+		if err := s.vmStore.SetLastError(s.ctx, vmID, vmErr); err != nil {
+			log.WithError(err).Warn("Failed to record MIGlet error on VM")
+		}
+	}
+}
+
+// reconcileRegisteredRunner records a newly registered runner on the job assigned to its VM
+// when the assignment didn't get it from the register_runner ack, e.g. because the ack
+// timed out. A runner on a VM with no assigned job is only logged
+func (s *Scheduler) reconcileRegisteredRunner(vmID string, data map[string]string) {
+	runnerName := data["runner_name"]
+	if runnerName == "" {
+		return
+	}
+	log := s.vmLog(vmID).WithField("runner_name", runnerName)
+
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err != nil {
+		log.WithError(err).Warn("Failed to look up job for registered runner")
+		return
+	}
+	if job == nil {
+		log.Warn("Runner registered on a VM with no assigned job")
+		return
+	}
+	if job.RunnerName != "" {
+		return // Already recorded from the ack
+	}
+
+	runnerID, _ := strconv.ParseInt(data["runner_id"], 10, 64)
+	s.recordRunner(job.ID, vmID, runnerName, runnerID)
+	log.WithField("job_id", job.ID).Info("Recorded late runner registration on its job")
+}
+
+// stopRetiredVM stops a VM whose MIGlet retired after running its maximum number of jobs
+func (s *Scheduler) stopRetiredVM(vmID string) {
+	defer s.wg.Done()
+	log := s.vmLog(vmID)
+
+	if err := s.vmManager.StopVM(s.ctx, vmID); err != nil {
+		log.WithError(err).Warn("Failed to stop retired VM")
+		return
+	}
+	log.Info("Stopped VM that ran its maximum number of jobs")
+}
+
+// handleRunnerCrashed records why the runner died and requeues the job it was running
+func (s *Scheduler) handleRunnerCrashed(vmID string, event *commands.EventNotification) {
+	vmErr := &redis.VMError{
+		Reason:  event.Data["reason"],
+		Message: event.Data["error"],
+		LogTail: truncateCrashLog(event.Data["log_tail"]),
+		At:      time.Now(),
+	}
+	vmErr.ExitCode, _ = strconv.Atoi(event.Data["exit_code"])
+
+	log := s.vmLog(vmID).WithFields(map[string]interface{}{
+		"event_type": event.Type,
+		"reason":     vmErr.Reason,
+		"exit_code":  vmErr.ExitCode,
+		"error":      vmErr.Message,
+	})
+	if vmErr.LogTail != "" {
+		log.WithField("log_tail", vmErr.LogTail).Debug("Runner output before crash")
+	}
+
+	// Handle runner crash - may need to reassign job
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err == nil && job != nil {
+		vmErr.JobID = job.ID
+		if vmErr.LogTail != "" {
+			job.CrashLog = vmErr.LogTail
+			if err := s.jobStore.Update(s.ctx, job); err != nil {
+				log.WithError(err).Warn("Failed to store crash log on job")
+			}
+		}
+	}
+
+	if err := s.vmStore.SetLastError(s.ctx, vmID, vmErr); err != nil {
+		log.WithError(err).Warn("Failed to record runner crash on VM")
+	}
+
+	if job != nil && job.Status == redis.JobStatusRunning {
+		// Attempt to requeue the job
+		if job.RetryCount < job.MaxRetries {
+			if err := s.jobStore.Requeue(s.ctx, job.ID); err != nil {
+				log.WithError(err).Warn("Failed to requeue job after crash")
 			} else {
-				s.jobStore.MarkFailed(s.ctx, job.ID, "runner crashed - max retries exceeded")
+				log.WithField("job_id", job.ID).Info("Job requeued after runner crash")
+			}
+		} else {
+			s.failExhaustedJob(job, fmt.Sprintf("runner crashed (%s) - max retries exceeded: %s", vmErr.Reason, vmErr.Message))
+		}
+	}
+	log.Warn("Runner crashed")
+}
+
+// CheckPendingCommand returns an error if a command queued while the VM was disconnected
+// is for a job no longer assigned to it. A failed send requeues the job, so without this
+// a reconnecting VM would register a runner for a job that may already run elsewhere
+func (s *Scheduler) CheckPendingCommand(vmID string, cmd *commands.Command) error {
+	if cmd.Type != "register_runner" && cmd.Type != "job_available" {
+		return nil
+	}
+	jobID := cmd.StringParams["job_id"]
+	if jobID == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+
+	job, err := s.jobStore.Get(ctx, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to load job %s: %w", jobID, err)
+	}
+	if job == nil {
+		return fmt.Errorf("job %s no longer exists", jobID)
+	}
+	if job.Status != redis.JobStatusAssigned || job.AssignedVMID != vmID {
+		return fmt.Errorf("job %s is %s on %q, not assigned to this VM", jobID, job.Status, job.AssignedVMID)
+	}
+	return nil
+}
+
+// HandleHeartbeat flags running jobs whose runner has stopped producing output
+// The flag is cleared again as soon as output resumes
+func (s *Scheduler) HandleHeartbeat(vmID string, heartbeat *commands.Heartbeat) {
+	timeout := s.cfg.Scheduler.StuckJobTimeout
+	if timeout <= 0 || heartbeat.CurrentJob == nil {
+		return
+	}
+
+	idle := time.Duration(heartbeat.CurrentJob.LogIdleSeconds) * time.Second
+	stuck := idle >= timeout
+
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err != nil || job == nil || job.Status != redis.JobStatusRunning || job.Stuck == stuck {
+		return
+	}
+
+	log := logger.WithJob(job.ID, s.cfg.Pool.ID).WithFields(map[string]interface{}{
+		"vm_id":    vmID,
+		"log_idle": idle.String(),
+	})
+	if stuck {
+		log.Warn("Job has produced no output for too long, it may be stuck")
+	} else {
+		log.Info("Stuck job is producing output again")
+	}
+
+	job.Stuck = stuck
+	if err := s.jobStore.Update(s.ctx, job); err != nil {
+		log.WithError(err).Warn("Failed to update job stuck flag")
+	}
+}
+
+// runnerVerifyInterval is how often the GitHub runners API is polled during verification
+const runnerVerifyInterval = 5 * time.Second
+
+// verifyRunner waits for a registered runner to show up on GitHub
+// If it never appears within RunnerVerifyTimeout the job is requeued and the VM recycled,
+// catching registrations that config.sh reported as successful but GitHub never saw
+func (s *Scheduler) verifyRunner(vmID, runnerName string) {
+	defer s.wg.Done()
+
+	log := s.vmLog(vmID).WithField("runner_name", runnerName)
+
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err != nil || job == nil {
+		log.Debug("No job assigned to VM, skipping runner verification")
+		return
+	}
+	log = log.WithField("job_id", job.ID)
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Scheduler.RunnerVerifyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(runnerVerifyInterval)
+	defer ticker.Stop()
+
+	for {
+		runner, err := s.tokenService.FindRunner(ctx, job.InstallationID, job.RepoFullName, false, runnerName)
+		if err != nil {
+			log.WithError(err).Debug("Runner lookup failed, will retry")
+		} else if runner != nil {
+			if current, err := s.jobStore.Get(s.ctx, job.ID); err == nil && current != nil {
+				current.RunnerID = runner.ID
+				if err := s.jobStore.Update(s.ctx, current); err != nil {
+					log.WithError(err).Warn("Failed to record runner ID on job")
+				}
+			}
+			log.WithFields(map[string]interface{}{
+				"runner_id": runner.ID,
+				"status":    runner.Status,
+			}).Info("Runner confirmed on GitHub")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			if s.ctx.Err() != nil {
+				return // Shutting down
 			}
+			s.handleUnverifiedRunner(vmID, job.ID)
+			return
+		case <-ticker.C:
 		}
-		log.Warn("Runner crashed")
 	}
 }
 
+// removeDeregisteredRunner finishes removing an unused runner MIGlet unregistered on drain or shutdown
+// MIGlet can only remove it from GitHub itself when it was given a remove token; otherwise the
+// controller deletes it through the API so it doesn't linger as "offline"
+func (s *Scheduler) removeDeregisteredRunner(vmID string, data map[string]string) {
+	defer s.wg.Done()
+
+	runnerName := data["runner_name"]
+	log := s.vmLog(vmID).WithField("runner_name", runnerName)
+
+	if err := s.vmStore.SetRunner(s.ctx, vmID, "", 0); err != nil {
+		log.WithError(err).Warn("Failed to clear runner on VM")
+	}
+
+	if data["removed"] == "true" {
+		log.Info("Unused runner removed from GitHub by MIGlet")
+		return
+	}
+
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err != nil || job == nil {
+		log.Warn("No job assigned to VM, cannot tell which repo the unused runner belongs to")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	runnerID, _ := strconv.ParseInt(data["runner_id"], 10, 64)
+	if runnerID == 0 {
+		runnerID = job.RunnerID
+	}
+	if runnerID == 0 && runnerName != "" {
+		runner, err := s.tokenService.FindRunner(ctx, job.InstallationID, job.RepoFullName, false, runnerName)
+		if err != nil {
+			log.WithError(err).Warn("Failed to look up unused runner")
+			return
+		}
+		if runner == nil {
+			log.Debug("Unused runner already gone from GitHub")
+			return
+		}
+		runnerID = runner.ID
+	}
+	if runnerID == 0 {
+		log.Warn("Unused runner has no known ID, leaving it in GitHub")
+		return
+	}
+
+	if err := s.tokenService.DeleteRunner(ctx, job.InstallationID, job.RepoFullName, false, runnerID); err != nil {
+		log.WithError(err).WithField("runner_id", runnerID).Warn("Failed to remove unused runner from GitHub")
+		return
+	}
+	log.WithField("runner_id", runnerID).Info("Unused runner removed from GitHub")
+}
+
+// handleUnverifiedRunner requeues a job whose runner never appeared on GitHub and recycles the VM
+func (s *Scheduler) handleUnverifiedRunner(vmID, jobID string) {
+	log := s.vmLog(vmID).WithField("job_id", jobID)
+
+	job, err := s.jobStore.Get(s.ctx, jobID)
+	if err != nil || job == nil {
+		return
+	}
+	if job.Status != redis.JobStatusAssigned {
+		// The job started (or finished) meanwhile, so the runner is evidently working
+		return
+	}
+
+	log.Error("Runner never appeared on GitHub")
+
+	if job.RetryCount < job.MaxRetries {
+		if err := s.jobStore.Requeue(s.ctx, job.ID); err != nil {
+			log.WithError(err).Warn("Failed to requeue job")
+		} else {
+			log.Info("Job requeued after runner verification failure")
+		}
+	} else {
+		s.failExhaustedJob(job, "runner never appeared on GitHub - max retries exceeded")
+	}
+
+	if err := s.vmManager.DrainAndRecycle(s.ctx, vmID, "scheduler"); err != nil {
+		log.WithError(err).Warn("Failed to recycle VM with unverified runner")
+	}
+}
+
+// handleVMStarted stores the machine specs MIGlet reports and checks they match the pool
+func (s *Scheduler) handleVMStarted(vmID string, event *commands.EventNotification) {
+	log := s.vmLog(vmID).WithField("event_type", event.Type)
+
+	spec := &redis.VMSpec{
+		MachineType: event.Data["machine_type"],
+		Region:      event.Data["region"],
+		Version:     event.Data["version"],
+	}
+	spec.CPU, _ = strconv.Atoi(event.Data["cpu"])
+	spec.MemoryMB, _ = strconv.Atoi(event.Data["memory_mb"])
+	spec.DiskGB, _ = strconv.Atoi(event.Data["disk_gb"])
+
+	log = log.WithFields(map[string]interface{}{
+		"machine_type": spec.MachineType,
+		"region":       spec.Region,
+		"cpu":          spec.CPU,
+		"memory_mb":    spec.MemoryMB,
+	})
+
+	if spec.Region != "" && s.cfg.Pool.Region != "" && spec.Region != s.cfg.Pool.Region {
+		log.WithField("pool_region", s.cfg.Pool.Region).Warn("VM is running outside the pool's region")
+	}
+
+	if err := s.vmStore.SetSpec(s.ctx, vmID, event.Data["zone"], spec); err != nil {
+		log.WithError(err).Warn("Failed to store VM spec")
+		return
+	}
+	log.Info("VM started")
+}
+
+// storedCrashLogMaxBytes caps the runner output stored on a job per crash
+// It is the controller's own limit: MIGlet caps what it sends at the same size, but
+// older or misbehaving agents may send more
+const storedCrashLogMaxBytes = 16 * 1024
+
+// truncateCrashLog keeps the last storedCrashLogMaxBytes of a crash log, starting on a
+// rune boundary so the stored JSON stays valid UTF-8
+func truncateCrashLog(tail string) string {
+	if len(tail) <= storedCrashLogMaxBytes {
+		return tail
+	}
+	cut := len(tail) - storedCrashLogMaxBytes
+	for cut < len(tail) && !utf8.RuneStart(tail[cut]) {
+		cut++
+	}
+	return tail[cut:]
+}
+
+// handleVMPreempted requeues the job a preempted Spot VM was holding
+// Unlike a runner crash the requeue doesn't use up a retry, and a job the MIGlet
+// reports as already finished is left for its job_completed event
+func (s *Scheduler) handleVMPreempted(vmID string, event *commands.EventNotification) {
+	log := s.vmLog(vmID).WithField("event_type", event.Type)
+	log.Warn("VM preempted")
+
+	if status, err := s.vmStore.Get(s.ctx, vmID); err == nil && status != nil {
+		if err := s.vmStore.UpdateFromInfra(s.ctx, vmID, status.Zone, redis.VMInfraStopping); err != nil {
+			log.WithError(err).Warn("Failed to mark preempted VM as stopping")
+		}
+	}
+
+	job, err := s.jobStore.GetByVM(s.ctx, vmID)
+	if err != nil || job == nil {
+		return
+	}
+	log = log.WithField("job_id", job.ID)
+
+	switch job.Status {
+	case redis.JobStatusAssigned:
+		// Never started on this VM
+	case redis.JobStatusRunning:
+		if event.Data["job_running"] == "false" {
+			log.Info("Job on preempted VM had already finished, not requeuing")
+			return
+		}
+	default:
+		return
+	}
+
+	if err := s.jobStore.RequeuePreempted(s.ctx, job.ID); err != nil {
+		log.WithError(err).Warn("Failed to requeue job from preempted VM")
+		return
+	}
+	log.Info("Job requeued after VM preemption")
+}
+
+// IsLeader reports whether this replica is currently running the scheduling loops
+func (s *Scheduler) IsLeader() bool {
+	return s.isLeader.Load()
+}
+
+// LeaderStatus returns leader election state for /stats
+func (s *Scheduler) LeaderStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"enabled":   s.leaderLock != nil,
+		"is_leader": s.IsLeader(),
+	}
+	if s.leaderLock != nil {
+		status["replica_id"] = s.leaderLock.ID()
+		if holder, err := s.leaderLock.Holder(s.ctx); err == nil {
+			status["leader_id"] = holder
+		}
+	}
+	return status
+}
+
 // GetStats returns scheduler statistics
 func (s *Scheduler) GetStats() map[string]interface{} {
 	queueLen, _ := s.jobStore.QueueLength(s.ctx)
+	delayedLen, _ := s.jobStore.DelayedLength(s.ctx)
+	claimedLen, _ := s.jobStore.ProcessingLength(s.ctx)
 	poolStats, _ := s.vmStore.GetStats(s.ctx)
+	jobsByStatus, _ := s.jobStore.CountByStatus(s.ctx)
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"queue_length":   queueLen,
+		"delayed_jobs":   delayedLen,
+		"claimed_jobs":   claimedLen,
 		"assigned_jobs":  s.assignedJobs,
 		"failed_jobs":    s.failedJobs,
+		"exhausted_jobs": s.exhaustedJobs.Load(),
+		"index_drift":    s.indexDrift.Load(),
+		"set_aside_vms":  s.setAsideVMs.Load(),
 		"started_vms":    s.startedVMs,
 		"created_vms":    s.createdVMs,
 		"connected_vms":  s.grpcServer.GetConnectionCount(),
 		"pool_stats":     poolStats,
+		"jobs_by_status": jobsByStatus,
+		"is_leader":      s.IsLeader(),
+	}
+	if s.cfg.Pool.MediatedDispatch() {
+		stats["dispatch"] = s.grants.stats()
 	}
+	return stats
 }
 
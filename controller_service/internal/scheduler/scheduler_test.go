@@ -0,0 +1,596 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/monkci/mig-controller/internal/config"
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/internal/token"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+const testPoolID = "pool-test"
+
+// fakeVMs is a VMController that records the VMs it is asked to start and recycle
+type fakeVMs struct {
+	mu       sync.Mutex
+	started  []string
+	recycled []string
+	scaled   int
+}
+
+func (f *fakeVMs) BreakerOpen() bool                               { return false }
+func (f *fakeVMs) RefreshVMList(ctx context.Context) error         { return nil }
+func (f *fakeVMs) StopVM(ctx context.Context, vmName string) error { return nil }
+func (f *fakeVMs) EnsureMinReadyVMs(ctx context.Context) error     { return nil }
+func (f *fakeVMs) CleanupIdleVMs(ctx context.Context) error        { return nil }
+func (f *fakeVMs) DeleteStoppedVMs(ctx context.Context) error      { return nil }
+func (f *fakeVMs) RecycleDegradedVMs(ctx context.Context) error    { return nil }
+
+func (f *fakeVMs) DrainAndRecycle(ctx context.Context, vmID, issuer string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recycled = append(f.recycled, vmID)
+	return nil
+}
+
+func (f *fakeVMs) ScaleUp(ctx context.Context, count int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scaled += count
+	return nil
+}
+
+func (f *fakeVMs) StartVM(ctx context.Context, vmName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, vmName)
+	return nil
+}
+
+// fakeMIGlets is a MIGletGateway that answers every command with ack, or fails it with err
+type fakeMIGlets struct {
+	mu     sync.Mutex
+	ack    *commands.CommandAck
+	err    error
+	states map[string]redis.MigletState
+	sent   []*commands.Command
+}
+
+func (f *fakeMIGlets) SendCommandAs(issuer, vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, cmd)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.ack, nil
+}
+
+func (f *fakeMIGlets) WaitForState(ctx context.Context, vmID string, targetState redis.MigletState, timeout time.Duration) error {
+	return nil
+}
+
+func (f *fakeMIGlets) GetMigletState(vmID string) (redis.MigletState, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.states[vmID]
+	return state, ok
+}
+
+func (f *fakeMIGlets) GetConnectionCount() int { return len(f.states) }
+func (f *fakeMIGlets) CloseStreams()           {}
+
+func (f *fakeMIGlets) ConnectionID(vmID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.states[vmID]; ok {
+		return "conn-" + vmID
+	}
+	return ""
+}
+
+// fakeTokens is a TokenProvider issuing a fixed registration token
+type fakeTokens struct{}
+
+func (fakeTokens) GetRegistrationToken(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool) (*token.RegistrationToken, error) {
+	return &token.RegistrationToken{Token: "reg-token"}, nil
+}
+
+func (fakeTokens) GetRunnerURL(repoOrOrg string, isOrg bool) string {
+	return "https://github.com/" + repoOrOrg
+}
+
+func (fakeTokens) FindRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, name string) (*token.Runner, error) {
+	return nil, nil
+}
+
+func (fakeTokens) DeleteRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, runnerID int64) error {
+	return nil
+}
+
+// testScheduler is a scheduler over in-memory stores and fakes
+type testScheduler struct {
+	*Scheduler
+	jobs    *redis.MemoryJobStore
+	vms     *redis.MemoryVMStatusStore
+	manager *fakeVMs
+	miglets *fakeMIGlets
+}
+
+func newTestScheduler(t *testing.T) *testScheduler {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Pool.ID = testPoolID
+	cfg.Pool.RunnerGroup = "Default"
+	cfg.Scheduler.ScanDepth = 10
+	cfg.Scheduler.AssignmentTimeout = time.Second
+	cfg.MIGlet.CommandTimeout = time.Second
+
+	ts := &testScheduler{
+		jobs:    redis.NewMemoryJobStore(testPoolID),
+		vms:     redis.NewMemoryVMStatusStore(testPoolID),
+		manager: &fakeVMs{},
+		miglets: &fakeMIGlets{
+			ack:    &commands.CommandAck{Success: true, Result: map[string]string{"runner_name": "vm-1", "runner_id": "42"}},
+			states: make(map[string]redis.MigletState),
+		},
+	}
+	ts.Scheduler = NewScheduler(cfg, ts.jobs, ts.vms, ts.manager, ts.miglets, fakeTokens{})
+	t.Cleanup(ts.Stop)
+	return ts
+}
+
+// addReadyVM tracks a running VM whose MIGlet reports ready
+func (ts *testScheduler) addReadyVM(t *testing.T, vmID string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := ts.vms.UpdateFromInfra(ctx, vmID, "us-central1-a", redis.VMInfraRunning); err != nil {
+		t.Fatalf("UpdateFromInfra: %v", err)
+	}
+	if err := ts.vms.SetMigletState(ctx, vmID, redis.MigletStateReady); err != nil {
+		t.Fatalf("SetMigletState: %v", err)
+	}
+}
+
+// enqueue queues a job with the given retry budget
+func (ts *testScheduler) enqueue(t *testing.T, jobID string, retryCount, maxRetries int) {
+	t.Helper()
+	job := &redis.Job{
+		ID:           jobID,
+		RepoFullName: "acme/app",
+		PoolID:       testPoolID,
+		RetryCount:   retryCount,
+		MaxRetries:   maxRetries,
+	}
+	if err := ts.jobs.Enqueue(context.Background(), job); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func (ts *testScheduler) job(t *testing.T, jobID string) *redis.Job {
+	t.Helper()
+	job, err := ts.jobs.Get(context.Background(), jobID)
+	if err != nil || job == nil {
+		t.Fatalf("Get(%s) = %v, %v", jobID, job, err)
+	}
+	return job
+}
+
+func TestProcessNextJobAssignsToReadyVM(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+
+	job := ts.job(t, "job-1")
+	if job.Status != redis.JobStatusAssigned || job.AssignedVMID != "vm-1" {
+		t.Fatalf("job status = %s on %q, want ASSIGNED on vm-1", job.Status, job.AssignedVMID)
+	}
+	if job.RunnerName != "vm-1" || job.RunnerID != 42 {
+		t.Errorf("job runner = %q/%d, want vm-1/42 from the ack", job.RunnerName, job.RunnerID)
+	}
+	if len(ts.miglets.sent) != 1 || ts.miglets.sent[0].Type != "register_runner" {
+		t.Fatalf("sent %v, want one register_runner", ts.miglets.sent)
+	}
+	if got := ts.miglets.sent[0].StringParams["registration_token"]; got != "reg-token" {
+		t.Errorf("registration_token = %q, want reg-token", got)
+	}
+	if got := ts.miglets.sent[0].StringArrayParams; len(got) != 1 || got[0] != "self-hosted" {
+		t.Errorf("runner labels = %v, want the pool's [self-hosted]", got)
+	}
+	if n, _ := ts.jobs.ProcessingLength(context.Background()); n != 0 {
+		t.Errorf("processing = %d after assignment, want 0", n)
+	}
+}
+
+func TestProcessNextJobStartsStoppedVM(t *testing.T) {
+	ts := newTestScheduler(t)
+	if err := ts.vms.UpdateFromInfra(context.Background(), "vm-stopped", "us-central1-a", redis.VMInfraStopped); err != nil {
+		t.Fatalf("UpdateFromInfra: %v", err)
+	}
+	ts.enqueue(t, "job-1", 0, 3)
+
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	if len(ts.manager.started) != 1 || ts.manager.started[0] != "vm-stopped" {
+		t.Fatalf("started %v, want [vm-stopped]", ts.manager.started)
+	}
+	if job := ts.job(t, "job-1"); job.AssignedVMID != "vm-stopped" {
+		t.Errorf("job assigned to %q, want vm-stopped", job.AssignedVMID)
+	}
+}
+
+func TestProcessNextJobRequeuesFailedAssignment(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	ts.miglets.ack = &commands.CommandAck{Success: false, Message: "busy"}
+
+	if err := ts.processNextJob(context.Background()); err == nil {
+		t.Fatal("processNextJob succeeded, want the rejected registration's error")
+	}
+
+	job := ts.job(t, "job-1")
+	if job.Status != redis.JobStatusQueued || job.RetryCount != 1 || job.AssignedVMID != "" {
+		t.Fatalf("job = %s retry %d on %q, want QUEUED retry 1 unassigned", job.Status, job.RetryCount, job.AssignedVMID)
+	}
+	if n, _ := ts.jobs.QueueLength(context.Background()); n != 1 {
+		t.Errorf("queue length = %d, want the job requeued", n)
+	}
+}
+
+func TestProcessNextJobFailsExhaustedJob(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 3, 3)
+	ts.miglets.err = errors.New("stream closed")
+
+	if err := ts.processNextJob(context.Background()); err == nil {
+		t.Fatal("processNextJob succeeded, want the send error")
+	}
+
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusFailed {
+		t.Fatalf("job status = %s, want FAILED", job.Status)
+	}
+	if got := ts.exhaustedJobs.Load(); got != 1 {
+		t.Errorf("exhausted jobs = %d, want 1", got)
+	}
+	if n, _ := ts.jobs.QueueLength(context.Background()); n != 0 {
+		t.Errorf("queue length = %d, want the exhausted job gone", n)
+	}
+}
+
+func TestProcessNextJobRequeuesUnsentJobWithoutRetry(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 3, 3)
+	ts.miglets.err = fmt.Errorf("failed to queue command: %w", redis.ErrPendingQueueFull)
+
+	if err := ts.processNextJob(context.Background()); err == nil {
+		t.Fatal("processNextJob succeeded, want the full queue error")
+	}
+
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusQueued || job.RetryCount != 3 {
+		t.Fatalf("job = %s retry %d, want QUEUED with its retry count unchanged", job.Status, job.RetryCount)
+	}
+	status, err := ts.vms.Get(context.Background(), "vm-1")
+	if err != nil || status == nil {
+		t.Fatalf("Get(vm-1) = %v, %v", status, err)
+	}
+	if status.MigletState != redis.MigletStateUnknown {
+		t.Errorf("miglet state = %s, want the VM set aside", status.MigletState)
+	}
+}
+
+func TestProcessNextJobKeepsLateRegistration(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	ts.miglets.err = grpcserver.ErrCommandTimeout
+	ts.miglets.states["vm-1"] = redis.MigletStateRegisteringRunner
+
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusAssigned || job.RetryCount != 0 {
+		t.Fatalf("job = %s retry %d, want ASSIGNED without a retry", job.Status, job.RetryCount)
+	}
+}
+
+func TestHandleMissingRegistrationRequeuesJob(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	ts.miglets.err = grpcserver.ErrCommandTimeout
+	ts.miglets.states["vm-1"] = redis.MigletStateRegisteringRunner
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+
+	ts.handleMissingRegistration("vm-1", "job-1")
+
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusQueued || job.RetryCount != 1 {
+		t.Fatalf("job = %s retry %d, want QUEUED retry 1", job.Status, job.RetryCount)
+	}
+	if len(ts.manager.recycled) != 1 || ts.manager.recycled[0] != "vm-1" {
+		t.Errorf("recycled = %v, want vm-1", ts.manager.recycled)
+	}
+}
+
+func TestHandleMissingRegistrationKeepsRegisteredRunner(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	ts.miglets.err = grpcserver.ErrCommandTimeout
+	ts.miglets.states["vm-1"] = redis.MigletStateRegisteringRunner
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	ts.HandleJobEvent("vm-1", &commands.EventNotification{
+		Type: "runner_registered",
+		Data: map[string]string{"runner_name": "vm-1", "runner_id": "42"},
+	})
+
+	ts.handleMissingRegistration("vm-1", "job-1")
+
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusAssigned || job.RunnerName != "vm-1" {
+		t.Fatalf("job = %s runner %q, want ASSIGNED to runner vm-1", job.Status, job.RunnerName)
+	}
+	if len(ts.manager.recycled) != 0 {
+		t.Errorf("recycled = %v, want none", ts.manager.recycled)
+	}
+}
+
+func TestHandleJobEventTracksJobLifecycle(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+
+	ts.HandleJobEvent("vm-1", &commands.EventNotification{
+		Type: "job_started",
+		Data: map[string]string{"job_id": "job-1"},
+	})
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusRunning {
+		t.Fatalf("after job_started status = %s, want RUNNING", job.Status)
+	}
+
+	ts.HandleJobEvent("vm-1", &commands.EventNotification{
+		Type: "job_completed",
+		Data: map[string]string{"job_id": "job-1", "success": "true"},
+	})
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusCompleted {
+		t.Fatalf("after job_completed status = %s, want COMPLETED", job.Status)
+	}
+}
+
+func TestHandleJobEventRecordsFailure(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+
+	ts.HandleJobEvent("vm-1", &commands.EventNotification{
+		Type: "job_completed",
+		Data: map[string]string{"job_id": "job-1", "success": "false", "error": "exit 1"},
+	})
+	job := ts.job(t, "job-1")
+	if job.Status != redis.JobStatusFailed || job.ErrorMessage != "exit 1" {
+		t.Fatalf("job = %s %q, want FAILED with the reported error", job.Status, job.ErrorMessage)
+	}
+}
+
+func TestHandleJobEventKeepsCancelledStatus(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	if err := ts.jobs.MarkCancelled(context.Background(), "job-1", "cancelled"); err != nil {
+		t.Fatalf("MarkCancelled: %v", err)
+	}
+
+	ts.HandleJobEvent("vm-1", &commands.EventNotification{
+		Type: "job_completed",
+		Data: map[string]string{"job_id": "job-1", "success": "false"},
+	})
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusCancelled {
+		t.Fatalf("job status = %s, want CANCELLED kept", job.Status)
+	}
+}
+
+func TestProcessNextJobScalesUpWithoutVMs(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.enqueue(t, "job-1", 0, 3)
+
+	if err := ts.processNextJob(context.Background()); err == nil {
+		t.Fatal("processNextJob succeeded, want the job left for the new VM")
+	}
+	if ts.manager.scaled != 1 {
+		t.Fatalf("scaled up by %d, want 1", ts.manager.scaled)
+	}
+	if job := ts.job(t, "job-1"); job.Status != redis.JobStatusQueued || job.RetryCount != 0 {
+		t.Fatalf("job = %s retry %d, want still QUEUED without a retry", job.Status, job.RetryCount)
+	}
+}
+
+// enqueueWithLabels queues a job that requires labels
+func (ts *testScheduler) enqueueWithLabels(t *testing.T, jobID string, labels ...string) {
+	t.Helper()
+	job := &redis.Job{
+		ID:           jobID,
+		RepoFullName: "acme/app",
+		Labels:       labels,
+		PoolID:       testPoolID,
+		MaxRetries:   3,
+	}
+	if err := ts.jobs.Enqueue(context.Background(), job); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func TestSchedulableJobsMatchesPoolLabels(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.cfg.Pool.Labels = []string{"self-hosted", "linux", "gpu"}
+	ts.cfg.Scheduler.UnschedulableTimeout = time.Hour
+	ts.enqueueWithLabels(t, "job-match", "Self-Hosted", "GPU") // GitHub compares labels case-insensitively
+	ts.enqueueWithLabels(t, "job-no-labels")
+	ts.enqueueWithLabels(t, "job-arm", "self-hosted", "arm64")
+
+	jobs := []*redis.Job{ts.job(t, "job-match"), ts.job(t, "job-no-labels"), ts.job(t, "job-arm")}
+	matched := ts.schedulableJobs(context.Background(), jobs)
+
+	if len(matched) != 2 || matched[0].ID != "job-match" || matched[1].ID != "job-no-labels" {
+		var ids []string
+		for _, job := range matched {
+			ids = append(ids, job.ID)
+		}
+		t.Fatalf("schedulable jobs = %v, want [job-match job-no-labels]", ids)
+	}
+	// An unmatched job is left queued until unschedulable_timeout, in case the pool labels are fixed
+	if job := ts.job(t, "job-arm"); job.Status != redis.JobStatusQueued {
+		t.Errorf("unmatched job status = %s, want QUEUED", job.Status)
+	}
+}
+
+func TestSchedulableJobsFailsUnmatchedJobAfterTimeout(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.cfg.Scheduler.UnschedulableTimeout = time.Hour
+	ts.enqueueWithLabels(t, "job-arm", "self-hosted", "arm64")
+
+	job := ts.job(t, "job-arm")
+	job.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if matched := ts.schedulableJobs(context.Background(), []*redis.Job{job}); len(matched) != 0 {
+		t.Fatalf("schedulable jobs = %d, want none", len(matched))
+	}
+
+	failed := ts.job(t, "job-arm")
+	if failed.Status != redis.JobStatusFailed || !strings.Contains(failed.ErrorMessage, "arm64") {
+		t.Errorf("job = %s %q, want FAILED naming the missing label", failed.Status, failed.ErrorMessage)
+	}
+	if n, _ := ts.jobs.QueueLength(context.Background()); n != 0 {
+		t.Errorf("queue length = %d, want the failed job dequeued", n)
+	}
+}
+
+func TestProcessNextJobSkipsJobWithUnmatchedLabels(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.cfg.Scheduler.UnschedulableTimeout = time.Hour
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueueWithLabels(t, "job-arm", "arm64")
+	ts.enqueueWithLabels(t, "job-linux", "self-hosted")
+
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	if job := ts.job(t, "job-linux"); job.Status != redis.JobStatusAssigned || job.AssignedVMID != "vm-1" {
+		t.Errorf("job-linux = %s on %q, want ASSIGNED on vm-1 ahead of the unmatched job", job.Status, job.AssignedVMID)
+	}
+	if job := ts.job(t, "job-arm"); job.Status != redis.JobStatusQueued {
+		t.Errorf("job-arm = %s, want still QUEUED", job.Status)
+	}
+}
+
+func TestResolveRunnerGroup(t *testing.T) {
+	ts := newTestScheduler(t) // Pool runner group "Default"
+
+	cases := []struct {
+		name     string
+		jobGroup string
+		want     string
+		wantErr  bool
+	}{
+		{name: "falls back to the pool group", jobGroup: "", want: "Default"},
+		{name: "job group wins", jobGroup: "gpu-runners", want: "gpu-runners"},
+		{name: "invalid job group", jobGroup: "team/gpu", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ts.resolveRunnerGroup(&redis.Job{ID: "job-1", RunnerGroup: tc.jobGroup})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("resolveRunnerGroup(%q) error = %v, want error %v", tc.jobGroup, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("resolveRunnerGroup(%q) = %q, want %q", tc.jobGroup, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProcessNextJobRegistersRunnerInPoolGroup(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3) // No runner group of its own
+
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	if len(ts.miglets.sent) != 1 {
+		t.Fatalf("sent %d commands, want one register_runner", len(ts.miglets.sent))
+	}
+	if got := ts.miglets.sent[0].StringParams["runner_group"]; got != "Default" {
+		t.Errorf("runner_group = %q, want the pool's Default", got)
+	}
+}
+
+func TestCheckPendingCommandDropsRequeuedJob(t *testing.T) {
+	ts := newTestScheduler(t)
+	ts.addReadyVM(t, "vm-1")
+	ts.enqueue(t, "job-1", 0, 3)
+	if err := ts.processNextJob(context.Background()); err != nil {
+		t.Fatalf("processNextJob: %v", err)
+	}
+	register := &commands.Command{Type: "register_runner", StringParams: map[string]string{"job_id": "job-1"}}
+
+	if err := ts.CheckPendingCommand("vm-1", register); err != nil {
+		t.Fatalf("CheckPendingCommand on the assigned VM = %v, want nil", err)
+	}
+	if err := ts.CheckPendingCommand("vm-2", register); err == nil {
+		t.Fatal("CheckPendingCommand on another VM = nil, want an error")
+	}
+
+	if err := ts.jobs.Requeue(context.Background(), "job-1"); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if err := ts.CheckPendingCommand("vm-1", register); err == nil {
+		t.Fatal("CheckPendingCommand for a requeued job = nil, want an error")
+	}
+	if err := ts.CheckPendingCommand("vm-1", &commands.Command{Type: "drain"}); err != nil {
+		t.Fatalf("CheckPendingCommand for drain = %v, want nil", err)
+	}
+}
+
+func TestTruncateCrashLogCutsOnRuneBoundary(t *testing.T) {
+	tail := strings.Repeat("€", 6000) // 3 bytes each, so a plain byte cut splits a rune
+
+	got := truncateCrashLog(tail)
+	if len(got) > storedCrashLogMaxBytes {
+		t.Fatalf("len(truncateCrashLog) = %d, want at most %d", len(got), storedCrashLogMaxBytes)
+	}
+	if !utf8.ValidString(got) {
+		t.Fatal("truncateCrashLog result is not valid UTF-8")
+	}
+	if short := "short log"; truncateCrashLog(short) != short {
+		t.Fatal("truncateCrashLog changed a log under the limit")
+	}
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/redis"
+)
+
+// JobQueue is the job persistence the scheduler uses
+// *redis.JobStore implements it against Redis, and *redis.MemoryJobStore in memory for tests
+type JobQueue interface {
+	Get(ctx context.Context, jobID string) (*redis.Job, error)
+	Update(ctx context.Context, job *redis.Job) error
+	GetByVM(ctx context.Context, vmID string) (*redis.Job, error)
+
+	PeekN(ctx context.Context, n int) ([]*redis.Job, error)
+	ClaimJob(ctx context.Context, jobID string) (*redis.Job, error)
+	ReapExpiredClaims(ctx context.Context, leaseTTL time.Duration) (int, error)
+	PromoteDelayedJobs(ctx context.Context) (int, error)
+	AgeQueuedJobs(ctx context.Context, threshold, interval time.Duration) (int, error)
+
+	AssignToVM(ctx context.Context, jobID, vmID string) error
+	SetRunner(ctx context.Context, jobID, runnerName string, runnerID int64) error
+	MarkRunning(ctx context.Context, jobID string) error
+	MarkCompleted(ctx context.Context, jobID string) error
+	MarkFailed(ctx context.Context, jobID, errorMsg string) error
+	MarkCancelled(ctx context.Context, jobID, reason string) error
+	Requeue(ctx context.Context, jobID string) error
+	RequeuePreempted(ctx context.Context, jobID string) error
+	RequeueUnsent(ctx context.Context, jobID string) error
+
+	QueueLength(ctx context.Context) (int64, error)
+	DelayedLength(ctx context.Context) (int64, error)
+	ProcessingLength(ctx context.Context) (int64, error)
+	CountByStatus(ctx context.Context) (map[redis.JobStatus]int64, error)
+}
+
+// VMStateStore is the VM status persistence the scheduler uses
+// *redis.VMStatusStore implements it against Redis, and *redis.MemoryVMStatusStore in memory for tests
+type VMStateStore interface {
+	Get(ctx context.Context, vmID string) (*redis.VMStatus, error)
+	GetFirstReady(ctx context.Context, orgID string) (*redis.VMStatus, error)
+	GetFirstIdleRunner(ctx context.Context, orgID string) (*redis.VMStatus, error)
+	GetFirstStopped(ctx context.Context, orgID string) (*redis.VMStatus, error)
+	GetStats(ctx context.Context) (*redis.PoolStats, error)
+
+	UpdateFromInfra(ctx context.Context, vmID, zone string, infraState redis.VMInfraState) error
+	SetSpec(ctx context.Context, vmID, zone string, spec *redis.VMSpec) error
+	SetLastError(ctx context.Context, vmID string, vmErr *redis.VMError) error
+	SetMigletState(ctx context.Context, vmID string, state redis.MigletState) error
+	SetOrg(ctx context.Context, vmID, orgID string) error
+	SetRunner(ctx context.Context, vmID, runnerName string, runnerID int64) error
+	ReconcileStateIndex(ctx context.Context) (int, error)
+}
+
+// The Redis stores are used in production and the in-memory ones in tests
+var (
+	_ JobQueue     = (*redis.JobStore)(nil)
+	_ JobQueue     = (*redis.MemoryJobStore)(nil)
+	_ VMStateStore = (*redis.VMStatusStore)(nil)
+	_ VMStateStore = (*redis.MemoryVMStatusStore)(nil)
+)
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,17 +32,31 @@ type InstallationToken struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// defaultAPIBaseURL is the github.com REST API endpoint
+const defaultAPIBaseURL = "https://api.github.com"
+
 // Service handles GitHub App authentication and token generation
 type Service struct {
-	appID      int64
-	privateKey *rsa.PrivateKey
-	httpClient *http.Client
+	appID        int64
+	privateKey   *rsa.PrivateKey
+	httpClient   *http.Client
+	maxRetries   int           // Retries after a rate-limited response
+	maxRetryWait time.Duration // Longest single wait for a rate limit reset
+	apiBaseURL   string        // REST API base, e.g. https://api.github.com or https://ghe.example.com/api/v3
+	htmlBaseURL  string        // Web base used for runner URLs, e.g. https://github.com or https://ghe.example.com
 
 	// Cache for installation tokens
 	tokenCache     map[int64]*InstallationToken
 	tokenCacheLock sync.RWMutex
+
+	// Cache for runner registration tokens, keyed by installation + repo/org
+	regTokenCache     map[string]*RegistrationToken
+	regTokenCacheLock sync.RWMutex
 }
 
+// regTokenMinValidity is the remaining lifetime below which a cached registration token is refreshed
+const regTokenMinValidity = 10 * time.Minute
+
 // NewService creates a new token service
 func NewService(cfg *config.GitHubAppConfig) (*Service, error) {
 	log := logger.WithComponent("token_service")
@@ -65,16 +82,41 @@ func NewService(cfg *config.GitHubAppConfig) (*Service, error) {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
 
-	log.WithField("app_id", cfg.AppID).Info("Token service initialized")
+	apiBaseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if apiBaseURL == "" {
+		apiBaseURL = defaultAPIBaseURL
+	}
+	htmlBaseURL := HTMLBaseURL(apiBaseURL)
+
+	log.WithFields(map[string]interface{}{
+		"app_id":   cfg.AppID,
+		"base_url": apiBaseURL,
+	}).Info("Token service initialized")
 
 	return &Service{
-		appID:      cfg.AppID,
-		privateKey: privateKey,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		tokenCache: make(map[int64]*InstallationToken),
+		appID:         cfg.AppID,
+		privateKey:    privateKey,
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		maxRetries:    cfg.MaxRetries,
+		maxRetryWait:  cfg.MaxRetryWait,
+		apiBaseURL:    apiBaseURL,
+		htmlBaseURL:   htmlBaseURL,
+		tokenCache:    make(map[int64]*InstallationToken),
+		regTokenCache: make(map[string]*RegistrationToken),
 	}, nil
 }
 
+// HTMLBaseURL derives the web base URL from a REST API base URL
+// https://api.github.com maps to https://github.com; a GitHub Enterprise
+// Server API base (https://HOST/api/v3) maps to https://HOST
+func HTMLBaseURL(apiBaseURL string) string {
+	apiBaseURL = strings.TrimSuffix(apiBaseURL, "/")
+	if apiBaseURL == "" || apiBaseURL == defaultAPIBaseURL {
+		return "https://github.com"
+	}
+	return strings.TrimSuffix(apiBaseURL, "/api/v3")
+}
+
 // GetRegistrationToken generates a runner registration token
 func (s *Service) GetRegistrationToken(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool) (*RegistrationToken, error) {
 	log := logger.WithComponent("token_service").WithFields(map[string]interface{}{
@@ -83,6 +125,17 @@ func (s *Service) GetRegistrationToken(ctx context.Context, installationID int64
 		"is_org":          isOrg,
 	})
 
+	// Check cache first (the registration-token endpoint is rate-limited per installation)
+	cacheKey := fmt.Sprintf("%d:%t:%s", installationID, isOrg, repoOrOrg)
+	s.regTokenCacheLock.RLock()
+	cached, exists := s.regTokenCache[cacheKey]
+	s.regTokenCacheLock.RUnlock()
+
+	if exists && time.Until(cached.ExpiresAt) > regTokenMinValidity {
+		log.Debug("Using cached registration token")
+		return cached, nil
+	}
+
 	// Get installation access token
 	accessToken, err := s.getInstallationToken(ctx, installationID)
 	if err != nil {
@@ -92,21 +145,12 @@ func (s *Service) GetRegistrationToken(ctx context.Context, installationID int64
 	// Create registration token
 	var url string
 	if isOrg {
-		url = fmt.Sprintf("https://api.github.com/orgs/%s/actions/runners/registration-token", repoOrOrg)
+		url = fmt.Sprintf("%s/orgs/%s/actions/runners/registration-token", s.apiBaseURL, repoOrOrg)
 	} else {
-		url = fmt.Sprintf("https://api.github.com/repos/%s/actions/runners/registration-token", repoOrOrg)
+		url = fmt.Sprintf("%s/repos/%s/actions/runners/registration-token", s.apiBaseURL, repoOrOrg)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+accessToken.Token)
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.post(ctx, url, accessToken.Token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to request registration token: %w", err)
 	}
@@ -130,10 +174,17 @@ func (s *Service) GetRegistrationToken(ctx context.Context, installationID int64
 
 	log.Info("Registration token created successfully")
 
-	return &RegistrationToken{
+	token := &RegistrationToken{
 		Token:     tokenResp.Token,
 		ExpiresAt: expiresAt,
-	}, nil
+	}
+
+	// Cache the token
+	s.regTokenCacheLock.Lock()
+	s.regTokenCache[cacheKey] = token
+	s.regTokenCacheLock.Unlock()
+
+	return token, nil
 }
 
 // getInstallationToken gets or refreshes an installation access token
@@ -153,17 +204,8 @@ func (s *Service) getInstallationToken(ctx context.Context, installationID int64
 		return nil, fmt.Errorf("failed to generate JWT: %w", err)
 	}
 
-	url := fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", installationID)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+jwt)
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-
-	resp, err := s.httpClient.Do(req)
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", s.apiBaseURL, installationID)
+	resp, err := s.post(ctx, url, jwt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to request installation token: %w", err)
 	}
@@ -198,6 +240,165 @@ func (s *Service) getInstallationToken(ctx context.Context, installationID int64
 	return token, nil
 }
 
+// Runner is a self-hosted runner as listed by the GitHub API
+type Runner struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"` // "online" or "offline"
+	Busy   bool   `json:"busy"`
+}
+
+// FindRunner looks up a self-hosted runner by name on a repo or org
+// Returns nil if no runner with that name is registered
+func (s *Service) FindRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, name string) (*Runner, error) {
+	accessToken, err := s.getInstallationToken(ctx, installationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installation token: %w", err)
+	}
+
+	scope := "repos"
+	if isOrg {
+		scope = "orgs"
+	}
+	url := fmt.Sprintf("%s/%s/%s/actions/runners?name=%s", s.apiBaseURL, scope, repoOrOrg, neturl.QueryEscape(name))
+
+	resp, err := s.do(ctx, http.MethodGet, url, accessToken.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runners: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list runners: %s - %s", resp.Status, string(body))
+	}
+
+	var listResp struct {
+		Runners []*Runner `json:"runners"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	for _, runner := range listResp.Runners {
+		if runner.Name == name {
+			return runner, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteRunner removes a self-hosted runner from a repo or org
+// A runner that is already gone is not an error
+func (s *Service) DeleteRunner(ctx context.Context, installationID int64, repoOrOrg string, isOrg bool, runnerID int64) error {
+	accessToken, err := s.getInstallationToken(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("failed to get installation token: %w", err)
+	}
+
+	scope := "repos"
+	if isOrg {
+		scope = "orgs"
+	}
+	url := fmt.Sprintf("%s/%s/%s/actions/runners/%d", s.apiBaseURL, scope, repoOrOrg, runnerID)
+
+	resp, err := s.do(ctx, http.MethodDelete, url, accessToken.Token)
+	if err != nil {
+		return fmt.Errorf("failed to delete runner: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete runner: %s - %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+// post sends an authenticated POST to the GitHub API, waiting out rate limits
+func (s *Service) post(ctx context.Context, url, bearer string) (*http.Response, error) {
+	return s.do(ctx, http.MethodPost, url, bearer)
+}
+
+// do sends an authenticated request to the GitHub API, waiting out rate limits
+// A rate-limited response is retried up to maxRetries times; once retries are exhausted
+// (or the wait would overrun the context deadline) the last response is returned to the caller
+func (s *Service) do(ctx context.Context, method, url, bearer string) (*http.Response, error) {
+	log := logger.WithComponent("token_service").WithField("url", url)
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		wait, limited := rateLimitWait(resp, time.Now())
+		if !limited || attempt >= s.maxRetries {
+			return resp, nil
+		}
+
+		if s.maxRetryWait > 0 && wait > s.maxRetryWait {
+			wait = s.maxRetryWait
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		log.WithFields(map[string]interface{}{
+			"status":  resp.StatusCode,
+			"attempt": attempt + 1,
+			"wait":    wait,
+		}).Warn("GitHub API rate limited, waiting before retry")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// rateLimitWait reports whether resp is a rate limit response and how long to wait
+// It honors Retry-After (seconds) and X-RateLimit-Reset (epoch seconds, when the
+// remaining quota is 0). A 429 without either header waits one minute, as GitHub recommends
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusForbidden {
+		return 0, false
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			wait := time.Unix(reset, 0).Sub(now)
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+	}
+
+	// A plain 403 is a permission error, not a rate limit
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return time.Minute, true
+	}
+	return 0, false
+}
+
 // generateAppJWT generates a JWT for GitHub App authentication
 func (s *Service) generateAppJWT() (string, error) {
 	now := time.Now()
@@ -213,10 +414,7 @@ func (s *Service) generateAppJWT() (string, error) {
 }
 
 // GetRunnerURL returns the URL for runner registration
-func GetRunnerURL(repoOrOrg string, isOrg bool) string {
-	if isOrg {
-		return fmt.Sprintf("https://github.com/%s", repoOrOrg)
-	}
-	return fmt.Sprintf("https://github.com/%s", repoOrOrg)
+// Org and repo runners share the same shape: <html base>/<org> or <html base>/<owner>/<repo>
+func (s *Service) GetRunnerURL(repoOrOrg string, isOrg bool) string {
+	return fmt.Sprintf("%s/%s", s.htmlBaseURL, repoOrOrg)
 }
-
@@ -0,0 +1,244 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// testKeyPEM returns a freshly generated RSA private key in PEM form
+func testKeyPEM(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+// newTestService returns a service talking to the GitHub API at baseURL
+func newTestService(t *testing.T, baseURL string, maxRetries int) *Service {
+	t.Helper()
+	s, err := NewService(&config.GitHubAppConfig{
+		AppID:        1,
+		PrivateKey:   testKeyPEM(t),
+		BaseURL:      baseURL,
+		MaxRetries:   maxRetries,
+		MaxRetryWait: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return s
+}
+
+// writeToken answers with a 201 and a token valid for an hour
+func writeToken(w http.ResponseWriter, token string) {
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"token":      token,
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+}
+
+// newGitHubStub serves installation tokens, and answers registration token requests with
+// limit's response (headers set and a status returned) until it returns 0, then a 201
+func newGitHubStub(t *testing.T, limit func(w http.ResponseWriter, attempt int32) int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var attempts atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/1/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		writeToken(w, "installation-token")
+	})
+	mux.HandleFunc("/repos/acme/app/actions/runners/registration-token", func(w http.ResponseWriter, r *http.Request) {
+		attempt := attempts.Add(1)
+		if status := limit(w, attempt); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		writeToken(w, "reg-token")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &attempts
+}
+
+func TestGetRegistrationTokenRetriesAfter429(t *testing.T) {
+	srv, attempts := newGitHubStub(t, func(w http.ResponseWriter, attempt int32) int {
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "1")
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+	s := newTestService(t, srv.URL, 3)
+
+	start := time.Now()
+	token, err := s.GetRegistrationToken(context.Background(), 1, "acme/app", false)
+	if err != nil {
+		t.Fatalf("GetRegistrationToken: %v", err)
+	}
+	if token.Token != "reg-token" {
+		t.Errorf("token = %q, want reg-token", token.Token)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want Retry-After's 1s honoured", elapsed)
+	}
+}
+
+func TestGetRegistrationTokenWaitsForRateLimitReset(t *testing.T) {
+	srv, attempts := newGitHubStub(t, func(w http.ResponseWriter, attempt int32) int {
+		if attempt == 1 {
+			// A 403 with no quota left is GitHub's primary rate limit response
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Second).Unix()+1, 10))
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	s := newTestService(t, srv.URL, 3)
+
+	start := time.Now()
+	if _, err := s.GetRegistrationToken(context.Background(), 1, "acme/app", false); err != nil {
+		t.Fatalf("GetRegistrationToken: %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want to wait for X-RateLimit-Reset", elapsed)
+	}
+}
+
+func TestGetRegistrationTokenFailsOnPlain403(t *testing.T) {
+	srv, attempts := newGitHubStub(t, func(w http.ResponseWriter, attempt int32) int {
+		return http.StatusForbidden
+	})
+	s := newTestService(t, srv.URL, 3)
+
+	if _, err := s.GetRegistrationToken(context.Background(), 1, "acme/app", false); err == nil {
+		t.Fatal("GetRegistrationToken succeeded on a 403")
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want a permission error not to be retried", got)
+	}
+}
+
+func TestGetRegistrationTokenGivesUpAfterMaxRetries(t *testing.T) {
+	srv, attempts := newGitHubStub(t, func(w http.ResponseWriter, attempt int32) int {
+		w.Header().Set("Retry-After", "0")
+		return http.StatusTooManyRequests
+	})
+	s := newTestService(t, srv.URL, 2)
+
+	if _, err := s.GetRegistrationToken(context.Background(), 1, "acme/app", false); err == nil {
+		t.Fatal("GetRegistrationToken succeeded while rate limited")
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want the first try plus 2 retries", got)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	reset := strconv.FormatInt(now.Add(45*time.Second).Unix(), 10)
+	past := strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name        string
+		status      int
+		headers     map[string]string
+		wantWait    time.Duration
+		wantLimited bool
+	}{
+		{"success", http.StatusCreated, nil, 0, false},
+		{"429 with Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}, 30 * time.Second, true},
+		{"429 without headers", http.StatusTooManyRequests, nil, time.Minute, true},
+		{"429 with reset", http.StatusTooManyRequests, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset}, 45 * time.Second, true},
+		{"403 with no quota left", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset}, 45 * time.Second, true},
+		{"403 with Retry-After", http.StatusForbidden, map[string]string{"Retry-After": "10"}, 10 * time.Second, true},
+		{"403 with reset in the past", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": past}, 0, true},
+		{"403 with quota left", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "12", "X-RateLimit-Reset": reset}, 0, false},
+		{"plain 403", http.StatusForbidden, nil, 0, false},
+		{"invalid Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "soon"}, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+			wait, limited := rateLimitWait(resp, now)
+			if wait != tt.wantWait || limited != tt.wantLimited {
+				t.Errorf("rateLimitWait = %v, %v, want %v, %v", wait, limited, tt.wantWait, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func TestNewServiceBaseURLs(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseURL    string
+		wantAPI    string
+		wantHTML   string
+		wantOrgURL string
+	}{
+		{"default", "", "https://api.github.com", "https://github.com", "https://github.com/acme"},
+		{"github.com with trailing slash", "https://api.github.com/", "https://api.github.com", "https://github.com", "https://github.com/acme"},
+		{"enterprise", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/v3", "https://ghe.example.com", "https://ghe.example.com/acme"},
+		{"enterprise with trailing slash", "https://ghe.example.com/api/v3/", "https://ghe.example.com/api/v3", "https://ghe.example.com", "https://ghe.example.com/acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, tt.baseURL, 0)
+			if s.apiBaseURL != tt.wantAPI {
+				t.Errorf("API base = %q, want %q", s.apiBaseURL, tt.wantAPI)
+			}
+			if s.htmlBaseURL != tt.wantHTML {
+				t.Errorf("HTML base = %q, want %q", s.htmlBaseURL, tt.wantHTML)
+			}
+			if got := s.GetRunnerURL("acme", true); got != tt.wantOrgURL {
+				t.Errorf("org runner URL = %q, want %q", got, tt.wantOrgURL)
+			}
+			if got, want := s.GetRunnerURL("acme/app", false), tt.wantHTML+"/acme/app"; got != want {
+				t.Errorf("repo runner URL = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestsUseEnterpriseBaseURL(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		writeToken(w, "token")
+	}))
+	defer srv.Close()
+	s := newTestService(t, srv.URL+"/api/v3/", 0)
+
+	if _, err := s.GetRegistrationToken(context.Background(), 1, "acme", true); err != nil {
+		t.Fatalf("GetRegistrationToken: %v", err)
+	}
+	want := []string{
+		"/api/v3/app/installations/1/access_tokens",
+		"/api/v3/orgs/acme/actions/runners/registration-token",
+	}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("requested %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// ErrCircuitOpen is returned (wrapped) without calling GCP while the circuit breaker is open
+var ErrCircuitOpen = errors.New("GCP circuit breaker open")
+
+// Breaker states
+const (
+	breakerClosed   = "closed"    // Calls go through
+	breakerOpen     = "open"      // Calls fail fast until the cooldown passes
+	breakerHalfOpen = "half_open" // One probe call is in flight; its outcome closes or reopens
+)
+
+// circuitBreaker stops calling GCP after consecutive failures, so a throttling or failing
+// API isn't hammered every maintenance tick. After cooldown a single probe call is let
+// through; success closes the breaker, failure reopens it
+type circuitBreaker struct {
+	threshold int // Consecutive failures that open the breaker; 0 disables it
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int // Consecutive failures
+	openedAt time.Time
+	opens    int64 // Times the breaker has opened
+	lastErr  error
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// allow returns nil if a call may proceed
+// Once the cooldown has passed the first caller becomes the half-open probe
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w after %d consecutive failures (last: %v)", ErrCircuitOpen, b.failures, b.lastErr)
+		}
+		b.state = breakerHalfOpen
+		logger.WithComponent("vm_manager").Info("GCP circuit breaker half-open, probing")
+		return nil
+	case breakerHalfOpen:
+		return fmt.Errorf("%w: probe in progress", ErrCircuitOpen)
+	}
+	return nil
+}
+
+// record feeds a call's outcome back to the breaker
+// Failures caused by the caller giving up (ctx done) aren't counted against GCP
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen // Inconclusive probe; the next call probes again
+		}
+		return
+	}
+
+	log := logger.WithComponent("vm_manager")
+
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Info("GCP circuit breaker closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.opens++
+		log.WithError(err).WithFields(map[string]interface{}{
+			"failures": b.failures,
+			"cooldown": b.cooldown.String(),
+		}).Warn("GCP circuit breaker opened")
+	}
+}
+
+// stats returns the breaker state for GetStats
+func (b *circuitBreaker) stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"state":    b.state,
+		"open":     b.state != breakerClosed,
+		"failures": b.failures,
+		"opens":    b.opens,
+	}
+}
+
+// isOpen reports whether calls are currently being short-circuited
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+// callGCP runs fn through the circuit breaker
+func (m *Manager) callGCP(ctx context.Context, fn func() error) error {
+	if err := m.breaker.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	m.breaker.record(ctx, err)
+	return err
+}
+
+// BreakerOpen reports whether GCP calls are being short-circuited
+func (m *Manager) BreakerOpen() bool {
+	return m.breaker.isOpen()
+}
@@ -0,0 +1,142 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/iterator"
+)
+
+// Operation is a long-running GCP operation
+type Operation interface {
+	// Wait blocks until the operation is done or ctx ends
+	Wait(ctx context.Context) error
+	// Proto returns the operation as last polled, including any errors it recorded
+	Proto() *computepb.Operation
+}
+
+// InstancesAPI is the subset of the GCE instances API the manager uses
+type InstancesAPI interface {
+	Start(ctx context.Context, req *computepb.StartInstanceRequest) (Operation, error)
+	Stop(ctx context.Context, req *computepb.StopInstanceRequest) (Operation, error)
+	Close() error
+}
+
+// InstanceGroupManagersAPI is the subset of the GCE managed instance group API the manager uses
+// List calls return every page
+type InstanceGroupManagersAPI interface {
+	Get(ctx context.Context, req *computepb.GetInstanceGroupManagerRequest) (*computepb.InstanceGroupManager, error)
+	Resize(ctx context.Context, req *computepb.ResizeInstanceGroupManagerRequest) (Operation, error)
+	DeleteInstances(ctx context.Context, req *computepb.DeleteInstancesInstanceGroupManagerRequest) (Operation, error)
+	ListManagedInstances(ctx context.Context, req *computepb.ListManagedInstancesInstanceGroupManagersRequest) ([]*computepb.ManagedInstance, error)
+	ListErrors(ctx context.Context, req *computepb.ListErrorsInstanceGroupManagersRequest) ([]*computepb.InstanceManagedByIgmError, error)
+	Close() error
+}
+
+// restInstances implements InstancesAPI with the GCE REST client
+type restInstances struct {
+	client *compute.InstancesClient
+}
+
+// NewRESTInstances creates the default InstancesAPI, backed by the GCE REST API
+func NewRESTInstances(ctx context.Context) (InstancesAPI, error) {
+	client, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %w", err)
+	}
+	return &restInstances{client: client}, nil
+}
+
+func (r *restInstances) Start(ctx context.Context, req *computepb.StartInstanceRequest) (Operation, error) {
+	return wrapOperation(r.client.Start(ctx, req))
+}
+
+func (r *restInstances) Stop(ctx context.Context, req *computepb.StopInstanceRequest) (Operation, error) {
+	return wrapOperation(r.client.Stop(ctx, req))
+}
+
+func (r *restInstances) Close() error {
+	return r.client.Close()
+}
+
+// restInstanceGroupManagers implements InstanceGroupManagersAPI with the GCE REST client
+type restInstanceGroupManagers struct {
+	client *compute.InstanceGroupManagersClient
+}
+
+// NewRESTInstanceGroupManagers creates the default InstanceGroupManagersAPI, backed by the GCE REST API
+func NewRESTInstanceGroupManagers(ctx context.Context) (InstanceGroupManagersAPI, error) {
+	client, err := compute.NewInstanceGroupManagersRESTClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MIG client: %w", err)
+	}
+	return &restInstanceGroupManagers{client: client}, nil
+}
+
+func (r *restInstanceGroupManagers) Get(ctx context.Context, req *computepb.GetInstanceGroupManagerRequest) (*computepb.InstanceGroupManager, error) {
+	return r.client.Get(ctx, req)
+}
+
+func (r *restInstanceGroupManagers) Resize(ctx context.Context, req *computepb.ResizeInstanceGroupManagerRequest) (Operation, error) {
+	return wrapOperation(r.client.Resize(ctx, req))
+}
+
+func (r *restInstanceGroupManagers) DeleteInstances(ctx context.Context, req *computepb.DeleteInstancesInstanceGroupManagerRequest) (Operation, error) {
+	return wrapOperation(r.client.DeleteInstances(ctx, req))
+}
+
+func (r *restInstanceGroupManagers) ListManagedInstances(ctx context.Context, req *computepb.ListManagedInstancesInstanceGroupManagersRequest) ([]*computepb.ManagedInstance, error) {
+	var instances []*computepb.ManagedInstance
+	it := r.client.ListManagedInstances(ctx, req)
+	for {
+		inst, err := it.Next()
+		if err == iterator.Done {
+			return instances, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+}
+
+func (r *restInstanceGroupManagers) ListErrors(ctx context.Context, req *computepb.ListErrorsInstanceGroupManagersRequest) ([]*computepb.InstanceManagedByIgmError, error) {
+	var igmErrors []*computepb.InstanceManagedByIgmError
+	it := r.client.ListErrors(ctx, req)
+	for {
+		igmErr, err := it.Next()
+		if err == iterator.Done {
+			return igmErrors, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		igmErrors = append(igmErrors, igmErr)
+	}
+}
+
+func (r *restInstanceGroupManagers) Close() error {
+	return r.client.Close()
+}
+
+// restOperation adapts *compute.Operation to Operation
+type restOperation struct {
+	op *compute.Operation
+}
+
+func wrapOperation(op *compute.Operation, err error) (Operation, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &restOperation{op: op}, nil
+}
+
+func (o *restOperation) Wait(ctx context.Context) error {
+	return o.op.Wait(ctx)
+}
+
+func (o *restOperation) Proto() *computepb.Operation {
+	return o.op.Proto()
+}
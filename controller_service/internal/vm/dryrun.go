@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// dryRunTargetSize stands in for the MIG target size in dry-run mode:
+// the number of VMs tracked in Redis for the MIG's zone
+func (m *Manager) dryRunTargetSize(ctx context.Context, target config.MIGTarget) (int, error) {
+	statuses, err := m.vmStore.GetAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	size := 0
+	for _, status := range statuses {
+		if status.Zone == target.Zone || (status.Zone == "" && len(m.cfg.GCP.MIGs) == 1) {
+			size++
+		}
+	}
+	return size, nil
+}
+
+// dryRunCreateInstances records count new VMs in Redis as if the MIG had created them
+// They start out provisioning, like real instances right after a resize
+func (m *Manager) dryRunCreateInstances(ctx context.Context, target config.MIGTarget, count int) error {
+	log := logger.WithComponent("vm_manager")
+
+	for i := 0; i < count; i++ {
+		vmName := fmt.Sprintf("%s-dryrun-%s", target.MIGName, uuid.New().String()[:8])
+		if err := m.vmStore.UpdateFromInfra(ctx, vmName, target.Zone, redis.VMInfraProvisioning); err != nil {
+			return fmt.Errorf("failed to record dry-run VM %s: %w", vmName, err)
+		}
+		log.WithFields(map[string]interface{}{
+			"vm":       vmName,
+			"zone":     target.Zone,
+			"mig_name": target.MIGName,
+		}).Info("Dry run: skipping GCP MIG resize, recorded new VM")
+	}
+	return nil
+}
@@ -2,46 +2,79 @@ package vm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
-	"google.golang.org/api/iterator"
+	"github.com/google/uuid"
 
 	"github.com/monkci/mig-controller/internal/config"
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
 	"github.com/monkci/mig-controller/internal/redis"
 	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
 )
 
+// drainPollInterval is how often DrainAndRecycle checks whether the drained VM finished its job
+const drainPollInterval = 5 * time.Second
+
 // Manager handles VM lifecycle management via GCloud API
 type Manager struct {
 	cfg             *config.Config
-	instancesClient *compute.InstancesClient
-	migClient       *compute.InstanceGroupManagersClient
-	vmStore         *redis.VMStatusStore
+	instancesClient InstancesAPI             // nil in dry-run mode
+	migClient       InstanceGroupManagersAPI // nil in dry-run mode
+	vmStore         VMStateStore
+	grpcServer      CommandSender
+	scaleUpLimiter  *scaleUpLimiter
+	breaker         *circuitBreaker // Short-circuits GCP calls after consecutive failures
+	sizeCache       *migSizeCache   // Last known MIG target sizes
+	scaleUpLock     sync.Mutex      // Serializes scale-up planning against the size cache
+
+	stopRetryLock sync.Mutex
+	stopRetries   map[string]bool // Idle VMs drained for stopping whose stop failed; retried next cleanup
+
+	throttledScaleUps atomic.Int64 // VMs withheld by the scale-up rate limiter
+	quotaBackoffUntil atomic.Int64 // Unix nanos; scale-ups are refused until then after a quota error
 }
 
 // NewManager creates a new VM manager
-func NewManager(cfg *config.Config, vmStore *redis.VMStatusStore) (*Manager, error) {
+// nil compute clients default to the GCE REST clients; in dry-run mode none are created
+func NewManager(cfg *config.Config, vmStore VMStateStore, grpcServer CommandSender, instancesClient InstancesAPI, migClient InstanceGroupManagersAPI) (*Manager, error) {
 	ctx := context.Background()
+	log := logger.WithComponent("vm_manager")
 
-	instancesClient, err := compute.NewInstancesRESTClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create instances client: %w", err)
-	}
+	if cfg.VMManager.DryRun {
+		log.Warn("VM Manager in dry-run mode: GCP API calls are logged, not made")
+	} else {
+		createdInstances := false
+		if instancesClient == nil {
+			var err error
+			if instancesClient, err = NewRESTInstances(ctx); err != nil {
+				return nil, err
+			}
+			createdInstances = true
+		}
 
-	migClient, err := compute.NewInstanceGroupManagersRESTClient(ctx)
-	if err != nil {
-		instancesClient.Close()
-		return nil, fmt.Errorf("failed to create MIG client: %w", err)
+		if migClient == nil {
+			var err error
+			if migClient, err = NewRESTInstanceGroupManagers(ctx); err != nil {
+				if createdInstances {
+					instancesClient.Close()
+				}
+				return nil, err
+			}
+		}
 	}
 
-	log := logger.WithComponent("vm_manager")
 	log.WithFields(map[string]interface{}{
-		"project":  cfg.GCP.ProjectID,
-		"zone":     cfg.GCP.Zone,
-		"mig_name": cfg.GCP.MIGName,
+		"project": cfg.GCP.ProjectID,
+		"migs":    len(cfg.GCP.MIGs),
+		"dry_run": cfg.VMManager.DryRun,
 	}).Info("VM Manager initialized")
 
 	return &Manager{
@@ -49,15 +82,36 @@ func NewManager(cfg *config.Config, vmStore *redis.VMStatusStore) (*Manager, err
 		instancesClient: instancesClient,
 		migClient:       migClient,
 		vmStore:         vmStore,
+		grpcServer:      grpcServer,
+		scaleUpLimiter:  newScaleUpLimiter(cfg.VMManager.MaxScaleUpPerMinute, scaleUpWindow),
+		breaker:         newCircuitBreaker(cfg.VMManager.BreakerThreshold, cfg.VMManager.BreakerCooldown),
+		sizeCache:       newMIGSizeCache(cfg.VMManager.MIGSizeCacheTTL),
+		stopRetries:     make(map[string]bool),
 	}, nil
 }
 
 // Close closes the GCloud clients
 func (m *Manager) Close() error {
-	if err := m.instancesClient.Close(); err != nil {
-		return err
+	if m.instancesClient != nil {
+		if err := m.instancesClient.Close(); err != nil {
+			return err
+		}
+	}
+	if m.migClient != nil {
+		return m.migClient.Close()
 	}
-	return m.migClient.Close()
+	return nil
+}
+
+// CheckClients verifies the GCloud clients are initialized
+func (m *Manager) CheckClients() error {
+	if m.cfg.VMManager.DryRun {
+		return nil
+	}
+	if m.instancesClient == nil || m.migClient == nil {
+		return fmt.Errorf("GCP compute clients not initialized")
+	}
+	return nil
 }
 
 // StartVM starts a stopped VM
@@ -65,28 +119,47 @@ func (m *Manager) StartVM(ctx context.Context, vmName string) error {
 	log := logger.WithVM(vmName, m.cfg.Pool.ID)
 	log.Info("Starting VM")
 
+	mig, err := m.migFor(ctx, vmName)
+	if err != nil {
+		return err
+	}
+
+	if m.cfg.VMManager.DryRun {
+		log.WithField("zone", mig.Zone).Info("Dry run: skipping GCP instance start")
+	} else if err := m.startInstance(ctx, mig, vmName); err != nil {
+		return err
+	}
+
+	// Update VM status in Redis
+	if err := m.vmStore.UpdateFromInfra(ctx, vmName, mig.Zone, redis.VMInfraStaging); err != nil {
+		log.WithError(err).Warn("Failed to update VM status")
+	}
+
+	log.Info("VM start initiated")
+	return nil
+}
+
+// startInstance starts the instance in GCP and waits for the operation
+func (m *Manager) startInstance(ctx context.Context, mig config.MIGTarget, vmName string) error {
 	req := &computepb.StartInstanceRequest{
 		Project:  m.cfg.GCP.ProjectID,
-		Zone:     m.cfg.GCP.Zone,
+		Zone:     mig.Zone,
 		Instance: vmName,
 	}
 
-	op, err := m.instancesClient.Start(ctx, req)
+	var op Operation
+	err := m.callGCP(ctx, func() (err error) {
+		op, err = m.instancesClient.Start(ctx, req)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to start VM: %w", err)
+		return classifyGCPError(fmt.Errorf("failed to start VM: %w", err))
 	}
 
 	// Wait for operation to complete
 	if err := op.Wait(ctx); err != nil {
-		return fmt.Errorf("failed waiting for VM start: %w", err)
-	}
-
-	// Update VM status in Redis
-	if err := m.vmStore.UpdateFromInfra(ctx, vmName, m.cfg.GCP.Zone, redis.VMInfraStaging); err != nil {
-		log.WithError(err).Warn("Failed to update VM status")
+		return classifyGCPError(fmt.Errorf("failed waiting for VM start: %w", err))
 	}
-
-	log.Info("VM start initiated")
 	return nil
 }
 
@@ -95,13 +168,39 @@ func (m *Manager) StopVM(ctx context.Context, vmName string) error {
 	log := logger.WithVM(vmName, m.cfg.Pool.ID)
 	log.Info("Stopping VM")
 
+	mig, err := m.migFor(ctx, vmName)
+	if err != nil {
+		return err
+	}
+
+	if m.cfg.VMManager.DryRun {
+		log.WithField("zone", mig.Zone).Info("Dry run: skipping GCP instance stop")
+	} else if err := m.stopInstance(ctx, mig, vmName); err != nil {
+		return err
+	}
+
+	// Update VM status in Redis
+	if err := m.vmStore.UpdateFromInfra(ctx, vmName, mig.Zone, redis.VMInfraStopping); err != nil {
+		log.WithError(err).Warn("Failed to update VM status")
+	}
+
+	log.Info("VM stop initiated")
+	return nil
+}
+
+// stopInstance stops the instance in GCP and waits for the operation
+func (m *Manager) stopInstance(ctx context.Context, mig config.MIGTarget, vmName string) error {
 	req := &computepb.StopInstanceRequest{
 		Project:  m.cfg.GCP.ProjectID,
-		Zone:     m.cfg.GCP.Zone,
+		Zone:     mig.Zone,
 		Instance: vmName,
 	}
 
-	op, err := m.instancesClient.Stop(ctx, req)
+	var op Operation
+	err := m.callGCP(ctx, func() (err error) {
+		op, err = m.instancesClient.Stop(ctx, req)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to stop VM: %w", err)
 	}
@@ -110,57 +209,143 @@ func (m *Manager) StopVM(ctx context.Context, vmName string) error {
 	if err := op.Wait(ctx); err != nil {
 		return fmt.Errorf("failed waiting for VM stop: %w", err)
 	}
-
-	// Update VM status in Redis
-	if err := m.vmStore.UpdateFromInfra(ctx, vmName, m.cfg.GCP.Zone, redis.VMInfraStopping); err != nil {
-		log.WithError(err).Warn("Failed to update VM status")
-	}
-
-	log.Info("VM stop initiated")
 	return nil
 }
 
-// ScaleUp increases the MIG size by the specified count
+// ScaleUp increases the pool size by the specified count
+// New VMs are spread across the configured MIGs, filling the smallest first
 func (m *Manager) ScaleUp(ctx context.Context, count int) error {
 	log := logger.WithComponent("vm_manager")
 
-	// Get current MIG size
-	mig, err := m.getMIG(ctx)
+	if until := m.quotaBackoffUntil.Load(); time.Now().UnixNano() < until {
+		return fmt.Errorf("%w: scale-ups paused until %s", ErrQuotaExhausted, time.Unix(0, until).Format(time.RFC3339))
+	}
+
+	sizes, added, err := m.planScaleUp(ctx, count)
 	if err != nil {
-		return fmt.Errorf("failed to get MIG: %w", err)
+		return err
+	}
+
+	var firstErr error
+	for i, target := range m.cfg.GCP.MIGs {
+		if added[i] == 0 {
+			continue
+		}
+
+		if m.cfg.VMManager.DryRun {
+			if err := m.dryRunCreateInstances(ctx, target, added[i]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		req := &computepb.ResizeInstanceGroupManagerRequest{
+			Project:              m.cfg.GCP.ProjectID,
+			Zone:                 target.Zone,
+			InstanceGroupManager: target.MIGName,
+			Size:                 int32(sizes[i] + added[i]),
+		}
+
+		started := time.Now()
+		var op Operation
+		err := m.callGCP(ctx, func() (err error) {
+			op, err = m.migClient.Resize(ctx, req)
+			return err
+		})
+		if err == nil && m.cfg.VMManager.ScaleUpWait {
+			err = m.waitForResize(ctx, target, op, started)
+		}
+		if err != nil {
+			// The resize may or may not have applied; read the size from GCP next time
+			m.sizeCache.invalidate(target)
+			log.WithError(err).WithFields(map[string]interface{}{
+				"zone":     target.Zone,
+				"mig_name": target.MIGName,
+			}).Warn("Failed to resize MIG")
+			if firstErr == nil {
+				firstErr = classifyGCPError(fmt.Errorf("failed to resize MIG %s: %w", target.MIGName, err))
+			}
+			continue
+		}
+
+		log.WithFields(map[string]interface{}{
+			"zone":     target.Zone,
+			"mig_name": target.MIGName,
+			"count":    added[i],
+		}).Info("MIG scale up initiated")
+	}
+
+	if errors.Is(firstErr, ErrQuotaExhausted) && m.cfg.VMManager.QuotaBackoff > 0 {
+		until := time.Now().Add(m.cfg.VMManager.QuotaBackoff)
+		m.quotaBackoffUntil.Store(until.UnixNano())
+		log.WithField("until", until).Warn("GCP quota exhausted, pausing scale-ups")
+	}
+
+	return firstErr
+}
+
+// planScaleUp reads the MIG sizes, checks the max VMs limit and spreads count new VMs
+// over the MIGs. The new sizes are written to the size cache before returning, so a
+// concurrent ScaleUp plans on top of this one instead of resizing to the same target
+func (m *Manager) planScaleUp(ctx context.Context, count int) (sizes, added []int, err error) {
+	log := logger.WithComponent("vm_manager")
+
+	m.scaleUpLock.Lock()
+	defer m.scaleUpLock.Unlock()
+
+	// Get current MIG sizes, cached for bursts of scale-ups
+	sizes = make([]int, len(m.cfg.GCP.MIGs))
+	currentSize := 0
+	var sizeAge time.Duration // Age of the stalest size used
+	for i, target := range m.cfg.GCP.MIGs {
+		size, age, err := m.targetSize(ctx, target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get MIG %s: %w", target.MIGName, err)
+		}
+		sizes[i] = size
+		currentSize += size
+		if age > sizeAge {
+			sizeAge = age
+		}
 	}
 
-	currentSize := int(mig.GetTargetSize())
 	newSize := currentSize + count
 
 	// Check against max VMs
 	if newSize > m.cfg.VMManager.MaxVMs {
-		return fmt.Errorf("cannot scale up: would exceed max VMs (%d > %d)", newSize, m.cfg.VMManager.MaxVMs)
+		return nil, nil, fmt.Errorf("cannot scale up: would exceed max VMs (%d > %d)", newSize, m.cfg.VMManager.MaxVMs)
 	}
 
 	log.WithFields(map[string]interface{}{
 		"current_size": currentSize,
 		"new_size":     newSize,
 		"count":        count,
-	}).Info("Scaling up MIG")
+		"size_age":     sizeAge.Round(time.Millisecond).String(),
+	}).Info("Scaling up pool")
 
-	req := &computepb.ResizeInstanceGroupManagerRequest{
-		Project:              m.cfg.GCP.ProjectID,
-		Zone:                 m.cfg.GCP.Zone,
-		InstanceGroupManager: m.cfg.GCP.MIGName,
-		Size:                 int32(newSize),
+	added = spreadScaleUp(sizes, count)
+	for i, target := range m.cfg.GCP.MIGs {
+		if added[i] > 0 {
+			m.sizeCache.set(target, sizes[i]+added[i])
+		}
 	}
+	return sizes, added, nil
+}
 
-	op, err := m.migClient.Resize(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to resize MIG: %w", err)
+// spreadScaleUp distributes count new VMs over MIGs with the given sizes,
+// always adding to the currently smallest MIG so zones stay balanced
+func spreadScaleUp(sizes []int, count int) []int {
+	added := make([]int, len(sizes))
+	for ; count > 0; count-- {
+		smallest := 0
+		for i := range sizes {
+			if sizes[i]+added[i] < sizes[smallest]+added[smallest] {
+				smallest = i
+			}
+		}
+		added[smallest]++
 	}
-
-	// Don't wait for completion - VMs will be provisioned asynchronously
-	_ = op
-
-	log.Info("MIG scale up initiated")
-	return nil
+	return added
 }
 
 // ScaleDown decreases the MIG size by removing specific VMs
@@ -173,24 +358,42 @@ func (m *Manager) ScaleDown(ctx context.Context, vmNames []string) error {
 
 	log.WithField("vms", vmNames).Info("Scaling down MIG")
 
-	// Delete specific instances
+	// Delete specific instances from the MIG in their zone
 	for _, vmName := range vmNames {
-		instanceURL := fmt.Sprintf("zones/%s/instances/%s", m.cfg.GCP.Zone, vmName)
+		mig, err := m.migFor(ctx, vmName)
+		if err != nil {
+			log.WithError(err).WithField("vm", vmName).Warn("Failed to resolve VM's MIG")
+			continue
+		}
+
+		instanceURL := fmt.Sprintf("zones/%s/instances/%s", mig.Zone, vmName)
 
 		req := &computepb.DeleteInstancesInstanceGroupManagerRequest{
 			Project:              m.cfg.GCP.ProjectID,
-			Zone:                 m.cfg.GCP.Zone,
-			InstanceGroupManager: m.cfg.GCP.MIGName,
+			Zone:                 mig.Zone,
+			InstanceGroupManager: mig.MIGName,
 			InstanceGroupManagersDeleteInstancesRequestResource: &computepb.InstanceGroupManagersDeleteInstancesRequest{
 				Instances: []string{instanceURL},
 			},
 		}
 
-		_, err := m.migClient.DeleteInstances(ctx, req)
+		if m.cfg.VMManager.DryRun {
+			log.WithFields(map[string]interface{}{
+				"vm":       vmName,
+				"mig_name": mig.MIGName,
+			}).Info("Dry run: skipping GCP instance deletion")
+		} else {
+			err = m.callGCP(ctx, func() error {
+				_, err := m.migClient.DeleteInstances(ctx, req)
+				return err
+			})
+		}
 		if err != nil {
 			log.WithError(err).WithField("vm", vmName).Warn("Failed to delete instance")
 			continue
 		}
+		// Deleting shrinks the target size by one, once GCP applies it
+		m.sizeCache.invalidate(mig)
 
 		// Remove from Redis
 		if err := m.vmStore.Delete(ctx, vmName); err != nil {
@@ -201,26 +404,58 @@ func (m *Manager) ScaleDown(ctx context.Context, vmNames []string) error {
 	return nil
 }
 
-// RefreshVMList updates the VM list from GCloud
+// RefreshVMList updates the VM list from GCloud across all configured MIGs
+// In dry-run mode Redis is the only record of the pool, so there is nothing to sync
 func (m *Manager) RefreshVMList(ctx context.Context) error {
 	log := logger.WithComponent("vm_manager")
 
-	instances, err := m.listManagedInstances(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to list managed instances: %w", err)
+	if m.cfg.VMManager.DryRun {
+		log.Debug("Dry run: skipping GCloud VM list refresh")
+		return nil
 	}
 
-	log.WithField("count", len(instances)).Debug("Retrieved managed instances from GCloud")
+	var firstErr error
+	for _, mig := range m.cfg.GCP.MIGs {
+		// Reconcile the cached target size with GCP, catching resizes made outside the controller
+		if err := m.refreshTargetSize(ctx, mig); err != nil {
+			log.WithError(err).WithField("mig_name", mig.MIGName).Warn("Failed to refresh MIG target size")
+		}
+
+		instances, err := m.listManagedInstances(ctx, mig)
+		if err != nil {
+			log.WithError(err).WithField("mig_name", mig.MIGName).Warn("Failed to list managed instances")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to list managed instances in %s: %w", mig.MIGName, err)
+			}
+			continue
+		}
+
+		log.WithFields(map[string]interface{}{
+			"mig_name": mig.MIGName,
+			"count":    len(instances),
+		}).Debug("Retrieved managed instances from GCloud")
 
-	// Update each instance in Redis
-	for _, inst := range instances {
-		infraState := mapInstanceStatus(inst.GetInstanceStatus())
+		// Update each instance in Redis under the zone its self-link reports
+		for _, inst := range instances {
+			zone, name := parseInstanceURL(inst.GetInstance())
+			if name == "" {
+				name = inst.GetName()
+			}
+			if zone == "" {
+				zone = mig.Zone
+			}
+			infraState := mapInstanceStatus(inst.GetInstanceStatus())
 
-		if err := m.vmStore.UpdateFromInfra(ctx, inst.GetInstance(), m.cfg.GCP.Zone, infraState); err != nil {
-			log.WithError(err).WithField("vm", inst.GetInstance()).Warn("Failed to update VM status")
+			if err := m.vmStore.UpdateFromInfra(ctx, name, zone, infraState); err != nil {
+				log.WithError(err).WithField("vm", name).Warn("Failed to update VM status")
+			}
 		}
 	}
 
+	if firstErr != nil {
+		return firstErr
+	}
+
 	// TODO: Clean up stale entries (VMs that no longer exist in GCloud)
 
 	return nil
@@ -229,7 +464,7 @@ func (m *Manager) RefreshVMList(ctx context.Context) error {
 // GetAvailableVM returns the first available VM for job assignment
 func (m *Manager) GetAvailableVM(ctx context.Context) (*redis.VMStatus, error) {
 	// First try to find a ready/idle VM
-	vm, err := m.vmStore.GetFirstReady(ctx)
+	vm, err := m.vmStore.GetFirstReady(ctx, "")
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +477,7 @@ func (m *Manager) GetAvailableVM(ctx context.Context) (*redis.VMStatus, error) {
 
 // GetStoppedVM returns the first stopped VM for starting
 func (m *Manager) GetStoppedVM(ctx context.Context) (*redis.VMStatus, error) {
-	return m.vmStore.GetFirstStopped(ctx)
+	return m.vmStore.GetFirstStopped(ctx, "")
 }
 
 // EnsureMinReadyVMs ensures minimum number of ready VMs are maintained
@@ -284,9 +519,21 @@ func (m *Manager) EnsureMinReadyVMs(ctx context.Context) error {
 	// If still need more, scale up MIG
 	stillNeeded := deficit - toStart
 	if stillNeeded > 0 {
-		// Respect rate limiting
-		scaleCount := min(stillNeeded, m.cfg.VMManager.MaxScaleUpPerMinute)
+		// Respect MaxScaleUpPerMinute across maintenance ticks
+		scaleCount := m.scaleUpLimiter.reserve(stillNeeded)
+		if scaleCount < stillNeeded {
+			m.throttledScaleUps.Add(int64(stillNeeded - scaleCount))
+			log.WithFields(map[string]interface{}{
+				"needed":  stillNeeded,
+				"granted": scaleCount,
+				"limit":   m.cfg.VMManager.MaxScaleUpPerMinute,
+			}).Warn("Scale-up throttled by per-minute rate limit")
+		}
+		if scaleCount == 0 {
+			return nil
+		}
 		if err := m.ScaleUp(ctx, scaleCount); err != nil {
+			m.scaleUpLimiter.release(scaleCount)
 			return fmt.Errorf("failed to scale up: %w", err)
 		}
 	}
@@ -298,6 +545,9 @@ func (m *Manager) EnsureMinReadyVMs(ctx context.Context) error {
 func (m *Manager) CleanupIdleVMs(ctx context.Context) error {
 	log := logger.WithComponent("vm_manager")
 
+	// Drained VMs no longer report idle, so finish stopping the ones that failed last time first
+	m.retryFailedStops(ctx)
+
 	stats, err := m.vmStore.GetStats(ctx)
 	if err != nil {
 		return err
@@ -308,8 +558,8 @@ func (m *Manager) CleanupIdleVMs(ctx context.Context) error {
 		return nil
 	}
 
-	// Get idle VMs
-	idleVMs, err := m.vmStore.GetByEffectiveState(ctx, redis.EffectiveStateIdle)
+	// Get idle VMs, longest idle first so those are reclaimed before recently used ones
+	idleVMs, err := m.vmStore.GetByEffectiveStateOrdered(ctx, redis.EffectiveStateIdle, redis.VMOrderLongestIdleFirst)
 	if err != nil {
 		return err
 	}
@@ -323,52 +573,375 @@ func (m *Manager) CleanupIdleVMs(ctx context.Context) error {
 			break
 		}
 
-		// Check if idle too long
-		if now.Sub(vm.LastHeartbeat) > idleTimeout {
+		// Check if idle too long; IdleSince is unset until the first heartbeat that tracks it
+		if !vm.IdleSince.IsZero() && now.Sub(vm.IdleSince) > idleTimeout {
+			// Drain first so we never stop a VM that just picked up a job
+			drained, err := m.drainIfIdle(ctx, vm.VMID)
+			if err != nil {
+				log.WithError(err).WithField("vm", vm.VMID).Warn("Failed to drain idle VM, skipping this cycle")
+				continue
+			}
+			if !drained {
+				log.WithField("vm", vm.VMID).Info("Idle VM reported a running job, skipping this cycle")
+				continue
+			}
+
 			log.WithField("vm", vm.VMID).Info("Stopping idle VM")
 
+			// The VM is drained either way, so it no longer counts as ready
+			stats.ReadyVMs--
 			if err := m.StopVM(ctx, vm.VMID); err != nil {
-				log.WithError(err).WithField("vm", vm.VMID).Warn("Failed to stop idle VM")
-			} else {
-				stats.ReadyVMs--
+				log.WithError(err).WithField("vm", vm.VMID).Warn("Failed to stop idle VM, retrying next cycle")
+				m.markStopRetry(vm.VMID, true)
+			}
+		}
+	}
+
+	return nil
+}
+
+// markStopRetry records whether a drained VM still has to be stopped
+func (m *Manager) markStopRetry(vmID string, retry bool) {
+	m.stopRetryLock.Lock()
+	defer m.stopRetryLock.Unlock()
+	if retry {
+		m.stopRetries[vmID] = true
+	} else {
+		delete(m.stopRetries, vmID)
+	}
+}
+
+// stopRetryCount returns how many drained VMs are waiting for a stop retry
+func (m *Manager) stopRetryCount() int {
+	m.stopRetryLock.Lock()
+	defer m.stopRetryLock.Unlock()
+	return len(m.stopRetries)
+}
+
+// retryFailedStops stops the drained idle VMs whose stop failed in an earlier cleanup
+// VMs that have since stopped, or were deleted, are dropped without calling GCP
+func (m *Manager) retryFailedStops(ctx context.Context) {
+	m.stopRetryLock.Lock()
+	vmIDs := make([]string, 0, len(m.stopRetries))
+	for vmID := range m.stopRetries {
+		vmIDs = append(vmIDs, vmID)
+	}
+	m.stopRetryLock.Unlock()
+
+	for _, vmID := range vmIDs {
+		log := logger.WithVM(vmID, m.cfg.Pool.ID)
+
+		status, err := m.vmStore.Get(ctx, vmID)
+		if err != nil {
+			log.WithError(err).Warn("Failed to get drained VM status, retrying next cycle")
+			continue
+		}
+		if status == nil || status.InfraState == redis.VMInfraStopped || status.InfraState == redis.VMInfraStopping {
+			m.markStopRetry(vmID, false)
+			continue
+		}
+
+		if err := m.StopVM(ctx, vmID); err != nil {
+			log.WithError(err).Warn("Failed to stop drained VM, retrying next cycle")
+			continue
+		}
+		m.markStopRetry(vmID, false)
+	}
+}
+
+// DeleteStoppedVMs deletes VMs that have been stopped for longer than DeleteDelay
+// Stopped VMs linger so a job burst can restart them faster than a cold scale-up;
+// if the pool is over MaxVMs the longest-stopped VMs are deleted early to get back under it
+func (m *Manager) DeleteStoppedVMs(ctx context.Context) error {
+	log := logger.WithComponent("vm_manager")
+
+	stoppedVMs, err := m.vmStore.GetByEffectiveState(ctx, redis.EffectiveStateStopped)
+	if err != nil {
+		return fmt.Errorf("failed to get stopped VMs: %w", err)
+	}
+	if len(stoppedVMs) == 0 {
+		return nil
+	}
+
+	stats, err := m.vmStore.GetStats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get pool stats: %w", err)
+	}
+	excess := int(stats.TotalVMs) - m.cfg.VMManager.MaxVMs
+
+	// Longest-stopped first; VMs without a stop time yet sort last
+	sort.Slice(stoppedVMs, func(i, j int) bool {
+		a, b := stoppedVMs[i].StoppedAt, stoppedVMs[j].StoppedAt
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.Before(b)
+	})
+
+	now := time.Now()
+	var toDelete []string
+	for _, vm := range stoppedVMs {
+		expired := !vm.StoppedAt.IsZero() && now.Sub(vm.StoppedAt) > m.cfg.VMManager.DeleteDelay
+		if !expired && excess <= 0 {
+			continue
+		}
+
+		log.WithFields(map[string]interface{}{
+			"vm":         vm.VMID,
+			"stopped_at": vm.StoppedAt,
+			"over_max":   !expired,
+		}).Info("Deleting stopped VM")
+
+		toDelete = append(toDelete, vm.VMID)
+		excess--
+	}
+
+	return m.ScaleDown(ctx, toDelete)
+}
+
+// RecycleDegradedVMs deletes VMs excluded from scheduling for high disk or memory usage,
+// so the pool is topped back up with fresh VMs. Does nothing unless vm_manager.recycle_degraded is set
+func (m *Manager) RecycleDegradedVMs(ctx context.Context) error {
+	if !m.cfg.VMManager.RecycleDegraded {
+		return nil
+	}
+
+	log := logger.WithComponent("vm_manager")
+
+	degradedVMs, err := m.vmStore.GetByEffectiveState(ctx, redis.EffectiveStateDegraded)
+	if err != nil {
+		return fmt.Errorf("failed to get degraded VMs: %w", err)
+	}
+
+	var toDelete []string
+	for _, vm := range degradedVMs {
+		// Drain first so we never delete a VM that just picked up a job
+		drained, err := m.drainIfIdle(ctx, vm.VMID)
+		if err != nil {
+			log.WithError(err).WithField("vm", vm.VMID).Warn("Failed to drain degraded VM, skipping this cycle")
+			continue
+		}
+		if !drained {
+			log.WithField("vm", vm.VMID).Info("Degraded VM reported a running job, skipping this cycle")
+			continue
+		}
+
+		log.WithFields(map[string]interface{}{
+			"vm":           vm.VMID,
+			"disk_usage":   vm.DiskUsage,
+			"memory_usage": vm.MemoryUsage,
+		}).Info("Recycling degraded VM")
+
+		toDelete = append(toDelete, vm.VMID)
+	}
+
+	if len(toDelete) == 0 {
+		return nil
+	}
+	return m.ScaleDown(ctx, toDelete)
+}
+
+// drainIfIdle asks the MIGlet to drain only if it has no running job
+// Returns true once the VM has confirmed it is idle and is now draining, or right away
+// for a MIGlet without drain support; false if it reported a running job
+func (m *Manager) drainIfIdle(ctx context.Context, vmID string) (bool, error) {
+	// Wait no longer than DrainTimeout for the MIGlet to confirm
+	timeout := m.cfg.MIGlet.CommandTimeout
+	if m.cfg.VMManager.DrainTimeout > 0 && m.cfg.VMManager.DrainTimeout < timeout {
+		timeout = m.cfg.VMManager.DrainTimeout
+	}
+
+	cmd := &commands.Command{
+		Id:        uuid.New().String(),
+		Type:      "drain",
+		CreatedAt: time.Now().Unix(),
+		BoolParams: map[string]bool{
+			"if_idle": true,
+		},
+	}
+
+	ack, err := m.grpcServer.SendCommandAs("vm_manager", vmID, cmd, timeout)
+	if errors.Is(err, grpcserver.ErrCommandUnsupported) {
+		// MIGlets that predate drain can't confirm, so rely on the idle state the caller saw
+		logger.WithVM(vmID, m.cfg.Pool.ID).Info("MIGlet does not support drain, proceeding without it")
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to send drain command: %w", err)
+	}
+
+	if !ack.Success {
+		if ack.Result["job_running"] == "true" {
+			return false, nil
+		}
+		return false, fmt.Errorf("drain rejected: %s", ack.Message)
+	}
+
+	return true, nil
+}
+
+// DrainAndRecycle drains a VM, waits for its current job to finish (up to DrainTimeout),
+// then deletes it from the MIG and tops the pool back up to the minimum ready VMs
+// issuer identifies who requested the recycle in the command audit log
+func (m *Manager) DrainAndRecycle(ctx context.Context, vmID, issuer string) error {
+	log := logger.WithComponent("vm_manager").WithField("vm", vmID)
+
+	cmd := &commands.Command{
+		Id:        uuid.New().String(),
+		Type:      "drain",
+		CreatedAt: time.Now().Unix(),
+	}
+
+	log.Info("Draining VM for recycle")
+
+	ack, err := m.grpcServer.SendCommandAs(issuer, vmID, cmd, m.cfg.MIGlet.CommandTimeout)
+	jobRunning := false
+	switch {
+	case errors.Is(err, grpcserver.ErrCommandTimeout):
+		// The MIGlet may have taken the drain and stopped accepting work, so leaving the VM
+		// would strand it draining; finish the recycle once any job it reports is done
+		log.WithError(err).Warn("Drain ack timed out, recycling the VM anyway")
+		jobRunning = true
+	case errors.Is(err, grpcserver.ErrCommandUnsupported):
+		// MIGlets that predate drain are recycled once their status shows no running job
+		log.Info("MIGlet does not support drain, recycling once its job is done")
+		jobRunning = true
+	case err != nil:
+		return fmt.Errorf("failed to send drain command: %w", err)
+	case !ack.Success:
+		return fmt.Errorf("drain rejected: %s", ack.Message)
+	default:
+		jobRunning = ack.Result["job_running"] == "true"
+	}
+
+	if jobRunning {
+		if err := m.waitForJobCompletion(ctx, vmID); err != nil {
+			if ctx.Err() != nil {
+				return err
 			}
+			// The MIGlet no longer takes work, so waiting longer would only strand the VM
+			// draining; the job overran DrainTimeout and is lost with the VM
+			log.WithError(err).Warn("Job still running after drain timeout, deleting the VM anyway")
 		}
 	}
 
+	log.Info("VM drained, deleting from MIG")
+
+	if err := m.ScaleDown(ctx, []string{vmID}); err != nil {
+		return fmt.Errorf("failed to delete VM: %w", err)
+	}
+
+	if err := m.EnsureMinReadyVMs(ctx); err != nil {
+		return fmt.Errorf("failed to replace recycled VM: %w", err)
+	}
+
+	log.Info("VM recycled")
 	return nil
 }
 
+// waitForJobCompletion polls the VM status until it no longer reports a running job
+func (m *Manager) waitForJobCompletion(ctx context.Context, vmID string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.VMManager.DrainTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := m.vmStore.Get(ctx, vmID)
+		if err == nil && (status == nil || !status.IsConnected ||
+			(status.CurrentJobID == "" && status.RunnerState != redis.RunnerStateRunning)) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for job to finish on drained VM: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetStats returns VM manager statistics
+func (m *Manager) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"scale_up_remaining":  m.scaleUpLimiter.remaining(),
+		"throttled_scale_ups": m.throttledScaleUps.Load(),
+		"stop_retries":        m.stopRetryCount(),
+		"quota_backoff":       time.Now().UnixNano() < m.quotaBackoffUntil.Load(),
+		"gcp_breaker":         m.breaker.stats(),
+	}
+}
+
+// migFor returns the MIG a VM belongs to, based on the zone stored in its status
+// A single-MIG pool falls back to that MIG when the zone isn't known yet
+func (m *Manager) migFor(ctx context.Context, vmName string) (config.MIGTarget, error) {
+	status, err := m.vmStore.Get(ctx, vmName)
+	if err != nil {
+		return config.MIGTarget{}, fmt.Errorf("failed to get VM status: %w", err)
+	}
+
+	if status != nil && status.Zone != "" {
+		if mig, ok := m.cfg.MIGForZone(status.Zone); ok {
+			return mig, nil
+		}
+		if len(m.cfg.GCP.MIGs) != 1 {
+			return config.MIGTarget{}, fmt.Errorf("no MIG configured for zone %s", status.Zone)
+		}
+	}
+
+	if len(m.cfg.GCP.MIGs) == 1 {
+		return m.cfg.GCP.MIGs[0], nil
+	}
+	return config.MIGTarget{}, fmt.Errorf("zone of VM %s is unknown", vmName)
+}
+
+// parseInstanceURL extracts the zone and instance name from an instance self-link
+// e.g. https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/instances/vm-1
+func parseInstanceURL(url string) (zone, name string) {
+	parts := strings.Split(strings.TrimSuffix(url, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "zones":
+			zone = parts[i+1]
+		case "instances":
+			name = parts[i+1]
+		}
+	}
+	return zone, name
+}
+
 // getMIG retrieves the MIG details
-func (m *Manager) getMIG(ctx context.Context) (*computepb.InstanceGroupManager, error) {
+func (m *Manager) getMIG(ctx context.Context, mig config.MIGTarget) (*computepb.InstanceGroupManager, error) {
 	req := &computepb.GetInstanceGroupManagerRequest{
 		Project:              m.cfg.GCP.ProjectID,
-		Zone:                 m.cfg.GCP.Zone,
-		InstanceGroupManager: m.cfg.GCP.MIGName,
+		Zone:                 mig.Zone,
+		InstanceGroupManager: mig.MIGName,
 	}
 
-	return m.migClient.Get(ctx, req)
+	var result *computepb.InstanceGroupManager
+	err := m.callGCP(ctx, func() (err error) {
+		result, err = m.migClient.Get(ctx, req)
+		return err
+	})
+	return result, err
 }
 
 // listManagedInstances lists all instances in the MIG
-func (m *Manager) listManagedInstances(ctx context.Context) ([]*computepb.ManagedInstance, error) {
+func (m *Manager) listManagedInstances(ctx context.Context, mig config.MIGTarget) ([]*computepb.ManagedInstance, error) {
 	req := &computepb.ListManagedInstancesInstanceGroupManagersRequest{
 		Project:              m.cfg.GCP.ProjectID,
-		Zone:                 m.cfg.GCP.Zone,
-		InstanceGroupManager: m.cfg.GCP.MIGName,
+		Zone:                 mig.Zone,
+		InstanceGroupManager: mig.MIGName,
 	}
 
 	var instances []*computepb.ManagedInstance
-	it := m.migClient.ListManagedInstances(ctx, req)
-
-	for {
-		inst, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		instances = append(instances, inst)
+	err := m.callGCP(ctx, func() (err error) {
+		instances, err = m.migClient.ListManagedInstances(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return instances, nil
@@ -0,0 +1,563 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+
+	"github.com/monkci/mig-controller/internal/config"
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// fakeOperation is a GCP operation that is already done
+type fakeOperation struct {
+	err error
+}
+
+func (o *fakeOperation) Wait(ctx context.Context) error { return o.err }
+
+func (o *fakeOperation) Proto() *computepb.Operation { return &computepb.Operation{} }
+
+// fakeCompute implements InstancesAPI and InstanceGroupManagersAPI in memory,
+// recording the calls the manager makes
+type fakeCompute struct {
+	mu          sync.Mutex
+	sizes       map[string]int32 // Target size by MIG name
+	resizeErr   error
+	stopErr     error
+	started     []*computepb.StartInstanceRequest
+	stopped     []*computepb.StopInstanceRequest
+	resized     []*computepb.ResizeInstanceGroupManagerRequest
+	deleted     []*computepb.DeleteInstancesInstanceGroupManagerRequest
+	resizeCalls int
+}
+
+func newFakeCompute() *fakeCompute {
+	return &fakeCompute{sizes: make(map[string]int32)}
+}
+
+func (c *fakeCompute) Start(ctx context.Context, req *computepb.StartInstanceRequest) (Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = append(c.started, req)
+	return &fakeOperation{}, nil
+}
+
+func (c *fakeCompute) Stop(ctx context.Context, req *computepb.StopInstanceRequest) (Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopErr != nil {
+		return nil, c.stopErr
+	}
+	c.stopped = append(c.stopped, req)
+	return &fakeOperation{}, nil
+}
+
+func (c *fakeCompute) Get(ctx context.Context, req *computepb.GetInstanceGroupManagerRequest) (*computepb.InstanceGroupManager, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := c.sizes[req.GetInstanceGroupManager()]
+	return &computepb.InstanceGroupManager{TargetSize: &size}, nil
+}
+
+func (c *fakeCompute) Resize(ctx context.Context, req *computepb.ResizeInstanceGroupManagerRequest) (Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resizeCalls++
+	if c.resizeErr != nil {
+		return nil, c.resizeErr
+	}
+	c.resized = append(c.resized, req)
+	c.sizes[req.GetInstanceGroupManager()] = req.GetSize()
+	return &fakeOperation{}, nil
+}
+
+func (c *fakeCompute) DeleteInstances(ctx context.Context, req *computepb.DeleteInstancesInstanceGroupManagerRequest) (Operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, req)
+	return &fakeOperation{}, nil
+}
+
+func (c *fakeCompute) ListManagedInstances(ctx context.Context, req *computepb.ListManagedInstancesInstanceGroupManagersRequest) ([]*computepb.ManagedInstance, error) {
+	return nil, nil
+}
+
+func (c *fakeCompute) ListErrors(ctx context.Context, req *computepb.ListErrorsInstanceGroupManagersRequest) ([]*computepb.InstanceManagedByIgmError, error) {
+	return nil, nil
+}
+
+func (c *fakeCompute) Close() error { return nil }
+
+// deletedInstances returns the instance URLs passed to DeleteInstances, in call order
+func (c *fakeCompute) deletedInstances() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var urls []string
+	for _, req := range c.deleted {
+		urls = append(urls, req.GetInstanceGroupManagersDeleteInstancesRequestResource().GetInstances()...)
+	}
+	return urls
+}
+
+// fakeSender answers every command with ack, or fails it with err
+type fakeSender struct {
+	mu   sync.Mutex
+	ack  *commands.CommandAck
+	err  error
+	sent []*commands.Command
+}
+
+func (s *fakeSender) SendCommandAs(issuer, vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, cmd)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.ack, nil
+}
+
+// testConfig returns a config for a pool with one MIG per zone
+func testConfig(zones ...string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Pool.ID = "pool-test"
+	cfg.GCP.ProjectID = "project-test"
+	for _, zone := range zones {
+		cfg.GCP.MIGs = append(cfg.GCP.MIGs, config.MIGTarget{Zone: zone, MIGName: "mig-" + zone})
+	}
+	cfg.VMManager.MaxVMs = 10
+	cfg.VMManager.MaxScaleUpPerMinute = 10
+	cfg.VMManager.DrainTimeout = time.Second
+	cfg.MIGlet.CommandTimeout = time.Second
+	return cfg
+}
+
+// newTestManager returns a manager on fake compute, a fake command sender and an in-memory VM store
+func newTestManager(t *testing.T, cfg *config.Config) (*Manager, *fakeCompute, *fakeSender, *redis.MemoryVMStatusStore) {
+	t.Helper()
+	logger.Init("error", "text", logger.Output{})
+
+	compute := newFakeCompute()
+	sender := &fakeSender{ack: &commands.CommandAck{Success: true}}
+	store := redis.NewMemoryVMStatusStore(cfg.Pool.ID)
+
+	m, err := NewManager(cfg, store, sender, compute, compute)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m, compute, sender, store
+}
+
+// putVM stores a VM in zone with the given infra and MIGlet states, idle since idleSince
+func putVM(t *testing.T, store *redis.MemoryVMStatusStore, vmID, zone string, infra redis.VMInfraState, state redis.MigletState, idleSince time.Time) {
+	t.Helper()
+	err := store.Update(context.Background(), &redis.VMStatus{
+		VMID:          vmID,
+		PoolID:        "pool-test",
+		Zone:          zone,
+		InfraState:    infra,
+		MigletState:   state,
+		RunnerState:   redis.RunnerStateIdle,
+		IsConnected:   infra == redis.VMInfraRunning,
+		LastHeartbeat: time.Now(),
+		IdleSince:     idleSince,
+	})
+	if err != nil {
+		t.Fatalf("Update(%s): %v", vmID, err)
+	}
+}
+
+// putBusyVM stores a connected VM in zone running jobID
+func putBusyVM(t *testing.T, store *redis.MemoryVMStatusStore, vmID, zone, jobID string) {
+	t.Helper()
+	err := store.Update(context.Background(), &redis.VMStatus{
+		VMID:          vmID,
+		PoolID:        "pool-test",
+		Zone:          zone,
+		InfraState:    redis.VMInfraRunning,
+		MigletState:   redis.MigletStateDraining,
+		RunnerState:   redis.RunnerStateRunning,
+		CurrentJobID:  jobID,
+		IsConnected:   true,
+		LastHeartbeat: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Update(%s): %v", vmID, err)
+	}
+}
+
+func TestDrainAndRecycleDrainsThenDeletes(t *testing.T) {
+	m, compute, sender, store := newTestManager(t, testConfig("us-central1-a"))
+	putBusyVM(t, store, "vm-1", "us-central1-a", "")
+
+	if err := m.DrainAndRecycle(context.Background(), "vm-1", "test"); err != nil {
+		t.Fatalf("DrainAndRecycle: %v", err)
+	}
+
+	if len(sender.sent) != 1 || sender.sent[0].GetType() != "drain" {
+		t.Fatalf("sent commands = %v, want one drain", sender.sent)
+	}
+	want := "zones/us-central1-a/instances/vm-1"
+	if got := compute.deletedInstances(); len(got) != 1 || got[0] != want {
+		t.Fatalf("deleted instances = %v, want [%s]", got, want)
+	}
+	if status, _ := store.Get(context.Background(), "vm-1"); status != nil {
+		t.Errorf("VM status still stored after recycle: %+v", status)
+	}
+}
+
+func TestDrainAndRecycleDeletesAfterJobFinishes(t *testing.T) {
+	m, compute, sender, store := newTestManager(t, testConfig("us-central1-a"))
+	sender.ack = &commands.CommandAck{Success: true, Result: map[string]string{"job_running": "true"}}
+	// The job finished by the time the manager checks
+	putBusyVM(t, store, "vm-1", "us-central1-a", "")
+	if err := store.SetConnected(context.Background(), "vm-1", false); err != nil {
+		t.Fatalf("SetConnected: %v", err)
+	}
+
+	if err := m.DrainAndRecycle(context.Background(), "vm-1", "test"); err != nil {
+		t.Fatalf("DrainAndRecycle: %v", err)
+	}
+	if got := compute.deletedInstances(); len(got) != 1 {
+		t.Fatalf("deleted instances = %v, want one", got)
+	}
+}
+
+func TestDrainAndRecycleDeletesAfterDrainTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		ack  *commands.CommandAck
+		err  error
+	}{
+		{
+			name: "job still running",
+			ack:  &commands.CommandAck{Success: true, Result: map[string]string{"job_running": "true"}},
+		},
+		{
+			name: "drain ack timed out",
+			err:  grpcserver.ErrCommandTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig("us-central1-a")
+			cfg.VMManager.DrainTimeout = 50 * time.Millisecond
+			m, compute, sender, store := newTestManager(t, cfg)
+			sender.ack, sender.err = tc.ack, tc.err
+			putBusyVM(t, store, "vm-1", "us-central1-a", "job-1")
+
+			if err := m.DrainAndRecycle(context.Background(), "vm-1", "test"); err != nil {
+				t.Fatalf("DrainAndRecycle: %v", err)
+			}
+
+			// The VM is deleted rather than left draining
+			if got := compute.deletedInstances(); len(got) != 1 {
+				t.Fatalf("deleted instances = %v, want one", got)
+			}
+			if status, _ := store.Get(context.Background(), "vm-1"); status != nil {
+				t.Errorf("VM status still stored after recycle: %+v", status)
+			}
+		})
+	}
+}
+
+func TestDrainAndRecycleStopsWhenContextCancelled(t *testing.T) {
+	m, compute, sender, store := newTestManager(t, testConfig("us-central1-a"))
+	sender.ack = &commands.CommandAck{Success: true, Result: map[string]string{"job_running": "true"}}
+	putBusyVM(t, store, "vm-1", "us-central1-a", "job-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := m.DrainAndRecycle(ctx, "vm-1", "test"); err == nil {
+		t.Fatal("DrainAndRecycle succeeded after its context was cancelled")
+	}
+	if got := compute.deletedInstances(); len(got) != 0 {
+		t.Errorf("deleted instances = %v, want none on shutdown", got)
+	}
+}
+
+func TestDrainAndRecycleRejectedDrainKeepsVM(t *testing.T) {
+	m, compute, sender, store := newTestManager(t, testConfig("us-central1-a"))
+	sender.ack = &commands.CommandAck{Success: false, Message: "unsupported"}
+	putBusyVM(t, store, "vm-1", "us-central1-a", "")
+
+	if err := m.DrainAndRecycle(context.Background(), "vm-1", "test"); err == nil {
+		t.Fatal("DrainAndRecycle succeeded after the drain was rejected")
+	}
+	if got := compute.deletedInstances(); len(got) != 0 {
+		t.Errorf("deleted instances = %v, want none", got)
+	}
+}
+
+func TestStartVMStartsInstanceInItsZone(t *testing.T) {
+	m, compute, _, store := newTestManager(t, testConfig("us-central1-a", "us-central1-b"))
+	putVM(t, store, "vm-1", "us-central1-b", redis.VMInfraStopped, redis.MigletStateUnknown, time.Time{})
+
+	if err := m.StartVM(context.Background(), "vm-1"); err != nil {
+		t.Fatalf("StartVM: %v", err)
+	}
+
+	if len(compute.started) != 1 {
+		t.Fatalf("Start called %d times, want 1", len(compute.started))
+	}
+	req := compute.started[0]
+	if req.GetProject() != "project-test" || req.GetZone() != "us-central1-b" || req.GetInstance() != "vm-1" {
+		t.Errorf("Start request = %v, want project-test/us-central1-b/vm-1", req)
+	}
+	status, err := store.Get(context.Background(), "vm-1")
+	if err != nil || status == nil {
+		t.Fatalf("Get(vm-1) = %v, %v", status, err)
+	}
+	if status.InfraState != redis.VMInfraStaging {
+		t.Errorf("infra state = %s, want %s", status.InfraState, redis.VMInfraStaging)
+	}
+}
+
+func TestScaleUpFillsSmallestMIGFirst(t *testing.T) {
+	m, compute, _, _ := newTestManager(t, testConfig("us-central1-a", "us-central1-b"))
+	compute.sizes["mig-us-central1-a"] = 2
+
+	if err := m.ScaleUp(context.Background(), 3); err != nil {
+		t.Fatalf("ScaleUp: %v", err)
+	}
+
+	got := map[string]int32{}
+	for _, req := range compute.resized {
+		got[req.GetInstanceGroupManager()] = req.GetSize()
+	}
+	want := map[string]int32{"mig-us-central1-a": 3, "mig-us-central1-b": 2}
+	if len(got) != len(want) || got["mig-us-central1-a"] != 3 || got["mig-us-central1-b"] != 2 {
+		t.Errorf("resized to %v, want %v", got, want)
+	}
+}
+
+func TestScaleUpPlansOnTopOfCachedSizes(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MIGSizeCacheTTL = time.Minute
+	m, compute, _, _ := newTestManager(t, cfg)
+
+	// GCP hasn't applied the first resize yet when the second one is planned
+	for i := 0; i < 2; i++ {
+		if err := m.ScaleUp(context.Background(), 1); err != nil {
+			t.Fatalf("ScaleUp #%d: %v", i+1, err)
+		}
+		compute.sizes["mig-us-central1-a"] = 0
+	}
+
+	if len(compute.resized) != 2 || compute.resized[1].GetSize() != 2 {
+		t.Errorf("resize requests = %v, want sizes 1 then 2", compute.resized)
+	}
+}
+
+func TestScaleUpRefusesToExceedMaxVMs(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MaxVMs = 3
+	m, compute, _, _ := newTestManager(t, cfg)
+	compute.sizes["mig-us-central1-a"] = 2
+
+	if err := m.ScaleUp(context.Background(), 2); err == nil {
+		t.Fatal("ScaleUp past MaxVMs succeeded")
+	}
+	if compute.resizeCalls != 0 {
+		t.Errorf("Resize called %d times, want 0", compute.resizeCalls)
+	}
+}
+
+func TestScaleUpPausesAfterQuotaError(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.QuotaBackoff = time.Minute
+	m, compute, _, _ := newTestManager(t, cfg)
+	compute.resizeErr = errors.New("googleapi: Error 403: QUOTA_EXCEEDED")
+
+	if err := m.ScaleUp(context.Background(), 1); !errors.Is(err, ErrQuotaExhausted) {
+		t.Fatalf("ScaleUp error = %v, want ErrQuotaExhausted", err)
+	}
+	if err := m.ScaleUp(context.Background(), 1); !errors.Is(err, ErrQuotaExhausted) {
+		t.Fatalf("ScaleUp during backoff error = %v, want ErrQuotaExhausted", err)
+	}
+	if compute.resizeCalls != 1 {
+		t.Errorf("Resize called %d times, want 1 during the quota backoff", compute.resizeCalls)
+	}
+}
+
+func TestEnsureMinReadyVMsStartsStoppedVMsBeforeScalingUp(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MinReadyVMs = 3
+	m, compute, _, store := newTestManager(t, cfg)
+	compute.sizes["mig-us-central1-a"] = 2
+	putVM(t, store, "vm-ready", "us-central1-a", redis.VMInfraRunning, redis.MigletStateReady, time.Time{})
+	putVM(t, store, "vm-stopped", "us-central1-a", redis.VMInfraStopped, redis.MigletStateUnknown, time.Time{})
+
+	if err := m.EnsureMinReadyVMs(context.Background()); err != nil {
+		t.Fatalf("EnsureMinReadyVMs: %v", err)
+	}
+
+	if len(compute.started) != 1 || compute.started[0].GetInstance() != "vm-stopped" {
+		t.Errorf("started = %v, want vm-stopped", compute.started)
+	}
+	if len(compute.resized) != 1 || compute.resized[0].GetSize() != 3 {
+		t.Errorf("resize requests = %v, want one to size 3", compute.resized)
+	}
+}
+
+func TestCleanupIdleVMsStopsLongestIdleAboveMinimum(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MinReadyVMs = 1
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, sender, store := newTestManager(t, cfg)
+	now := time.Now()
+	putVM(t, store, "vm-recent", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, now.Add(-2*time.Minute))
+	putVM(t, store, "vm-oldest", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, now.Add(-time.Hour))
+	putVM(t, store, "vm-fresh", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, now)
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+
+	// Two VMs are over the idle timeout, and stopping both would still leave vm-fresh
+	var stopped []string
+	for _, req := range compute.stopped {
+		stopped = append(stopped, req.GetInstance())
+	}
+	if len(stopped) != 2 || stopped[0] != "vm-oldest" || stopped[1] != "vm-recent" {
+		t.Errorf("stopped = %v, want [vm-oldest vm-recent]", stopped)
+	}
+	for _, cmd := range sender.sent {
+		if cmd.GetType() != "drain" || !cmd.GetBoolParams()["if_idle"] {
+			t.Errorf("sent %s %v, want drain if_idle before stopping", cmd.GetType(), cmd.GetBoolParams())
+		}
+	}
+}
+
+func TestCleanupIdleVMsKeepsMinimumReady(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MinReadyVMs = 1
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, _, store := newTestManager(t, cfg)
+	now := time.Now()
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, now.Add(-time.Hour))
+	putVM(t, store, "vm-2", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, now.Add(-2*time.Hour))
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if len(compute.stopped) != 1 || compute.stopped[0].GetInstance() != "vm-2" {
+		t.Errorf("stopped = %v, want only vm-2", compute.stopped)
+	}
+}
+
+func TestCleanupIdleVMsSkipsVMThatPickedUpJob(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, sender, store := newTestManager(t, cfg)
+	sender.ack = &commands.CommandAck{Success: false, Result: map[string]string{"job_running": "true"}}
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, time.Now().Add(-time.Hour))
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if len(compute.stopped) != 0 {
+		t.Errorf("stopped = %v, want none", compute.stopped)
+	}
+}
+
+func TestCleanupIdleVMsRetriesFailedStop(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, sender, store := newTestManager(t, cfg)
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, time.Now().Add(-time.Hour))
+	compute.stopErr = errors.New("backend error")
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if got := m.GetStats()["stop_retries"]; got != 1 {
+		t.Fatalf("stop_retries = %v, want 1", got)
+	}
+
+	// The drained VM now reports draining, so it is no longer in the idle set
+	if err := store.SetMigletState(context.Background(), "vm-1", redis.MigletStateDraining); err != nil {
+		t.Fatalf("SetMigletState: %v", err)
+	}
+	compute.stopErr = nil
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if len(compute.stopped) != 1 || compute.stopped[0].GetInstance() != "vm-1" {
+		t.Errorf("stopped = %v, want vm-1 on the retry", compute.stopped)
+	}
+	if len(sender.sent) != 1 {
+		t.Errorf("sent %d commands, want only the first drain", len(sender.sent))
+	}
+	if got := m.GetStats()["stop_retries"]; got != 0 {
+		t.Errorf("stop_retries = %v after the retry, want 0", got)
+	}
+}
+
+func TestCleanupIdleVMsDropsRetryForStoppedVM(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, _, store := newTestManager(t, cfg)
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, time.Now().Add(-time.Hour))
+	compute.stopErr = errors.New("backend error")
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+
+	// The stop went through in GCP after all
+	if err := store.UpdateFromInfra(context.Background(), "vm-1", "us-central1-a", redis.VMInfraStopped); err != nil {
+		t.Fatalf("UpdateFromInfra: %v", err)
+	}
+	compute.stopErr = nil
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if len(compute.stopped) != 0 {
+		t.Errorf("stopped = %v, want no retry for a stopped VM", compute.stopped)
+	}
+	if got := m.GetStats()["stop_retries"]; got != 0 {
+		t.Errorf("stop_retries = %v, want 0", got)
+	}
+}
+
+func TestCleanupIdleVMsStopsMIGletWithoutDrainSupport(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.IdleTimeout = time.Minute
+	m, compute, sender, store := newTestManager(t, cfg)
+	sender.err = fmt.Errorf("%w: MIGlet v1 does not handle drain", grpcserver.ErrCommandUnsupported)
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, time.Now().Add(-time.Hour))
+
+	if err := m.CleanupIdleVMs(context.Background()); err != nil {
+		t.Fatalf("CleanupIdleVMs: %v", err)
+	}
+	if len(compute.stopped) != 1 || compute.stopped[0].GetInstance() != "vm-1" {
+		t.Errorf("stopped = %v, want vm-1 without a drain", compute.stopped)
+	}
+}
+
+func TestDrainAndRecycleMIGletWithoutDrainSupport(t *testing.T) {
+	m, compute, sender, store := newTestManager(t, testConfig("us-central1-a"))
+	sender.err = fmt.Errorf("%w: MIGlet v1 does not handle drain", grpcserver.ErrCommandUnsupported)
+	putVM(t, store, "vm-1", "us-central1-a", redis.VMInfraRunning, redis.MigletStateIdle, time.Now())
+
+	if err := m.DrainAndRecycle(context.Background(), "vm-1", "test"); err != nil {
+		t.Fatalf("DrainAndRecycle: %v", err)
+	}
+	if got := compute.deletedInstances(); len(got) != 1 {
+		t.Errorf("deleted instances = %v, want one", got)
+	}
+}
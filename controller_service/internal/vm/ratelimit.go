@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"sync"
+	"time"
+)
+
+// scaleUpWindow is the sliding window MaxScaleUpPerMinute applies to
+const scaleUpWindow = time.Minute
+
+// scaleUpRequest records VMs requested from the MIG at a point in time
+type scaleUpRequest struct {
+	at    time.Time
+	count int
+}
+
+// scaleUpLimiter caps how many VMs can be requested within a sliding window
+// Reservations are atomic so concurrent callers can't exceed the limit together
+type scaleUpLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	requests []scaleUpRequest
+}
+
+func newScaleUpLimiter(limit int, window time.Duration) *scaleUpLimiter {
+	return &scaleUpLimiter{
+		limit:  limit,
+		window: window,
+	}
+}
+
+// reserve claims up to n VMs from the current window and returns how many were granted
+func (l *scaleUpLimiter) reserve(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	granted := min(n, l.limit-l.used())
+	if granted <= 0 {
+		return 0
+	}
+
+	l.requests = append(l.requests, scaleUpRequest{at: now, count: granted})
+	return granted
+}
+
+// release returns a reservation that wasn't used (e.g. the resize call failed)
+func (l *scaleUpLimiter) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Undo from the newest reservation backwards
+	for i := len(l.requests) - 1; i >= 0 && n > 0; i-- {
+		take := min(n, l.requests[i].count)
+		l.requests[i].count -= take
+		n -= take
+	}
+}
+
+// remaining returns how many VMs can still be requested in the current window
+func (l *scaleUpLimiter) remaining() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(time.Now())
+	return max(l.limit-l.used(), 0)
+}
+
+// used returns the VMs requested in the current window; callers hold mu
+func (l *scaleUpLimiter) used() int {
+	total := 0
+	for _, r := range l.requests {
+		total += r.count
+	}
+	return total
+}
+
+// prune drops requests that fell out of the window; callers hold mu
+func (l *scaleUpLimiter) prune(now time.Time) {
+	cutoff := now.Add(-l.window)
+	i := 0
+	for i < len(l.requests) && !l.requests[i].at.After(cutoff) {
+		i++
+	}
+	l.requests = l.requests[i:]
+}
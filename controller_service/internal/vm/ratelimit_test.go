@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScaleUpLimiterSlidingWindow(t *testing.T) {
+	l := newScaleUpLimiter(3, 50*time.Millisecond)
+
+	if got := l.reserve(5); got != 3 {
+		t.Fatalf("reserve(5) = %d, want 3", got)
+	}
+	if got := l.reserve(1); got != 0 {
+		t.Fatalf("reserve(1) in a full window = %d, want 0", got)
+	}
+
+	// An unused reservation is given back
+	l.release(2)
+	if got := l.remaining(); got != 2 {
+		t.Fatalf("remaining after release = %d, want 2", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := l.remaining(); got != 3 {
+		t.Fatalf("remaining after the window passed = %d, want 3", got)
+	}
+}
+
+func TestEnsureMinReadyVMsThrottlesRapidTicks(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MinReadyVMs = 5
+	cfg.VMManager.MaxScaleUpPerMinute = 3
+	m, compute, _, _ := newTestManager(t, cfg)
+
+	// New VMs take longer than a tick to become ready, so every tick sees the full deficit
+	for i := 0; i < 5; i++ {
+		if err := m.EnsureMinReadyVMs(context.Background()); err != nil {
+			t.Fatalf("tick %d: EnsureMinReadyVMs: %v", i+1, err)
+		}
+	}
+
+	if compute.resizeCalls != 1 {
+		t.Errorf("Resize called %d times, want 1 within the minute", compute.resizeCalls)
+	}
+	if got := compute.sizes["mig-us-central1-a"]; got != 3 {
+		t.Errorf("MIG size = %d, want 3 (MaxScaleUpPerMinute)", got)
+	}
+	// The first tick is short 2 VMs, the next four all 5
+	stats := m.GetStats()
+	if got := stats["throttled_scale_ups"]; got != int64(2+4*5) {
+		t.Errorf("throttled_scale_ups = %v, want %d", got, 2+4*5)
+	}
+	if got := stats["scale_up_remaining"]; got != 0 {
+		t.Errorf("scale_up_remaining = %v, want 0", got)
+	}
+}
+
+func TestEnsureMinReadyVMsReleasesFailedScaleUp(t *testing.T) {
+	cfg := testConfig("us-central1-a")
+	cfg.VMManager.MinReadyVMs = 2
+	cfg.VMManager.MaxScaleUpPerMinute = 2
+	cfg.VMManager.MaxVMs = 1
+	m, compute, _, _ := newTestManager(t, cfg)
+
+	// Scaling past MaxVMs fails, so the reservation is returned for the next tick
+	if err := m.EnsureMinReadyVMs(context.Background()); err == nil {
+		t.Fatal("EnsureMinReadyVMs past MaxVMs succeeded")
+	}
+	if got := m.GetStats()["scale_up_remaining"]; got != 2 {
+		t.Errorf("scale_up_remaining = %v, want 2 after the failed scale-up", got)
+	}
+	if compute.resizeCalls != 0 {
+		t.Errorf("Resize called %d times, want 0", compute.resizeCalls)
+	}
+}
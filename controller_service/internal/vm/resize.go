@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// ErrQuotaExhausted is returned (wrapped) when GCP refuses new instances for lack of quota
+// Callers should back off rather than retry on their next tick
+var ErrQuotaExhausted = errors.New("GCP quota exhausted")
+
+// resizePollInterval is how often waitForResize checks a MIG while it creates instances
+const resizePollInterval = 2 * time.Second
+
+// waitForResize waits for a resize operation to finish and for the MIG to create the
+// new instances, returning the first creation error GCP records after since
+// A MIG still creating instances when ScaleUpWaitTimeout passes is not an error
+func (m *Manager) waitForResize(ctx context.Context, target config.MIGTarget, op Operation, since time.Time) error {
+	waitCtx, cancel := context.WithTimeout(ctx, m.cfg.VMManager.ScaleUpWaitTimeout)
+	defer cancel()
+
+	if err := op.Wait(waitCtx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if waitCtx.Err() != nil {
+			return nil // Accepted but slow; provisioning continues asynchronously
+		}
+		return classifyGCPError(fmt.Errorf("resize operation failed: %w", err))
+	}
+	if opErr := op.Proto().GetError(); opErr != nil && len(opErr.GetErrors()) > 0 {
+		first := opErr.GetErrors()[0]
+		return classifyGCPError(fmt.Errorf("resize operation failed: %s: %s", first.GetCode(), first.GetMessage()))
+	}
+
+	ticker := time.NewTicker(resizePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.recentCreateError(waitCtx, target, since); err != nil {
+			return err
+		}
+		if mig, err := m.getMIG(waitCtx, target); err == nil && mig.GetStatus().GetIsStable() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-waitCtx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// recentCreateError returns the first instance creation error the MIG recorded after since
+func (m *Manager) recentCreateError(ctx context.Context, target config.MIGTarget, since time.Time) error {
+	igmErrors, err := m.migClient.ListErrors(ctx, &computepb.ListErrorsInstanceGroupManagersRequest{
+		Project:              m.cfg.GCP.ProjectID,
+		Zone:                 target.Zone,
+		InstanceGroupManager: target.MIGName,
+	})
+	if err != nil {
+		return nil // Best effort; the MIG status poll still bounds the wait
+	}
+
+	for _, igmErr := range igmErrors {
+		at, err := time.Parse(time.RFC3339, igmErr.GetTimestamp())
+		if err != nil || at.Before(since) {
+			continue
+		}
+		if igmErr.GetInstanceActionDetails().GetAction() != "CREATING" {
+			continue
+		}
+
+		return classifyGCPError(fmt.Errorf("failed to create instance in MIG %s: %s: %s",
+			target.MIGName, igmErr.GetError().GetCode(), igmErr.GetError().GetMessage()))
+	}
+	return nil
+}
+
+// classifyGCPError wraps err with ErrQuotaExhausted when GCP rejected it for quota
+func classifyGCPError(err error) error {
+	if err == nil || errors.Is(err, ErrQuotaExhausted) {
+		return err
+	}
+	msg := strings.ToUpper(err.Error())
+	if strings.Contains(msg, "QUOTA_EXCEEDED") || strings.Contains(msg, "QUOTA EXCEEDED") {
+		return fmt.Errorf("%w: %w", ErrQuotaExhausted, err)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package vm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// cachedSize is a MIG target size and when it was read or set
+type cachedSize struct {
+	size int
+	at   time.Time
+}
+
+// migSizeCache remembers MIG target sizes so bursts of ScaleUp calls don't each need a Get
+// Entries are set after our own resizes, refreshed from GCP by RefreshVMList,
+// and dropped when we change a MIG in a way whose resulting size we don't know
+type migSizeCache struct {
+	ttl time.Duration // 0 disables caching
+
+	mu    sync.Mutex
+	sizes map[string]cachedSize // Keyed by migKey
+}
+
+func newMIGSizeCache(ttl time.Duration) *migSizeCache {
+	return &migSizeCache{
+		ttl:   ttl,
+		sizes: make(map[string]cachedSize),
+	}
+}
+
+// get returns the cached size and its age, if present and fresher than the TTL
+func (c *migSizeCache) get(target config.MIGTarget) (int, time.Duration, bool) {
+	if c.ttl <= 0 {
+		return 0, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.sizes[migKey(target)]
+	if !ok {
+		return 0, 0, false
+	}
+	age := time.Since(entry.at)
+	if age >= c.ttl {
+		return 0, 0, false
+	}
+	return entry.size, age, true
+}
+
+// set records a known target size, returning the previous cached size (-1 if none)
+func (c *migSizeCache) set(target config.MIGTarget, size int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := -1
+	if entry, ok := c.sizes[migKey(target)]; ok {
+		previous = entry.size
+	}
+	c.sizes[migKey(target)] = cachedSize{size: size, at: time.Now()}
+	return previous
+}
+
+// invalidate drops the cached size so the next read goes to GCP
+func (c *migSizeCache) invalidate(target config.MIGTarget) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sizes, migKey(target))
+}
+
+func migKey(target config.MIGTarget) string {
+	return target.Zone + "/" + target.MIGName
+}
+
+// targetSize returns the MIG's target size, from the cache when fresh
+// The second return value is the age of the cached value, or 0 if it was just read from GCP
+func (m *Manager) targetSize(ctx context.Context, target config.MIGTarget) (int, time.Duration, error) {
+	if size, age, ok := m.sizeCache.get(target); ok {
+		return size, age, nil
+	}
+
+	size, err := m.readTargetSize(ctx, target)
+	if err != nil {
+		return 0, 0, err
+	}
+	m.sizeCache.set(target, size)
+	return size, 0, nil
+}
+
+// readTargetSize reads the MIG's target size from GCP, bypassing the cache
+func (m *Manager) readTargetSize(ctx context.Context, target config.MIGTarget) (int, error) {
+	if m.cfg.VMManager.DryRun {
+		return m.dryRunTargetSize(ctx, target)
+	}
+	mig, err := m.getMIG(ctx, target)
+	if err != nil {
+		return 0, err
+	}
+	return int(mig.GetTargetSize()), nil
+}
+
+// refreshTargetSize reads the MIG's target size from GCP into the cache
+// A value different from the cached one means the MIG was resized outside our own
+// resizes (e.g. by an operator or the autohealer), so it is logged
+func (m *Manager) refreshTargetSize(ctx context.Context, target config.MIGTarget) error {
+	size, err := m.readTargetSize(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if previous := m.sizeCache.set(target, size); previous >= 0 && previous != size {
+		logger.WithComponent("vm_manager").WithFields(map[string]interface{}{
+			"mig_name":    target.MIGName,
+			"zone":        target.Zone,
+			"cached_size": previous,
+			"target_size": size,
+		}).Info("MIG target size changed outside the controller, cache reconciled")
+	}
+	return nil
+}
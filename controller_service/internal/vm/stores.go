@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"context"
+	"time"
+
+	grpcserver "github.com/monkci/mig-controller/internal/grpc"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/proto/commands"
+)
+
+// VMStateStore is the VM status persistence the manager uses
+// *redis.VMStatusStore implements it against Redis, and *redis.MemoryVMStatusStore in memory for tests
+type VMStateStore interface {
+	Get(ctx context.Context, vmID string) (*redis.VMStatus, error)
+	GetAll(ctx context.Context) ([]*redis.VMStatus, error)
+	GetStats(ctx context.Context) (*redis.PoolStats, error)
+	GetFirstReady(ctx context.Context, orgID string) (*redis.VMStatus, error)
+	GetFirstStopped(ctx context.Context, orgID string) (*redis.VMStatus, error)
+	GetByEffectiveState(ctx context.Context, state redis.EffectiveState) ([]*redis.VMStatus, error)
+	GetByEffectiveStateOrdered(ctx context.Context, state redis.EffectiveState, order redis.VMOrder) ([]*redis.VMStatus, error)
+
+	UpdateFromInfra(ctx context.Context, vmID, zone string, infraState redis.VMInfraState) error
+	Delete(ctx context.Context, vmID string) error
+}
+
+// CommandSender delivers commands to connected MIGlets and waits for their ack
+// *grpcserver.Server implements it
+type CommandSender interface {
+	SendCommandAs(issuer, vmID string, cmd *commands.Command, timeout time.Duration) (*commands.CommandAck, error)
+}
+
+var (
+	_ VMStateStore  = (*redis.VMStatusStore)(nil)
+	_ VMStateStore  = (*redis.MemoryVMStatusStore)(nil)
+	_ CommandSender = (*grpcserver.Server)(nil)
+)
@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monkci/mig-controller/internal/config"
+	"github.com/monkci/mig-controller/internal/pubsub"
+	"github.com/monkci/mig-controller/internal/redis"
+	"github.com/monkci/mig-controller/pkg/logger"
+)
+
+// maxPayloadBytes caps the webhook body size (GitHub's documented limit is 25MB,
+// workflow_job payloads are a few KB)
+const maxPayloadBytes = 1 << 20
+
+// WorkflowJobEvent is the subset of GitHub's workflow_job webhook payload we use
+type WorkflowJobEvent struct {
+	Action      string `json:"action"`
+	WorkflowJob struct {
+		ID     int64    `json:"id"`
+		RunID  int64    `json:"run_id"`
+		Labels []string `json:"labels"`
+	} `json:"workflow_job"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Owner    struct {
+			ID    int64  `json:"id"`
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Organization *struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	} `json:"organization"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+// GitHubHandler receives GitHub workflow_job webhooks and enqueues queued jobs
+// It is an alternative ingestion path to the Pub/Sub subscriber
+type GitHubHandler struct {
+	cfg      *config.Config
+	secret   []byte
+	jobStore *redis.JobStore
+}
+
+// NewGitHubHandler creates a new GitHub webhook handler
+func NewGitHubHandler(cfg *config.Config, jobStore *redis.JobStore) *GitHubHandler {
+	return &GitHubHandler{
+		cfg:      cfg,
+		secret:   []byte(cfg.GitHubApp.WebhookSecret),
+		jobStore: jobStore,
+	}
+}
+
+// ServeHTTP handles POST /webhooks/github
+func (h *GitHubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := logger.WithComponent("github_webhook")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadBytes+1))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPayloadBytes {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !VerifySignature(h.secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		log.WithField("delivery", r.Header.Get("X-GitHub-Delivery")).Warn("Rejected webhook with invalid signature")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := r.Header.Get("X-GitHub-Event")
+	if eventType == "ping" {
+		writeResult(w, "pong")
+		return
+	}
+	if eventType != "workflow_job" {
+		writeResult(w, "ignored")
+		return
+	}
+
+	var event WorkflowJobEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if event.Action != "queued" {
+		writeResult(w, "ignored")
+		return
+	}
+
+	if !h.cfg.MatchesPoolLabels(event.WorkflowJob.Labels) {
+		log.WithFields(map[string]interface{}{
+			"job_id": event.WorkflowJob.ID,
+			"labels": event.WorkflowJob.Labels,
+		}).Debug("Job labels don't match this pool, ignoring")
+		writeResult(w, "ignored")
+		return
+	}
+
+	jobMsg := ToJobMessage(&event)
+	if err := pubsub.ValidateJobMessage(jobMsg); err != nil {
+		log.WithError(err).Warn("Invalid workflow_job payload, dropping")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.WithFields(map[string]interface{}{
+		"org_id":          jobMsg.OrgID,
+		"repo":            jobMsg.RepoFullName,
+		"job_id":          jobMsg.JobID,
+		"installation_id": jobMsg.InstallationID,
+	}).Info("Received workflow_job webhook")
+
+	enqueued, err := pubsub.EnqueueJobMessage(r.Context(), h.jobStore, h.cfg.Pool.ID, jobMsg)
+	if err != nil {
+		log.WithError(err).Error("Failed to enqueue webhook job")
+		// 5xx lets GitHub's redelivery retry the delivery
+		http.Error(w, "failed to enqueue job", http.StatusInternalServerError)
+		return
+	}
+
+	if !enqueued {
+		writeResult(w, "duplicate")
+		return
+	}
+	writeResult(w, "enqueued")
+}
+
+// VerifySignature checks a GitHub X-Hub-Signature-256 header against the payload
+// An empty secret never verifies, so an unconfigured endpoint rejects everything
+func VerifySignature(secret, payload []byte, signatureHeader string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	signature, ok := strings.CutPrefix(signatureHeader, "sha256=")
+	if !ok {
+		return false
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// ToJobMessage maps a workflow_job event onto the job message used by the Pub/Sub path
+func ToJobMessage(event *WorkflowJobEvent) *pubsub.JobMessage {
+	orgID := event.Repository.Owner.ID
+	orgName := event.Repository.Owner.Login
+	if event.Organization != nil {
+		orgID = event.Organization.ID
+		orgName = event.Organization.Login
+	}
+
+	msg := &pubsub.JobMessage{
+		OrgName:        orgName,
+		InstallationID: event.Installation.ID,
+		RepoFullName:   event.Repository.FullName,
+		RunID:          event.WorkflowJob.RunID,
+		JobID:          event.WorkflowJob.ID,
+		Labels:         event.WorkflowJob.Labels,
+		ReceivedAt:     time.Now().Unix(),
+	}
+	if orgID != 0 {
+		msg.OrgID = strconv.FormatInt(orgID, 10)
+	}
+
+	return msg
+}
+
+// writeResult writes a small JSON status body
+func writeResult(w http.ResponseWriter, result string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"result":%q}`, result)
+}
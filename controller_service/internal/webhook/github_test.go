@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monkci/mig-controller/internal/config"
+)
+
+// sign returns the X-Hub-Signature-256 header GitHub sends for payload
+func sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("webhook-secret")
+	payload := []byte(`{"action":"queued"}`)
+	valid := sign(secret, payload)
+
+	cases := []struct {
+		name    string
+		secret  []byte
+		payload []byte
+		header  string
+		want    bool
+	}{
+		{name: "valid", secret: secret, payload: payload, header: valid, want: true},
+		{name: "uppercase hex", secret: secret, payload: payload, header: "sha256=" + strings.ToUpper(strings.TrimPrefix(valid, "sha256=")), want: true},
+		{name: "wrong secret", secret: []byte("other-secret"), payload: payload, header: valid},
+		{name: "tampered payload", secret: secret, payload: []byte(`{"action":"completed"}`), header: valid},
+		{name: "missing header", secret: secret, payload: payload, header: ""},
+		{name: "sha1 prefix", secret: secret, payload: payload, header: "sha1=" + strings.TrimPrefix(valid, "sha256=")},
+		{name: "no prefix", secret: secret, payload: payload, header: strings.TrimPrefix(valid, "sha256=")},
+		{name: "not hex", secret: secret, payload: payload, header: "sha256=not-hex"},
+		{name: "truncated", secret: secret, payload: payload, header: valid[:len(valid)-2]},
+		{name: "empty secret", secret: nil, payload: payload, header: sign(nil, payload)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := VerifySignature(tc.secret, tc.payload, tc.header); got != tc.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTPChecksSignatureBeforeHandling(t *testing.T) {
+	secret := "webhook-secret"
+	payload := []byte(`{"zen":"Keep it logically awesome."}`)
+
+	cases := []struct {
+		name      string
+		secret    string
+		signature string
+		want      int
+	}{
+		{name: "valid", secret: secret, signature: sign([]byte(secret), payload), want: http.StatusOK},
+		{name: "bad signature", secret: secret, signature: sign([]byte("other-secret"), payload), want: http.StatusUnauthorized},
+		{name: "missing header", secret: secret, want: http.StatusUnauthorized},
+		{name: "wrong prefix", secret: secret, signature: strings.Replace(sign([]byte(secret), payload), "sha256=", "sha1=", 1), want: http.StatusUnauthorized},
+		{name: "secret not configured", secret: "", signature: sign(nil, payload), want: http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.GitHubApp.WebhookSecret = tc.secret
+			h := NewGitHubHandler(cfg, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(payload))
+			req.Header.Set("X-GitHub-Event", "ping")
+			if tc.signature != "" {
+				req.Header.Set("X-Hub-Signature-256", tc.signature)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeHTTPRejectsNonPost(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.GitHubApp.WebhookSecret = "webhook-secret"
+	rec := httptest.NewRecorder()
+	NewGitHubHandler(cfg, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/webhooks/github", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
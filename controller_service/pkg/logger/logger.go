@@ -1,17 +1,23 @@
 package logger
 
 import (
+	"io"
 	"os"
 
 	"github.com/sirupsen/logrus"
 )
 
-var log *logrus.Logger
+var (
+	log     *logrus.Logger
+	format  string
+	logFile io.Closer // Open log file, closed when the output changes
+)
 
-// Init initializes the logger with the specified level and format
-func Init(level, format string) {
+// Init initializes the logger with the specified level, format and output
+// If out can't be opened the logger falls back to stdout and the error is returned
+func Init(level, logFormat string, out Output) error {
 	log = logrus.New()
-	log.SetOutput(os.Stdout)
+	format = logFormat
 
 	// Set log level
 	switch level {
@@ -27,7 +33,36 @@ func Init(level, format string) {
 		log.SetLevel(logrus.InfoLevel)
 	}
 
-	// Set formatter
+	if err := SetOutput(out); err != nil {
+		setWriter(os.Stdout, true)
+		return err
+	}
+	return nil
+}
+
+// SetOutput redirects the logger
+// If out can't be opened the current output is kept and the error is returned
+func SetOutput(out Output) error {
+	w, terminal, err := openOutput(out)
+	if err != nil {
+		return err
+	}
+
+	// Swap first so nothing writes to the old file after it's closed
+	setWriter(w, terminal)
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if c, ok := w.(io.Closer); ok && !terminal {
+		logFile = c
+	}
+	return nil
+}
+
+// setWriter sets the output and picks a formatter to suit it
+func setWriter(w io.Writer, terminal bool) {
+	// Set formatter; colors only on terminals, they'd be noise in a log file
 	if format == "json" {
 		log.SetFormatter(&logrus.JSONFormatter{
 			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
@@ -36,16 +71,19 @@ func Init(level, format string) {
 		log.SetFormatter(&logrus.TextFormatter{
 			FullTimestamp:   true,
 			TimestampFormat: "15:04:05",
-			ForceColors:     true,
+			ForceColors:     terminal,
+			DisableColors:   !terminal,
 			PadLevelText:    true,
 		})
 	}
+
+	log.SetOutput(w)
 }
 
 // Get returns the logger instance
 func Get() *logrus.Logger {
 	if log == nil {
-		Init("info", "text")
+		Init("info", "text", Output{})
 	}
 	return log
 }
@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Output selects where logs are written
+type Output struct {
+	Path       string // "stdout" (default), "stderr", or a file path
+	MaxSizeMB  int    // Rotate the file once it reaches this size; 0 disables rotation
+	MaxBackups int    // Rotated files to keep, as <path>.1 (newest) to <path>.N
+}
+
+// openOutput returns the writer for out and whether it is a terminal stream
+func openOutput(out Output) (io.Writer, bool, error) {
+	switch out.Path {
+	case "", "stdout":
+		return os.Stdout, true, nil
+	case "stderr":
+		return os.Stderr, true, nil
+	}
+
+	f, err := openRotatingFile(out.Path, out.MaxSizeMB, out.MaxBackups)
+	if err != nil {
+		return nil, false, err
+	}
+	return f, false, nil
+}
+
+// rotatingFile appends to a log file and rotates it when it grows past maxSize
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile opens path for appending, creating its directory if needed
+func openRotatingFile(path string, maxSizeMB, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating first if it would push the file past maxSize
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than dropping lines
+			fmt.Fprintf(os.Stderr, "failed to rotate log file %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// open opens the log file for appending and records its current size
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts <path>.N up by one, moves the current file to <path>.1, and reopens
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			f.open()
+			return fmt.Errorf("failed to rename log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		f.open()
+		return fmt.Errorf("failed to remove log file: %w", err)
+	}
+
+	return f.open()
+}
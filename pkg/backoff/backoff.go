@@ -0,0 +1,87 @@
+package backoff
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Backoff computes exponentially increasing delays with optional jitter
+type Backoff struct {
+	base    time.Duration
+	max     time.Duration
+	jitter  float64 // Fraction of the delay to randomize (0 disables jitter)
+	attempt int
+	mu      sync.Mutex
+}
+
+// New creates a new backoff with the given base delay, max delay and jitter fraction
+// Jitter is clamped to [0, 1]; a max smaller than base is raised to base
+func New(base, max time.Duration, jitter float64) *Backoff {
+	if base <= 0 {
+		base = time.Second
+	}
+	if max < base {
+		max = base
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return &Backoff{
+		base:   base,
+		max:    max,
+		jitter: jitter,
+	}
+}
+
+// Next returns the delay for the current attempt and advances the attempt counter
+// The delay is base * 2^attempt capped at max, then reduced by up to jitter * delay
+func (b *Backoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delay := b.delayFor(b.attempt)
+	b.attempt++
+
+	if b.jitter > 0 {
+		spread := int64(float64(delay) * b.jitter)
+		if spread > 0 {
+			delay -= time.Duration(rand.Int64N(spread + 1))
+		}
+	}
+
+	return delay
+}
+
+// Reset resets the attempt counter so the next delay starts from base again
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempt = 0
+}
+
+// Attempt returns the number of delays handed out since the last reset
+func (b *Backoff) Attempt() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempt
+}
+
+// delayFor returns the un-jittered delay for the given attempt
+func (b *Backoff) delayFor(attempt int) time.Duration {
+	delay := b.base
+	for i := 0; i < attempt; i++ {
+		// Stop doubling once we hit the cap (also guards against overflow)
+		if delay >= b.max/2 {
+			return b.max
+		}
+		delay *= 2
+	}
+	if delay > b.max {
+		return b.max
+	}
+	return delay
+}
@@ -0,0 +1,70 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDoublesUpToMax(t *testing.T) {
+	b := New(time.Second, 10*time.Second, 0)
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, w := range want {
+		if got := b.Next(); got != w*time.Second {
+			t.Fatalf("attempt %d: delay = %v, want %v", i, got, w*time.Second)
+		}
+	}
+	if got := b.Attempt(); got != len(want) {
+		t.Errorf("Attempt = %d, want %d", got, len(want))
+	}
+}
+
+func TestResetStartsFromBase(t *testing.T) {
+	b := New(time.Second, time.Minute, 0)
+	b.Next()
+	b.Next()
+	b.Reset()
+	if got := b.Attempt(); got != 0 {
+		t.Fatalf("Attempt after Reset = %d, want 0", got)
+	}
+	if got := b.Next(); got != time.Second {
+		t.Errorf("delay after Reset = %v, want %v", got, time.Second)
+	}
+}
+
+func TestDelayForDoesNotOverflow(t *testing.T) {
+	b := New(time.Second, time.Hour, 0)
+	if got := b.delayFor(1000); got != time.Hour {
+		t.Errorf("delayFor(1000) = %v, want %v", got, time.Hour)
+	}
+}
+
+func TestNextJitterStaysWithinBounds(t *testing.T) {
+	const jitter = 0.5
+	for attempt := 0; attempt < 6; attempt++ {
+		ceiling := New(time.Second, 10*time.Second, 0).delayFor(attempt)
+		floor := ceiling - time.Duration(float64(ceiling)*jitter)
+		for i := 0; i < 200; i++ {
+			b := New(time.Second, 10*time.Second, jitter)
+			b.attempt = attempt
+			if got := b.Next(); got < floor || got > ceiling {
+				t.Fatalf("attempt %d: delay = %v, want within [%v, %v]", attempt, got, floor, ceiling)
+			}
+		}
+	}
+}
+
+func TestNewClampsArguments(t *testing.T) {
+	b := New(0, 0, 2)
+	if b.base != time.Second || b.max != time.Second || b.jitter != 1 {
+		t.Fatalf("New(0, 0, 2) = base %v, max %v, jitter %v, want 1s, 1s, 1", b.base, b.max, b.jitter)
+	}
+	if b := New(time.Second, time.Minute, -1); b.jitter != 0 {
+		t.Errorf("jitter = %v, want negative jitter clamped to 0", b.jitter)
+	}
+	// Full jitter may go down to zero but never above the delay
+	for i := 0; i < 200; i++ {
+		if got := New(time.Second, time.Second, 1).Next(); got < 0 || got > time.Second {
+			t.Fatalf("full jitter delay = %v, want within [0, 1s]", got)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package buildinfo
+
+// Version and BuildTime identify the running MIGlet build
+// main sets them from its ldflags-injected values at startup
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
@@ -1,12 +1,15 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/monkci/miglet/pkg/metadata"
 )
 
 // Config holds all MIGlet configuration
@@ -16,12 +19,22 @@ type Config struct {
 	VMID   string `mapstructure:"vm_id"`
 	OrgID  string `mapstructure:"org_id"`
 
+	// Location (filled from the GCE metadata server when available)
+	Zone   string `mapstructure:"zone"`
+	Region string `mapstructure:"region"`
+
 	// MIG Controller
 	Controller ControllerConfig `mapstructure:"controller"`
 
 	// GitHub Runner (metadata only, no credentials)
 	GitHub GitHubConfig `mapstructure:"github"`
 
+	// Runner installation
+	Runner RunnerConfig `mapstructure:"runner"`
+
+	// Prerequisite checks run before connecting
+	Preflight PreflightConfig `mapstructure:"preflight"`
+
 	// Behavior
 	Heartbeat HeartbeatConfig `mapstructure:"heartbeat"`
 	Shutdown  ShutdownConfig  `mapstructure:"shutdown"`
@@ -32,6 +45,9 @@ type Config struct {
 	// Metrics
 	Metrics MetricsConfig `mapstructure:"metrics"`
 
+	// Local HTTP status endpoint
+	StatusServer StatusServerConfig `mapstructure:"status_server"`
+
 	// Storage
 	Storage StorageConfig `mapstructure:"storage"`
 }
@@ -51,11 +67,31 @@ type MongoDBConfig struct {
 
 // ControllerConfig holds MIG Controller configuration
 type ControllerConfig struct {
-	Endpoint     string        `mapstructure:"endpoint"`      // HTTP endpoint (legacy, used for gRPC derivation)
-	GRPCEndpoint string        `mapstructure:"grpc_endpoint"` // gRPC endpoint (e.g., "localhost:50051")
-	Auth         AuthConfig    `mapstructure:"auth"`
-	Timeout      time.Duration `mapstructure:"timeout"`
-	Retry        RetryConfig   `mapstructure:"retry"`
+	Endpoint     string                   `mapstructure:"endpoint"`      // HTTP endpoint (legacy, used for gRPC derivation)
+	GRPCEndpoint string                   `mapstructure:"grpc_endpoint"` // gRPC endpoint (e.g., "localhost:50051")
+	Auth         AuthConfig               `mapstructure:"auth"`
+	Timeout      time.Duration            `mapstructure:"timeout"`  // Per-request timeout for HTTP calls
+	Timeouts     map[string]time.Duration `mapstructure:"timeouts"` // Per-operation overrides of Timeout, keyed by operation
+	Retry        RetryConfig              `mapstructure:"retry"`
+
+	// MaxMessageSize caps gRPC messages in bytes, both directions; event data and
+	// command results are truncated to fit. Keep in line with the controller's limit
+	MaxMessageSize int `mapstructure:"max_message_size"`
+
+	// HTTPFallback sends events and heartbeats to Endpoint's /api/v1/vms/... API when
+	// gRPC isn't available. Turn off if the controller's server.http_fallback is disabled
+	HTTPFallback bool `mapstructure:"http_fallback"`
+}
+
+// ControllerOperations are the HTTP calls whose timeout can be set in controller.timeouts
+var ControllerOperations = []string{"registration_token", "event", "heartbeat", "poll_commands"}
+
+// TimeoutFor returns the request timeout for a controller operation, falling back to Timeout
+func (c ControllerConfig) TimeoutFor(op string) time.Duration {
+	if d, ok := c.Timeouts[op]; ok && d > 0 {
+		return d
+	}
+	return c.Timeout
 }
 
 // AuthConfig holds authentication configuration
@@ -69,6 +105,7 @@ type RetryConfig struct {
 	MaxAttempts    int           `mapstructure:"max_attempts"`
 	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
 	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	Jitter         float64       `mapstructure:"jitter"` // Fraction of each backoff delay to randomize (0-1)
 }
 
 // GitHubConfig holds GitHub runner configuration (metadata only)
@@ -79,6 +116,35 @@ type GitHubConfig struct {
 	TokenSource  string        `mapstructure:"token_source"`  // "controller" or "metadata"
 	MetadataPath string        `mapstructure:"metadata_path"` // If token_source is "metadata"
 	Timeout      time.Duration `mapstructure:"registration_timeout"`
+	MaxJobsPerVM int           `mapstructure:"max_jobs_per_vm"` // Jobs after which the VM retires; 0 means no limit
+}
+
+// RunnerConfig holds GitHub Actions runner installation configuration
+type RunnerConfig struct {
+	BaseDir         string `mapstructure:"base_dir"`         // Install directory; must be writable and exec-capable
+	Version         string `mapstructure:"version"`          // Runner release, e.g. "2.329.0"
+	Platform        string `mapstructure:"platform"`         // e.g. "linux-x64", "linux-arm64", "win-x64" (empty = detect)
+	SHA256          string `mapstructure:"sha256"`           // Expected archive checksum (overrides the built-in table)
+	AllowUnverified bool   `mapstructure:"allow_unverified"` // Skip checksum verification (air-gapped mirrors)
+	PrebakedPath    string `mapstructure:"prebaked_path"`    // Runner baked into the image; skips download/extract when valid
+	ForceReinstall  bool   `mapstructure:"force_reinstall"`  // Reinstall on every boot even if a matching runner exists
+	WatchDiag       bool   `mapstructure:"watch_diag"`       // Take job events from _diag/Worker_*.log rather than stdout
+
+	// Download (proxies are taken from HTTP_PROXY/HTTPS_PROXY/NO_PROXY)
+	DownloadBaseURL  string        `mapstructure:"download_base_url"` // Release mirror; archives are fetched from <base>/v<version>/<archive>
+	DownloadTimeout  time.Duration `mapstructure:"download_timeout"`
+	DownloadAttempts int           `mapstructure:"download_attempts"`
+}
+
+// PreflightConfig controls the prerequisite checks run during initialization
+// Each check can be disabled individually; a failed enabled check moves MIGlet to the error state
+type PreflightConfig struct {
+	ControllerCheck bool          `mapstructure:"controller_check"` // TCP reachability of the controller gRPC endpoint
+	DockerCheck     bool          `mapstructure:"docker_check"`     // Presence of the Docker socket
+	DockerSocket    string        `mapstructure:"docker_socket"`
+	DNSCheck        bool          `mapstructure:"dns_check"` // DNS resolution of DNSHost
+	DNSHost         string        `mapstructure:"dns_host"`  // Host or base URL to resolve (e.g. a GHES URL)
+	Timeout         time.Duration `mapstructure:"timeout"`   // Overall budget for all checks
 }
 
 // HeartbeatConfig holds heartbeat configuration
@@ -89,8 +155,13 @@ type HeartbeatConfig struct {
 
 // ShutdownConfig holds shutdown configuration
 type ShutdownConfig struct {
-	GracePeriod time.Duration `mapstructure:"grace_period"`
-	ForceAfter  time.Duration `mapstructure:"force_after"`
+	GracePeriod    time.Duration `mapstructure:"grace_period"`
+	ForceAfter     time.Duration `mapstructure:"force_after"`
+	JobCancelGrace time.Duration `mapstructure:"job_cancel_grace"` // Time a cancelled job gets to run post steps before SIGKILL
+
+	// Spot/preemptible VMs: poll the metadata server and report vm_preempted before shutdown
+	WatchPreemption        bool          `mapstructure:"watch_preemption"`
+	PreemptionPollInterval time.Duration `mapstructure:"preemption_poll_interval"`
 }
 
 // LoggingConfig holds logging configuration
@@ -98,6 +169,14 @@ type LoggingConfig struct {
 	Level         string `mapstructure:"level"`  // "debug", "info", "warn", "error"
 	Format        string `mapstructure:"format"` // "json" or "text"
 	RedactSecrets bool   `mapstructure:"redact_secrets"`
+	OutputPath    string `mapstructure:"output_path"` // "stdout", "stderr", or a file path
+	MaxSizeMB     int    `mapstructure:"max_size_mb"` // Rotate the log file at this size; 0 disables rotation
+	MaxBackups    int    `mapstructure:"max_backups"` // Rotated log files to keep
+
+	// Runner output kept in memory for crash reports and log tails; the oldest lines
+	// are dropped once either limit is exceeded
+	RunnerLogLines int `mapstructure:"runner_log_lines"`
+	RunnerLogBytes int `mapstructure:"runner_log_bytes"` // 0 disables the byte limit
 }
 
 // MetricsConfig holds metrics collection configuration
@@ -107,10 +186,17 @@ type MetricsConfig struct {
 	IncludeNetwork     bool          `mapstructure:"include_network"`
 }
 
+// StatusServerConfig holds the local HTTP status endpoint configuration
+type StatusServerConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	BindAddress string `mapstructure:"bind_address"` // e.g. "127.0.0.1" for local-only access
+	Port        int    `mapstructure:"port"`
+}
+
 // Load loads configuration from multiple sources (priority order):
 // 1. Environment variables (MIGLET_*)
 // 2. Config file
-// 3. Metadata server (future)
+// 3. GCE metadata server (only when vm_id or pool_id are still unset)
 func Load(configPath string) (*Config, error) {
 	v := viper.New()
 
@@ -129,6 +215,12 @@ func Load(configPath string) (*Config, error) {
 	if val := os.Getenv("MIGLET_ORG_ID"); val != "" {
 		v.Set("org_id", val)
 	}
+	if val := os.Getenv("MIGLET_ZONE"); val != "" {
+		v.Set("zone", val)
+	}
+	if val := os.Getenv("MIGLET_REGION"); val != "" {
+		v.Set("region", val)
+	}
 	if val := os.Getenv("MIGLET_CONTROLLER_ENDPOINT"); val != "" {
 		v.Set("controller.endpoint", val)
 	}
@@ -144,6 +236,17 @@ func Load(configPath string) (*Config, error) {
 	if val := os.Getenv("MIGLET_CONTROLLER_TIMEOUT"); val != "" {
 		v.Set("controller.timeout", val)
 	}
+	if val := os.Getenv("MIGLET_CONTROLLER_MAX_MESSAGE_SIZE"); val != "" {
+		v.Set("controller.max_message_size", val)
+	}
+	if val := os.Getenv("MIGLET_CONTROLLER_HTTP_FALLBACK"); val != "" {
+		v.Set("controller.http_fallback", val == "true" || val == "1")
+	}
+	for _, op := range ControllerOperations {
+		if val := os.Getenv("MIGLET_CONTROLLER_TIMEOUT_" + strings.ToUpper(op)); val != "" {
+			v.Set("controller.timeouts."+op, val)
+		}
+	}
 	if val := os.Getenv("MIGLET_GITHUB_ORG"); val != "" {
 		v.Set("github.org", val)
 	}
@@ -167,6 +270,60 @@ func Load(configPath string) (*Config, error) {
 	if val := os.Getenv("MIGLET_GITHUB_REGISTRATION_TIMEOUT"); val != "" {
 		v.Set("github.registration_timeout", val)
 	}
+	if val := os.Getenv("MIGLET_GITHUB_MAX_JOBS_PER_VM"); val != "" {
+		v.Set("github.max_jobs_per_vm", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_BASE_DIR"); val != "" {
+		v.Set("runner.base_dir", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_VERSION"); val != "" {
+		v.Set("runner.version", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_PLATFORM"); val != "" {
+		v.Set("runner.platform", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_SHA256"); val != "" {
+		v.Set("runner.sha256", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_ALLOW_UNVERIFIED"); val != "" {
+		v.Set("runner.allow_unverified", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_RUNNER_PREBAKED_PATH"); val != "" {
+		v.Set("runner.prebaked_path", val)
+	}
+	if val := os.Getenv("MIGLET_RUNNER_FORCE_REINSTALL"); val != "" {
+		v.Set("runner.force_reinstall", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_RUNNER_WATCH_DIAG"); val != "" {
+		v.Set("runner.watch_diag", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_RUNNER_DOWNLOAD_BASE_URL"); val != "" {
+		v.Set("runner.download_base_url", val)
+	}
+	if val := os.Getenv("MIGLET_PREFLIGHT_CONTROLLER_CHECK"); val != "" {
+		v.Set("preflight.controller_check", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_PREFLIGHT_DOCKER_CHECK"); val != "" {
+		v.Set("preflight.docker_check", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_PREFLIGHT_DOCKER_SOCKET"); val != "" {
+		v.Set("preflight.docker_socket", val)
+	}
+	if val := os.Getenv("MIGLET_PREFLIGHT_DNS_CHECK"); val != "" {
+		v.Set("preflight.dns_check", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_PREFLIGHT_DNS_HOST"); val != "" {
+		v.Set("preflight.dns_host", val)
+	}
+	if val := os.Getenv("MIGLET_SHUTDOWN_JOB_CANCEL_GRACE"); val != "" {
+		v.Set("shutdown.job_cancel_grace", val)
+	}
+	if val := os.Getenv("MIGLET_SHUTDOWN_WATCH_PREEMPTION"); val != "" {
+		v.Set("shutdown.watch_preemption", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_SHUTDOWN_PREEMPTION_POLL_INTERVAL"); val != "" {
+		v.Set("shutdown.preemption_poll_interval", val)
+	}
 	if val := os.Getenv("MIGLET_LOGGING_LEVEL"); val != "" {
 		v.Set("logging.level", val)
 	}
@@ -176,6 +333,30 @@ func Load(configPath string) (*Config, error) {
 	if val := os.Getenv("MIGLET_LOGGING_REDACT_SECRETS"); val != "" {
 		v.Set("logging.redact_secrets", val == "true" || val == "1")
 	}
+	if val := os.Getenv("MIGLET_LOGGING_OUTPUT_PATH"); val != "" {
+		v.Set("logging.output_path", val)
+	}
+	if val := os.Getenv("MIGLET_LOGGING_MAX_SIZE_MB"); val != "" {
+		v.Set("logging.max_size_mb", val)
+	}
+	if val := os.Getenv("MIGLET_LOGGING_MAX_BACKUPS"); val != "" {
+		v.Set("logging.max_backups", val)
+	}
+	if val := os.Getenv("MIGLET_LOGGING_RUNNER_LOG_LINES"); val != "" {
+		v.Set("logging.runner_log_lines", val)
+	}
+	if val := os.Getenv("MIGLET_LOGGING_RUNNER_LOG_BYTES"); val != "" {
+		v.Set("logging.runner_log_bytes", val)
+	}
+	if val := os.Getenv("MIGLET_STATUS_SERVER_ENABLED"); val != "" {
+		v.Set("status_server.enabled", val == "true" || val == "1")
+	}
+	if val := os.Getenv("MIGLET_STATUS_SERVER_BIND_ADDRESS"); val != "" {
+		v.Set("status_server.bind_address", val)
+	}
+	if val := os.Getenv("MIGLET_STATUS_SERVER_PORT"); val != "" {
+		v.Set("status_server.port", val)
+	}
 	if val := os.Getenv("MIGLET_STORAGE_MONGODB_ENABLED"); val != "" {
 		v.Set("storage.mongodb.enabled", val == "true" || val == "1")
 	}
@@ -197,6 +378,11 @@ func Load(configPath string) (*Config, error) {
 		}
 	}
 
+	// On GCE, fill in identity that wasn't provided via env or file
+	if v.GetString("vm_id") == "" || v.GetString("pool_id") == "" {
+		applyMetadata(v)
+	}
+
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
@@ -210,17 +396,76 @@ func Load(configPath string) (*Config, error) {
 	return &cfg, nil
 }
 
+// metadataAttributes maps custom instance metadata keys to config keys
+var metadataAttributes = map[string]string{
+	"pool-id":                  "pool_id",
+	"org-id":                   "org_id",
+	"controller-endpoint":      "controller.endpoint",
+	"controller-grpc-endpoint": "controller.grpc_endpoint",
+}
+
+// applyMetadata reads identity from the GCE metadata server
+// Values are set as defaults so env vars and the config file still take precedence
+// Off GCE the server is unreachable and nothing is applied
+func applyMetadata(v *viper.Viper) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := metadata.NewClient()
+	if !client.OnGCE(ctx) {
+		return
+	}
+
+	keys := make([]string, 0, len(metadataAttributes))
+	for key := range metadataAttributes {
+		keys = append(keys, key)
+	}
+	inst, err := client.Instance(ctx, keys...)
+	if err != nil {
+		return
+	}
+
+	v.SetDefault("vm_id", inst.Name)
+	v.SetDefault("zone", inst.Zone)
+	v.SetDefault("region", inst.Region)
+	for key, val := range inst.Attributes {
+		v.SetDefault(metadataAttributes[key], val)
+	}
+}
+
 // setDefaults sets default values for configuration
 func setDefaults(v *viper.Viper) {
 	// Controller defaults
 	v.SetDefault("controller.timeout", "30s")
+	v.SetDefault("controller.max_message_size", 4*1024*1024)
+	v.SetDefault("controller.http_fallback", true)
 	v.SetDefault("controller.retry.max_attempts", 5)
 	v.SetDefault("controller.retry.initial_backoff", "1s")
 	v.SetDefault("controller.retry.max_backoff", "30s")
+	v.SetDefault("controller.retry.jitter", 0.2)
 
 	// GitHub defaults
 	v.SetDefault("github.token_source", "controller")
 	v.SetDefault("github.registration_timeout", "60s")
+	v.SetDefault("github.max_jobs_per_vm", 1)
+
+	// Runner defaults
+	v.SetDefault("runner.base_dir", "/tmp/miglet-runner")
+	v.SetDefault("runner.version", "2.329.0")
+	v.SetDefault("runner.allow_unverified", false)
+	v.SetDefault("runner.force_reinstall", false)
+	v.SetDefault("runner.watch_diag", true)
+	v.SetDefault("runner.download_base_url", "https://github.com/actions/runner/releases/download")
+	v.SetDefault("runner.download_timeout", "10m")
+	v.SetDefault("runner.download_attempts", 3)
+
+	// Preflight defaults
+	v.SetDefault("preflight.controller_check", true)
+	v.SetDefault("preflight.docker_check", false)
+	v.SetDefault("preflight.docker_socket", "/var/run/docker.sock")
+	v.SetDefault("preflight.dns_check", true)
+	v.SetDefault("preflight.dns_host", "github.com")
+	v.SetDefault("preflight.timeout", "10s")
 
 	// Heartbeat defaults
 	v.SetDefault("heartbeat.interval", "15s")
@@ -229,17 +474,30 @@ func setDefaults(v *viper.Viper) {
 	// Shutdown defaults
 	v.SetDefault("shutdown.grace_period", "30s")
 	v.SetDefault("shutdown.force_after", "5m")
+	v.SetDefault("shutdown.job_cancel_grace", "30s")
+	v.SetDefault("shutdown.watch_preemption", true)
+	v.SetDefault("shutdown.preemption_poll_interval", "5s")
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
 	v.SetDefault("logging.redact_secrets", true)
+	v.SetDefault("logging.output_path", "stdout")
+	v.SetDefault("logging.max_size_mb", 100)
+	v.SetDefault("logging.max_backups", 5)
+	v.SetDefault("logging.runner_log_lines", 1000)
+	v.SetDefault("logging.runner_log_bytes", 1024*1024)
 
 	// Metrics defaults
 	v.SetDefault("metrics.collection_interval", "10s")
 	v.SetDefault("metrics.include_disk", true)
 	v.SetDefault("metrics.include_network", true)
 
+	// Status server defaults
+	v.SetDefault("status_server.enabled", false)
+	v.SetDefault("status_server.bind_address", "0.0.0.0")
+	v.SetDefault("status_server.port", 8090)
+
 	// Storage defaults
 	v.SetDefault("storage.mongodb.enabled", false)
 	v.SetDefault("storage.mongodb.database", "monkci")
@@ -263,6 +521,18 @@ func validate(cfg *Config) error {
 	if cfg.Controller.GRPCEndpoint == "" && cfg.Controller.Endpoint == "" {
 		return fmt.Errorf("controller.grpc_endpoint or controller.endpoint is required")
 	}
+	if cfg.Controller.MaxMessageSize <= 0 {
+		return fmt.Errorf("controller.max_message_size must be > 0")
+	}
+	if cfg.GitHub.MaxJobsPerVM < 0 {
+		return fmt.Errorf("github.max_jobs_per_vm must be >= 0")
+	}
+	if cfg.Logging.RunnerLogLines < 1 {
+		return fmt.Errorf("logging.runner_log_lines must be >= 1")
+	}
+	if cfg.Logging.RunnerLogBytes < 0 {
+		return fmt.Errorf("logging.runner_log_bytes must be >= 0")
+	}
 
 	// github.org is optional - may be provided later via controller
 	// if cfg.GitHub.Org == "" {
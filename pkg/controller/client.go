@@ -10,6 +10,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/monkci/miglet/pkg/backoff"
 	"github.com/monkci/miglet/pkg/config"
 	"github.com/monkci/miglet/pkg/events"
 	"github.com/monkci/miglet/pkg/logger"
@@ -21,16 +22,27 @@ type Client struct {
 	httpClient *http.Client
 	vmID       string
 	authToken  string
+	retry      config.RetryConfig
+	timeouts   config.ControllerConfig // Per-operation request timeouts; see TimeoutFor
 }
 
+// Operation names used to look up per-request timeouts in controller.timeouts
+const (
+	opRegistrationToken = "registration_token"
+	opEvent             = "event"
+	opHeartbeat         = "heartbeat"
+	opPollCommands      = "poll_commands"
+)
+
 // NewClient creates a new MIG Controller client
 func NewClient(cfg *config.Config) (*Client, error) {
 	client := &Client{
 		endpoint: cfg.Controller.Endpoint,
-		httpClient: &http.Client{
-			Timeout: cfg.Controller.Timeout,
-		},
-		vmID: cfg.VMID,
+		// Timeouts are applied per attempt via the request context so they can vary by operation
+		httpClient: &http.Client{},
+		vmID:       cfg.VMID,
+		retry:      cfg.Controller.Retry,
+		timeouts:   cfg.Controller,
 	}
 
 	// Load auth token if configured
@@ -45,97 +57,6 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	return client, nil
 }
 
-// VMStartedAckResponse represents the acknowledgment response from controller
-type VMStartedAckResponse struct {
-	Status            string    `json:"status"`
-	VMID              string    `json:"vm_id"`
-	Message           string    `json:"message"`
-	Acknowledged      bool      `json:"acknowledged,omitempty"`
-	RegistrationToken string    `json:"registration_token,omitempty"` // Token for runner config
-	RunnerURL         string    `json:"runner_url,omitempty"`
-	RunnerGroup       string    `json:"runner_group,omitempty"`
-	Labels            []string  `json:"labels,omitempty"`
-	ExpiresAt         time.Time `json:"expires_at,omitempty"`
-}
-
-// SendVMStartedEvent sends a VM started event to the controller
-// Returns acknowledgment response if successful, nil otherwise
-func (c *Client) SendVMStartedEvent(ctx context.Context, event *events.VMStartedEvent) (*VMStartedAckResponse, error) {
-	log := logger.WithContext(c.vmID, event.PoolID, event.OrgID)
-
-	// Marshal event to JSON
-	body, err := json.Marshal(event)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal event: %w", err)
-	}
-
-	// Create request
-	url := fmt.Sprintf("%s/api/v1/vms/%s/events", c.endpoint, c.vmID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if c.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.authToken)
-	}
-
-	// Send request
-	log.WithField("url", url).Debug("Sending VM started event")
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	// Parse response
-	var ackResponse VMStartedAckResponse
-	if err := json.Unmarshal(respBody, &ackResponse); err != nil {
-		// If response doesn't match expected format, check if status is OK
-		log.WithField("response", string(respBody)).Debug("Response doesn't match expected format")
-		// Try to parse as basic acknowledgment
-		var basicAck struct {
-			Status       string `json:"status"`
-			Acknowledged bool   `json:"acknowledged"`
-		}
-		if json.Unmarshal(respBody, &basicAck) == nil {
-			if basicAck.Acknowledged || basicAck.Status == "received" || basicAck.Status == "acknowledged" {
-				// Return minimal response if basic acknowledgment works
-				return &VMStartedAckResponse{
-					Status:       basicAck.Status,
-					Acknowledged: true,
-				}, nil
-			}
-		}
-		return nil, fmt.Errorf("failed to parse acknowledgment response: %w", err)
-	}
-
-	log.WithField("response body", string(respBody)).Debug("Received acknowledgment response body")
-	log.WithField("response", ackResponse).Debug("Received acknowledgment response")
-
-	// Check if explicitly acknowledged
-	if ackResponse.Acknowledged || ackResponse.Status == "received" || ackResponse.Status == "acknowledged" {
-		if ackResponse.RegistrationToken != "" {
-			log.WithField("token_length", len(ackResponse.RegistrationToken)).Debug("Received registration token in acknowledgment")
-		}
-		return &ackResponse, nil
-	}
-
-	return nil, fmt.Errorf("controller did not acknowledge: %s", ackResponse.Message)
-}
-
 // RequestRegistrationToken requests a registration token from the controller
 func (c *Client) RequestRegistrationToken(ctx context.Context, req *RegistrationTokenRequest) (*RegistrationTokenResponse, error) {
 	log := logger.WithContext(c.vmID, req.PoolID, "")
@@ -146,35 +67,12 @@ func (c *Client) RequestRegistrationToken(ctx context.Context, req *Registration
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create request
-	url := fmt.Sprintf("%s/api/v1/vms/%s/registration-token", c.endpoint, c.vmID)
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	if c.authToken != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+c.authToken)
-	}
-
 	// Send request
+	url := fmt.Sprintf("%s/api/v1/vms/%s/registration-token", c.endpoint, c.vmID)
 	log.WithField("url", url).Debug("Requesting registration token")
-	resp, err := c.httpClient.Do(httpReq)
+	respBody, err := c.doRequest(ctx, opRegistrationToken, http.MethodPost, url, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
+		return nil, err
 	}
 
 	// Parse response
@@ -213,33 +111,11 @@ func (c *Client) SendEvent(ctx context.Context, event interface{}) error {
 		return fmt.Errorf("failed to marshal event: %w", err)
 	}
 
-	// Create request
-	url := fmt.Sprintf("%s/api/v1/vms/%s/events", c.endpoint, c.vmID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if c.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.authToken)
-	}
-
 	// Send request
+	url := fmt.Sprintf("%s/api/v1/vms/%s/events", c.endpoint, c.vmID)
 	log.Debug("Sending event to controller")
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	return nil
+	_, err = c.doRequest(ctx, opEvent, http.MethodPost, url, body)
+	return err
 }
 
 // SendHeartbeat sends a heartbeat to the controller
@@ -252,33 +128,11 @@ func (c *Client) SendHeartbeat(ctx context.Context, heartbeat *events.HeartbeatE
 		return fmt.Errorf("failed to marshal heartbeat: %w", err)
 	}
 
-	// Create request
-	url := fmt.Sprintf("%s/api/v1/vms/%s/heartbeat", c.endpoint, c.vmID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if c.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.authToken)
-	}
-
 	// Send request
+	url := fmt.Sprintf("%s/api/v1/vms/%s/heartbeat", c.endpoint, c.vmID)
 	log.Debug("Sending heartbeat to controller")
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	return nil
+	_, err = c.doRequest(ctx, opHeartbeat, http.MethodPost, url, body)
+	return err
 }
 
 // Command represents a command from the controller
@@ -301,18 +155,100 @@ type RegisterRunnerCommandParams struct {
 	RunnerURL         string   `json:"runner_url"`
 	RunnerGroup       string   `json:"runner_group"`
 	Labels            []string `json:"labels"`
-	ExpiresAt         string   `json:"expires_at,omitempty"`
+	ExpiresAt         string   `json:"expires_at,omitempty"` // RFC3339
+}
+
+// ParseExpiresAt parses a register_runner expires_at value
+// An empty value means the controller didn't send an expiry and yields the zero time
+func ParseExpiresAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires_at %q: must be RFC3339", value)
+	}
+	return expiresAt, nil
 }
 
 // PollCommands polls the controller for pending commands
 func (c *Client) PollCommands(ctx context.Context) (*CommandsResponse, error) {
 	log := logger.WithContext(c.vmID, "", "")
 
-	// Create request
+	// Send request
 	url := fmt.Sprintf("%s/api/v1/vms/%s/commands", c.endpoint, c.vmID)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	log.Debug("Polling for commands from controller")
+	respBody, err := c.doRequest(ctx, opPollCommands, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+
+	// Parse response
+	var commandsResp CommandsResponse
+	if err := json.Unmarshal(respBody, &commandsResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &commandsResp, nil
+}
+
+// doRequest sends an HTTP request to the controller and returns the response body
+// Network errors and 5xx/429 responses are retried with exponential backoff
+// up to the configured max attempts; other non-200 responses fail immediately
+// Each attempt is bounded by the timeout configured for op
+func (c *Client) doRequest(ctx context.Context, op, method, url string, body []byte) ([]byte, error) {
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	bo := backoff.New(c.retry.InitialBackoff, c.retry.MaxBackoff, c.retry.Jitter)
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		respBody, retryable, err := c.sendOnce(ctx, c.timeouts.TimeoutFor(op), method, url, body)
+		if err == nil {
+			return respBody, nil
+		}
+		lastErr = err
+		if !retryable || attempt == attempts {
+			break
+		}
+
+		delay := bo.Next()
+		logger.WithContext(c.vmID, "", "").WithError(err).WithFields(map[string]interface{}{
+			"url":     url,
+			"attempt": attempt,
+			"delay":   delay.String(),
+		}).Debug("Controller request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	return nil, lastErr
+}
+
+// sendOnce performs a single HTTP request attempt
+// Returns the response body, whether the failure is retryable, and any error
+func (c *Client) sendOnce(ctx context.Context, timeout time.Duration, method, url string, body []byte) ([]byte, bool, error) {
+	reqCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(reqCtx, method, url, reqBody)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -320,30 +256,22 @@ func (c *Client) PollCommands(ctx context.Context) (*CommandsResponse, error) {
 		req.Header.Set("Authorization", "Bearer "+c.authToken)
 	}
 
-	// Send request
-	log.Debug("Polling for commands from controller")
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		// Don't retry if the caller gave up; a per-attempt timeout is retryable
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Read response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, true, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	// Parse response
-	var commandsResp CommandsResponse
-	if err := json.Unmarshal(respBody, &commandsResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(respBody))
 	}
 
-	return &commandsResp, nil
+	return respBody, false, nil
 }
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiresAt(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "UTC", value: "2026-10-16T14:30:00Z", want: time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)},
+		{name: "offset", value: "2026-10-16T16:30:00+02:00", want: time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)},
+		{name: "empty means unknown", value: "", want: time.Time{}},
+		{name: "unix seconds", value: "1792161000", wantErr: true},
+		{name: "date only", value: "2026-10-16", wantErr: true},
+		{name: "missing zone", value: "2026-10-16T14:30:00", wantErr: true},
+		{name: "garbage", value: "tomorrow", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseExpiresAt(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseExpiresAt(%q) error = %v, want error %v", tc.value, err, tc.wantErr)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("ParseExpiresAt(%q) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
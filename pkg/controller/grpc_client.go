@@ -3,18 +3,39 @@ package controller
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 
+	"github.com/monkci/miglet/pkg/backoff"
+	"github.com/monkci/miglet/pkg/buildinfo"
 	"github.com/monkci/miglet/pkg/config"
+	"github.com/monkci/miglet/pkg/events"
 	"github.com/monkci/miglet/pkg/logger"
 	"github.com/monkci/miglet/proto/commands"
 )
 
+// Capabilities lists the command types this MIGlet handles, reported on connect
+// so the controller never sends commands an agent can't run
+// register_runner is only listed once the runner is verified installed; it is the
+// signal that the VM can take a job. job_available goes with it, for persistent runners
+func Capabilities(runnerReady bool) []string {
+	capabilities := []string{"drain", "get_logs"}
+	if runnerReady {
+		capabilities = append(capabilities, "register_runner", "job_available")
+	}
+	// cancel_job finds the runner's worker processes via /proc
+	if runtime.GOOS != "windows" {
+		capabilities = append(capabilities, "cancel_job")
+	}
+	return capabilities
+}
+
 // GRPCClient handles gRPC bidirectional streaming with the controller
 type GRPCClient struct {
 	config          *config.Config
@@ -22,9 +43,14 @@ type GRPCClient struct {
 	client          commands.CommandServiceClient
 	stream          commands.CommandService_StreamCommandsClient
 	mu              sync.RWMutex
+	sendMu          sync.Mutex // Serializes Send/CloseSend; a gRPC stream doesn't allow them concurrently
 	connected       bool
 	shouldReconnect bool
 	commandCh       chan *commands.Command
+	backoff         *backoff.Backoff // Reconnect backoff, reset once the controller accepts us
+	backpressure    atomic.Int64     // Times a command had to wait for the state machine
+	runnerReady     atomic.Bool      // Runner installation verified; advertises register_runner
+	onConnected     func()           // Called after every accepted (re)connect
 	ctx             context.Context
 	cancel          context.CancelFunc
 }
@@ -33,9 +59,12 @@ type GRPCClient struct {
 func NewGRPCClient(cfg *config.Config) (*GRPCClient, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	retry := cfg.Controller.Retry
+
 	client := &GRPCClient{
 		config:          cfg,
 		commandCh:       make(chan *commands.Command, 10),
+		backoff:         backoff.New(retry.InitialBackoff, retry.MaxBackoff, retry.Jitter),
 		ctx:             ctx,
 		cancel:          cancel,
 		shouldReconnect: true,
@@ -48,28 +77,15 @@ func NewGRPCClient(cfg *config.Config) (*GRPCClient, error) {
 func (c *GRPCClient) Connect() error {
 	log := logger.WithContext(c.config.VMID, c.config.PoolID, c.config.OrgID)
 
-	// Get gRPC endpoint from config
-	grpcEndpoint := c.config.Controller.GRPCEndpoint
-	if grpcEndpoint == "" {
-		// Fallback: derive from HTTP endpoint
-		if c.config.Controller.Endpoint == "" {
-			return fmt.Errorf("controller gRPC endpoint not configured")
-		}
-		grpcEndpoint = convertHTTPToGRPC(c.config.Controller.Endpoint)
+	grpcEndpoint, err := ResolveGRPCEndpoint(c.config)
+	if err != nil {
+		return err
 	}
 
 	log.WithField("endpoint", grpcEndpoint).Info("Connecting to controller via gRPC")
 
 	// Create gRPC connection with keepalive
-	conn, err := grpc.NewClient(
-		grpcEndpoint,
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // TODO: Add TLS support
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:                10 * time.Second,
-			Timeout:             3 * time.Second,
-			PermitWithoutStream: true,
-		}),
-	)
+	conn, err := grpc.NewClient(grpcEndpoint, c.dialOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to create gRPC connection: %w", err)
 	}
@@ -85,6 +101,36 @@ func (c *GRPCClient) Connect() error {
 	return nil
 }
 
+// dialOptions returns the options for connecting to the controller
+func (c *GRPCClient) dialOptions() []grpc.DialOption {
+	maxSize := c.config.Controller.MaxMessageSize
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()), // TODO: Add TLS support
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                10 * time.Second,
+			Timeout:             3 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(maxSize),
+			grpc.MaxCallSendMsgSize(maxSize),
+		),
+	}
+}
+
+// SetRunnerReady records whether the runner is installed, reported in each ConnectRequest
+// Must be called before Connect for the first handshake to include it
+func (c *GRPCClient) SetRunnerReady(ready bool) {
+	c.runnerReady.Store(ready)
+}
+
+// SetOnConnected sets a callback run in its own goroutine each time the controller accepts
+// a (re)connect, e.g. to send a heartbeat so the controller's view of the VM converges
+// without waiting for the next interval. Must be called before Connect
+func (c *GRPCClient) SetOnConnected(fn func()) {
+	c.onConnected = fn
+}
+
 // createStream creates a new gRPC stream
 func (c *GRPCClient) createStream() (commands.CommandService_StreamCommandsClient, error) {
 	c.mu.RLock()
@@ -125,8 +171,10 @@ func (c *GRPCClient) streamLoop() {
 		// Reconnect only if connection is nil (not just because connected is false)
 		if conn == nil || client == nil {
 			if err := c.reconnect(); err != nil {
-				log.WithError(err).Warn("Failed to reconnect, retrying in 5s")
-				time.Sleep(5 * time.Second)
+				log.WithError(err).Warn("Failed to reconnect, backing off")
+				if !c.waitBackoff() {
+					return
+				}
 				continue
 			}
 		}
@@ -135,13 +183,15 @@ func (c *GRPCClient) streamLoop() {
 		if stream == nil {
 			newStream, err := c.createStream()
 			if err != nil {
-				log.WithError(err).Warn("Failed to create stream, retrying in 5s")
+				log.WithError(err).Warn("Failed to create stream, backing off")
 				// Mark as needing reconnection
 				c.mu.Lock()
 				c.connected = false
 				c.stream = nil
 				c.mu.Unlock()
-				time.Sleep(5 * time.Second)
+				if !c.waitBackoff() {
+					return
+				}
 				continue
 			}
 
@@ -154,10 +204,11 @@ func (c *GRPCClient) streamLoop() {
 
 		// Send connect request
 		connectReq := &commands.ConnectRequest{
-			VmId:    c.config.VMID,
-			PoolId:  c.config.PoolID,
-			OrgId:   c.config.OrgID,
-			Version: "dev", // TODO: Get from build info
+			VmId:         c.config.VMID,
+			PoolId:       c.config.PoolID,
+			OrgId:        c.config.OrgID,
+			Version:      buildinfo.Version,
+			Capabilities: Capabilities(c.runnerReady.Load()),
 		}
 
 		connectMsg := &commands.MIGletMessage{
@@ -166,13 +217,15 @@ func (c *GRPCClient) streamLoop() {
 			},
 		}
 
-		if err := stream.Send(connectMsg); err != nil {
+		if err := c.send(stream, connectMsg); err != nil {
 			log.WithError(err).Error("Failed to send connect request")
 			c.mu.Lock()
 			c.connected = false
 			c.stream = nil
 			c.mu.Unlock()
-			time.Sleep(5 * time.Second)
+			if !c.waitBackoff() {
+				return
+			}
 			continue
 		}
 
@@ -194,6 +247,9 @@ func (c *GRPCClient) streamLoop() {
 				c.connected = false
 				c.stream = nil
 				c.mu.Unlock()
+				if !c.waitBackoff() {
+					return
+				}
 				break // Break inner loop to reconnect
 			}
 
@@ -206,6 +262,10 @@ func (c *GRPCClient) streamLoop() {
 					c.mu.Lock()
 					c.connected = true
 					c.mu.Unlock()
+					c.backoff.Reset()
+					if c.onConnected != nil {
+						go c.onConnected()
+					}
 				} else {
 					log.WithField("message", ack.Message).Error("Connection rejected by controller")
 					c.mu.Lock()
@@ -221,10 +281,8 @@ func (c *GRPCClient) streamLoop() {
 					"type":       cmd.Type,
 				}).Info("Received command from controller")
 
-				select {
-				case c.commandCh <- cmd:
-				default:
-					log.Warn("Command channel full, dropping command")
+				if !c.deliverCommand(cmd) {
+					return
 				}
 			case *commands.ControllerMessage_Error:
 				errMsg := m.Error
@@ -237,6 +295,54 @@ func (c *GRPCClient) streamLoop() {
 	}
 }
 
+// deliverCommand hands a command to the state machine, blocking while the channel is full
+// Commands are never dropped; returns false only if the client was closed while waiting
+func (c *GRPCClient) deliverCommand(cmd *commands.Command) bool {
+	select {
+	case c.commandCh <- cmd:
+		return true
+	default:
+	}
+
+	c.backpressure.Add(1)
+	logger.WithContext(c.config.VMID, c.config.PoolID, c.config.OrgID).
+		WithFields(map[string]interface{}{
+			"command_id":  cmd.Id,
+			"type":        cmd.Type,
+			"queue_depth": len(c.commandCh),
+		}).Warn("Command channel full, waiting for state machine to catch up")
+
+	select {
+	case c.commandCh <- cmd:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// BackpressureCount returns how many commands had to wait for a full command channel
+func (c *GRPCClient) BackpressureCount() int64 {
+	return c.backpressure.Load()
+}
+
+// waitBackoff sleeps for the next backoff delay
+// Returns false if the client was closed while waiting
+func (c *GRPCClient) waitBackoff() bool {
+	delay := c.backoff.Next()
+	logger.WithContext(c.config.VMID, c.config.PoolID, c.config.OrgID).
+		WithFields(map[string]interface{}{
+			"delay":   delay.String(),
+			"attempt": c.backoff.Attempt(),
+		}).Debug("Waiting before next connection attempt")
+
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
 // reconnect attempts to reconnect to the controller
 func (c *GRPCClient) reconnect() error {
 	log := logger.WithContext(c.config.VMID, c.config.PoolID, c.config.OrgID)
@@ -251,15 +357,7 @@ func (c *GRPCClient) reconnect() error {
 	endpoint := convertHTTPToGRPC(c.config.Controller.Endpoint)
 	log.WithField("endpoint", endpoint).Info("Reconnecting to controller")
 
-	conn, err := grpc.NewClient(
-		endpoint,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:                10 * time.Second,
-			Timeout:             3 * time.Second,
-			PermitWithoutStream: true,
-		}),
-	)
+	conn, err := grpc.NewClient(endpoint, c.dialOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to reconnect: %w", err)
 	}
@@ -270,6 +368,13 @@ func (c *GRPCClient) reconnect() error {
 	return nil
 }
 
+// IsConnected reports whether the controller has accepted the stream
+func (c *GRPCClient) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
 // GetCommandChannel returns the channel for receiving commands
 func (c *GRPCClient) GetCommandChannel() <-chan *commands.Command {
 	return c.commandCh
@@ -289,7 +394,7 @@ func (c *GRPCClient) SendCommandAck(commandID string, success bool, message stri
 		CommandId: commandID,
 		Success:   success,
 		Message:   message,
-		Result:    result,
+		Result:    copyData(result),
 	}
 
 	msg := &commands.MIGletMessage{
@@ -297,12 +402,17 @@ func (c *GRPCClient) SendCommandAck(commandID string, success bool, message stri
 			CommandAck: ack,
 		},
 	}
+	if err := fitData(msg, ack.Result, c.config.Controller.MaxMessageSize); err != nil {
+		return fmt.Errorf("command ack too large: %w", err)
+	}
 
-	return stream.Send(msg)
+	return c.send(stream, msg)
 }
 
 // SendEvent sends an event notification to the controller
-func (c *GRPCClient) SendEvent(eventType, vmID, poolID, orgID string, data map[string]string) error {
+// base supplies the event ID, type and identity; data is the event-specific payload.
+// Callers falling back to HTTP send the same event so the controller can drop the duplicate
+func (c *GRPCClient) SendEvent(base events.Event, data map[string]string) error {
 	c.mu.RLock()
 	stream := c.stream
 	c.mu.RUnlock()
@@ -312,12 +422,13 @@ func (c *GRPCClient) SendEvent(eventType, vmID, poolID, orgID string, data map[s
 	}
 
 	event := &commands.EventNotification{
-		Type:      eventType,
-		VmId:      vmID,
-		PoolId:    poolID,
-		OrgId:     orgID,
-		Data:      data,
-		Timestamp: time.Now().Unix(),
+		Type:      string(base.Type),
+		VmId:      base.VMID,
+		PoolId:    base.PoolID,
+		OrgId:     base.OrgID,
+		Data:      copyData(data),
+		Timestamp: base.Timestamp.Unix(),
+		EventId:   base.EventID,
 	}
 
 	msg := &commands.MIGletMessage{
@@ -325,8 +436,11 @@ func (c *GRPCClient) SendEvent(eventType, vmID, poolID, orgID string, data map[s
 			Event: event,
 		},
 	}
+	if err := fitData(msg, event.Data, c.config.Controller.MaxMessageSize); err != nil {
+		return fmt.Errorf("event %s too large: %w", base.Type, err)
+	}
 
-	return stream.Send(msg)
+	return c.send(stream, msg)
 }
 
 // SendHeartbeat sends a heartbeat to the controller
@@ -355,7 +469,19 @@ func (c *GRPCClient) SendHeartbeat(vmID, poolID, orgID, migletState string, heal
 			Heartbeat: heartbeat,
 		},
 	}
+	if err := checkSize(msg, c.config.Controller.MaxMessageSize); err != nil {
+		return fmt.Errorf("heartbeat too large: %w", err)
+	}
+
+	return c.send(stream, msg)
+}
 
+// send sends msg on stream
+// Heartbeats, events and acks are sent from different goroutines (the heartbeat loop,
+// runner callbacks, the state machine), so every send goes through sendMu
+func (c *GRPCClient) send(stream commands.CommandService_StreamCommandsClient, msg *commands.MIGletMessage) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 	return stream.Send(msg)
 }
 
@@ -368,7 +494,9 @@ func (c *GRPCClient) Close() error {
 	c.cancel()
 
 	if c.stream != nil {
+		c.sendMu.Lock()
 		c.stream.CloseSend()
+		c.sendMu.Unlock()
 	}
 
 	if c.conn != nil {
@@ -378,6 +506,18 @@ func (c *GRPCClient) Close() error {
 	return nil
 }
 
+// ResolveGRPCEndpoint returns the controller gRPC address from config
+// Falls back to deriving it from the HTTP endpoint when grpc_endpoint is unset
+func ResolveGRPCEndpoint(cfg *config.Config) (string, error) {
+	if cfg.Controller.GRPCEndpoint != "" {
+		return cfg.Controller.GRPCEndpoint, nil
+	}
+	if cfg.Controller.Endpoint == "" {
+		return "", fmt.Errorf("controller gRPC endpoint not configured")
+	}
+	return convertHTTPToGRPC(cfg.Controller.Endpoint), nil
+}
+
 // convertHTTPToGRPC converts HTTP endpoint to gRPC endpoint
 func convertHTTPToGRPC(endpoint string) string {
 	// Remove http:// or https://
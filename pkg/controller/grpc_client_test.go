@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monkci/miglet/pkg/config"
+	"github.com/monkci/miglet/pkg/events"
+	"github.com/monkci/miglet/proto/commands"
+)
+
+// recordingStream is a controller stream that records whether Send was ever entered
+// by two callers at once
+type recordingStream struct {
+	commands.CommandService_StreamCommandsClient // Only Send is used
+
+	inFlight   atomic.Int32
+	concurrent atomic.Bool
+	sent       atomic.Int32
+}
+
+func (r *recordingStream) Send(msg *commands.MIGletMessage) error {
+	if r.inFlight.Add(1) > 1 {
+		r.concurrent.Store(true)
+	}
+	defer r.inFlight.Add(-1)
+	time.Sleep(100 * time.Microsecond) // Widen the window for an overlapping Send
+	r.sent.Add(1)
+	return nil
+}
+
+func TestSendsAreSerializedOnTheStream(t *testing.T) {
+	cfg := &config.Config{VMID: "vm-1", PoolID: "pool-test"}
+	cfg.Controller.MaxMessageSize = 4 * 1024 * 1024
+	client, err := NewGRPCClient(cfg)
+	if err != nil {
+		t.Fatalf("NewGRPCClient: %v", err)
+	}
+	defer client.cancel()
+	stream := &recordingStream{}
+	client.stream = stream
+
+	const perKind = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*perKind)
+	for i := 0; i < perKind; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := client.SendHeartbeat("vm-1", "pool-test", "", "idle", &commands.VMHealth{}, nil, nil); err != nil {
+				errs <- fmt.Errorf("heartbeat: %w", err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			base := events.Event{EventID: fmt.Sprintf("event-%d", i), Type: events.EventTypeJobStarted, VMID: "vm-1", PoolID: "pool-test", Timestamp: time.Now()}
+			if err := client.SendEvent(base, map[string]string{"job_id": "42"}); err != nil {
+				errs <- fmt.Errorf("event: %w", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := client.SendCommandAck(fmt.Sprintf("cmd-%d", i), true, "", nil); err != nil {
+				errs <- fmt.Errorf("ack: %w", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if stream.concurrent.Load() {
+		t.Error("Send called concurrently on one stream")
+	}
+	if got := stream.sent.Load(); got != 3*perKind {
+		t.Errorf("sent %d messages, want %d", got, 3*perKind)
+	}
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/monkci/miglet/proto/commands"
+)
+
+// messageHeadroom is kept free below the max message size for gRPC framing
+const messageHeadroom = 1024
+
+// truncatedMarker prefixes a value that was cut to fit the message size limit
+const truncatedMarker = "[truncated] "
+
+// truncatedKey is set in event data and ack results that had values cut
+const truncatedKey = "truncated"
+
+// fitData shrinks the largest values in data until msg fits in limit bytes
+// Values keep their end (the newest lines of a log tail) behind truncatedMarker.
+// Returns an error if msg still doesn't fit, e.g. when the other fields alone are too large
+func fitData(msg *commands.MIGletMessage, data map[string]string, limit int) error {
+	budget := limit - messageHeadroom
+	for {
+		over := proto.Size(msg) - budget
+		if over <= 0 {
+			return nil
+		}
+
+		key := largestValue(data)
+		if key == "" {
+			return fmt.Errorf("message is %d bytes over the %d byte limit", over, limit)
+		}
+		value := data[key]
+		data[key] = truncateValue(value, len(value)-over-len(truncatedMarker))
+		data[truncatedKey] = "true"
+	}
+}
+
+// copyData returns a copy of data that can be truncated without touching the caller's map
+func copyData(data map[string]string) map[string]string {
+	if data == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+// largestValue returns the key of the longest value that can still be shrunk, or ""
+func largestValue(data map[string]string) string {
+	largest := ""
+	for key, value := range data {
+		if key == truncatedKey || len(value) <= len(truncatedMarker) {
+			continue
+		}
+		if largest == "" || len(value) > len(data[largest]) {
+			largest = key
+		}
+	}
+	return largest
+}
+
+// truncateValue keeps at most the last keep bytes of value, prefixed with truncatedMarker
+// The cut is moved forward to a rune boundary, since proto strings must be valid UTF-8
+func truncateValue(value string, keep int) string {
+	if keep < 0 {
+		keep = 0
+	}
+	start := len(value) - keep
+	for start < len(value) && !utf8.RuneStart(value[start]) {
+		start++
+	}
+	return truncatedMarker + value[start:]
+}
+
+// checkSize returns an error if msg is too large to send within limit bytes
+// Sending an oversized message would abort the stream, so callers drop it instead
+func checkSize(msg *commands.MIGletMessage, limit int) error {
+	if size := proto.Size(msg); size > limit-messageHeadroom {
+		return fmt.Errorf("message of %d bytes exceeds the %d byte limit", size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/monkci/miglet/pkg/config"
+	"github.com/monkci/miglet/proto/commands"
+)
+
+// heartbeatOfSize returns a heartbeat message whose encoded size is exactly size bytes
+func heartbeatOfSize(t *testing.T, size int) *commands.MIGletMessage {
+	t.Helper()
+	hb := &commands.Heartbeat{VmId: "vm-1", PoolId: "pool-test"}
+	msg := &commands.MIGletMessage{Message: &commands.MIGletMessage_Heartbeat{Heartbeat: hb}}
+	// Grow the state until the length prefixes settle at the requested size
+	for pad := size - proto.Size(msg); pad != 0; pad = size - proto.Size(msg) {
+		if len(hb.MigletState)+pad < 0 {
+			t.Fatalf("cannot build a %d byte heartbeat", size)
+		}
+		hb.MigletState = strings.Repeat("x", len(hb.MigletState)+pad)
+	}
+	return msg
+}
+
+func eventMessage(data map[string]string) *commands.MIGletMessage {
+	return &commands.MIGletMessage{Message: &commands.MIGletMessage_Event{Event: &commands.EventNotification{
+		Type: "job_failed",
+		VmId: "vm-1",
+		Data: data,
+	}}}
+}
+
+func TestCheckSize(t *testing.T) {
+	const limit = 64 * 1024
+	budget := limit - messageHeadroom
+
+	if err := checkSize(heartbeatOfSize(t, budget), limit); err != nil {
+		t.Errorf("heartbeat at the limit rejected: %v", err)
+	}
+	if err := checkSize(heartbeatOfSize(t, budget+1), limit); err == nil {
+		t.Error("heartbeat over the limit accepted")
+	}
+}
+
+func TestFitDataLeavesSmallMessagesAlone(t *testing.T) {
+	data := map[string]string{"job_id": "42", "log_tail": "done"}
+	if err := fitData(eventMessage(data), data, 64*1024); err != nil {
+		t.Fatalf("fitData: %v", err)
+	}
+	if data["log_tail"] != "done" {
+		t.Errorf("log_tail = %q, want it untouched", data["log_tail"])
+	}
+	if _, ok := data[truncatedKey]; ok {
+		t.Error("small message marked truncated")
+	}
+}
+
+func TestFitDataTruncatesLargestValueKeepingItsEnd(t *testing.T) {
+	const limit = 16 * 1024
+	tail := strings.Repeat("é", 4*limit) + "last line"
+	data := map[string]string{"job_id": "42", "log_tail": tail}
+	msg := eventMessage(data)
+
+	if err := fitData(msg, data, limit); err != nil {
+		t.Fatalf("fitData: %v", err)
+	}
+	if size := proto.Size(msg); size > limit-messageHeadroom {
+		t.Errorf("message is %d bytes, want at most %d", size, limit-messageHeadroom)
+	}
+	got := data["log_tail"]
+	if !strings.HasPrefix(got, truncatedMarker) || !strings.HasSuffix(got, "last line") {
+		t.Errorf("log_tail = %.40q..., want the marker and the newest lines", got)
+	}
+	if !utf8.ValidString(got) {
+		t.Error("truncated log_tail is not valid UTF-8")
+	}
+	if data["job_id"] != "42" {
+		t.Errorf("job_id = %q, want it untouched", data["job_id"])
+	}
+	if data[truncatedKey] != "true" {
+		t.Error("truncated message not marked")
+	}
+}
+
+func TestFitDataFailsWhenOtherFieldsAreTooLarge(t *testing.T) {
+	const limit = 16 * 1024
+	data := map[string]string{"job_id": "42"}
+	msg := eventMessage(data)
+	msg.GetEvent().VmId = strings.Repeat("v", limit)
+
+	if err := fitData(msg, data, limit); err == nil {
+		t.Error("fitData succeeded with an oversized VM ID")
+	}
+}
+
+func TestSendHeartbeatSizeLimit(t *testing.T) {
+	const limit = 64 * 1024
+	cfg := &config.Config{VMID: "vm-1", PoolID: "pool-test"}
+	cfg.Controller.MaxMessageSize = limit
+	client, err := NewGRPCClient(cfg)
+	if err != nil {
+		t.Fatalf("NewGRPCClient: %v", err)
+	}
+	defer client.cancel()
+	stream := &recordingStream{}
+	client.stream = stream
+
+	// The timestamp is filled in by SendHeartbeat, so leave room for it
+	state := strings.Repeat("x", limit-messageHeadroom-128)
+	if err := client.SendHeartbeat("vm-1", "pool-test", "", state, &commands.VMHealth{}, nil, nil); err != nil {
+		t.Errorf("heartbeat under the limit rejected: %v", err)
+	}
+	state = strings.Repeat("x", limit)
+	if err := client.SendHeartbeat("vm-1", "pool-test", "", state, &commands.VMHealth{}, nil, nil); err == nil {
+		t.Error("heartbeat over the limit sent")
+	}
+	if got := stream.sent.Load(); got != 1 {
+		t.Errorf("sent %d heartbeats, want 1", got)
+	}
+}
@@ -1,25 +1,35 @@
 package events
 
 import (
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/monkci/miglet/pkg/buildinfo"
 )
 
 // EventType represents the type of event
 type EventType string
 
 const (
-	EventTypeVMStarted        EventType = "vm_started"
-	EventTypeRunnerRegistered EventType = "runner_registered"
-	EventTypeJobStarted       EventType = "job_started"
-	EventTypeJobHeartbeat     EventType = "job_heartbeat"
-	EventTypeJobCompleted     EventType = "job_completed"
-	EventTypeRunnerCrashed    EventType = "runner_crashed"
-	EventTypeVMShuttingDown   EventType = "vm_shutting_down"
-	EventTypeError            EventType = "error"
+	EventTypeVMStarted          EventType = "vm_started"
+	EventTypeRunnerRegistered   EventType = "runner_registered"
+	EventTypeJobStarted         EventType = "job_started"
+	EventTypeJobHeartbeat       EventType = "job_heartbeat"
+	EventTypeJobCompleted       EventType = "job_completed"
+	EventTypeRunnerCrashed      EventType = "runner_crashed"
+	EventTypeRunnerDeregistered EventType = "runner_deregistered"
+	EventTypeRunnerAvailable    EventType = "runner_available"
+	EventTypeVMShuttingDown     EventType = "vm_shutting_down"
+	EventTypeVMPreempted        EventType = "vm_preempted"
+	EventTypeError              EventType = "error"
 )
 
 // Event represents a base event structure
 type Event struct {
+	EventID   string                 `json:"event_id"` // Unique per event, reused on retries so the controller can drop duplicates
 	Type      EventType              `json:"type"`
 	Timestamp time.Time              `json:"timestamp"`
 	VMID      string                 `json:"vm_id"`
@@ -28,6 +38,19 @@ type Event struct {
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// newEvent creates the base of an event with a fresh event ID
+func newEvent(eventType EventType, vmID, poolID, orgID string) Event {
+	return Event{
+		EventID:   uuid.NewString(),
+		Type:      eventType,
+		Timestamp: time.Now(),
+		VMID:      vmID,
+		PoolID:    poolID,
+		OrgID:     orgID,
+		Metadata:  make(map[string]interface{}),
+	}
+}
+
 // VMStartedEvent represents a VM started event
 type VMStartedEvent struct {
 	Event
@@ -43,16 +66,9 @@ type VMStartedEvent struct {
 // NewVMStartedEvent creates a new VM started event
 func NewVMStartedEvent(vmID, poolID, orgID string) *VMStartedEvent {
 	return &VMStartedEvent{
-		Event: Event{
-			Type:      EventTypeVMStarted,
-			Timestamp: time.Now(),
-			VMID:      vmID,
-			PoolID:    poolID,
-			OrgID:     orgID,
-			Metadata:  make(map[string]interface{}),
-		},
-		Version:   "dev",
-		BuildTime: "unknown",
+		Event:     newEvent(EventTypeVMStarted, vmID, poolID, orgID),
+		Version:   buildinfo.Version,
+		BuildTime: buildinfo.BuildTime,
 	}
 }
 
@@ -65,6 +81,7 @@ type Emitter struct {
 type RunnerRegisteredEvent struct {
 	Event
 	RunnerURL   string   `json:"runner_url"`
+	RunnerName  string   `json:"runner_name,omitempty"`
 	RunnerID    string   `json:"runner_id,omitempty"`
 	Labels      []string `json:"labels,omitempty"`
 	RunnerGroup string   `json:"runner_group,omitempty"`
@@ -73,14 +90,7 @@ type RunnerRegisteredEvent struct {
 // NewRunnerRegisteredEvent creates a new runner registered event
 func NewRunnerRegisteredEvent(vmID, poolID, orgID, runnerURL string) *RunnerRegisteredEvent {
 	return &RunnerRegisteredEvent{
-		Event: Event{
-			Type:      EventTypeRunnerRegistered,
-			Timestamp: time.Now(),
-			VMID:      vmID,
-			PoolID:    poolID,
-			OrgID:     orgID,
-			Metadata:  make(map[string]interface{}),
-		},
+		Event:     newEvent(EventTypeRunnerRegistered, vmID, poolID, orgID),
 		RunnerURL: runnerURL,
 	}
 }
@@ -98,14 +108,7 @@ type JobStartedEvent struct {
 // NewJobStartedEvent creates a new job started event
 func NewJobStartedEvent(vmID, poolID, orgID, jobID, runID string) *JobStartedEvent {
 	return &JobStartedEvent{
-		Event: Event{
-			Type:      EventTypeJobStarted,
-			Timestamp: time.Now(),
-			VMID:      vmID,
-			PoolID:    poolID,
-			OrgID:     orgID,
-			Metadata:  make(map[string]interface{}),
-		},
+		Event: newEvent(EventTypeJobStarted, vmID, poolID, orgID),
 		JobID: jobID,
 		RunID: runID,
 	}
@@ -124,20 +127,160 @@ type JobCompletedEvent struct {
 // NewJobCompletedEvent creates a new job completed event
 func NewJobCompletedEvent(vmID, poolID, orgID, jobID, runID string, success bool) *JobCompletedEvent {
 	return &JobCompletedEvent{
-		Event: Event{
-			Type:      EventTypeJobCompleted,
-			Timestamp: time.Now(),
-			VMID:      vmID,
-			PoolID:    poolID,
-			OrgID:     orgID,
-			Metadata:  make(map[string]interface{}),
-		},
+		Event:   newEvent(EventTypeJobCompleted, vmID, poolID, orgID),
 		JobID:   jobID,
 		RunID:   runID,
 		Success: success,
 	}
 }
 
+// ErrorEvent reports a condition that stops MIGlet from serving jobs
+type ErrorEvent struct {
+	Event
+	Reason  string `json:"reason"` // Machine-readable reason, e.g. "controller_unreachable"
+	Message string `json:"message,omitempty"`
+}
+
+// NewErrorEvent creates a new error event
+func NewErrorEvent(vmID, poolID, orgID, reason, message string) *ErrorEvent {
+	return &ErrorEvent{
+		Event:   newEvent(EventTypeError, vmID, poolID, orgID),
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// Runner crash reasons
+const (
+	CrashReasonProcessExited = "process_exited" // Runner exited with a non-zero code
+	CrashReasonKilled        = "killed"         // Runner was terminated by a signal
+	CrashReasonWaitFailed    = "wait_failed"    // The process could not be waited on
+)
+
+// RunnerCrashedEvent reports that the runner process exited unexpectedly
+type RunnerCrashedEvent struct {
+	Event
+	Reason   string   `json:"reason"`
+	Error    string   `json:"error,omitempty"`
+	ExitCode int      `json:"exit_code"` // -1 if the process was killed by a signal
+	LogTail  []string `json:"log_tail,omitempty"`
+}
+
+// NewRunnerCrashedEvent creates a new runner crashed event
+func NewRunnerCrashedEvent(vmID, poolID, orgID, reason, errMsg string, exitCode int, logTail []string) *RunnerCrashedEvent {
+	return &RunnerCrashedEvent{
+		Event:    newEvent(EventTypeRunnerCrashed, vmID, poolID, orgID),
+		Reason:   reason,
+		Error:    errMsg,
+		ExitCode: exitCode,
+		LogTail:  logTail,
+	}
+}
+
+// Data flattens the event into the string map carried by gRPC events
+func (e *RunnerCrashedEvent) Data() map[string]string {
+	return map[string]string{
+		"reason":    e.Reason,
+		"error":     e.Error,
+		"exit_code": strconv.Itoa(e.ExitCode),
+		"log_tail":  strings.Join(e.LogTail, "\n"),
+	}
+}
+
+// RunnerDeregisteredEvent reports that MIGlet unregistered a runner that never ran a job
+type RunnerDeregisteredEvent struct {
+	Event
+	RunnerName string `json:"runner_name"`
+	RunnerID   string `json:"runner_id,omitempty"`
+	Removed    bool   `json:"removed"` // False if only local files were cleared and GitHub still lists the runner
+	Reason     string `json:"reason"`  // "drain" or "shutdown"
+}
+
+// NewRunnerDeregisteredEvent creates a new runner deregistered event
+func NewRunnerDeregisteredEvent(vmID, poolID, orgID, runnerName, runnerID string, removed bool, reason string) *RunnerDeregisteredEvent {
+	return &RunnerDeregisteredEvent{
+		Event:      newEvent(EventTypeRunnerDeregistered, vmID, poolID, orgID),
+		RunnerName: runnerName,
+		RunnerID:   runnerID,
+		Removed:    removed,
+		Reason:     reason,
+	}
+}
+
+// Data flattens the event into the string map carried by gRPC events
+func (e *RunnerDeregisteredEvent) Data() map[string]string {
+	return map[string]string{
+		"runner_name": e.RunnerName,
+		"runner_id":   e.RunnerID,
+		"removed":     strconv.FormatBool(e.Removed),
+		"reason":      e.Reason,
+	}
+}
+
+// RunnerAvailableEvent reports that a mediated runner finished its granted job and is
+// registered but not listening, waiting for the controller to grant the next one
+type RunnerAvailableEvent struct {
+	Event
+	RunnerName    string `json:"runner_name"`
+	RunnerID      string `json:"runner_id,omitempty"`
+	JobsCompleted int64  `json:"jobs_completed"`
+}
+
+// NewRunnerAvailableEvent creates a new runner available event
+func NewRunnerAvailableEvent(vmID, poolID, orgID, runnerName, runnerID string, jobsCompleted int64) *RunnerAvailableEvent {
+	return &RunnerAvailableEvent{
+		Event:         newEvent(EventTypeRunnerAvailable, vmID, poolID, orgID),
+		RunnerName:    runnerName,
+		RunnerID:      runnerID,
+		JobsCompleted: jobsCompleted,
+	}
+}
+
+// Data flattens the event into the string map carried by gRPC events
+func (e *RunnerAvailableEvent) Data() map[string]string {
+	return map[string]string{
+		"runner_name":    e.RunnerName,
+		"runner_id":      e.RunnerID,
+		"jobs_completed": strconv.FormatInt(e.JobsCompleted, 10),
+	}
+}
+
+// VMPreemptedEvent reports that GCP is reclaiming a Spot/preemptible VM
+type VMPreemptedEvent struct {
+	Event
+	JobRunning bool `json:"job_running"` // Whether a job was interrupted by the preemption
+}
+
+// NewVMPreemptedEvent creates a new VM preempted event
+func NewVMPreemptedEvent(vmID, poolID, orgID string, jobRunning bool) *VMPreemptedEvent {
+	return &VMPreemptedEvent{
+		Event:      newEvent(EventTypeVMPreempted, vmID, poolID, orgID),
+		JobRunning: jobRunning,
+	}
+}
+
+// Reasons reported in vm_shutting_down
+const (
+	ShutdownReasonSignal  = "shutdown" // MIGlet was told to stop (SIGTERM/SIGINT)
+	ShutdownReasonMaxJobs = "max_jobs" // The VM ran its configured number of jobs; the controller should stop it
+)
+
+// VMShuttingDownEvent reports that MIGlet is shutting down and the VM is going away
+type VMShuttingDownEvent struct {
+	Event
+	Reason     string `json:"reason"`
+	JobRunning bool   `json:"job_running"` // Whether a job was still running when shutdown started
+}
+
+// NewVMShuttingDownEvent creates a new VM shutting down event
+func NewVMShuttingDownEvent(vmID, poolID, orgID, reason string, jobRunning bool) *VMShuttingDownEvent {
+	return &VMShuttingDownEvent{
+		Event:      newEvent(EventTypeVMShuttingDown, vmID, poolID, orgID),
+		Reason:     reason,
+		JobRunning: jobRunning,
+	}
+}
+
 // HeartbeatEvent represents a heartbeat event with VM and runner state
 type HeartbeatEvent struct {
 	Event
@@ -172,19 +315,15 @@ type JobInfo struct {
 	RunID      string    `json:"run_id"`
 	Repository string    `json:"repository,omitempty"`
 	StartedAt  time.Time `json:"started_at,omitempty"`
+
+	// Seconds since the runner last wrote a log line; a large value suggests a hung job
+	LogIdleSeconds int64 `json:"log_idle_seconds"`
 }
 
 // NewHeartbeatEvent creates a new heartbeat event
 func NewHeartbeatEvent(vmID, poolID, orgID, migletState string, vmHealth VMHealth, runnerState RunnerState, currentJob *JobInfo) *HeartbeatEvent {
 	return &HeartbeatEvent{
-		Event: Event{
-			Type:      EventTypeJobHeartbeat,
-			Timestamp: time.Now(),
-			VMID:      vmID,
-			PoolID:    poolID,
-			OrgID:     orgID,
-			Metadata:  make(map[string]interface{}),
-		},
+		Event:       newEvent(EventTypeJobHeartbeat, vmID, poolID, orgID),
 		MigletState: migletState,
 		VMHealth:    vmHealth,
 		RunnerState: runnerState,
@@ -1,16 +1,23 @@
 package logger
 
 import (
+	"io"
 	"os"
 
 	"github.com/sirupsen/logrus"
 )
 
-var log *logrus.Logger
+var (
+	log     *logrus.Logger
+	format  string
+	logFile io.Closer // Open log file, closed when the output changes
+)
 
-// Init initializes the logger with structured JSON output
-func Init(level string, format string) {
+// Init initializes the logger with structured JSON output, written to out
+// If out can't be opened the logger falls back to stdout and the error is returned
+func Init(level string, logFormat string, out Output) error {
 	log = logrus.New()
+	format = logFormat
 
 	// Set log level
 	switch level {
@@ -26,6 +33,35 @@ func Init(level string, format string) {
 		log.SetLevel(logrus.InfoLevel)
 	}
 
+	if err := SetOutput(out); err != nil {
+		setWriter(os.Stdout, true)
+		return err
+	}
+	return nil
+}
+
+// SetOutput redirects the logger, e.g. once the config file has been loaded
+// If out can't be opened the current output is kept and the error is returned
+func SetOutput(out Output) error {
+	w, terminal, err := openOutput(out)
+	if err != nil {
+		return err
+	}
+
+	// Swap first so nothing writes to the old file after it's closed
+	setWriter(w, terminal)
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if c, ok := w.(io.Closer); ok && !terminal {
+		logFile = c
+	}
+	return nil
+}
+
+// setWriter sets the output and picks a formatter to suit it
+func setWriter(w io.Writer, terminal bool) {
 	// Set output format
 	if format == "json" {
 		log.SetFormatter(&logrus.JSONFormatter{
@@ -33,10 +69,10 @@ func Init(level string, format string) {
 		})
 	} else {
 		// Use colored text formatter for better readability
-		// Force colors for better terminal readability
+		// Force colors on terminals only; they'd be noise in a log file
 		log.SetFormatter(&logrus.TextFormatter{
-			ForceColors:      true, // Force colors for better readability
-			DisableColors:    false,
+			ForceColors:      terminal,
+			DisableColors:    !terminal,
 			FullTimestamp:    true,
 			TimestampFormat:  "15:04:05",
 			DisableSorting:   false,
@@ -45,14 +81,14 @@ func Init(level string, format string) {
 		})
 	}
 
-	log.SetOutput(os.Stdout)
+	log.SetOutput(w)
 }
 
 // Get returns the logger instance
 func Get() *logrus.Logger {
 	if log == nil {
 		// Initialize with defaults if not initialized
-		Init("info", "json")
+		Init("info", "json", Output{})
 	}
 	return log
 }
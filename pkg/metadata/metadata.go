@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// baseURL is the GCE instance metadata server
+const baseURL = "http://metadata.google.internal/computeMetadata/v1/"
+
+// Client reads instance data from the GCE metadata server
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewClient creates a metadata client
+// Requests are short-lived since the server is link-local; a missing server fails fast
+func NewClient() *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: 2 * time.Second},
+		baseURL:    baseURL,
+	}
+}
+
+// Get fetches a metadata path relative to computeMetadata/v1, e.g. "instance/zone"
+func (c *Client) Get(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to query metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d for %s", resp.StatusCode, path)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata response: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// MachineInfo describes where and on what the instance runs
+type MachineInfo struct {
+	MachineType string // e.g. "e2-standard-4"
+	Zone        string // e.g. "us-central1-a"
+	Region      string // e.g. "us-central1"
+}
+
+// MachineInfo returns the instance machine type and location
+func (c *Client) MachineInfo(ctx context.Context) (*MachineInfo, error) {
+	machineType, err := c.Get(ctx, "instance/machine-type")
+	if err != nil {
+		return nil, err
+	}
+	zone, err := c.Get(ctx, "instance/zone")
+	if err != nil {
+		return nil, err
+	}
+
+	zone = lastSegment(zone)
+	return &MachineInfo{
+		MachineType: lastSegment(machineType),
+		Zone:        zone,
+		Region:      RegionFromZone(zone),
+	}, nil
+}
+
+// RegionFromZone strips the zone suffix, e.g. "us-central1-a" -> "us-central1"
+func RegionFromZone(zone string) string {
+	if i := strings.LastIndexByte(zone, '-'); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// lastSegment returns the final path element of a resource name
+// The server returns e.g. "projects/123/zones/us-central1-a"
+func lastSegment(resource string) string {
+	return resource[strings.LastIndexByte(resource, '/')+1:]
+}
+
+// Preempted reports whether GCP has started preempting this Spot/preemptible instance
+func (c *Client) Preempted(ctx context.Context) (bool, error) {
+	val, err := c.Get(ctx, "instance/preempted")
+	if err != nil {
+		return false, err
+	}
+	return val == "TRUE", nil
+}
+
+// Instance holds the identity and custom attributes of a GCE instance
+type Instance struct {
+	Name       string            // Instance name
+	Zone       string            // e.g. "us-central1-a"
+	Region     string            // e.g. "us-central1"
+	Attributes map[string]string // Custom metadata keys that were set
+}
+
+// OnGCE reports whether the metadata server is reachable
+func (c *Client) OnGCE(ctx context.Context) bool {
+	_, err := c.Get(ctx, "instance/id")
+	return err == nil
+}
+
+// Instance returns the instance identity plus the requested custom metadata keys
+// Keys that are not set on the instance are omitted from Attributes
+func (c *Client) Instance(ctx context.Context, keys ...string) (*Instance, error) {
+	name, err := c.Get(ctx, "instance/name")
+	if err != nil {
+		return nil, err
+	}
+	zone, err := c.Get(ctx, "instance/zone")
+	if err != nil {
+		return nil, err
+	}
+
+	zone = lastSegment(zone)
+	inst := &Instance{
+		Name:       name,
+		Zone:       zone,
+		Region:     RegionFromZone(zone),
+		Attributes: make(map[string]string),
+	}
+	for _, key := range keys {
+		// Unset attributes return 404; treat any failure as "not provided"
+		if val, err := c.Get(ctx, "instance/attributes/"+key); err == nil && val != "" {
+			inst.Attributes[key] = val
+		}
+	}
+	return inst, nil
+}
@@ -1,8 +1,11 @@
 package metrics
 
 import (
+	"bufio"
+	"os"
 	"runtime"
-	"syscall"
+	"strconv"
+	"strings"
 
 	"github.com/monkci/miglet/pkg/events"
 )
@@ -23,9 +26,13 @@ func (c *Collector) CollectVMHealth() events.VMHealth {
 	// Get CPU load (simplified - just use runtime stats)
 	cpuLoad := float64(runtime.NumGoroutine()) / 100.0 // Simplified metric
 
-	// Memory in MB
+	// Memory in MB: system-wide where /proc/meminfo exists, otherwise this process's runtime stats
 	memoryUsed := int64(m.Alloc / 1024 / 1024)
 	memoryTotal := int64(m.Sys / 1024 / 1024)
+	if used, total, err := getMemUsage(); err == nil {
+		memoryUsed = used
+		memoryTotal = total
+	}
 
 	// Disk usage (simplified - would need to check actual disk)
 	// For now, return 0 - can be enhanced with actual disk stats
@@ -47,29 +54,85 @@ func (c *Collector) CollectVMHealth() events.VMHealth {
 	}
 }
 
-// DiskStats represents disk statistics
-type DiskStats struct {
-	Used  int64
-	Total int64
+// MachineSpec describes the hardware MIGlet is running on
+type MachineSpec struct {
+	CPU    int // Logical CPUs
+	Memory int // Total memory in MB
+	Disk   int // Root filesystem size in GB
 }
 
-// getDiskStats gets disk statistics (simplified implementation)
-func getDiskStats() (*DiskStats, error) {
-	// Try to get disk stats from syscall
-	var stat syscall.Statfs_t
-	err := syscall.Statfs("/", &stat)
+// CollectMachineSpec collects static machine capacity
+// Values that cannot be read are left at 0
+func (c *Collector) CollectMachineSpec() MachineSpec {
+	spec := MachineSpec{
+		CPU: runtime.NumCPU(),
+	}
+	if memTotal, err := getMemTotal(); err == nil {
+		spec.Memory = int(memTotal)
+	}
+	if stat, err := getDiskStats(); err == nil {
+		spec.Disk = int(stat.Total)
+	}
+	return spec
+}
+
+// getMemTotal reads total system memory in MB from /proc/meminfo
+func getMemTotal() (int64, error) {
+	info, err := readMeminfo()
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	total, ok := info["MemTotal"]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return total, nil
+}
+
+// getMemUsage reads used and total system memory in MB from /proc/meminfo
+// Memory the kernel can reclaim (MemAvailable, e.g. page cache) doesn't count as used
+func getMemUsage() (used, total int64, err error) {
+	info, err := readMeminfo()
+	if err != nil {
+		return 0, 0, err
 	}
+	total, hasTotal := info["MemTotal"]
+	available, hasAvailable := info["MemAvailable"]
+	if !hasTotal || !hasAvailable {
+		return 0, 0, os.ErrNotExist
+	}
+	return total - available, total, nil
+}
 
-	// Calculate disk space
-	total := int64(stat.Blocks) * int64(stat.Bsize) / 1024 / 1024 / 1024 // GB
-	available := int64(stat.Bavail) * int64(stat.Bsize) / 1024 / 1024 / 1024 // GB
-	used := total - available
+// readMeminfo parses /proc/meminfo into values in MB, keyed by field name
+func readMeminfo() (map[string]int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	return &DiskStats{
-		Used:  used,
-		Total: total,
-	}, nil
+	info := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		info[strings.TrimSuffix(fields[0], ":")] = kb / 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
+// DiskStats represents disk statistics
+type DiskStats struct {
+	Used  int64
+	Total int64
+}
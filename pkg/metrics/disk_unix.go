@@ -0,0 +1,25 @@
+//go:build !windows
+
+package metrics
+
+import "syscall"
+
+// getDiskStats gets disk statistics for the root filesystem
+func getDiskStats() (*DiskStats, error) {
+	// Try to get disk stats from syscall
+	var stat syscall.Statfs_t
+	err := syscall.Statfs("/", &stat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate disk space
+	total := int64(stat.Blocks) * int64(stat.Bsize) / 1024 / 1024 / 1024     // GB
+	available := int64(stat.Bavail) * int64(stat.Bsize) / 1024 / 1024 / 1024 // GB
+	used := total - available
+
+	return &DiskStats{
+		Used:  used,
+		Total: total,
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// getDiskStats gets disk statistics for the system drive
+func getDiskStats() (*DiskStats, error) {
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	root, err := windows.UTF16PtrFromString(filepath.Join(drive, `\`))
+	if err != nil {
+		return nil, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(root, &available, &total, &free); err != nil {
+		return nil, err
+	}
+
+	totalGB := int64(total / 1024 / 1024 / 1024)
+	availableGB := int64(available / 1024 / 1024 / 1024)
+
+	return &DiskStats{
+		Used:  totalGB - availableGB,
+		Total: totalGB,
+	}, nil
+}
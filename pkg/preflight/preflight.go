@@ -0,0 +1,113 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/monkci/miglet/pkg/config"
+)
+
+// Reasons reported when a prerequisite is missing
+const (
+	ReasonControllerUnreachable = "controller_unreachable"
+	ReasonDockerUnavailable     = "docker_unavailable"
+	ReasonDNSResolutionFailed   = "dns_resolution_failed"
+)
+
+// Failure describes a prerequisite that is not met
+type Failure struct {
+	Check  string // Name of the check, e.g. "controller"
+	Reason string // Machine-readable reason, e.g. "controller_unreachable"
+	Target string // Endpoint, socket or host that was checked
+	Err    error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("preflight check %s failed for %s: %v", f.Check, f.Target, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Run executes the enabled preflight checks and returns the first failure, or nil
+// grpcEndpoint is the controller address (host:port) MIGlet will connect to
+func Run(ctx context.Context, cfg config.PreflightConfig, grpcEndpoint string) *Failure {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	if cfg.ControllerCheck {
+		if err := checkTCP(ctx, grpcEndpoint); err != nil {
+			return &Failure{Check: "controller", Reason: ReasonControllerUnreachable, Target: grpcEndpoint, Err: err}
+		}
+	}
+
+	if cfg.DockerCheck {
+		if err := checkSocket(cfg.DockerSocket); err != nil {
+			return &Failure{Check: "docker", Reason: ReasonDockerUnavailable, Target: cfg.DockerSocket, Err: err}
+		}
+	}
+
+	if cfg.DNSCheck {
+		host := dnsHost(cfg.DNSHost)
+		if err := checkDNS(ctx, host); err != nil {
+			return &Failure{Check: "dns", Reason: ReasonDNSResolutionFailed, Target: host, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// checkTCP verifies a TCP connection can be opened to addr
+func checkTCP(ctx context.Context, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("endpoint not configured")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	return conn.Close()
+}
+
+// checkSocket verifies path exists and is a Unix socket
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat socket: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	return nil
+}
+
+// checkDNS verifies host resolves to at least one address
+func checkDNS(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve: %w", err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses returned")
+	}
+	return nil
+}
+
+// dnsHost accepts a bare host or a URL (e.g. a GHES base URL) and returns the host name
+func dnsHost(value string) string {
+	if strings.Contains(value, "://") {
+		if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return value
+}
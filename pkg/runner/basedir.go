@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/monkci/miglet/pkg/logger"
+)
+
+// fallbackBaseDirs are tried, in order, when the configured runner base directory is unusable
+var fallbackBaseDirs = []string{
+	"/tmp/miglet-runner",
+	"/var/lib/miglet/runner",
+}
+
+// ResolveBaseDir returns the first directory (preferred, then fallbacks) that can be
+// created, written to and executed from. noexec mounts (common for /tmp on hardened
+// images) would otherwise only surface later as a cryptic run.sh failure
+func ResolveBaseDir(preferred string) (string, error) {
+	candidates := make([]string, 0, len(fallbackBaseDirs)+1)
+	if preferred != "" {
+		candidates = append(candidates, preferred)
+	}
+	for _, dir := range fallbackBaseDirs {
+		if dir != preferred {
+			candidates = append(candidates, dir)
+		}
+	}
+
+	var problems []string
+	for _, dir := range candidates {
+		err := CheckBaseDir(dir)
+		if err == nil {
+			if dir != preferred && preferred != "" {
+				logger.Get().WithFields(map[string]interface{}{
+					"configured": preferred,
+					"using":      dir,
+				}).Warn("Configured runner base directory unusable, using fallback")
+			}
+			return dir, nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: %v", dir, err))
+	}
+
+	return "", fmt.Errorf("no usable runner base directory (set runner.base_dir to a writable, exec-capable path): %s", strings.Join(problems, "; "))
+}
+
+// CheckBaseDir verifies dir can be created, written to, and (outside Windows) execute scripts
+func CheckBaseDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create: %w", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		probe, err := os.CreateTemp(dir, ".miglet-probe-*")
+		if err != nil {
+			return fmt.Errorf("not writable: %w", err)
+		}
+		probe.Close()
+		return os.Remove(probe.Name())
+	}
+
+	probe := filepath.Join(dir, fmt.Sprintf(".miglet-exec-probe-%d.sh", os.Getpid()))
+	if err := os.WriteFile(probe, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	defer os.Remove(probe)
+
+	if err := exec.Command(probe).Run(); err != nil {
+		return fmt.Errorf("cannot execute scripts (noexec mount?): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Registration failure reasons, derived from config.sh output
+const (
+	ConfigReasonInvalidToken        = "invalid_registration_token"
+	ConfigReasonNetworkError        = "network_error"
+	ConfigReasonRunnerNotConfigured = "runner_not_configured"
+	ConfigReasonConfigFailed        = "runner_config_failed"
+)
+
+// configOutputMaxBytes caps how much config.sh output is kept on a ConfigError
+const configOutputMaxBytes = 4096
+
+// ConfigError is a failed runner configuration, with what config.sh printed
+type ConfigError struct {
+	Reason string // One of the ConfigReason* constants
+	Output string // Tail of config.sh's combined stdout/stderr
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	msg := fmt.Sprintf("runner configuration failed (%s): %v", e.Reason, e.Err)
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// newConfigError builds a ConfigError, classifying the failure from config.sh's output
+func newConfigError(output string, err error) *ConfigError {
+	output = strings.TrimSpace(output)
+	if len(output) > configOutputMaxBytes {
+		output = output[len(output)-configOutputMaxBytes:]
+	}
+	return &ConfigError{
+		Reason: classifyConfigOutput(output),
+		Output: output,
+		Err:    err,
+	}
+}
+
+// classifyConfigOutput maps known config.sh failure messages to a reason
+func classifyConfigOutput(output string) string {
+	lower := strings.ToLower(output)
+
+	for _, marker := range []string{
+		"http response code: notfound",
+		"http response code: unauthorized",
+		"http response code: forbidden",
+		"invalid token",
+		"token expired",
+		"registration token",
+	} {
+		if strings.Contains(lower, marker) {
+			return ConfigReasonInvalidToken
+		}
+	}
+
+	for _, marker := range []string{
+		"name or service not known",
+		"no such host",
+		"connection refused",
+		"network is unreachable",
+		"timed out",
+		"ssl connection could not be established",
+		"failed to connect",
+	} {
+		if strings.Contains(lower, marker) {
+			return ConfigReasonNetworkError
+		}
+	}
+
+	return ConfigReasonConfigFailed
+}
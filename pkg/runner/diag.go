@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/monkci/miglet/pkg/logger"
+)
+
+// diagPollInterval is how often WatchDiag checks the worker log for new output
+const diagPollInterval = time.Second
+
+// Worker log lines, e.g. "[2024-05-01 10:00:00Z INFO JobRunner] Job ID 3b5e..."
+// The runner writes one Worker_*.log per job under _diag
+var (
+	diagJobStartPattern  = regexp.MustCompile(`\bJob ID ([0-9A-Za-z-]+)`)
+	diagJobResultPattern = regexp.MustCompile(`(?:Job result after all job steps finish|Job completed with result): (\w+)`)
+)
+
+// WatchDiag tails the newest _diag/Worker_*.log under runnerDir until ctx ends and drives
+// the job callbacks from its job begin and result lines instead of stdout scraping
+// Stdout detection stays in use until the _diag directory exists, so runners without it
+// still report jobs. Worker logs present when watching starts are skipped, not replayed
+func (m *Monitor) WatchDiag(ctx context.Context, runnerDir string) {
+	log := logger.Get().WithField("source", "runner_diag")
+	diagDir := filepath.Join(runnerDir, "_diag")
+
+	ticker := time.NewTicker(diagPollInterval)
+	defer ticker.Stop()
+
+	var tail *diagTail
+	skipExisting := true
+	for {
+		if !m.diagActive.Load() {
+			if info, err := os.Stat(diagDir); err == nil && info.IsDir() {
+				m.diagActive.Store(true)
+				log.WithField("dir", diagDir).Info("Taking job events from runner worker logs")
+			}
+		}
+
+		if m.diagActive.Load() {
+			if newest := newestWorkerLog(diagDir); newest != "" && (tail == nil || tail.path != newest) {
+				if tail != nil {
+					tail.read(m.parseDiagLine) // Finish the previous job's log first
+				}
+				tail = &diagTail{path: newest}
+				if skipExisting {
+					if info, err := os.Stat(newest); err == nil {
+						tail.offset = info.Size()
+					}
+				}
+			}
+			skipExisting = false
+			if tail != nil {
+				if err := tail.read(m.parseDiagLine); err != nil {
+					log.WithError(err).WithField("file", tail.path).Debug("Failed to read runner worker log")
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseDiagLine drives the job callbacks from a worker log line
+func (m *Monitor) parseDiagLine(line string) {
+	if match := diagJobStartPattern.FindStringSubmatch(line); match != nil {
+		m.startJob(match[1], "")
+		return
+	}
+	if match := diagJobResultPattern.FindStringSubmatch(line); match != nil {
+		m.completeJob(strings.EqualFold(match[1], "Succeeded"))
+	}
+}
+
+// newestWorkerLog returns the most recently modified Worker_*.log in dir, or ""
+func newestWorkerLog(dir string) string {
+	paths, err := filepath.Glob(filepath.Join(dir, "Worker_*.log"))
+	if err != nil {
+		return ""
+	}
+
+	newest := ""
+	var newestMod time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestMod) {
+			newest = path
+			newestMod = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// diagTail reads complete lines appended to a file since the last read
+type diagTail struct {
+	path    string
+	offset  int64
+	partial string // Incomplete trailing line from the last read
+}
+
+// read calls fn for each complete line written since the last read
+func (t *diagTail) read(fn func(line string)) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.Size() < t.offset {
+		t.offset = 0 // Truncated; start over
+		t.partial = ""
+	}
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	t.offset += int64(len(data))
+
+	lines := strings.Split(t.partial+string(data), "\n")
+	t.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		fn(strings.TrimRight(line, "\r"))
+	}
+	return nil
+}
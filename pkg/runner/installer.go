@@ -1,127 +1,309 @@
 package runner
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/monkci/miglet/pkg/backoff"
+	"github.com/monkci/miglet/pkg/config"
 	"github.com/monkci/miglet/pkg/logger"
 )
 
 const (
-	runnerVersion     = "2.329.0"
-	runnerPlatform    = "linux-x64"
-	runnerDir         = "actions-runner"
-	runnerArchiveName = "actions-runner-linux-x64-2.329.0.tar.gz"
-	runnerURL         = "https://github.com/actions/runner/releases/download/v2.329.0/actions-runner-linux-x64-2.329.0.tar.gz"
-	runnerSHA256      = "194f1e1e4bd02f80b7e9633fc546084d8d4e19f3928a324d512ea53430102e1d"
+	defaultRunnerVersion    = "2.329.0"
+	runnerDir               = "actions-runner"
+	installedVersionFile    = ".miglet-runner-version"
+	runnerReleaseURL        = "https://github.com/actions/runner/releases/download"
+	defaultDownloadTimeout  = 10 * time.Minute
+	defaultDownloadAttempts = 3
 )
 
+// runnerChecksums holds the published SHA256 of each known runner archive, keyed by "version/platform"
+// Versions or platforms not listed here need runner.sha256 (or runner.allow_unverified)
+var runnerChecksums = map[string]string{
+	"2.329.0/linux-x64": "194f1e1e4bd02f80b7e9633fc546084d8d4e19f3928a324d512ea53430102e1d",
+}
+
 // Installer handles GitHub Actions runner installation
 type Installer struct {
-	baseDir string
+	baseDir          string
+	version          string
+	platform         string
+	sha256           string // Checksum override (empty = use runnerChecksums)
+	allowUnverified  bool
+	forceReinstall   bool
+	downloadBaseURL  string
+	downloadAttempts int
+	httpClient       *http.Client
 }
 
 // NewInstaller creates a new runner installer
-func NewInstaller(baseDir string) *Installer {
+func NewInstaller(baseDir string, cfg config.RunnerConfig) *Installer {
+	version := cfg.Version
+	if version == "" {
+		version = defaultRunnerVersion
+	}
+	platform := cfg.Platform
+	if platform == "" {
+		platform = detectPlatform()
+	}
+
+	baseURL := strings.TrimSuffix(cfg.DownloadBaseURL, "/")
+	if baseURL == "" {
+		baseURL = runnerReleaseURL
+	}
+	timeout := cfg.DownloadTimeout
+	if timeout <= 0 {
+		timeout = defaultDownloadTimeout
+	}
+	attempts := cfg.DownloadAttempts
+	if attempts <= 0 {
+		attempts = defaultDownloadAttempts
+	}
+
 	return &Installer{
-		baseDir: baseDir,
+		baseDir:          baseDir,
+		version:          version,
+		platform:         platform,
+		sha256:           strings.ToLower(strings.TrimSpace(cfg.SHA256)),
+		allowUnverified:  cfg.AllowUnverified,
+		forceReinstall:   cfg.ForceReinstall,
+		downloadBaseURL:  baseURL,
+		downloadAttempts: attempts,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+		},
 	}
 }
 
-// Install downloads and installs the GitHub Actions runner
-func (i *Installer) Install() error {
-	runnerPath := filepath.Join(i.baseDir, runnerDir)
+// archiveName returns the release archive file name for the configured version/platform
+// Windows runners ship as .zip, everything else as .tar.gz
+func (i *Installer) archiveName() string {
+	ext := "tar.gz"
+	if isWindowsPlatform(i.platform) {
+		ext = "zip"
+	}
+	return fmt.Sprintf("actions-runner-%s-%s.%s", i.platform, i.version, ext)
+}
 
-	// Check if runner is already installed
-	if i.isInstalled(runnerPath) {
-		logger.Get().WithField("path", runnerPath).Info("GitHub Actions runner already installed, removing existing installation for clean reinstall")
+// downloadURL returns the release archive URL for the configured version/platform
+func (i *Installer) downloadURL() string {
+	return fmt.Sprintf("%s/v%s/%s", i.downloadBaseURL, i.version, i.archiveName())
+}
 
-		// Remove existing installation for clean reinstall
-		if err := i.removeExisting(runnerPath); err != nil {
-			return fmt.Errorf("failed to remove existing runner installation: %w", err)
+// expectedChecksum resolves the checksum to verify the archive against
+// Returns "" when verification is explicitly disabled
+func (i *Installer) expectedChecksum() (string, error) {
+	if i.allowUnverified {
+		return "", nil
+	}
+	if i.sha256 != "" {
+		return i.sha256, nil
+	}
+	if sum, ok := runnerChecksums[i.version+"/"+i.platform]; ok {
+		return sum, nil
+	}
+	return "", fmt.Errorf("no known checksum for runner %s (%s); set runner.sha256 or runner.allow_unverified", i.version, i.platform)
+}
+
+// Install downloads and installs the GitHub Actions runner
+// An existing installation of the same version is kept (minus stale registration files);
+// it is only replaced when the version differs, it is incomplete, or ForceReinstall is set.
+// The replacement archive is downloaded and verified before the old install is removed.
+// Cancelling ctx aborts the download or extraction in progress
+func (i *Installer) Install(ctx context.Context) error {
+	runnerPath := filepath.Join(i.baseDir, runnerDir)
+	log := logger.Get().WithFields(map[string]interface{}{
+		"path":     runnerPath,
+		"version":  i.version,
+		"platform": i.platform,
+	})
+
+	installed := i.isInstalled(runnerPath)
+	if installed && !i.forceReinstall {
+		installedVersion := readInstalledVersion(runnerPath)
+		if installedVersion == i.version {
+			log.Info("GitHub Actions runner already installed, keeping existing installation")
+			return removeStaleRegistration(runnerPath)
 		}
+		log.WithField("installed_version", installedVersion).Info("Installed runner version differs, reinstalling")
 	}
 
-	logger.Get().Info("Installing GitHub Actions runner")
+	log.Info("Installing GitHub Actions runner")
 
-	// Create runner directory
-	if err := os.MkdirAll(runnerPath, 0755); err != nil {
-		return fmt.Errorf("failed to create runner directory: %w", err)
+	// Resolve the checksum up front so we don't download an archive we can't verify
+	expectedHash, err := i.expectedChecksum()
+	if err != nil {
+		return err
 	}
 
 	// Download runner archive
-	archivePath := filepath.Join(i.baseDir, runnerArchiveName)
-	if err := i.downloadRunner(archivePath); err != nil {
+	archivePath := filepath.Join(i.baseDir, i.archiveName())
+	if err := i.downloadRunner(ctx, archivePath); err != nil {
 		return fmt.Errorf("failed to download runner: %w", err)
 	}
 	defer os.Remove(archivePath) // Clean up archive after extraction
 
-	// Validate hash (optional but recommended)
-	if err := i.validateHash(archivePath); err != nil {
+	// Validate hash
+	if expectedHash == "" {
+		log.Warn("SECURITY WARNING: runner archive checksum verification is DISABLED (runner.allow_unverified)")
+	} else if err := validateHash(archivePath, expectedHash); err != nil {
 		return fmt.Errorf("hash validation failed: %w", err)
 	}
 
+	// Only now that we have a verified archive, replace any existing installation
+	if installed || dirExists(runnerPath) {
+		if err := i.removeExisting(runnerPath); err != nil {
+			return fmt.Errorf("failed to remove existing runner installation: %w", err)
+		}
+	}
+
+	// Create runner directory
+	if err := os.MkdirAll(runnerPath, 0755); err != nil {
+		return fmt.Errorf("failed to create runner directory: %w", err)
+	}
+
 	// Extract archive
-	if err := i.extractArchive(archivePath, runnerPath); err != nil {
+	if err := i.extractArchive(ctx, archivePath, runnerPath); err != nil {
 		return fmt.Errorf("failed to extract runner: %w", err)
 	}
 
-	logger.Get().WithField("path", runnerPath).Info("GitHub Actions runner installed successfully")
+	if err := writeInstalledVersion(runnerPath, i.version); err != nil {
+		log.WithError(err).Warn("Failed to record installed runner version")
+	}
+
+	log.Info("GitHub Actions runner installed successfully")
 	return nil
 }
 
-// isInstalled checks if the runner is already installed
+// isInstalled checks if a complete runner installation exists at runnerPath
 func (i *Installer) isInstalled(runnerPath string) bool {
-	// Check if runner binary exists
-	runnerBin := filepath.Join(runnerPath, "run.sh")
-	if _, err := os.Stat(runnerBin); err == nil {
-		return true
+	if err := VerifyInstallation(runnerPath, i.platform); err != nil {
+		return false
+	}
+	// The scripts alone are not enough; a partial extraction may be missing the binaries
+	return dirExists(filepath.Join(runnerPath, "bin"))
+}
+
+// readInstalledVersion returns the version recorded by a previous Install ("" if unknown)
+func readInstalledVersion(runnerPath string) string {
+	data, err := os.ReadFile(filepath.Join(runnerPath, installedVersionFile))
+	if err != nil {
+		return ""
 	}
-	return false
+	return strings.TrimSpace(string(data))
 }
 
-// downloadRunner downloads the runner archive
-func (i *Installer) downloadRunner(destPath string) error {
-	logger.Get().WithField("url", runnerURL).Info("Downloading GitHub Actions runner")
+// writeInstalledVersion records the installed runner version next to the runner
+func writeInstalledVersion(runnerPath, version string) error {
+	return os.WriteFile(filepath.Join(runnerPath, installedVersionFile), []byte(version+"\n"), 0644)
+}
+
+// removeStaleRegistration deletes registration files left by a previous boot
+// config.sh refuses to configure a runner that already has them
+func removeStaleRegistration(runnerPath string) error {
+	for _, name := range []string{".runner", ".credentials", ".credentials_rsaparams"} {
+		if err := os.Remove(filepath.Join(runnerPath, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// dirExists reports whether path exists and is a directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// downloadRunner downloads the runner archive, retrying transient failures
+func (i *Installer) downloadRunner(ctx context.Context, destPath string) error {
+	url := i.downloadURL()
+	proxy := ""
+	if req, err := http.NewRequest(http.MethodGet, url, nil); err == nil {
+		if proxyURL, err := http.ProxyFromEnvironment(req); err == nil && proxyURL != nil {
+			proxy = proxyURL.Redacted()
+		}
+	}
+	logger.Get().WithFields(map[string]interface{}{
+		"url":   url,
+		"proxy": proxy,
+	}).Info("Downloading GitHub Actions runner")
+
+	bo := backoff.New(2*time.Second, 30*time.Second, 0.2)
+	var lastErr error
+	for attempt := 1; attempt <= i.downloadAttempts; attempt++ {
+		retryable, err := i.downloadOnce(ctx, url, destPath)
+		if err == nil {
+			logger.Get().WithField("path", destPath).Info("Downloaded GitHub Actions runner")
+			return nil
+		}
+		lastErr = err
+		if !retryable || attempt == i.downloadAttempts || ctx.Err() != nil {
+			break
+		}
 
-	// Create the file
+		delay := bo.Next()
+		logger.Get().WithError(err).WithFields(map[string]interface{}{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("Runner download failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("download cancelled: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	return lastErr
+}
+
+// downloadOnce performs a single download attempt, reporting whether a failure is retryable
+func (i *Installer) downloadOnce(ctx context.Context, url, destPath string) (bool, error) {
+	// Create (or truncate) the file
 	out, err := os.Create(destPath)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return false, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer out.Close()
 
 	// Get the data
-	resp, err := http.Get(runnerURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := i.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+		return true, fmt.Errorf("failed to download: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("bad status: %s", resp.Status)
 	}
 
 	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return true, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	logger.Get().WithField("path", destPath).Info("Downloaded GitHub Actions runner")
-	return nil
+	return false, nil
 }
 
-// validateHash validates the SHA256 hash of the downloaded archive
-func (i *Installer) validateHash(filePath string) error {
+// validateHash validates the SHA256 hash of the downloaded archive against expectedHash
+func validateHash(filePath, expectedHash string) error {
 	logger.Get().Debug("Validating runner archive hash")
 
 	file, err := os.Open(filePath)
@@ -136,7 +318,7 @@ func (i *Installer) validateHash(filePath string) error {
 	}
 
 	calculatedHash := hex.EncodeToString(hash.Sum(nil))
-	expectedHash := strings.ToLower(runnerSHA256)
+	expectedHash = strings.ToLower(expectedHash)
 
 	if calculatedHash != expectedHash {
 		return fmt.Errorf("hash mismatch: expected %s, got %s", expectedHash, calculatedHash)
@@ -146,19 +328,18 @@ func (i *Installer) validateHash(filePath string) error {
 	return nil
 }
 
-// extractArchive extracts the tar.gz archive
-func (i *Installer) extractArchive(archivePath, destPath string) error {
+// extractArchive extracts the runner archive (.zip on Windows, .tar.gz elsewhere)
+func (i *Installer) extractArchive(ctx context.Context, archivePath, destPath string) error {
 	logger.Get().WithFields(map[string]interface{}{
 		"archive": archivePath,
 		"dest":    destPath,
 	}).Info("Extracting runner archive")
 
-	// Use tar command to extract
-	cmd := exec.Command("tar", "xzf", archivePath, "-C", destPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	extract := extractTarGz
+	if strings.HasSuffix(archivePath, ".zip") {
+		extract = extractZip
+	}
+	if err := extract(ctx, archivePath, destPath); err != nil {
 		return fmt.Errorf("failed to extract archive: %w", err)
 	}
 
@@ -166,6 +347,23 @@ func (i *Installer) extractArchive(archivePath, destPath string) error {
 	return nil
 }
 
+// VerifyInstallation checks that runnerPath holds a runner for platform
+// (run.sh and config.sh, or run.cmd and config.cmd on Windows)
+func VerifyInstallation(runnerPath, platform string) error {
+	scripts := scriptsFor(platform)
+	for _, script := range []string{scripts.run, scripts.config} {
+		path := filepath.Join(runnerPath, script)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("runner script %s not found: %w", path, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("runner script %s is a directory", path)
+		}
+	}
+	return nil
+}
+
 // GetRunnerPath returns the path to the installed runner
 func (i *Installer) GetRunnerPath() string {
 	return filepath.Join(i.baseDir, runnerDir)
@@ -184,7 +382,7 @@ func (i *Installer) removeExisting(runnerPath string) error {
 	return nil
 }
 
-// GetRunnerVersion returns the runner version
-func GetRunnerVersion() string {
-	return runnerVersion
+// Version returns the runner version this installer installs
+func (i *Installer) Version() string {
+	return i.version
 }
@@ -0,0 +1,174 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkci/miglet/pkg/config"
+)
+
+// runnerArchive builds a minimal linux runner .tar.gz
+func runnerArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		mode int64
+	}{
+		{"run.sh", 0755},
+		{"config.sh", 0755},
+		{"bin/Runner.Listener", 0755},
+	} {
+		body := []byte("#!/bin/sh\n")
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestInstallVerifiesChecksum(t *testing.T) {
+	archive := runnerArchive(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	wrongSum := strings.Repeat("0", 64)
+	cases := []struct {
+		name            string
+		sha256          string
+		allowUnverified bool
+		wantErr         string
+	}{
+		{name: "match", sha256: strings.ToUpper(sha256Hex(archive))},
+		{name: "mismatch", sha256: wrongSum, wantErr: "hash mismatch"},
+		{name: "skipped", sha256: wrongSum, allowUnverified: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			inst := NewInstaller(baseDir, config.RunnerConfig{
+				Version:          "9.9.9",
+				Platform:         "linux-x64",
+				DownloadBaseURL:  srv.URL,
+				DownloadAttempts: 1,
+				SHA256:           tc.sha256,
+				AllowUnverified:  tc.allowUnverified,
+			})
+
+			err := inst.Install(context.Background())
+			runnerPath := inst.GetRunnerPath()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Install() error = %v, want %q", err, tc.wantErr)
+				}
+				if dirExists(runnerPath) {
+					t.Error("runner directory created from an unverified archive")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Install() error = %v", err)
+				}
+				if err := VerifyInstallation(runnerPath, "linux-x64"); err != nil {
+					t.Errorf("runner not installed: %v", err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(baseDir, inst.archiveName())); !os.IsNotExist(err) {
+				t.Errorf("downloaded archive left behind (stat err %v)", err)
+			}
+		})
+	}
+}
+
+func TestInstallMismatchKeepsExistingInstallation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(runnerArchive(t))
+	}))
+	defer srv.Close()
+
+	baseDir := t.TempDir()
+	runnerPath := filepath.Join(baseDir, runnerDir)
+	for _, name := range []string{"run.sh", "config.sh", "bin/Runner.Listener"} {
+		path := filepath.Join(runnerPath, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeInstalledVersion(runnerPath, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	inst := NewInstaller(baseDir, config.RunnerConfig{
+		Version:          "9.9.9",
+		Platform:         "linux-x64",
+		DownloadBaseURL:  srv.URL,
+		DownloadAttempts: 1,
+		SHA256:           strings.Repeat("0", 64),
+	})
+	if err := inst.Install(context.Background()); err == nil {
+		t.Fatal("Install() succeeded with a mismatched checksum")
+	}
+	if got := readInstalledVersion(runnerPath); got != "1.0.0" {
+		t.Errorf("installed version = %q, want the previous install kept", got)
+	}
+}
+
+func TestExpectedChecksum(t *testing.T) {
+	known := runnerChecksums[defaultRunnerVersion+"/linux-x64"]
+	cases := []struct {
+		name    string
+		cfg     config.RunnerConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "built-in table", cfg: config.RunnerConfig{Platform: "linux-x64"}, want: known},
+		{name: "override", cfg: config.RunnerConfig{Platform: "linux-x64", SHA256: " ABCDEF "}, want: "abcdef"},
+		{name: "override for unknown version", cfg: config.RunnerConfig{Version: "9.9.9", Platform: "linux-x64", SHA256: "abcdef"}, want: "abcdef"},
+		{name: "unknown version", cfg: config.RunnerConfig{Version: "9.9.9", Platform: "linux-x64"}, wantErr: true},
+		{name: "unknown platform", cfg: config.RunnerConfig{Platform: "osx-arm64"}, wantErr: true},
+		{name: "verification disabled", cfg: config.RunnerConfig{Version: "9.9.9", Platform: "linux-x64", SHA256: "abcdef", AllowUnverified: true}, want: ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewInstaller(t.TempDir(), tc.cfg).expectedChecksum()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expectedChecksum() error = %v, want error %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("expectedChecksum() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,15 @@
 package runner
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/monkci/miglet/pkg/logger"
 )
@@ -13,19 +17,23 @@ import (
 // Manager handles GitHub Actions runner lifecycle
 type Manager struct {
 	runnerPath string
+	scripts    runnerScripts // config/run script names for the runner's platform
 }
 
-// NewManager creates a new runner manager
-func NewManager(runnerPath string) *Manager {
+// NewManager creates a new runner manager for a runner built for platform (see Platform)
+func NewManager(runnerPath, platform string) *Manager {
 	return &Manager{
 		runnerPath: runnerPath,
+		scripts:    scriptsFor(platform),
 	}
 }
 
 // ConfigureRunner configures the runner with the provided token and settings
-// Returns error if configuration fails
-func (m *Manager) ConfigureRunner(token, runnerURL, runnerGroup string, labels []string) error {
-	configScript := filepath.Join(m.runnerPath, "config.sh")
+// An ephemeral runner exits after one job; otherwise it stays registered and takes jobs until stopped.
+// config.sh's (config.cmd on Windows) combined output is also copied to logSink when it is non-nil
+// Returns a *ConfigError carrying config.sh's output if configuration fails
+func (m *Manager) ConfigureRunner(token, runnerURL, runnerGroup string, labels []string, ephemeral bool, logSink io.Writer) error {
+	configScript := filepath.Join(m.runnerPath, m.scripts.config)
 
 	// Check if config script exists
 	if _, err := os.Stat(configScript); os.IsNotExist(err) {
@@ -43,10 +51,12 @@ func (m *Manager) ConfigureRunner(token, runnerURL, runnerGroup string, labels [
 	args := []string{
 		"--url", runnerURL,
 		"--token", token,
-		"--ephemeral",  // Ephemeral runner
 		"--unattended", // Non-interactive mode
 		"--replace",    // Replace existing configuration
 	}
+	if ephemeral {
+		args = append(args, "--ephemeral")
+	}
 
 	// Add runner group if provided
 	if runnerGroup != "" {
@@ -59,22 +69,32 @@ func (m *Manager) ConfigureRunner(token, runnerURL, runnerGroup string, labels [
 		args = append(args, "--labels", labelsStr)
 	}
 
-	// Execute config.sh
+	// Execute config.sh, capturing stdout and stderr together so errors can carry them
+	var output bytes.Buffer
+	var w io.Writer = &output
+	if logSink != nil {
+		w = io.MultiWriter(&output, logSink)
+	}
 	cmd := exec.Command(configScript, args...)
 	cmd.Dir = m.runnerPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = w
+	cmd.Stderr = w
 
 	logger.Get().WithField("command", fmt.Sprintf("%s %s", configScript, strings.Join(args, " "))).Debug("Running runner configuration")
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("runner configuration failed: %w", err)
+		return newConfigError(output.String(), err)
 	}
 
 	// Verify configuration by checking if .runner file exists
+	// config.sh can exit 0 without registering, e.g. when the token is for another URL or scope
 	runnerFile := filepath.Join(m.runnerPath, ".runner")
 	if _, err := os.Stat(runnerFile); os.IsNotExist(err) {
-		return fmt.Errorf("runner configuration verification failed: .runner file not found")
+		configErr := newConfigError(output.String(), fmt.Errorf(".runner file not found after config.sh succeeded"))
+		if configErr.Reason == ConfigReasonConfigFailed {
+			configErr.Reason = ConfigReasonRunnerNotConfigured
+		}
+		return configErr
 	}
 
 	logger.Get().Info("GitHub Actions runner configured successfully")
@@ -82,9 +102,10 @@ func (m *Manager) ConfigureRunner(token, runnerURL, runnerGroup string, labels [
 }
 
 // StartRunner starts the runner process with log capture
+// once runs the runner for a single job (--once), after which it exits but stays registered
 // Returns the command, monitor, and error
-func (m *Manager) StartRunner(monitor *Monitor) (*exec.Cmd, *Monitor, error) {
-	runScript := filepath.Join(m.runnerPath, "run.sh")
+func (m *Manager) StartRunner(monitor *Monitor, once bool) (*exec.Cmd, *Monitor, error) {
+	runScript := filepath.Join(m.runnerPath, m.scripts.run)
 
 	// Check if run script exists
 	if _, err := os.Stat(runScript); os.IsNotExist(err) {
@@ -94,14 +115,19 @@ func (m *Manager) StartRunner(monitor *Monitor) (*exec.Cmd, *Monitor, error) {
 	// Check if runner is configured
 	runnerFile := filepath.Join(m.runnerPath, ".runner")
 	if _, err := os.Stat(runnerFile); os.IsNotExist(err) {
-		return nil, nil, fmt.Errorf("runner not configured: .runner file not found")
+		return nil, nil, fmt.Errorf("runner not configured: .runner file not found in %s (registration did not complete)", m.runnerPath)
 	}
 
 	logger.Get().WithField("runner_path", m.runnerPath).Info("Starting GitHub Actions runner")
 
 	// Create command to run the runner
-	cmd := exec.Command(runScript)
+	var args []string
+	if once {
+		args = append(args, "--once")
+	}
+	cmd := exec.Command(runScript, args...)
 	cmd.Dir = m.runnerPath
+	prepareRunnerProcess(cmd)
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := cmd.StdoutPipe()
@@ -116,7 +142,7 @@ func (m *Manager) StartRunner(monitor *Monitor) (*exec.Cmd, *Monitor, error) {
 
 	// Start log capture
 	if monitor == nil {
-		monitor = NewMonitor()
+		monitor = NewMonitor(DefaultMaxLogLines, DefaultMaxLogBytes)
 	}
 
 	// Capture stdout and stderr
@@ -138,8 +164,8 @@ func (m *Manager) StopRunner(cmd *exec.Cmd) error {
 
 	logger.Get().Info("Stopping GitHub Actions runner")
 
-	// Try graceful shutdown first
-	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+	// Try graceful shutdown first (SIGINT, or CTRL_BREAK on Windows)
+	if err := interruptProcess(cmd.Process); err != nil {
 		logger.Get().WithError(err).Warn("Failed to send interrupt signal, trying kill")
 		return cmd.Process.Kill()
 	}
@@ -147,6 +173,63 @@ func (m *Manager) StopRunner(cmd *exec.Cmd) error {
 	return nil
 }
 
+// CancelCurrentJob cancels the job the runner is executing without stopping the runner
+// The Actions runner treats SIGINT to Runner.Worker as a job cancellation: it cancels the
+// running step and still runs post steps. If the worker hasn't exited after grace, it is killed
+// Workers are found via /proc, so cancellation is not supported on Windows
+func (m *Manager) CancelCurrentJob(cmd *exec.Cmd, grace time.Duration) error {
+	if cmd == nil || cmd.Process == nil {
+		return fmt.Errorf("runner is not running")
+	}
+
+	workers := findWorkerPIDs(cmd.Process.Pid)
+	if len(workers) == 0 {
+		return fmt.Errorf("no running job found")
+	}
+
+	log := logger.Get().WithField("worker_pids", workers)
+	log.Info("Cancelling current job")
+
+	for _, pid := range workers {
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			continue
+		}
+		if err := process.Signal(os.Interrupt); err != nil {
+			log.WithError(err).WithField("pid", pid).Warn("Failed to interrupt runner worker")
+		}
+	}
+
+	// Give the worker time to run post steps before escalating
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		if !anyProcessExists(workers) {
+			log.Info("Job cancelled")
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	log.WithField("grace", grace).Warn("Runner worker did not exit after cancel, killing it")
+	for _, pid := range workers {
+		if process, err := os.FindProcess(pid); err == nil {
+			process.Kill()
+		}
+	}
+
+	return nil
+}
+
+// anyProcessExists reports whether any of the given processes is still alive
+func anyProcessExists(pids []int) bool {
+	for _, pid := range pids {
+		if processExists(pid) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsConfigured checks if the runner is already configured
 func (m *Manager) IsConfigured() bool {
 	runnerFile := filepath.Join(m.runnerPath, ".runner")
@@ -156,6 +239,51 @@ func (m *Manager) IsConfigured() bool {
 	return false
 }
 
+// RemoveRunnerConfiguration unregisters the runner and deletes its local registration files
+// With a remove token the runner is also removed from GitHub via config.sh remove; without
+// one only the local files are cleared and removing it from GitHub is left to the controller
+// The runner process must already have exited
+func (m *Manager) RemoveRunnerConfiguration(removeToken string) error {
+	if removeToken != "" {
+		var output bytes.Buffer
+		cmd := exec.Command(filepath.Join(m.runnerPath, m.scripts.config), "remove", "--token", removeToken)
+		cmd.Dir = m.runnerPath
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to remove runner: %w: %s", err, strings.TrimSpace(output.String()))
+		}
+	}
+
+	return removeStaleRegistration(m.runnerPath)
+}
+
+// RunnerInfo identifies a configured runner as GitHub knows it
+type RunnerInfo struct {
+	ID   int64
+	Name string
+}
+
+// ReadRunnerInfo reads the runner's GitHub ID and name from the .runner file config.sh writes
+func (m *Manager) ReadRunnerInfo() (*RunnerInfo, error) {
+	data, err := os.ReadFile(filepath.Join(m.runnerPath, ".runner"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .runner file: %w", err)
+	}
+	// config.sh writes the file with a UTF-8 byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var file struct {
+		AgentID   int64  `json:"agentId"`
+		AgentName string `json:"agentName"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse .runner file: %w", err)
+	}
+	return &RunnerInfo{ID: file.AgentID, Name: file.AgentName}, nil
+}
+
 // GetRunnerPath returns the runner installation path
 func (m *Manager) GetRunnerPath() string {
 	return m.runnerPath
@@ -2,9 +2,11 @@ package runner
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/monkci/miglet/pkg/events"
@@ -20,21 +22,37 @@ type Monitor struct {
 	stateMutex    sync.RWMutex
 	logs          []string
 	logsMutex     sync.RWMutex
+	logBytes      int // Total length of logs
 	maxLogLines   int
+	maxLogBytes   int // 0 means no byte limit
 	currentJobID  string
 	currentRunID  string
+	jobStartedAt  time.Time // When the current job started (zero when idle)
+	lastLogAt     time.Time // When the runner last wrote a log line
 	lastHeartbeat time.Time
 	onStateChange func(RunnerState)
 	onJobStart    func(jobID, runID string)
 	onJobComplete func(jobID, runID string, success bool)
+	diagActive    atomic.Bool // Job events come from _diag worker logs, not stdout (see WatchDiag)
 }
 
-// NewMonitor creates a new runner monitor
-func NewMonitor() *Monitor {
+// Default log buffer limits, used when the configured values aren't available
+const (
+	DefaultMaxLogLines = 1000
+	DefaultMaxLogBytes = 1024 * 1024
+)
+
+// NewMonitor creates a new runner monitor that keeps at most maxLogLines lines and
+// maxLogBytes bytes (0 for no byte limit) of runner output
+func NewMonitor(maxLogLines, maxLogBytes int) *Monitor {
+	if maxLogLines < 1 {
+		maxLogLines = DefaultMaxLogLines
+	}
 	return &Monitor{
 		state:       events.RunnerStateIdle,
 		logs:        make([]string, 0),
-		maxLogLines: 1000, // Keep last 1000 log lines
+		maxLogLines: maxLogLines,
+		maxLogBytes: maxLogBytes,
 	}
 }
 
@@ -75,9 +93,30 @@ func (m *Monitor) GetCurrentJob() (jobID, runID string) {
 	return m.currentJobID, m.currentRunID
 }
 
+// GetCurrentJobStartedAt returns when the current job started (zero if no job is running)
+func (m *Monitor) GetCurrentJobStartedAt() time.Time {
+	m.stateMutex.RLock()
+	defer m.stateMutex.RUnlock()
+	return m.jobStartedAt
+}
+
+// GetLastLogActivity returns when the runner last wrote a log line (zero if never)
+func (m *Monitor) GetLastLogActivity() time.Time {
+	m.stateMutex.RLock()
+	defer m.stateMutex.RUnlock()
+	return m.lastLogAt
+}
+
 // SetCurrentJob sets the current job information
+// The start time is recorded when a new job is set and cleared when the job is cleared
 func (m *Monitor) SetCurrentJob(jobID, runID string) {
 	m.stateMutex.Lock()
+	switch {
+	case jobID == "":
+		m.jobStartedAt = time.Time{}
+	case jobID != m.currentJobID:
+		m.jobStartedAt = time.Now()
+	}
 	m.currentJobID = jobID
 	m.currentRunID = runID
 	m.stateMutex.Unlock()
@@ -88,6 +127,10 @@ func (m *Monitor) CaptureLogs(reader io.Reader, prefix string) {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
+		m.stateMutex.Lock()
+		m.lastLogAt = time.Now()
+		m.stateMutex.Unlock()
+
 		logLine := line
 		if prefix != "" {
 			logLine = prefix + ": " + line
@@ -108,16 +151,54 @@ func (m *Monitor) CaptureLogs(reader io.Reader, prefix string) {
 	}
 }
 
+// LogWriter returns a writer that adds each line written to the log buffer
+// Used for output of helper commands (e.g. config.sh) that isn't parsed for job events
+func (m *Monitor) LogWriter(prefix string) io.Writer {
+	return &logWriter{monitor: m, prefix: prefix}
+}
+
+// logWriter splits writes into lines for the monitor's log buffer
+type logWriter struct {
+	monitor *Monitor
+	prefix  string
+	partial []byte // Incomplete trailing line from the last write
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.partial[:i]), "\r")
+		w.partial = w.partial[i+1:]
+
+		if w.prefix != "" {
+			line = w.prefix + ": " + line
+		}
+		w.monitor.addLog(line)
+		logger.Get().WithField("source", "runner").Info(line)
+	}
+	return len(p), nil
+}
+
 // addLog adds a log line to the buffer
 func (m *Monitor) addLog(line string) {
 	m.logsMutex.Lock()
 	defer m.logsMutex.Unlock()
 
 	m.logs = append(m.logs, line)
-	if len(m.logs) > m.maxLogLines {
-		// Remove oldest logs
-		m.logs = m.logs[len(m.logs)-m.maxLogLines:]
+	m.logBytes += len(line)
+
+	// Remove oldest logs, always keeping the newest line
+	drop := 0
+	for len(m.logs)-drop > 1 &&
+		(len(m.logs)-drop > m.maxLogLines || (m.maxLogBytes > 0 && m.logBytes > m.maxLogBytes)) {
+		m.logBytes -= len(m.logs[drop])
+		drop++
 	}
+	m.logs = m.logs[drop:]
 }
 
 // GetLogs returns the captured logs
@@ -144,33 +225,22 @@ func (m *Monitor) GetLogs(limit int) []string {
 func (m *Monitor) parseLogLine(line string) {
 	lineLower := strings.ToLower(line)
 
+	// Job events come from the worker logs when they are available
+	diag := m.diagActive.Load()
+
 	// Detect job start
 	// GitHub Actions runner logs typically contain patterns like:
 	// "Running job: <job-id>"
 	// "Job <job-id> started"
-	if strings.Contains(lineLower, "running job") || strings.Contains(lineLower, "job") && strings.Contains(lineLower, "started") {
+	if !diag && (strings.Contains(lineLower, "running job") || strings.Contains(lineLower, "job") && strings.Contains(lineLower, "started")) {
 		// Try to extract job ID and run ID
 		// This is a simple parser - can be enhanced
-		if m.onJobStart != nil {
-			// Extract job ID and run ID from log line
-			jobID, runID := extractJobInfo(line)
-			if jobID != "" {
-				m.SetCurrentJob(jobID, runID)
-				m.SetState(events.RunnerStateRunning)
-				m.onJobStart(jobID, runID)
-			}
-		}
+		m.startJob(extractJobInfo(line))
 	}
 
 	// Detect job completion
-	if strings.Contains(lineLower, "job") && (strings.Contains(lineLower, "completed") || strings.Contains(lineLower, "finished")) {
-		jobID, runID := m.GetCurrentJob()
-		if jobID != "" && m.onJobComplete != nil {
-			success := strings.Contains(lineLower, "succeeded") || strings.Contains(lineLower, "success")
-			m.onJobComplete(jobID, runID, success)
-			m.SetCurrentJob("", "")
-			m.SetState(events.RunnerStateIdle)
-		}
+	if !diag && strings.Contains(lineLower, "job") && (strings.Contains(lineLower, "completed") || strings.Contains(lineLower, "finished")) {
+		m.completeJob(strings.Contains(lineLower, "succeeded") || strings.Contains(lineLower, "success"))
 	}
 
 	// Detect runner offline
@@ -179,6 +249,27 @@ func (m *Monitor) parseLogLine(line string) {
 	}
 }
 
+// startJob records a started job and fires the job start callback
+func (m *Monitor) startJob(jobID, runID string) {
+	if jobID == "" || m.onJobStart == nil {
+		return
+	}
+	m.SetCurrentJob(jobID, runID)
+	m.SetState(events.RunnerStateRunning)
+	m.onJobStart(jobID, runID)
+}
+
+// completeJob fires the job complete callback for the current job, if any, and clears it
+func (m *Monitor) completeJob(success bool) {
+	jobID, runID := m.GetCurrentJob()
+	if jobID == "" || m.onJobComplete == nil {
+		return
+	}
+	m.onJobComplete(jobID, runID, success)
+	m.SetCurrentJob("", "")
+	m.SetState(events.RunnerStateIdle)
+}
+
 // extractJobInfo extracts job ID and run ID from log line
 func extractJobInfo(line string) (jobID, runID string) {
 	// Simple extraction - can be enhanced with regex
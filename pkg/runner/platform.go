@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/monkci/miglet/pkg/config"
+)
+
+// runnerScripts names the runner's configure and run entry points
+type runnerScripts struct {
+	config string
+	run    string
+}
+
+var (
+	unixScripts    = runnerScripts{config: "config.sh", run: "run.sh"}
+	windowsScripts = runnerScripts{config: "config.cmd", run: "run.cmd"}
+)
+
+// Platform returns the runner release platform to use, e.g. "linux-x64" or "win-x64"
+// runner.platform wins; otherwise it is detected from the host
+func Platform(cfg config.RunnerConfig) string {
+	if cfg.Platform != "" {
+		return cfg.Platform
+	}
+	return detectPlatform()
+}
+
+// detectPlatform maps the host OS/arch onto the runner release platform name
+func detectPlatform() string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x64"
+	case "386":
+		arch = "x86"
+	}
+	goos := runtime.GOOS
+	switch goos {
+	case "darwin":
+		goos = "osx"
+	case "windows":
+		goos = "win"
+	}
+	return goos + "-" + arch
+}
+
+// isWindowsPlatform reports whether platform is a Windows runner build
+func isWindowsPlatform(platform string) bool {
+	return strings.HasPrefix(platform, "win-")
+}
+
+// scriptsFor returns the runner scripts shipped in the platform's archive
+func scriptsFor(platform string) runnerScripts {
+	if isWindowsPlatform(platform) {
+		return windowsScripts
+	}
+	return unixScripts
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// workerProcessName is the process the Actions runner spawns to execute a job
+const workerProcessName = "Runner.Worker"
+
+// findWorkerPIDs returns the PIDs of Runner.Worker processes descended from rootPID
+// It reads /proc, so it only finds processes on Linux
+func findWorkerPIDs(rootPID int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	parents := make(map[int]int)
+	var workers []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		name, ppid, ok := readProcStat(pid)
+		if !ok {
+			continue
+		}
+		parents[pid] = ppid
+		if strings.HasPrefix(name, workerProcessName) || cmdlineContains(pid, workerProcessName) {
+			workers = append(workers, pid)
+		}
+	}
+
+	var descendants []int
+	for _, pid := range workers {
+		if isDescendant(pid, rootPID, parents) {
+			descendants = append(descendants, pid)
+		}
+	}
+	return descendants
+}
+
+// processExists reports whether a process with the given PID is still alive
+func processExists(pid int) bool {
+	_, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid)))
+	return err == nil
+}
+
+// readProcStat returns the command name and parent PID from /proc/<pid>/stat
+func readProcStat(pid int) (name string, ppid int, ok bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, false
+	}
+
+	// Format: pid (comm) state ppid ...; comm may contain spaces and parens
+	stat := string(data)
+	open := strings.IndexByte(stat, '(')
+	closeIdx := strings.LastIndexByte(stat, ')')
+	if open < 0 || closeIdx < open {
+		return "", 0, false
+	}
+	fields := strings.Fields(stat[closeIdx+1:])
+	if len(fields) < 2 {
+		return "", 0, false
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return stat[open+1 : closeIdx], ppid, true
+}
+
+// cmdlineContains reports whether /proc/<pid>/cmdline contains s
+// comm is truncated to 15 characters, so the worker is also matched by its command line
+func cmdlineContains(pid int, s string) bool {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), s)
+}
+
+// isDescendant walks the parent chain from pid looking for ancestor
+func isDescendant(pid, ancestor int, parents map[int]int) bool {
+	for i := 0; i < 64 && pid > 1; i++ {
+		ppid, ok := parents[pid]
+		if !ok {
+			return false
+		}
+		if ppid == ancestor {
+			return true
+		}
+		pid = ppid
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+)
+
+// prepareRunnerProcess sets OS-specific process attributes before the runner starts
+func prepareRunnerProcess(cmd *exec.Cmd) {}
+
+// interruptProcess asks a process to stop gracefully (SIGINT)
+func interruptProcess(process *os.Process) error {
+	return process.Signal(os.Interrupt)
+}
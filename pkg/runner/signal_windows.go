@@ -0,0 +1,28 @@
+//go:build windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// prepareRunnerProcess starts the runner in its own process group so it can be
+// sent CTRL_BREAK without also interrupting MIGlet
+func prepareRunnerProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// interruptProcess asks a process to stop gracefully
+// Windows has no SIGINT; the runner listener handles CTRL_BREAK like Ctrl+C and
+// deregisters its session before exiting. Only works for process group leaders
+// started via prepareRunnerProcess
+func interruptProcess(process *os.Process) error {
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(process.Pid))
+}
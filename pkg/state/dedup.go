@@ -0,0 +1,44 @@
+package state
+
+import (
+	"container/list"
+	"sync"
+)
+
+// processedCommandsSize bounds how many command IDs are remembered for deduplication
+const processedCommandsSize = 256
+
+// commandLRU remembers recently handled command IDs, evicting the oldest when full
+type commandLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List               // Front is most recent
+	items map[string]*list.Element // Command ID -> element in order
+}
+
+func newCommandLRU(size int) *commandLRU {
+	return &commandLRU{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// seen records id and reports whether it was already present
+func (l *commandLRU) seen(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[id]; ok {
+		l.order.MoveToFront(elem)
+		return true
+	}
+
+	l.items[id] = l.order.PushFront(id)
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(string))
+	}
+	return false
+}
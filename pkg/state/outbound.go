@@ -0,0 +1,58 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/monkci/miglet/pkg/events"
+)
+
+// errHTTPFallbackDisabled is returned instead of sending over HTTP when controller.http_fallback
+// is off or no HTTP endpoint is configured
+var errHTTPFallbackDisabled = errors.New("HTTP fallback disabled")
+
+// finalSendTimeout bounds each controller or storage call made once Shutdown has started
+const finalSendTimeout = 5 * time.Second
+
+// outboundContext returns the context for a controller or storage call, with an optional timeout
+// Normally it derives from sm.ctx, so calls are abandoned when the state machine stops
+// Once Shutdown has started sm.ctx is about to be cancelled, so calls get a fresh context
+// bounded by finalSendTimeout instead and the last events still reach the controller
+func (sm *StateMachine) outboundContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if sm.shuttingDown.Load() {
+		if timeout <= 0 || timeout > finalSendTimeout {
+			timeout = finalSendTimeout
+		}
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	if timeout > 0 {
+		return context.WithTimeout(sm.ctx, timeout)
+	}
+	return context.WithCancel(sm.ctx)
+}
+
+// httpFallbackEnabled reports whether events and heartbeats may be sent over HTTP
+func (sm *StateMachine) httpFallbackEnabled() bool {
+	return sm.config.Controller.HTTPFallback && sm.config.Controller.Endpoint != ""
+}
+
+// sendHTTPEvent sends an event to the controller over HTTP
+func (sm *StateMachine) sendHTTPEvent(event interface{}) error {
+	if !sm.httpFallbackEnabled() {
+		return errHTTPFallbackDisabled
+	}
+	ctx, cancel := sm.outboundContext(0)
+	defer cancel()
+	return sm.controller.SendEvent(ctx, event)
+}
+
+// sendHTTPHeartbeat sends a heartbeat to the controller over HTTP
+func (sm *StateMachine) sendHTTPHeartbeat(heartbeat *events.HeartbeatEvent) error {
+	if !sm.httpFallbackEnabled() {
+		return errHTTPFallbackDisabled
+	}
+	ctx, cancel := sm.outboundContext(0)
+	defer cancel()
+	return sm.controller.SendHeartbeat(ctx, heartbeat)
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/monkci/miglet/pkg/events"
+	"github.com/monkci/miglet/pkg/logger"
+	"github.com/monkci/miglet/pkg/metadata"
+)
+
+// watchPreemption polls the metadata server until the VM is preempted or MIGlet stops
+// Off GCE the metadata server is unreachable and the watcher exits after the first poll
+func (sm *StateMachine) watchPreemption() {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	interval := sm.config.Shutdown.PreemptionPollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	client := metadata.NewClient()
+	if !client.OnGCE(sm.ctx) {
+		log.Debug("Metadata server unreachable, not watching for preemption")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case <-ticker.C:
+			preempted, err := client.Preempted(sm.ctx)
+			if err != nil {
+				log.WithError(err).Debug("Failed to query preemption status")
+				continue
+			}
+			if preempted {
+				sm.reportPreemption()
+				return
+			}
+		}
+	}
+}
+
+// checkPreemption does a final preemption check during shutdown
+// The SIGTERM can arrive before the watcher's next poll notices the preemption
+func (sm *StateMachine) checkPreemption() {
+	if !sm.config.Shutdown.WatchPreemption || sm.preemptionReported.Load() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if preempted, err := metadata.NewClient().Preempted(ctx); err == nil && preempted {
+		sm.reportPreemption()
+	}
+}
+
+// reportPreemption tells the controller the VM is being preempted, at most once
+// The controller uses it to requeue an interrupted job without treating the VM as crashed
+func (sm *StateMachine) reportPreemption() {
+	if !sm.preemptionReported.CompareAndSwap(false, true) {
+		return
+	}
+
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	jobRunning := sm.isJobRunning()
+	log.WithField("job_running", jobRunning).Warn("VM is being preempted")
+
+	// Prefer gRPC, fall back to HTTP
+	event := events.NewVMPreemptedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobRunning)
+	if sm.grpcClient != nil {
+		data := map[string]string{
+			"job_running": strconv.FormatBool(jobRunning),
+		}
+		err := sm.grpcClient.SendEvent(event.Event, data)
+		if err == nil {
+			log.Debug("VM preempted event sent via gRPC")
+			return
+		}
+		log.WithError(err).Warn("Failed to send VM preempted event via gRPC, falling back to HTTP")
+	}
+
+	if err := sm.sendHTTPEvent(event); err != nil {
+		log.WithError(err).Warn("Failed to send VM preempted event via HTTP")
+	}
+}
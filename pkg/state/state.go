@@ -2,22 +2,50 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/monkci/miglet/pkg/config"
 	"github.com/monkci/miglet/pkg/controller"
 	"github.com/monkci/miglet/pkg/events"
 	"github.com/monkci/miglet/pkg/logger"
+	"github.com/monkci/miglet/pkg/metadata"
 	"github.com/monkci/miglet/pkg/metrics"
+	"github.com/monkci/miglet/pkg/preflight"
 	"github.com/monkci/miglet/pkg/runner"
 	"github.com/monkci/miglet/pkg/storage"
 	"github.com/monkci/miglet/proto/commands"
 )
 
+// crashLogLines is how many trailing runner log lines a crash event carries
+const crashLogLines = 50
+
+// crashLogMaxBytes caps the log tail so crash events stay well under the gRPC message limit
+const crashLogMaxBytes = 16 * 1024
+
+// getLogsDefaultLines is how many runner log lines get_logs returns without a tail param
+const getLogsDefaultLines = 100
+
+// getLogsMaxBytes caps the get_logs result so its ack stays well under the gRPC message limit
+const getLogsMaxBytes = 256 * 1024
+
+// minTokenValidity is the minimum remaining lifetime a registration token needs to be used
+const minTokenValidity = 30 * time.Second
+
+// tokenUsable reports whether a registration token expiring at expiresAt still has
+// minTokenValidity left at now; a zero expiresAt means the expiry is unknown
+func tokenUsable(expiresAt, now time.Time) bool {
+	return expiresAt.IsZero() || expiresAt.Sub(now) >= minTokenValidity
+}
+
 // State represents the current state of MIGlet
 type State string
 
@@ -43,32 +71,61 @@ type StateMachine struct {
 	ctx                context.Context
 	cancel             context.CancelFunc
 	vmStartedEventSent bool                    // Track if VM started event has been sent
+	errorReason        string                  // Why MIGlet entered StateError (empty if unknown)
 	registrationToken  string                  // Registration token received from controller
+	registerCommandID  string                  // register_runner command whose ack waits for config.sh to finish
+	tokenExpiresAt     time.Time               // Registration token expiry (zero if unknown)
 	runnerURL          string                  // Runner URL for registration
 	runnerGroup        string                  // Runner group
+	runnerPersistent   bool                    // Runner registered without --ephemeral; it takes jobs until stopped
+	runnerMediated     bool                    // Persistent runner started with --once for each job the controller grants
 	runnerLabels       []string                // Runner labels
 	runnerPath         string                  // Path to installed runner
+	runnerReady        bool                    // Runner verified installed at runnerPath; gates register_runner
+	runnerInstallErr   string                  // Why the runner couldn't be installed; reported once the stream is up
 	runnerCmd          *exec.Cmd               // Runner process command
+	runnerExited       chan struct{}           // Closed once the runner process has exited
+	runnerFinished     chan struct{}           // Signalled by monitorRunner when the runner exits on its own; handled by the state loop
+	runnerStopping     atomic.Bool             // Set when MIGlet stops the runner itself, so its exit isn't a crash
+	runnerName         string                  // Name of the registered runner
+	runnerID           string                  // GitHub ID of the registered runner (empty if unknown)
+	removeToken        string                  // Optional remove token sent with register_runner
+	jobConsumed        atomic.Bool             // Set once the runner picks up a job
+	assignedJobID      atomic.Value            // Controller's ID (string) for the job handed to the runner, from register_runner or job_available
+	stopDiag           context.CancelFunc      // Stops the current runner's WatchDiag goroutine (nil if not watching)
+	runnerDeregistered atomic.Bool             // Set once an unused runner has been deregistered
 	runnerMonitor      *runner.Monitor         // Runner monitor for logs/state
 	metricsCollector   *metrics.Collector      // Metrics collector
 	lastHeartbeat      time.Time               // Last heartbeat time
 	mongoStorage       *storage.MongoDBStorage // MongoDB storage (optional)
 	heartbeatStop      chan struct{}           // Signal to stop heartbeat goroutine
 	heartbeatWg        sync.WaitGroup          // Wait group for heartbeat goroutine
+	processedCommands  *commandLRU             // Recently handled command IDs, for at-least-once delivery
+	statusMu           sync.RWMutex            // Guards currentState, lastHeartbeat, grpcClient and runnerMonitor for readers outside the state loop
+	preemptionReported atomic.Bool             // Set once vm_preempted has been sent
+	shuttingDown       atomic.Bool             // Set when Shutdown starts; outbound calls stop using ctx
+	shutdownReported   atomic.Bool             // Set once vm_shutting_down has been sent
+	jobsCompleted      atomic.Int64            // Jobs run by runners on this VM, counted as each runner exits
+	storageWg          sync.WaitGroup          // In-flight MongoDB writes, waited on before closing storage
+	loopStarted        atomic.Bool             // Set when Run starts the state loop
+	loopDone           chan struct{}           // Closed when Run returns
 }
 
 // NewStateMachine creates a new state machine
 func NewStateMachine(cfg *config.Config, ctrl *controller.Client, emitter *events.Emitter) *StateMachine {
 	ctx, cancel := context.WithCancel(context.Background())
 	sm := &StateMachine{
-		currentState:     StateInitializing,
-		config:           cfg,
-		controller:       ctrl,
-		eventEmitter:     emitter,
-		ctx:              ctx,
-		cancel:           cancel,
-		metricsCollector: metrics.NewCollector(),
-		heartbeatStop:    make(chan struct{}),
+		currentState:      StateInitializing,
+		config:            cfg,
+		controller:        ctrl,
+		eventEmitter:      emitter,
+		ctx:               ctx,
+		cancel:            cancel,
+		metricsCollector:  metrics.NewCollector(),
+		heartbeatStop:     make(chan struct{}),
+		processedCommands: newCommandLRU(processedCommandsSize),
+		loopDone:          make(chan struct{}),
+		runnerFinished:    make(chan struct{}, 1),
 	}
 
 	// Initialize MongoDB storage if enabled
@@ -94,32 +151,59 @@ func NewStateMachine(cfg *config.Config, ctrl *controller.Client, emitter *event
 
 // GetCurrentState returns the current state
 func (sm *StateMachine) GetCurrentState() State {
+	sm.statusMu.RLock()
+	defer sm.statusMu.RUnlock()
 	return sm.currentState
 }
 
 // Transition transitions to a new state
+// ShuttingDown and Error are final: transitions out of them are refused
 func (sm *StateMachine) Transition(newState State) {
-	oldState := sm.currentState
-	sm.currentState = newState
+	oldState, ok := sm.setState(newState)
 
-	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
-	log.WithFields(map[string]interface{}{
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
 		"old_state": oldState,
 		"new_state": newState,
-	}).Info("State transition")
+	})
+	if !ok {
+		log.Warn("Refusing state transition out of a final state")
+		return
+	}
+	log.Info("State transition")
 
 	// Send immediate heartbeat on state transition (non-blocking)
 	go sm.sendHeartbeat()
 }
 
+// setState sets the current state unless the state machine is shutting down or in error
+// Returns the previous state and whether it changed
+func (sm *StateMachine) setState(newState State) (State, bool) {
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+
+	oldState := sm.currentState
+	if oldState != newState && (oldState == StateShuttingDown || oldState == StateError) {
+		return oldState, false
+	}
+	sm.currentState = newState
+	return oldState, true
+}
+
 // Run starts the state machine and executes state handlers
 func (sm *StateMachine) Run() error {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
 	log.Info("State machine starting")
 
+	sm.loopStarted.Store(true)
+	defer close(sm.loopDone)
+
 	// Start background heartbeat loop
 	sm.startHeartbeatLoop()
 
+	if sm.config.Shutdown.WatchPreemption {
+		go sm.watchPreemption()
+	}
+
 	for {
 		select {
 		case <-sm.ctx.Done():
@@ -128,7 +212,7 @@ func (sm *StateMachine) Run() error {
 		default:
 			// Execute current state handler
 			// State handlers should block or transition to next state
-			// Terminal states (Error, ShuttingDown) will return
+			// The terminal Error state returns; ShuttingDown blocks until Shutdown cancels the context
 			if err := sm.executeState(); err != nil {
 				log.WithError(err).Error("State execution failed")
 				sm.Transition(StateError)
@@ -136,8 +220,8 @@ func (sm *StateMachine) Run() error {
 			}
 
 			// Check if we're in a terminal state
-			if sm.currentState == StateError || sm.currentState == StateShuttingDown {
-				log.WithField("state", sm.currentState).Info("Reached terminal state")
+			if state := sm.GetCurrentState(); state == StateError {
+				log.WithField("state", state).Info("Reached terminal state")
 				return nil
 			}
 
@@ -188,7 +272,7 @@ func (sm *StateMachine) stopHeartbeatLoop() {
 
 // executeState executes the handler for the current state
 func (sm *StateMachine) executeState() error {
-	switch sm.currentState {
+	switch sm.GetCurrentState() {
 	case StateInitializing:
 		return sm.handleInitializing()
 	case StateConnecting:
@@ -198,22 +282,14 @@ func (sm *StateMachine) executeState() error {
 	case StateRegisteringRunner:
 		return sm.handleRegisteringRunner()
 	case StateIdle:
-		// Runner is running, heartbeats are sent by background goroutine
-		// The runner process is monitored in a separate goroutine
-		// Just wait for context cancellation or state change
-		select {
-		case <-sm.ctx.Done():
-			return nil
-		case <-time.After(1 * time.Second):
-			// Small delay to prevent tight loop
-			return nil
-		}
+		return sm.handleIdle()
+	case StateDraining:
+		return sm.handleDraining()
 	case StateError:
 		// Terminal state
 		return nil
 	case StateShuttingDown:
-		// TODO: Phase 4
-		return nil
+		return sm.handleShuttingDown()
 	default:
 		return nil
 	}
@@ -224,42 +300,140 @@ func (sm *StateMachine) handleInitializing() error {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
 	log.Info("Initializing MIGlet")
 
-	// Determine base directory for runner installation
-	// Use /tmp/miglet-runner or current directory if /tmp is not writable
-	baseDir := "/tmp/miglet-runner"
-	if _, err := os.Stat(baseDir); err != nil {
-		// Try to create it, if it fails, use current directory
-		if err := os.MkdirAll(baseDir, 0755); err != nil {
-			log.WithError(err).Warn("Failed to create /tmp/miglet-runner, using current directory")
-			baseDir = "."
+	// Validate prerequisites before spending time on the runner download
+	if !sm.runPreflight() {
+		return nil
+	}
+
+	// Use a runner baked into the image if one is configured and present
+	if prebaked := sm.config.Runner.PrebakedPath; prebaked != "" {
+		if err := runner.VerifyInstallation(prebaked, runner.Platform(sm.config.Runner)); err != nil {
+			log.WithError(err).WithField("path", prebaked).Warn("Pre-baked runner not usable, falling back to installation")
+		} else {
+			sm.runnerPath = prebaked
+			sm.runnerReady = true
+			log.WithField("runner_path", prebaked).Info("Using pre-baked GitHub Actions runner, skipping installation")
+			sm.Transition(StateConnecting)
+			return nil
 		}
 	}
 
+	// Determine base directory for runner installation
+	baseDir, err := runner.ResolveBaseDir(sm.config.Runner.BaseDir)
+	if err != nil {
+		log.WithError(err).Error("Cannot install GitHub Actions runner")
+		sm.Transition(StateError)
+		return nil
+	}
+
 	// Install GitHub Actions runner
 	log.Info("Installing GitHub Actions runner")
-	installer := runner.NewInstaller(baseDir)
-	if err := installer.Install(); err != nil {
+	installer := runner.NewInstaller(baseDir, sm.config.Runner)
+	if err := installer.Install(sm.ctx); err != nil {
+		if sm.ctx.Err() != nil {
+			log.Info("Runner installation aborted by shutdown")
+			return nil
+		}
 		log.WithError(err).Error("Failed to install GitHub Actions runner")
-		// For now, we'll continue even if installation fails
-		// In production, you might want to fail here
-		log.Warn("Continuing despite runner installation failure")
+		// Stay reachable for drain/shutdown, but without advertising register_runner
+		// so the controller never picks this VM for a job
+		log.Warn("Continuing without a runner; this VM will not accept jobs")
+		sm.runnerInstallErr = err.Error()
 	} else {
 		runnerPath := installer.GetRunnerPath()
 		sm.runnerPath = runnerPath
 		log.WithFields(map[string]interface{}{
 			"runner_path": runnerPath,
-			"version":     runner.GetRunnerVersion(),
+			"version":     installer.Version(),
 		}).Info("GitHub Actions runner installed and ready")
 	}
-
-	// Validate prerequisites (Docker, network, etc.)
-	// TODO: Add Docker check, network connectivity check, etc.
+	sm.verifyRunner()
 
 	// Transition to connecting state (gRPC only)
 	sm.Transition(StateConnecting)
 	return nil
 }
 
+// verifyRunner checks the installed runner's scripts are present before the VM
+// advertises that it can register a runner
+func (sm *StateMachine) verifyRunner() {
+	if sm.runnerPath == "" {
+		return
+	}
+	if err := runner.VerifyInstallation(sm.runnerPath, runner.Platform(sm.config.Runner)); err != nil {
+		logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithError(err).
+			Error("Installed runner failed verification; this VM will not accept jobs")
+		sm.runnerInstallErr = err.Error()
+		return
+	}
+	sm.runnerReady = true
+}
+
+// runPreflight runs the enabled prerequisite checks
+// On failure it emits an error event and transitions to StateError; returns true if all checks passed
+func (sm *StateMachine) runPreflight() bool {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	grpcEndpoint, _ := controller.ResolveGRPCEndpoint(sm.config)
+	failure := preflight.Run(sm.ctx, sm.config.Preflight, grpcEndpoint)
+	if failure == nil {
+		log.Debug("Preflight checks passed")
+		return true
+	}
+	if sm.ctx.Err() != nil {
+		log.Info("Preflight checks aborted by shutdown")
+		return false
+	}
+
+	log.WithError(failure.Err).WithFields(map[string]interface{}{
+		"check":  failure.Check,
+		"reason": failure.Reason,
+		"target": failure.Target,
+	}).Error("Preflight check failed")
+
+	// gRPC isn't connected yet, so report over HTTP (best effort)
+	errorEvent := events.NewErrorEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, failure.Reason, failure.Error())
+	errorEvent.Metadata["check"] = failure.Check
+	errorEvent.Metadata["target"] = failure.Target
+	if err := sm.sendHTTPEvent(errorEvent); err != nil {
+		log.WithError(err).Warn("Failed to send preflight error event")
+	}
+
+	sm.errorReason = failure.Reason
+	sm.Transition(StateError)
+	return false
+}
+
+// sendErrorEvent reports a failure to the controller (prefer gRPC, fallback to HTTP)
+// extra is added to the event data; empty values are skipped
+func (sm *StateMachine) sendErrorEvent(reason, message string, extra map[string]string) {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	errorEvent := events.NewErrorEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, reason, message)
+	data := map[string]string{
+		"reason":  reason,
+		"message": message,
+	}
+	for k, v := range extra {
+		if v != "" {
+			data[k] = v
+			errorEvent.Metadata[k] = v
+		}
+	}
+
+	if sm.grpcClient != nil {
+		err := sm.grpcClient.SendEvent(errorEvent.Event, data)
+		if err == nil {
+			return
+		}
+		log.WithError(err).Warn("Failed to send error event via gRPC, falling back to HTTP")
+	}
+
+	if err := sm.sendHTTPEvent(errorEvent); err != nil {
+		log.WithError(err).Warn("Failed to send error event via HTTP")
+	}
+}
+
 // handleConnecting handles establishing gRPC connection to controller
 // All communication happens via gRPC - no HTTP
 func (sm *StateMachine) handleConnecting() error {
@@ -275,8 +449,14 @@ func (sm *StateMachine) handleConnecting() error {
 			sm.Transition(StateError)
 			return nil
 		}
+		// Heartbeat as soon as each (re)connect is accepted, so the controller doesn't keep
+		// the stale state from before the stream dropped until the next interval
+		grpcClient.SetOnConnected(sm.sendHeartbeat)
+		sm.statusMu.Lock()
 		sm.grpcClient = grpcClient
+		sm.statusMu.Unlock()
 	}
+	sm.grpcClient.SetRunnerReady(sm.runnerReady)
 
 	// Connect to controller via gRPC
 	if err := sm.grpcClient.Connect(); err != nil {
@@ -285,11 +465,95 @@ func (sm *StateMachine) handleConnecting() error {
 		return nil
 	}
 
+	// Report machine specs (and any runner install failure) once per boot; waits for the
+	// controller to accept the stream
+	if !sm.vmStartedEventSent {
+		sm.vmStartedEventSent = true
+		go sm.sendStartupEvents()
+	}
+
 	log.Info("gRPC connection established, transitioning to ready state")
 	sm.Transition(StateReady)
 	return nil
 }
 
+// sendStartupEvents sends the once-per-boot events after the first connect: vm_started,
+// then the runner install failure from initialization, which ran before there was a stream
+func (sm *StateMachine) sendStartupEvents() {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	// The stream is established asynchronously; wait for the controller's connect ack
+	deadline := time.Now().Add(sm.config.Controller.Timeout)
+	for !sm.grpcClient.IsConnected() {
+		if time.Now().After(deadline) {
+			log.Warn("gRPC stream not accepted in time, skipping vm_started event")
+			break
+		}
+		select {
+		case <-sm.ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	if sm.grpcClient.IsConnected() {
+		sm.sendVMStartedEvent()
+	}
+
+	// sendErrorEvent falls back to HTTP if the stream still isn't up
+	if sm.runnerInstallErr != "" {
+		sm.sendErrorEvent("runner_install_failed", sm.runnerInstallErr, nil)
+	}
+}
+
+// sendVMStartedEvent sends a vm_started event with machine metadata over gRPC
+// Machine type and region come from the GCE metadata server; if it is unavailable they are omitted
+func (sm *StateMachine) sendVMStartedEvent() {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	event := events.NewVMStartedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	spec := sm.metricsCollector.CollectMachineSpec()
+	event.CPU = spec.CPU
+	event.Memory = spec.Memory
+	event.Disk = spec.Disk
+
+	var zone string
+	ctx, cancel := sm.outboundContext(5 * time.Second)
+	defer cancel()
+	if info, err := metadata.NewClient().MachineInfo(ctx); err != nil {
+		log.WithError(err).Debug("GCE metadata server unavailable, sending vm_started without machine type/region")
+	} else {
+		event.MachineType = info.MachineType
+		event.Region = info.Region
+		zone = info.Zone
+	}
+
+	eventData := map[string]string{
+		"cpu":        strconv.Itoa(event.CPU),
+		"memory_mb":  strconv.Itoa(event.Memory),
+		"disk_gb":    strconv.Itoa(event.Disk),
+		"version":    event.Version,
+		"build_time": event.BuildTime,
+	}
+	if event.MachineType != "" {
+		eventData["machine_type"] = event.MachineType
+		eventData["region"] = event.Region
+		eventData["zone"] = zone
+	}
+
+	if err := sm.grpcClient.SendEvent(event.Event, eventData); err != nil {
+		log.WithError(err).Warn("Failed to send vm_started event")
+		return
+	}
+	log.WithFields(map[string]interface{}{
+		"machine_type": event.MachineType,
+		"region":       event.Region,
+		"cpu":          event.CPU,
+		"memory_mb":    event.Memory,
+		"disk_gb":      event.Disk,
+	}).Info("VM started event sent via gRPC")
+}
+
 // handleReady handles the ready state - waiting for commands via gRPC
 func (sm *StateMachine) handleReady() error {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
@@ -323,6 +587,10 @@ func (sm *StateMachine) handleReady() error {
 				"type":       cmd.Type,
 			}).Info("Received command from controller via gRPC")
 
+			if sm.isDuplicateCommand(cmd) {
+				continue
+			}
+
 			if cmd.Type == "register_runner" {
 				// Extract registration token
 				token, ok := cmd.StringParams["registration_token"]
@@ -340,14 +608,36 @@ func (sm *StateMachine) handleReady() error {
 					continue
 				}
 
+				// Extract token expiry (optional, RFC3339)
+				expiresAt, err := controller.ParseExpiresAt(cmd.StringParams["expires_at"])
+				if err != nil {
+					log.WithError(err).Error("Register runner command has malformed expires_at")
+					sm.grpcClient.SendCommandAck(cmd.Id, false, err.Error(), nil)
+					continue
+				}
+				if !tokenUsable(expiresAt, time.Now()) {
+					log.WithField("expires_at", expiresAt).Error("Register runner command carries an expired registration token")
+					sm.grpcClient.SendCommandAck(cmd.Id, false, "Registration token expired", nil)
+					continue
+				}
+
 				// Extract runner group (optional)
 				runnerGroup := cmd.StringParams["runner_group"]
 
+				// Extract remove token (optional); lets MIGlet remove an unused runner from GitHub itself
+				sm.removeToken = cmd.StringParams["remove_token"]
+
+				// Runners are ephemeral unless the controller's pool keeps them registered
+				sm.runnerPersistent = cmd.StringParams["ephemeral"] == "false"
+				sm.runnerMediated = sm.runnerPersistent && cmd.StringParams["dispatch"] == "mediated"
+
 				// Extract labels
 				labels := cmd.StringArrayParams
 
 				// Store registration config
+				sm.assignedJobID.Store(cmd.StringParams["job_id"])
 				sm.registrationToken = token
+				sm.tokenExpiresAt = expiresAt
 				sm.runnerURL = runnerURL
 				sm.runnerGroup = runnerGroup
 				sm.runnerLabels = labels
@@ -357,16 +647,24 @@ func (sm *StateMachine) handleReady() error {
 					"runner_url":   runnerURL,
 					"runner_group": runnerGroup,
 					"labels":       labels,
+					"persistent":   sm.runnerPersistent,
+					"mediated":     sm.runnerMediated,
 				}).Info("Registration config received, transitioning to registering runner")
 
-				// Send acknowledgment
-				sm.grpcClient.SendCommandAck(cmd.Id, true, "Registration config received", nil)
+				// The ack is sent once config.sh has run, so it can carry the runner's identity
+				sm.registerCommandID = cmd.Id
 
 				// Transition to registering runner state
 				sm.Transition(StateRegisteringRunner)
 				return nil
+			} else if cmd.Type == "drain" {
+				if sm.handleDrain(cmd) {
+					return nil
+				}
+			} else if cmd.Type == "get_logs" {
+				sm.handleGetLogs(cmd)
 			} else {
-				// Handle other command types (drain, shutdown, etc.)
+				// Handle other command types (shutdown, etc.)
 				log.WithField("command_type", cmd.Type).Info("Received command (not register_runner)")
 				// TODO: Handle other command types
 				sm.grpcClient.SendCommandAck(cmd.Id, false, "Command type not yet implemented", nil)
@@ -375,6 +673,415 @@ func (sm *StateMachine) handleReady() error {
 	}
 }
 
+// handleIdle handles the idle state - runner is running and waiting for a job
+// Heartbeats are sent by the background goroutine and the runner process is
+// monitored separately; here we only listen for controller commands
+func (sm *StateMachine) handleIdle() error {
+	cmd := sm.nextCommand()
+	if cmd == nil {
+		return nil
+	}
+
+	switch cmd.Type {
+	case "drain":
+		sm.handleDrain(cmd)
+	case "cancel_job":
+		sm.handleCancelJob(cmd)
+	case "job_available":
+		sm.handleJobAvailable(cmd)
+	case "get_logs":
+		sm.handleGetLogs(cmd)
+	default:
+		sm.ackUnsupported(cmd)
+	}
+	return nil
+}
+
+// handleDraining handles the draining state - no new work is accepted, but a running job
+// carries on and commands are still answered, so the controller isn't left waiting on acks
+func (sm *StateMachine) handleDraining() error {
+	cmd := sm.nextCommand()
+	if cmd == nil {
+		return nil
+	}
+
+	switch cmd.Type {
+	case "drain":
+		sm.grpcClient.SendCommandAck(cmd.Id, true, "Already draining", map[string]string{
+			"job_running": strconv.FormatBool(sm.isJobRunning()),
+		})
+	case "cancel_job":
+		sm.handleCancelJob(cmd)
+	case "get_logs":
+		sm.handleGetLogs(cmd)
+	default:
+		sm.ackUnsupported(cmd)
+	}
+	return nil
+}
+
+// nextCommand waits up to a second for a controller command
+// A runner that exits on its own meanwhile is handled here, so runner state is only changed
+// by the state loop. Returns nil on timeout, shutdown, a runner exit or a duplicate command,
+// which it has already acked
+func (sm *StateMachine) nextCommand() *commands.Command {
+	if sm.grpcClient == nil {
+		select {
+		case <-sm.ctx.Done():
+		case <-sm.runnerFinished:
+			sm.handleRunnerFinished()
+		case <-time.After(1 * time.Second):
+		}
+		return nil
+	}
+
+	select {
+	case <-sm.ctx.Done():
+		return nil
+	case <-sm.runnerFinished:
+		sm.handleRunnerFinished()
+		return nil
+	case cmd := <-sm.grpcClient.GetCommandChannel():
+		if cmd == nil {
+			return nil
+		}
+
+		logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
+			"command_id": cmd.Id,
+			"type":       cmd.Type,
+		}).Info("Received command from controller via gRPC")
+
+		if sm.isDuplicateCommand(cmd) {
+			return nil
+		}
+		return cmd
+	case <-time.After(1 * time.Second):
+		// Small delay to prevent tight loop
+		return nil
+	}
+}
+
+// ackUnsupported rejects a command the current state doesn't handle
+func (sm *StateMachine) ackUnsupported(cmd *commands.Command) {
+	sm.grpcClient.SendCommandAck(cmd.Id, false, fmt.Sprintf("Command type %s not supported in state %s", cmd.Type, sm.GetCurrentState()), nil)
+}
+
+// isDuplicateCommand reports whether cmd was already handled and acks it as a duplicate if so
+// The controller may replay commands after a reconnect, so delivery is at-least-once
+func (sm *StateMachine) isDuplicateCommand(cmd *commands.Command) bool {
+	if cmd.Id == "" || !sm.processedCommands.seen(cmd.Id) {
+		return false
+	}
+
+	logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).
+		WithFields(map[string]interface{}{
+			"command_id": cmd.Id,
+			"type":       cmd.Type,
+		}).Info("Ignoring duplicate command")
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Duplicate command ignored", map[string]string{"duplicate": "true"})
+	return true
+}
+
+// handleDrain handles a drain command from the controller
+// When the if_idle bool param is set, the drain is refused while a job is running
+// so the VM stays in service; otherwise the VM stops accepting new work immediately
+// Returns true if the state machine transitioned to draining
+func (sm *StateMachine) handleDrain(cmd *commands.Command) bool {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	jobRunning := sm.isJobRunning()
+	result := map[string]string{
+		"job_running": strconv.FormatBool(jobRunning),
+	}
+
+	if jobRunning && cmd.BoolParams["if_idle"] {
+		log.WithField("command_id", cmd.Id).Info("Drain requested only if idle, but a job is running")
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Job running, not draining", result)
+		return false
+	}
+
+	log.WithFields(map[string]interface{}{
+		"command_id":  cmd.Id,
+		"job_running": jobRunning,
+	}).Info("Draining - no longer accepting new work")
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Draining", result)
+	sm.Transition(StateDraining)
+
+	if !jobRunning && sm.runnerName != "" && !sm.jobConsumed.Load() {
+		sm.stopRunner()
+		sm.deregisterUnusedRunner("drain")
+	}
+	return true
+}
+
+// stopDiagWatch stops watching the runner's worker logs, once the runner is gone
+func (sm *StateMachine) stopDiagWatch() {
+	if sm.stopDiag != nil {
+		sm.stopDiag()
+		sm.stopDiag = nil
+	}
+}
+
+// runnerStopTimeout bounds how long stopRunner waits for the runner to exit
+const runnerStopTimeout = 30 * time.Second
+
+// stopRunner stops the runner process, if running, and waits for it to exit
+func (sm *StateMachine) stopRunner() {
+	sm.stopDiagWatch()
+	if sm.runnerCmd == nil || sm.runnerCmd.Process == nil {
+		return
+	}
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	if sm.runnerExited != nil {
+		select {
+		case <-sm.runnerExited:
+			return
+		default:
+		}
+	}
+
+	log.Info("Stopping GitHub Actions runner")
+	sm.runnerStopping.Store(true)
+	runnerMgr := runner.NewManager(sm.runnerPath, runner.Platform(sm.config.Runner))
+	if err := runnerMgr.StopRunner(sm.runnerCmd); err != nil {
+		log.WithError(err).Warn("Error stopping runner")
+	}
+
+	if sm.runnerExited == nil {
+		return // Not monitored yet, so there is nothing to wait on
+	}
+	select {
+	case <-sm.runnerExited:
+	case <-time.After(runnerStopTimeout):
+		log.Warn("Runner did not exit in time, killing it")
+		sm.runnerCmd.Process.Kill()
+		<-sm.runnerExited
+	}
+}
+
+// deregisterUnusedRunner unregisters a runner that was configured but never picked up a job
+// Without a remove token GitHub removal is left to the controller, which gets the runner ID
+// in the runner_deregistered event. The runner must already be stopped
+func (sm *StateMachine) deregisterUnusedRunner(reason string) {
+	if sm.runnerName == "" || sm.jobConsumed.Load() || !sm.runnerDeregistered.CompareAndSwap(false, true) {
+		return
+	}
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
+		"runner_name": sm.runnerName,
+		"reason":      reason,
+	})
+
+	runnerMgr := runner.NewManager(sm.runnerPath, runner.Platform(sm.config.Runner))
+	removed := false
+	if err := runnerMgr.RemoveRunnerConfiguration(sm.removeToken); err != nil {
+		log.WithError(err).Warn("Failed to remove runner configuration")
+	} else {
+		removed = sm.removeToken != ""
+	}
+	log.WithField("removed_from_github", removed).Info("Unused runner deregistered")
+
+	event := events.NewRunnerDeregisteredEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, sm.runnerName, sm.runnerID, removed, reason)
+	if sm.grpcClient != nil {
+		err := sm.grpcClient.SendEvent(event.Event, event.Data())
+		if err == nil {
+			return
+		}
+		log.WithError(err).Warn("Failed to send runner deregistered event via gRPC, falling back to HTTP")
+	}
+	if err := sm.sendHTTPEvent(event); err != nil {
+		log.WithError(err).Warn("Failed to send runner deregistered event via HTTP")
+	}
+}
+
+// handleCancelJob cancels the running job if it matches the command's job_id
+// The command is acked once cancellation starts; the grace period before the worker is
+// killed runs in the background so commands and heartbeats keep flowing
+func (sm *StateMachine) handleCancelJob(cmd *commands.Command) {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	if sm.runnerCmd == nil || !sm.isJobRunning() {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "No job running", nil)
+		return
+	}
+
+	runnerJobID, _ := sm.runnerMonitor.GetCurrentJob()
+	currentJobID := sm.reportedJobID(runnerJobID)
+	if jobID := cmd.StringParams["job_id"]; jobID != "" && jobID != currentJobID {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, fmt.Sprintf("Job %s is not running (current: %q)", jobID, currentJobID), nil)
+		return
+	}
+
+	grace := sm.config.Shutdown.JobCancelGrace
+	if secs, ok := cmd.IntParams["grace_period_seconds"]; ok && secs >= 0 {
+		grace = time.Duration(secs) * time.Second
+	}
+
+	log.WithFields(map[string]interface{}{
+		"command_id": cmd.Id,
+		"job_id":     currentJobID,
+		"grace":      grace,
+	}).Info("Cancelling current job")
+
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Cancelling job", map[string]string{
+		"job_id": currentJobID,
+	})
+
+	runnerMgr := runner.NewManager(sm.runnerPath, runner.Platform(sm.config.Runner))
+	runnerCmd := sm.runnerCmd
+	go func() {
+		if err := runnerMgr.CancelCurrentJob(runnerCmd, grace); err != nil {
+			log.WithError(err).WithField("job_id", currentJobID).Warn("Failed to cancel job")
+		}
+	}()
+}
+
+// handleGetLogs returns the newest runner log lines in the ack result: lines (newline
+// separated, oldest first), line_count, truncated and the current job_id
+// The optional tail int param sets how many lines (default getLogsDefaultLines, capped
+// by the monitor's buffer); lines beyond getLogsMaxBytes are left out and truncated set
+func (sm *StateMachine) handleGetLogs(cmd *commands.Command) {
+	if sm.runnerMonitor == nil {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Runner not configured, no logs captured", nil)
+		return
+	}
+
+	tail := getLogsDefaultLines
+	if n, ok := cmd.IntParams["tail"]; ok && n > 0 {
+		tail = int(n)
+	}
+
+	logs := sm.runnerMonitor.GetLogs(tail)
+	lines := truncateLogTail(logs, getLogsMaxBytes)
+	runnerJobID, _ := sm.runnerMonitor.GetCurrentJob()
+	jobID := sm.reportedJobID(runnerJobID)
+
+	logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
+		"command_id": cmd.Id,
+		"lines":      len(lines),
+	}).Debug("Returning runner logs")
+
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Runner logs", map[string]string{
+		"lines":      strings.Join(lines, "\n"),
+		"line_count": strconv.Itoa(len(lines)),
+		"truncated":  strconv.FormatBool(len(lines) < len(logs)),
+		"job_id":     jobID,
+	})
+}
+
+// handleJobAvailable confirms a persistent runner can take the job the controller is
+// dispatching to it. The runner already listens to GitHub, so nothing is registered;
+// the ack carries the runner's identity, as register_runner's does
+func (sm *StateMachine) handleJobAvailable(cmd *commands.Command) {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	if !sm.runnerPersistent {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Runner is ephemeral", nil)
+		return
+	}
+	if sm.runnerMediated {
+		sm.grantJob(cmd)
+		return
+	}
+	if sm.runnerCmd == nil || sm.runnerExited == nil {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "No runner registered", nil)
+		return
+	}
+	select {
+	case <-sm.runnerExited:
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Runner has exited", nil)
+		return
+	default:
+	}
+	if sm.isJobRunning() {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Runner is busy", nil)
+		return
+	}
+
+	log.WithFields(map[string]interface{}{
+		"command_id":  cmd.Id,
+		"job_id":      cmd.StringParams["job_id"],
+		"repository":  cmd.StringParams["repository"],
+		"runner_name": sm.runnerName,
+	}).Info("Job dispatched to registered runner")
+
+	sm.assignedJobID.Store(cmd.StringParams["job_id"])
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Runner idle", map[string]string{
+		"runner_name": sm.runnerName,
+		"runner_id":   sm.runnerID,
+	})
+}
+
+// grantJob starts a mediated runner for the one job the controller granted it
+// The runner doesn't listen to GitHub between grants, so it can't pick up work on its own
+// The grant is advisory: with --once the runner takes whichever matching job GitHub
+// offers it first, which need not be the granted job_id
+func (sm *StateMachine) grantJob(cmd *commands.Command) {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	if sm.runnerName == "" || sm.runnerMonitor == nil {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "No runner registered", nil)
+		return
+	}
+	if sm.runnerCmd != nil {
+		sm.grpcClient.SendCommandAck(cmd.Id, false, "Runner is busy", nil)
+		return
+	}
+
+	runnerMgr := runner.NewManager(sm.runnerPath, runner.Platform(sm.config.Runner))
+	runnerCmd, _, err := runnerMgr.StartRunner(sm.runnerMonitor, true)
+	if err == nil {
+		err = runnerCmd.Start()
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to start runner for granted job")
+		sm.grpcClient.SendCommandAck(cmd.Id, false, fmt.Sprintf("Failed to start runner: %v", err), nil)
+		return
+	}
+
+	sm.runnerCmd = runnerCmd
+	sm.runnerExited = make(chan struct{})
+	sm.assignedJobID.Store(cmd.StringParams["job_id"])
+	go sm.monitorRunner(runnerCmd, sm.runnerExited)
+
+	log.WithFields(map[string]interface{}{
+		"command_id":  cmd.Id,
+		"job_id":      cmd.StringParams["job_id"],
+		"repository":  cmd.StringParams["repository"],
+		"runner_name": sm.runnerName,
+		"pid":         runnerCmd.Process.Pid,
+	}).Info("Job granted, runner started for one job")
+
+	sm.grpcClient.SendCommandAck(cmd.Id, true, "Runner started", map[string]string{
+		"runner_name": sm.runnerName,
+		"runner_id":   sm.runnerID,
+	})
+}
+
+// isJobRunning reports whether the runner is currently executing a job
+func (sm *StateMachine) isJobRunning() bool {
+	if sm.runnerMonitor == nil {
+		return false
+	}
+	if jobID, _ := sm.runnerMonitor.GetCurrentJob(); jobID != "" {
+		return true
+	}
+	return sm.runnerMonitor.GetState() == events.RunnerStateRunning
+}
+
+// reportedJobID returns the job ID to report to the controller for a job the runner started
+// The runner knows a job by GitHub's ID (a GUID in its worker logs), so the controller's ID
+// for the job it handed this runner is reported instead, when known
+func (sm *StateMachine) reportedJobID(runnerJobID string) string {
+	if runnerJobID == "" {
+		return ""
+	}
+	if assigned, _ := sm.assignedJobID.Load().(string); assigned != "" {
+		return assigned
+	}
+	return runnerJobID
+}
+
 // GetRegistrationToken returns the registration token received from controller
 func (sm *StateMachine) GetRegistrationToken() string {
 	return sm.registrationToken
@@ -385,6 +1092,15 @@ func (sm *StateMachine) GetRunnerConfig() (url, group string, labels []string) {
 	return sm.runnerURL, sm.runnerGroup, sm.runnerLabels
 }
 
+// ackRegistration answers the pending register_runner command, if any
+func (sm *StateMachine) ackRegistration(success bool, message string, result map[string]string) {
+	if sm.registerCommandID == "" || sm.grpcClient == nil {
+		return
+	}
+	sm.grpcClient.SendCommandAck(sm.registerCommandID, success, message, result)
+	sm.registerCommandID = ""
+}
+
 // handleRegisteringRunner handles the runner registration state
 func (sm *StateMachine) handleRegisteringRunner() error {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
@@ -392,26 +1108,47 @@ func (sm *StateMachine) handleRegisteringRunner() error {
 	// Check if we have all required information
 	if sm.registrationToken == "" {
 		log.Error("Registration token not available")
+		sm.ackRegistration(false, "Registration token not available", nil)
 		sm.Transition(StateError)
 		return nil
 	}
 
 	if sm.runnerURL == "" {
 		log.Error("Runner URL not available")
+		sm.ackRegistration(false, "Runner URL not available", nil)
 		sm.Transition(StateError)
 		return nil
 	}
 
 	if sm.runnerPath == "" {
 		log.Error("Runner path not available")
+		sm.ackRegistration(false, "Runner not installed", nil)
 		sm.Transition(StateError)
 		return nil
 	}
 
+	// Don't attempt registration with a token GitHub will reject; go back to
+	// ready so the controller can send a fresh one
+	if !tokenUsable(sm.tokenExpiresAt, time.Now()) {
+		log.WithField("expires_at", sm.tokenExpiresAt).Warn("Registration token expired before use, waiting for a new one")
+		sm.registrationToken = ""
+		sm.tokenExpiresAt = time.Time{}
+		sm.ackRegistration(false, "Registration token expired", nil)
+		sm.Transition(StateReady)
+		return nil
+	}
+
 	log.Info("Starting GitHub Actions runner registration")
 
 	// Create runner manager
-	runnerMgr := runner.NewManager(sm.runnerPath)
+	runnerMgr := runner.NewManager(sm.runnerPath, runner.Platform(sm.config.Runner))
+
+	// Create runner monitor; it also keeps config.sh's output
+	monitor := runner.NewMonitor(sm.config.Logging.RunnerLogLines, sm.config.Logging.RunnerLogBytes)
+	sm.setupRunnerCallbacks(monitor)
+	sm.statusMu.Lock()
+	sm.runnerMonitor = monitor
+	sm.statusMu.Unlock()
 
 	// Configure runner (non-interactive)
 	log.Info("Configuring runner with token")
@@ -420,20 +1157,43 @@ func (sm *StateMachine) handleRegisteringRunner() error {
 		sm.runnerURL,
 		sm.runnerGroup,
 		sm.runnerLabels,
+		!sm.runnerPersistent,
+		monitor.LogWriter("config"),
 	); err != nil {
-		log.WithError(err).Error("Failed to configure runner")
+		reason := runner.ConfigReasonConfigFailed
+		var output string
+		var configErr *runner.ConfigError
+		if errors.As(err, &configErr) {
+			reason = configErr.Reason
+			output = configErr.Output
+		}
+		log.WithError(err).WithField("reason", reason).Error("Failed to configure runner")
+		sm.ackRegistration(false, err.Error(), map[string]string{"reason": reason})
+		sm.sendErrorEvent(reason, err.Error(), map[string]string{"output": output})
+		sm.errorReason = reason
 		sm.Transition(StateError)
 		return nil
 	}
 
-	// Create runner monitor
-	monitor := runner.NewMonitor()
-	sm.setupRunnerCallbacks(monitor)
-	sm.runnerMonitor = monitor
+	// config.sh runs without --name, so the runner is named after the host
+	runnerName, _ := os.Hostname()
+	var runnerID string
+	if info, err := runnerMgr.ReadRunnerInfo(); err != nil {
+		log.WithError(err).Warn("Failed to read runner identity")
+	} else {
+		runnerName = info.Name
+		runnerID = strconv.FormatInt(info.ID, 10)
+	}
+	sm.runnerName = runnerName
+	sm.runnerID = runnerID
+	sm.ackRegistration(true, "Runner registered", map[string]string{
+		"runner_name": runnerName,
+		"runner_id":   runnerID,
+	})
 
 	// Start runner process with log capture
 	log.Info("Starting runner process")
-	runnerCmd, _, err := runnerMgr.StartRunner(monitor)
+	runnerCmd, _, err := runnerMgr.StartRunner(monitor, sm.runnerMediated)
 	if err != nil {
 		log.WithError(err).Error("Failed to start runner")
 		sm.Transition(StateError)
@@ -452,6 +1212,12 @@ func (sm *StateMachine) handleRegisteringRunner() error {
 
 	log.WithField("pid", runnerCmd.Process.Pid).Info("GitHub Actions runner started successfully")
 
+	if sm.config.Runner.WatchDiag {
+		diagCtx, stopDiag := context.WithCancel(sm.ctx)
+		sm.stopDiag = stopDiag
+		go monitor.WatchDiag(diagCtx, sm.runnerPath)
+	}
+
 	// Send runner registered event (prefer gRPC, fallback to HTTP)
 	registeredEvent := events.NewRunnerRegisteredEvent(
 		sm.config.VMID,
@@ -461,29 +1227,34 @@ func (sm *StateMachine) handleRegisteringRunner() error {
 	)
 	registeredEvent.Labels = sm.runnerLabels
 	registeredEvent.RunnerGroup = sm.runnerGroup
+	registeredEvent.RunnerName = runnerName
+	registeredEvent.RunnerID = runnerID
 
 	// Try gRPC first, fallback to HTTP
 	if sm.grpcClient != nil {
 		eventData := map[string]string{
 			"runner_url":   sm.runnerURL,
 			"runner_group": sm.runnerGroup,
+			"runner_name":  runnerName,
+			"runner_id":    runnerID,
 		}
-		if err := sm.grpcClient.SendEvent("runner_registered", sm.config.VMID, sm.config.PoolID, sm.config.OrgID, eventData); err != nil {
+		if err := sm.grpcClient.SendEvent(registeredEvent.Event, eventData); err != nil {
 			log.WithError(err).Warn("Failed to send runner registered event via gRPC, falling back to HTTP")
-			if err := sm.controller.SendEvent(sm.ctx, registeredEvent); err != nil {
+			if err := sm.sendHTTPEvent(registeredEvent); err != nil {
 				log.WithError(err).Warn("Failed to send runner registered event via HTTP")
 			}
 		} else {
 			log.Debug("Runner registered event sent via gRPC")
 		}
 	} else {
-		if err := sm.controller.SendEvent(sm.ctx, registeredEvent); err != nil {
+		if err := sm.sendHTTPEvent(registeredEvent); err != nil {
 			log.WithError(err).Warn("Failed to send runner registered event")
 		}
 	}
 
 	// Monitor runner process in a goroutine
-	go sm.monitorRunner(runnerCmd)
+	sm.runnerExited = make(chan struct{})
+	go sm.monitorRunner(runnerCmd, sm.runnerExited)
 
 	// Transition to idle state (runner is running)
 	log.Info("Runner registered and running, transitioning to idle")
@@ -502,60 +1273,73 @@ func (sm *StateMachine) setupRunnerCallbacks(monitor *runner.Monitor) {
 
 	// Job start callback
 	monitor.SetJobCallbacks(
-		func(jobID, runID string) {
+		func(runnerJobID, runID string) {
+			sm.jobConsumed.Store(true)
+			jobID := sm.reportedJobID(runnerJobID)
 			log.WithFields(map[string]interface{}{
-				"job_id": jobID,
-				"run_id": runID,
+				"job_id":        jobID,
+				"runner_job_id": runnerJobID,
+				"run_id":        runID,
 			}).Info("Job started")
 
 			// Send job started event (prefer gRPC, fallback to HTTP)
+			jobEvent := events.NewJobStartedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID)
 			eventData := map[string]string{
-				"job_id": jobID,
-				"run_id": runID,
+				"job_id":        jobID,
+				"runner_job_id": runnerJobID,
+				"run_id":        runID,
 			}
 			if sm.grpcClient != nil {
-				if err := sm.grpcClient.SendEvent("job_started", sm.config.VMID, sm.config.PoolID, sm.config.OrgID, eventData); err != nil {
+				if err := sm.grpcClient.SendEvent(jobEvent.Event, eventData); err != nil {
 					log.WithError(err).Warn("Failed to send job started event via gRPC, falling back to HTTP")
-					jobEvent := events.NewJobStartedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID)
-					if err := sm.controller.SendEvent(sm.ctx, jobEvent); err != nil {
+					if err := sm.sendHTTPEvent(jobEvent); err != nil {
 						log.WithError(err).Warn("Failed to send job started event via HTTP")
 					}
 				} else {
 					log.Debug("Job started event sent via gRPC")
 				}
 			} else {
-				jobEvent := events.NewJobStartedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID)
-				if err := sm.controller.SendEvent(sm.ctx, jobEvent); err != nil {
+				if err := sm.sendHTTPEvent(jobEvent); err != nil {
 					log.WithError(err).Warn("Failed to send job started event")
 				}
 			}
 		},
-		func(jobID, runID string, success bool) {
+		func(runnerJobID, runID string, success bool) {
+			jobID := sm.reportedJobID(runnerJobID)
 			log.WithFields(map[string]interface{}{
-				"job_id":  jobID,
-				"run_id":  runID,
-				"success": success,
+				"job_id":        jobID,
+				"runner_job_id": runnerJobID,
+				"run_id":        runID,
+				"success":       success,
 			}).Info("Job completed")
 
+			if sm.runnerPersistent {
+				// The runner keeps running, so the job is counted now rather than on exit.
+				// It may take its next job straight from GitHub, so that one isn't reported
+				// under this job's ID
+				sm.assignedJobID.CompareAndSwap(jobID, "")
+				defer sm.handlePersistentJobFinished()
+			}
+
 			// Send job completed event (prefer gRPC, fallback to HTTP)
+			jobEvent := events.NewJobCompletedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID, success)
 			eventData := map[string]string{
-				"job_id":  jobID,
-				"run_id":  runID,
-				"success": fmt.Sprintf("%t", success),
+				"job_id":        jobID,
+				"runner_job_id": runnerJobID,
+				"run_id":        runID,
+				"success":       fmt.Sprintf("%t", success),
 			}
 			if sm.grpcClient != nil {
-				if err := sm.grpcClient.SendEvent("job_completed", sm.config.VMID, sm.config.PoolID, sm.config.OrgID, eventData); err != nil {
+				if err := sm.grpcClient.SendEvent(jobEvent.Event, eventData); err != nil {
 					log.WithError(err).Warn("Failed to send job completed event via gRPC, falling back to HTTP")
-					jobEvent := events.NewJobCompletedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID, success)
-					if err := sm.controller.SendEvent(sm.ctx, jobEvent); err != nil {
+					if err := sm.sendHTTPEvent(jobEvent); err != nil {
 						log.WithError(err).Warn("Failed to send job completed event via HTTP")
 					}
 				} else {
 					log.Debug("Job completed event sent via gRPC")
 				}
 			} else {
-				jobEvent := events.NewJobCompletedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, jobID, runID, success)
-				if err := sm.controller.SendEvent(sm.ctx, jobEvent); err != nil {
+				if err := sm.sendHTTPEvent(jobEvent); err != nil {
 					log.WithError(err).Warn("Failed to send job completed event")
 				}
 			}
@@ -569,6 +1353,7 @@ func (sm *StateMachine) sendHeartbeat() {
 
 	// Collect VM health metrics
 	vmHealth := sm.metricsCollector.CollectVMHealth()
+	state := sm.GetCurrentState()
 
 	// Get runner state
 	runnerState := events.RunnerStateIdle
@@ -578,9 +1363,12 @@ func (sm *StateMachine) sendHeartbeat() {
 		jobID, runID := sm.runnerMonitor.GetCurrentJob()
 		if jobID != "" {
 			currentJob = &events.JobInfo{
-				JobID:     jobID,
+				JobID:     sm.reportedJobID(jobID),
 				RunID:     runID,
-				StartedAt: time.Now(), // TODO: Track actual start time
+				StartedAt: sm.runnerMonitor.GetCurrentJobStartedAt(),
+			}
+			if lastLog := sm.runnerMonitor.GetLastLogActivity(); !lastLog.IsZero() {
+				currentJob.LogIdleSeconds = int64(time.Since(lastLog).Seconds())
 			}
 		}
 	}
@@ -590,7 +1378,7 @@ func (sm *StateMachine) sendHeartbeat() {
 		sm.config.VMID,
 		sm.config.PoolID,
 		sm.config.OrgID,
-		string(sm.currentState), // Include MIGlet state machine state
+		string(state), // Include MIGlet state machine state
 		vmHealth,
 		runnerState,
 		currentJob,
@@ -598,11 +1386,13 @@ func (sm *StateMachine) sendHeartbeat() {
 
 	// Send heartbeat via gRPC if available, otherwise fall back to HTTP
 	if sm.grpcClient != nil {
+		heartbeat.Metadata["command_backpressure"] = sm.grpcClient.BackpressureCount()
+
 		// Convert to proto format
 		protoHealth := &commands.VMHealth{
 			CpuUsagePercent:    vmHealth.CPULoad,
-			MemoryUsagePercent: float64(vmHealth.MemoryUsed) / float64(vmHealth.MemoryTotal) * 100,
-			DiskUsagePercent:   0,                                  // TODO: Calculate from disk stats
+			MemoryUsagePercent: usagePercent(vmHealth.MemoryUsed, vmHealth.MemoryTotal),
+			DiskUsagePercent:   usagePercent(vmHealth.DiskUsed, vmHealth.DiskTotal),
 			MemoryTotalBytes:   vmHealth.MemoryTotal * 1024 * 1024, // Convert MB to bytes
 			MemoryUsedBytes:    vmHealth.MemoryUsed * 1024 * 1024,
 			DiskTotalBytes:     vmHealth.DiskTotal * 1024 * 1024 * 1024, // Convert GB to bytes
@@ -619,13 +1409,14 @@ func (sm *StateMachine) sendHeartbeat() {
 		var protoJobInfo *commands.JobInfo
 		if currentJob != nil {
 			protoJobInfo = &commands.JobInfo{
-				JobId:      currentJob.JobID,
-				RunId:      currentJob.RunID,
-				Repository: "",        // TODO: Get from job metadata if available
-				Branch:     "",        // TODO: Get from job metadata if available
-				Commit:     "",        // TODO: Get from job metadata if available
-				Status:     "running", // TODO: Get actual status
-				StartedAt:  currentJob.StartedAt.Unix(),
+				JobId:          currentJob.JobID,
+				RunId:          currentJob.RunID,
+				Repository:     "",        // TODO: Get from job metadata if available
+				Branch:         "",        // TODO: Get from job metadata if available
+				Commit:         "",        // TODO: Get from job metadata if available
+				Status:         "running", // TODO: Get actual status
+				StartedAt:      currentJob.StartedAt.Unix(),
+				LogIdleSeconds: currentJob.LogIdleSeconds,
 			}
 		}
 
@@ -634,14 +1425,14 @@ func (sm *StateMachine) sendHeartbeat() {
 			sm.config.VMID,
 			sm.config.PoolID,
 			sm.config.OrgID,
-			string(sm.currentState), // Include MIGlet state machine state
+			string(state), // Include MIGlet state machine state
 			protoHealth,
 			protoRunnerState,
 			protoJobInfo,
 		); err != nil {
 			log.WithError(err).Warn("Failed to send heartbeat via gRPC, falling back to HTTP")
 			// Fall back to HTTP
-			if err := sm.controller.SendHeartbeat(sm.ctx, heartbeat); err != nil {
+			if err := sm.sendHTTPHeartbeat(heartbeat); err != nil {
 				log.WithError(err).Warn("Failed to send heartbeat to controller")
 			}
 		} else {
@@ -649,7 +1440,7 @@ func (sm *StateMachine) sendHeartbeat() {
 		}
 	} else {
 		// Use HTTP fallback
-		if err := sm.controller.SendHeartbeat(sm.ctx, heartbeat); err != nil {
+		if err := sm.sendHTTPHeartbeat(heartbeat); err != nil {
 			log.WithError(err).Warn("Failed to send heartbeat to controller")
 		} else {
 			log.Debug("Heartbeat sent to controller via HTTP successfully")
@@ -658,8 +1449,10 @@ func (sm *StateMachine) sendHeartbeat() {
 
 	// Store heartbeat in MongoDB if enabled (non-blocking)
 	if sm.mongoStorage != nil && sm.mongoStorage.IsConnected() {
+		sm.storageWg.Add(1)
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer sm.storageWg.Done()
+			ctx, cancel := sm.outboundContext(5 * time.Second)
 			defer cancel()
 
 			if err := sm.mongoStorage.StoreHeartbeat(ctx, heartbeat); err != nil {
@@ -670,57 +1463,270 @@ func (sm *StateMachine) sendHeartbeat() {
 		}()
 	}
 
+	sm.statusMu.Lock()
 	sm.lastHeartbeat = time.Now()
+	sm.statusMu.Unlock()
 	if sm.runnerMonitor != nil {
 		sm.runnerMonitor.UpdateLastHeartbeat()
 	}
 }
 
 // monitorRunner monitors the runner process and handles crashes
-func (sm *StateMachine) monitorRunner(cmd *exec.Cmd) {
+func (sm *StateMachine) monitorRunner(cmd *exec.Cmd, exited chan struct{}) {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
 
 	// Wait for process to exit
 	err := cmd.Wait()
+	close(exited)
 	if err != nil {
+		if sm.preemptionReported.Load() {
+			// Killed by the preemption shutdown; the controller already knows
+			log.WithError(err).Warn("Runner process exited during preemption")
+			return
+		}
+		if sm.runnerStopping.Load() {
+			log.WithError(err).Info("Runner process exited after being stopped")
+			return
+		}
+
 		log.WithError(err).Error("Runner process exited with error")
 
-		// Send runner crashed event
-		crashedEvent := &events.Event{
-			Type:      events.EventTypeRunnerCrashed,
-			Timestamp: time.Now(),
-			VMID:      sm.config.VMID,
-			PoolID:    sm.config.PoolID,
-			OrgID:     sm.config.OrgID,
-			Metadata: map[string]interface{}{
-				"error": err.Error(),
-			},
-		}
-		// Send runner crashed event (prefer gRPC, fallback to HTTP)
+		crashedEvent := sm.newRunnerCrashedEvent(err)
+
+		// Send runner crashed event (prefer gRPC, fallback to HTTP); both carry the same details
 		if sm.grpcClient != nil {
-			eventData := map[string]string{
-				"reason": "process_exited",
-			}
-			if err := sm.grpcClient.SendEvent("runner_crashed", sm.config.VMID, sm.config.PoolID, sm.config.OrgID, eventData); err != nil {
+			if err := sm.grpcClient.SendEvent(crashedEvent.Event, crashedEvent.Data()); err != nil {
 				log.WithError(err).Warn("Failed to send runner crashed event via gRPC, falling back to HTTP")
-				if sendErr := sm.controller.SendEvent(sm.ctx, crashedEvent); sendErr != nil {
+				if sendErr := sm.sendHTTPEvent(crashedEvent); sendErr != nil {
 					log.WithError(sendErr).Warn("Failed to send runner crashed event via HTTP")
 				}
 			} else {
 				log.Debug("Runner crashed event sent via gRPC")
 			}
 		} else {
-			if sendErr := sm.controller.SendEvent(sm.ctx, crashedEvent); sendErr != nil {
+			if sendErr := sm.sendHTTPEvent(crashedEvent); sendErr != nil {
 				log.WithError(sendErr).Warn("Failed to send runner crashed event")
 			}
 		}
 
 		sm.Transition(StateError)
 	} else {
+		if sm.runnerStopping.Load() || sm.shuttingDown.Load() {
+			log.Info("Runner process exited after being stopped")
+			return
+		}
 		log.Info("Runner process exited normally")
-		// Runner exited - could be normal shutdown or crash
-		// TODO: Determine if it was intentional shutdown or crash
+		select {
+		case sm.runnerFinished <- struct{}{}:
+		case <-sm.ctx.Done():
+		}
+	}
+}
+
+// handleRunnerFinished handles the runner exiting on its own, as an ephemeral runner does
+// after its job. Once github.max_jobs_per_vm jobs have run the VM retires; until then the
+// runner state is reset and MIGlet waits in ready for the next register_runner, or stays
+// draining. Runs on the state loop
+func (sm *StateMachine) handleRunnerFinished() {
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
+
+	if sm.runnerMediated {
+		sm.handleGrantFinished()
+		return
+	}
+	sm.stopDiagWatch()
+
+	completed := sm.jobsCompleted.Load()
+	if sm.jobConsumed.Load() && !sm.runnerPersistent {
+		completed = sm.jobsCompleted.Add(1) // Persistent runners count each job as it completes
+	}
+	maxJobs := sm.config.GitHub.MaxJobsPerVM
+	log = log.WithFields(map[string]interface{}{
+		"jobs_completed":  completed,
+		"max_jobs_per_vm": maxJobs,
+	})
+
+	if maxJobs > 0 && completed >= int64(maxJobs) {
+		log.Info("VM ran its maximum number of jobs, retiring")
+		sm.reportShuttingDown(events.ShutdownReasonMaxJobs)
+		return
+	}
+
+	log.Info("Runner finished, waiting for the next registration")
+	sm.runnerCmd = nil
+	sm.runnerName = ""
+	sm.runnerID = ""
+	sm.removeToken = ""
+	sm.assignedJobID.Store("")
+	sm.runnerDeregistered.Store(false)
+	sm.jobConsumed.Store(false)
+	if sm.GetCurrentState() == StateDraining {
+		return
+	}
+	sm.Transition(StateReady)
+}
+
+// handlePersistentJobFinished counts a job run by a persistent runner, which stays registered
+// for the next one. At github.max_jobs_per_vm the VM retires: it announces shutting_down,
+// then stops the runner so no further job is picked up
+func (sm *StateMachine) handlePersistentJobFinished() {
+	completed := sm.jobsCompleted.Add(1)
+	maxJobs := sm.config.GitHub.MaxJobsPerVM
+	if maxJobs <= 0 || completed < int64(maxJobs) {
+		return
+	}
+
+	logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
+		"jobs_completed":  completed,
+		"max_jobs_per_vm": maxJobs,
+	}).Info("VM ran its maximum number of jobs, retiring")
+
+	// Called from the runner's log reader, which stopRunner waits on, so stop asynchronously
+	go func() {
+		sm.reportShuttingDown(events.ShutdownReasonMaxJobs)
+		sm.stopRunner()
+	}()
+}
+
+// handleGrantFinished handles a mediated runner exiting after its granted job
+// The runner stays registered and MIGlet stays idle, reporting runner_available so the
+// controller can grant the next job. Jobs are counted as they complete; a VM that has
+// reached github.max_jobs_per_vm is retiring, and a draining one takes no more work, so
+// neither is reported available. Runs on the state loop
+func (sm *StateMachine) handleGrantFinished() {
+	completed := sm.jobsCompleted.Load()
+	if maxJobs := sm.config.GitHub.MaxJobsPerVM; maxJobs > 0 && completed >= int64(maxJobs) {
+		return
+	}
+	sm.runnerCmd = nil
+	// The listener may have logged going offline as it exited; it is ready to start again
+	sm.runnerMonitor.SetState(events.RunnerStateIdle)
+	if sm.GetCurrentState() == StateDraining {
+		return
+	}
+
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithFields(map[string]interface{}{
+		"runner_name":    sm.runnerName,
+		"jobs_completed": completed,
+	})
+	log.Info("Runner finished its granted job, reporting available")
+
+	event := events.NewRunnerAvailableEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, sm.runnerName, sm.runnerID, completed)
+	if sm.grpcClient != nil {
+		err := sm.grpcClient.SendEvent(event.Event, event.Data())
+		if err == nil {
+			return
+		}
+		log.WithError(err).Warn("Failed to send runner available event via gRPC, falling back to HTTP")
+	}
+	if err := sm.sendHTTPEvent(event); err != nil {
+		log.WithError(err).Warn("Failed to send runner available event via HTTP")
+	}
+}
+
+// handleShuttingDown waits for Shutdown once the VM has announced it is going away
+// Heartbeats keep reporting shutting_down so the controller doesn't assign it work
+func (sm *StateMachine) handleShuttingDown() error {
+	<-sm.ctx.Done()
+	return nil
+}
+
+// newRunnerCrashedEvent describes why the runner exited, with the tail of its output
+func (sm *StateMachine) newRunnerCrashedEvent(waitErr error) *events.RunnerCrashedEvent {
+	reason := events.CrashReasonWaitFailed
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		exitCode = exitErr.ExitCode()
+		reason = events.CrashReasonProcessExited
+		if exitCode < 0 {
+			reason = events.CrashReasonKilled
+		}
+	}
+
+	var logTail []string
+	if sm.runnerMonitor != nil {
+		logTail = truncateLogTail(sm.runnerMonitor.GetLogs(crashLogLines), crashLogMaxBytes)
 	}
+
+	return events.NewRunnerCrashedEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, reason, waitErr.Error(), exitCode, logTail)
+}
+
+// truncateLogTail keeps the newest lines that fit in maxBytes
+// A single oversized last line is cut down to at most its final maxBytes, starting on a
+// rune boundary so the result is still valid UTF-8
+func truncateLogTail(lines []string, maxBytes int) []string {
+	size := 0
+	start := len(lines)
+	for start > 0 {
+		n := len(lines[start-1]) + 1 // Joined with newlines
+		if size+n > maxBytes {
+			break
+		}
+		size += n
+		start--
+	}
+
+	if start == len(lines) && len(lines) > 0 {
+		last := lines[len(lines)-1]
+		cut := len(last) - maxBytes
+		for cut < len(last) && !utf8.RuneStart(last[cut]) {
+			cut++
+		}
+		return []string{last[cut:]}
+	}
+	return lines[start:]
+}
+
+// usagePercent returns used as a percentage of total, or 0 if total is unknown
+func usagePercent(used, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
+}
+
+// reportShuttingDown enters StateShuttingDown and sends vm_shutting_down plus a heartbeat
+// carrying that state (prefer gRPC, fallback to HTTP), at most once
+// During Shutdown the sends get fresh deadlines rather than the cancelled context
+func (sm *StateMachine) reportShuttingDown(reason string) {
+	if !sm.shutdownReported.CompareAndSwap(false, true) {
+		return
+	}
+	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).WithField("reason", reason)
+
+	// Not Transition, which heartbeats asynchronously; the heartbeat is sent below, after the event
+	if oldState, ok := sm.setState(StateShuttingDown); ok {
+		log.WithFields(map[string]interface{}{
+			"old_state": oldState,
+			"new_state": StateShuttingDown,
+		}).Info("State transition")
+	}
+
+	jobRunning := sm.isJobRunning()
+	event := events.NewVMShuttingDownEvent(sm.config.VMID, sm.config.PoolID, sm.config.OrgID, reason, jobRunning)
+	sent := false
+	if sm.grpcClient != nil {
+		data := map[string]string{
+			"reason":      reason,
+			"job_running": strconv.FormatBool(jobRunning),
+		}
+		err := sm.grpcClient.SendEvent(event.Event, data)
+		if err == nil {
+			log.Debug("VM shutting down event sent via gRPC")
+			sent = true
+		} else {
+			log.WithError(err).Warn("Failed to send VM shutting down event via gRPC, falling back to HTTP")
+		}
+	}
+	if !sent {
+		if err := sm.sendHTTPEvent(event); err != nil {
+			log.WithError(err).Warn("Failed to send VM shutting down event via HTTP")
+		}
+	}
+
+	// Synchronous, unlike the heartbeat Transition fires, so it goes out before the stream closes
+	sm.sendHeartbeat()
 }
 
 // Shutdown gracefully shuts down the state machine
@@ -728,17 +1734,28 @@ func (sm *StateMachine) Shutdown() {
 	log := logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID)
 	log.Info("Shutting down state machine")
 
+	// From here on, outbound calls get fresh deadlines instead of sm.ctx
+	sm.shuttingDown.Store(true)
+
 	// Stop heartbeat loop first
 	sm.stopHeartbeatLoop()
 
+	// Report preemption while the controller connection is still open
+	sm.checkPreemption()
+
+	// Tell the controller the VM is going away, so a disconnect isn't mistaken for a crash
+	// and the scheduler stops assigning jobs to it
+	sm.reportShuttingDown(events.ShutdownReasonSignal)
+
+	// Stop the state loop before touching the runner, so a handler doesn't act on it meanwhile
+	sm.cancel()
+	sm.waitForLoop()
+
 	// Stop runner if running
-	if sm.runnerCmd != nil && sm.runnerCmd.Process != nil {
-		log.Info("Stopping GitHub Actions runner")
-		runnerMgr := runner.NewManager(sm.runnerPath)
-		if err := runnerMgr.StopRunner(sm.runnerCmd); err != nil {
-			log.WithError(err).Warn("Error stopping runner")
-		}
-	}
+	sm.stopRunner()
+
+	// Don't leave an unused runner behind as "offline" in GitHub
+	sm.deregisterUnusedRunner("shutdown")
 
 	// Close gRPC connection if connected
 	if sm.grpcClient != nil {
@@ -749,8 +1766,9 @@ func (sm *StateMachine) Shutdown() {
 		}
 	}
 
-	// Close MongoDB connection if connected
+	// Close MongoDB connection if connected, after in-flight writes finish
 	if sm.mongoStorage != nil {
+		sm.storageWg.Wait()
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := sm.mongoStorage.Close(ctx); err != nil {
@@ -759,6 +1777,21 @@ func (sm *StateMachine) Shutdown() {
 			log.Debug("MongoDB connection closed")
 		}
 	}
+}
 
-	sm.cancel()
+// loopStopTimeout bounds how long Shutdown waits for the state loop to return
+// Handlers running an external command (such as config.sh) only notice cancellation once it ends
+const loopStopTimeout = 30 * time.Second
+
+// waitForLoop waits for Run to return once sm.ctx is cancelled, up to loopStopTimeout
+func (sm *StateMachine) waitForLoop() {
+	if !sm.loopStarted.Load() {
+		return
+	}
+	select {
+	case <-sm.loopDone:
+	case <-time.After(loopStopTimeout):
+		logger.WithContext(sm.config.VMID, sm.config.PoolID, sm.config.OrgID).
+			Warn("State loop did not stop in time, continuing shutdown")
+	}
 }
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTruncateLogTailKeepsNewestLines(t *testing.T) {
+	lines := []string{"first", "second", "third"}
+
+	got := truncateLogTail(lines, len("second\nthird\n"))
+	if strings.Join(got, "\n") != "second\nthird" {
+		t.Fatalf("truncateLogTail = %q, want the last two lines", got)
+	}
+	if got := truncateLogTail(lines, 1024); len(got) != 3 {
+		t.Fatalf("truncateLogTail = %q, want every line", got)
+	}
+}
+
+func TestTruncateLogTailCutsOversizedLineOnRuneBoundary(t *testing.T) {
+	line := strings.Repeat("é", 10) // 2 bytes each
+
+	got := truncateLogTail([]string{"before", line}, 5)
+	if len(got) != 1 {
+		t.Fatalf("truncateLogTail = %q, want only the last line", got)
+	}
+	if !utf8.ValidString(got[0]) {
+		t.Fatalf("truncateLogTail = %q, not valid UTF-8", got[0])
+	}
+	if got[0] != "éé" {
+		t.Fatalf("truncateLogTail = %q, want the last whole runes within 5 bytes", got[0])
+	}
+}
+
+func TestTokenUsable(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name      string
+		expiresAt time.Time
+		want      bool
+	}{
+		{name: "unknown expiry", expiresAt: time.Time{}, want: true},
+		{name: "an hour left", expiresAt: now.Add(time.Hour), want: true},
+		{name: "exactly the minimum left", expiresAt: now.Add(minTokenValidity), want: true},
+		{name: "just under the minimum", expiresAt: now.Add(minTokenValidity - time.Second), want: false},
+		{name: "already expired", expiresAt: now.Add(-time.Minute), want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tokenUsable(tc.expiresAt, now); got != tc.want {
+				t.Errorf("tokenUsable(%v) = %v, want %v", tc.expiresAt, got, tc.want)
+			}
+		})
+	}
+}
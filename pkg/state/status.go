@@ -0,0 +1,67 @@
+package state
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/monkci/miglet/pkg/events"
+)
+
+// Status is a point-in-time snapshot of MIGlet for the local status endpoint
+type Status struct {
+	VMID                string             `json:"vm_id"`
+	PoolID              string             `json:"pool_id"`
+	State               State              `json:"state"`
+	GRPCConnected       bool               `json:"grpc_connected"`
+	LastHeartbeat       time.Time          `json:"last_heartbeat,omitempty"`
+	LastHeartbeatAgeSec float64            `json:"last_heartbeat_age_seconds,omitempty"` // Omitted until the first heartbeat
+	RunnerState         events.RunnerState `json:"runner_state"`
+	CurrentJobID        string             `json:"current_job_id,omitempty"`
+}
+
+// Status returns a snapshot of the state machine
+// It runs on HTTP handler goroutines, so everything the state loop assigns is read under statusMu
+func (sm *StateMachine) Status() Status {
+	sm.statusMu.RLock()
+	status := Status{
+		VMID:          sm.config.VMID,
+		PoolID:        sm.config.PoolID,
+		State:         sm.currentState,
+		LastHeartbeat: sm.lastHeartbeat,
+		RunnerState:   events.RunnerStateOffline,
+	}
+	grpcClient := sm.grpcClient
+	monitor := sm.runnerMonitor
+	sm.statusMu.RUnlock()
+
+	if !status.LastHeartbeat.IsZero() {
+		status.LastHeartbeatAgeSec = time.Since(status.LastHeartbeat).Seconds()
+	}
+	if grpcClient != nil {
+		status.GRPCConnected = grpcClient.IsConnected()
+	}
+	if monitor != nil {
+		status.RunnerState = monitor.GetState()
+		runnerJobID, _ := monitor.GetCurrentJob()
+		status.CurrentJobID = sm.reportedJobID(runnerJobID)
+	}
+	return status
+}
+
+// StatusHandler serves the status snapshot as JSON
+// It responds 503 once MIGlet is in the error state so health checks can replace the VM
+func (sm *StateMachine) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := sm.Status()
+
+		code := http.StatusOK
+		if status.State == StateError {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
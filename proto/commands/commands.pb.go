@@ -261,11 +261,13 @@ func (*ControllerMessage_Error) isControllerMessage_Message() {}
 
 // ConnectRequest is sent by MIGlet when establishing connection
 type ConnectRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
-	PoolId        string                 `protobuf:"bytes,2,opt,name=pool_id,json=poolId,proto3" json:"pool_id,omitempty"`
-	OrgId         string                 `protobuf:"bytes,3,opt,name=org_id,json=orgId,proto3" json:"org_id,omitempty"`
-	Version       string                 `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	VmId    string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	PoolId  string                 `protobuf:"bytes,2,opt,name=pool_id,json=poolId,proto3" json:"pool_id,omitempty"`
+	OrgId   string                 `protobuf:"bytes,3,opt,name=org_id,json=orgId,proto3" json:"org_id,omitempty"`
+	Version string                 `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	// Command types this MIGlet handles; empty for agents predating negotiation
+	Capabilities  []string `protobuf:"bytes,5,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -328,6 +330,13 @@ func (x *ConnectRequest) GetVersion() string {
 	return ""
 }
 
+func (x *ConnectRequest) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
 // ConnectAck is sent by Controller to acknowledge connection
 type ConnectAck struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -560,6 +569,7 @@ type EventNotification struct {
 	OrgId         string                 `protobuf:"bytes,4,opt,name=org_id,json=orgId,proto3" json:"org_id,omitempty"`
 	Data          map[string]string      `protobuf:"bytes,5,rep,name=data,proto3" json:"data,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Event-specific data
 	Timestamp     int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	EventId       string                 `protobuf:"bytes,7,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"` // Client-generated UUID, reused on retries so the controller can drop duplicates
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -636,6 +646,13 @@ func (x *EventNotification) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *EventNotification) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
 // Heartbeat contains VM health and runner state
 type Heartbeat struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -901,16 +918,17 @@ func (x *RunnerState) GetLabels() []string {
 
 // JobInfo contains current job information
 type JobInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
-	RunId         string                 `protobuf:"bytes,2,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
-	Repository    string                 `protobuf:"bytes,3,opt,name=repository,proto3" json:"repository,omitempty"`
-	Branch        string                 `protobuf:"bytes,4,opt,name=branch,proto3" json:"branch,omitempty"`
-	Commit        string                 `protobuf:"bytes,5,opt,name=commit,proto3" json:"commit,omitempty"`
-	Status        string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"` // running, completed, failed
-	StartedAt     int64                  `protobuf:"varint,7,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	JobId          string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	RunId          string                 `protobuf:"bytes,2,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	Repository     string                 `protobuf:"bytes,3,opt,name=repository,proto3" json:"repository,omitempty"`
+	Branch         string                 `protobuf:"bytes,4,opt,name=branch,proto3" json:"branch,omitempty"`
+	Commit         string                 `protobuf:"bytes,5,opt,name=commit,proto3" json:"commit,omitempty"`
+	Status         string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"` // running, completed, failed
+	StartedAt      int64                  `protobuf:"varint,7,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	LogIdleSeconds int64                  `protobuf:"varint,8,opt,name=log_idle_seconds,json=logIdleSeconds,proto3" json:"log_idle_seconds,omitempty"` // Seconds since the runner last wrote a log line
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *JobInfo) Reset() {
@@ -992,6 +1010,13 @@ func (x *JobInfo) GetStartedAt() int64 {
 	return 0
 }
 
+func (x *JobInfo) GetLogIdleSeconds() int64 {
+	if x != nil {
+		return x.LogIdleSeconds
+	}
+	return 0
+}
+
 // ErrorNotification represents errors from either side
 type ErrorNotification struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -1079,12 +1104,13 @@ const file_proto_commands_proto_rawDesc = "" +
 	"connectAck\x124\n" +
 	"\acommand\x18\x02 \x01(\v2\x18.miglet.commands.CommandH\x00R\acommand\x12:\n" +
 	"\x05error\x18\x03 \x01(\v2\".miglet.commands.ErrorNotificationH\x00R\x05errorB\t\n" +
-	"\amessage\"o\n" +
+	"\amessage\"\x93\x01\n" +
 	"\x0eConnectRequest\x12\x13\n" +
 	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\x12\x17\n" +
 	"\apool_id\x18\x02 \x01(\tR\x06poolId\x12\x15\n" +
 	"\x06org_id\x18\x03 \x01(\tR\x05orgId\x12\x18\n" +
-	"\aversion\x18\x04 \x01(\tR\aversion\"i\n" +
+	"\aversion\x18\x04 \x01(\tR\aversion\x12\"\n" +
+	"\fcapabilities\x18\x05 \x03(\tR\fcapabilities\"i\n" +
 	"\n" +
 	"ConnectAck\x12\x1a\n" +
 	"\baccepted\x18\x01 \x01(\bR\baccepted\x12\x18\n" +
@@ -1119,14 +1145,15 @@ const file_proto_commands_proto_rawDesc = "" +
 	"\x06result\x18\x04 \x03(\v2'.miglet.commands.CommandAck.ResultEntryR\x06result\x1a9\n" +
 	"\vResultEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x85\x02\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa0\x02\n" +
 	"\x11EventNotification\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x13\n" +
 	"\x05vm_id\x18\x02 \x01(\tR\x04vmId\x12\x17\n" +
 	"\apool_id\x18\x03 \x01(\tR\x06poolId\x12\x15\n" +
 	"\x06org_id\x18\x04 \x01(\tR\x05orgId\x12@\n" +
 	"\x04data\x18\x05 \x03(\v2,.miglet.commands.EventNotification.DataEntryR\x04data\x12\x1c\n" +
-	"\ttimestamp\x18\x06 \x01(\x03R\ttimestamp\x1a7\n" +
+	"\ttimestamp\x18\x06 \x01(\x03R\ttimestamp\x12\x19\n" +
+	"\bevent_id\x18\a \x01(\tR\aeventId\x1a7\n" +
 	"\tDataEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc0\x02\n" +
@@ -1155,7 +1182,7 @@ const file_proto_commands_proto_rawDesc = "" +
 	"configured\x12\x1f\n" +
 	"\vrunner_name\x18\x03 \x01(\tR\n" +
 	"runnerName\x12\x16\n" +
-	"\x06labels\x18\x04 \x03(\tR\x06labels\"\xbe\x01\n" +
+	"\x06labels\x18\x04 \x03(\tR\x06labels\"\xe8\x01\n" +
 	"\aJobInfo\x12\x15\n" +
 	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x15\n" +
 	"\x06run_id\x18\x02 \x01(\tR\x05runId\x12\x1e\n" +
@@ -1166,7 +1193,8 @@ const file_proto_commands_proto_rawDesc = "" +
 	"\x06commit\x18\x05 \x01(\tR\x06commit\x12\x16\n" +
 	"\x06status\x18\x06 \x01(\tR\x06status\x12\x1d\n" +
 	"\n" +
-	"started_at\x18\a \x01(\x03R\tstartedAt\"\xe6\x01\n" +
+	"started_at\x18\a \x01(\x03R\tstartedAt\x12(\n" +
+	"\x10log_idle_seconds\x18\b \x01(\x03R\x0elogIdleSeconds\"\xe6\x01\n" +
 	"\x11ErrorNotification\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\tR\amessage\x12I\n" +